@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/grafana/metrictank/api/middleware"
 	"github.com/grafana/metrictank/api/models"
@@ -12,9 +13,24 @@ import (
 	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/expr/tagquery"
 	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/schema"
 	log "github.com/sirupsen/logrus"
 )
 
+var (
+	// metric api.ccache_peers.hit is how many series were (partially) served from the chunk cache of a peer, rather than from the store
+	ccachePeersHit = stats.NewCounterRate32("api.ccache_peers.hit")
+	// metric api.ccache_peers.miss is how many series none of the peers had any of the requested chunks of in their chunk cache
+	ccachePeersMiss = stats.NewCounterRate32("api.ccache_peers.miss")
+	// metric api.ccache_peers.chunks is how many chunks were served from the chunk cache of peers
+	ccachePeersChunks = stats.NewCounter32("api.ccache_peers.chunks")
+	// metric api.ccache_peers.errors is how many requests to the chunk cache of peers failed or timed out
+	ccachePeersErrors = stats.NewCounter32("api.ccache_peers.errors")
+	// metric api.ccache_peers.duration is how long requests to the chunk cache of peers take
+	ccachePeersDuration = stats.NewLatencyHistogram15s32("api.ccache_peers.duration")
+)
+
 func (s *Server) ccacheDelete(ctx *middleware.Context, req models.CCacheDelete) {
 	res := models.CCacheDeleteResp{}
 	code := http.StatusOK
@@ -135,3 +151,107 @@ func (s *Server) ccacheDeleteRemote(ctx context.Context, req *models.CCacheDelet
 
 	return res
 }
+
+// ccacheSearch returns the chunks of the requested series that are in the local chunk cache.
+// it never falls back to the store, that's up to the requesting peer.
+// these requests are counted separately from our own cache use, see cache.SearchPeer
+func (s *Server) ccacheSearch(ctx *middleware.Context, req models.CCacheSearch) {
+	key, err := schema.AMKeyFromString(req.Key)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	res, err := s.Cache.SearchPeer(ctx.Req.Context(), key, req.From, req.Until)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	response.Write(ctx, response.NewMsgp(http.StatusOK, &models.CCacheSearchResp{
+		From:     res.From,
+		Until:    res.Until,
+		Complete: res.Complete,
+		Start:    res.Start,
+		End:      res.End,
+	}))
+}
+
+// ccacheSearchPeers asks the peers that own the partition of the given series for the chunks they have cached
+// in the given range, and returns the response that covers the most chunks, or nil if none of them has any.
+// peers that don't respond within chunk-cache-peers-timeout are ignored
+func (s *Server) ccacheSearchPeers(ctx context.Context, key schema.AMKey, from, until uint32) *models.CCacheSearchResp {
+	if s.MetricIndex == nil {
+		return nil
+	}
+	def, ok := s.MetricIndex.Get(key.MKey)
+	if !ok {
+		return nil
+	}
+	var peers []cluster.Node
+	for _, peer := range cluster.Manager.MemberList(false, true) {
+		if peer.IsLocal() {
+			continue
+		}
+		for _, p := range peer.GetPartitions() {
+			if p == def.Partition {
+				peers = append(peers, peer)
+				break
+			}
+		}
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, cachePeersTimeout)
+	defer cancel()
+
+	req := models.CCacheSearch{
+		Key:   key.String(),
+		From:  from,
+		Until: until,
+	}
+	responses := make(chan *models.CCacheSearchResp, len(peers))
+	for _, peer := range peers {
+		go func(peer cluster.Node) {
+			pre := time.Now()
+			buf, err := peer.Post(reqCtx, "ccacheSearchRemote", "/ccache/search", req)
+			ccachePeersDuration.Value(time.Since(pre))
+			if err != nil {
+				// requests we canceled because another peer had all the chunks are not errors
+				if reqCtx.Err() != context.Canceled {
+					log.Debugf("HTTP ccacheSearch error querying %s/ccache/search: %q", peer.GetName(), err.Error())
+					ccachePeersErrors.Inc()
+				}
+				responses <- nil
+				return
+			}
+			var resp models.CCacheSearchResp
+			if _, err := resp.UnmarshalMsg(buf); err != nil {
+				log.Errorf("HTTP ccacheSearch error unmarshaling body from %s/ccache/search: %q", peer.GetName(), err.Error())
+				ccachePeersErrors.Inc()
+				responses <- nil
+				return
+			}
+			responses <- &resp
+		}(peer)
+	}
+
+	var best *models.CCacheSearchResp
+	for range peers {
+		select {
+		case resp := <-responses:
+			if resp == nil || len(resp.Start)+len(resp.End) == 0 {
+				continue
+			}
+			if best == nil || len(resp.Start)+len(resp.End) > len(best.Start)+len(best.End) {
+				best = resp
+			}
+			if best.Complete {
+				return best
+			}
+		case <-reqCtx.Done():
+			return best
+		}
+	}
+	return best
+}
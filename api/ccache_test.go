@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -12,9 +13,12 @@ import (
 	"github.com/grafana/metrictank/api/response"
 	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/idx/memory"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/cache/accnt"
+	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/test"
 	"github.com/raintank/schema"
 )
@@ -316,3 +320,156 @@ func TestMetricDeletePropagation(t *testing.T) {
 		)
 	}
 }
+
+func getPeerTestItgens(t *testing.T, span, start, end uint32) []chunk.IterGen {
+	var itgens []chunk.IterGen
+	chunks := generateChunks(span, start, end)
+	for i := range chunks {
+		itgen, err := chunk.NewIterGen(chunks[i].Series.T0, 0, chunks[i].Encode(span))
+		if err != nil {
+			t.Fatalf("NewIterGen error: %s", err)
+		}
+		itgens = append(itgens, itgen)
+	}
+	return itgens
+}
+
+func TestCCacheSearch(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	defer accnt.CacheChunkHit.SetUint32(0)
+	srv, _ := newSrv(0, 0)
+	defer srv.Stop()
+	c := cache.NewCCache()
+	defer c.Stop()
+	srv.BindCache(c)
+
+	metric := test.GetAMKey(1)
+	metric.Archive = schema.NewArchive(schema.Cnt, 600)
+	c.AddRange(metric, 0, getPeerTestItgens(t, 600, 600, 1800))
+
+	ts := httptest.NewServer(srv.Macaron)
+	defer ts.Close()
+
+	chunkHits, peerHits, peerChunkHits := accnt.CacheChunkHit.Peek(), accnt.CachePeerHit.Peek(), accnt.CachePeerChunkHit.Peek()
+	req, _ := json.Marshal(models.CCacheSearch{
+		Key:   metric.String(),
+		From:  600,
+		Until: 3000,
+	})
+	res, err := http.Post(ts.URL+"/ccache/search", "application/json", bytes.NewReader(req))
+	if err != nil {
+		t.Fatalf("There was an error in the request: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %s", err)
+	}
+	var resp models.CCacheSearchResp
+	if _, err := resp.UnmarshalMsg(buf); err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+	if resp.Complete || len(resp.Start) != 2 || len(resp.End) != 0 || resp.From != 1800 || resp.Until != 3000 {
+		t.Fatalf("expected 2 start chunks and the range 1800-3000 to be missing, got %+v", resp)
+	}
+	if resp.Start[0].T0 != 600 || resp.Start[1].T0 != 1200 {
+		t.Fatalf("expected chunks 600 and 1200, got %d and %d", resp.Start[0].T0, resp.Start[1].T0)
+	}
+	// the search of the peer doesn't count as our own cache use
+	if accnt.CacheChunkHit.Peek() != chunkHits {
+		t.Fatalf("expected the local chunk hits not to change, got %d more", accnt.CacheChunkHit.Peek()-chunkHits)
+	}
+	if accnt.CachePeerHit.Peek()-peerHits != 1 || accnt.CachePeerChunkHit.Peek()-peerChunkHits != 2 {
+		t.Fatalf("expected 1 peer hit of 2 chunks, got %d hits of %d chunks", accnt.CachePeerHit.Peek()-peerHits, accnt.CachePeerChunkHit.Peek()-peerChunkHits)
+	}
+}
+
+// TestGetSeriesCachedStorePeers checks that chunks that are not in the local cache are fetched from the cache
+// of the peer that owns the same partition, and only the remaining ones from the store
+func TestGetSeriesCachedStorePeers(t *testing.T) {
+	defer cluster.Init("default", "test", time.Now(), "http", 6060)
+	defer accnt.CacheChunkHit.SetUint32(0)
+	origCachePeers, origCachePeersTimeout := cachePeers, cachePeersTimeout
+	defer func() { cachePeers, cachePeersTimeout = origCachePeers, origCachePeersTimeout }()
+	cachePeers, cachePeersTimeout = true, time.Second
+
+	span := uint32(600)
+	itgens := getPeerTestItgens(t, span, span, 6*span)
+	md := &schema.MetricData{
+		OrgId:    1,
+		Name:     "test.key",
+		Interval: 1,
+	}
+	md.SetId()
+	metric := schema.AMKey{MKey: test.MustMKeyFromString(md.Id)}
+
+	// the peer has the first 2 chunks cached, the store has the others
+	peerResp, _ := (&models.CCacheSearchResp{
+		From:  3 * span,
+		Until: 6 * span,
+		Start: itgens[:2],
+	}).MarshalMsg(nil)
+	cluster.Manager = &cluster.MockClusterManager{
+		Peers: []*cluster.MockNode{
+			cluster.NewMockNode(true, "local", []int32{0}, nil),
+			cluster.NewMockNode(false, "peer", []int32{0}, peerResp),
+			// this one doesn't own the partition of the series, so should not be asked
+			cluster.NewMockNode(false, "other", []int32{1}, []byte("invalid")),
+		},
+	}
+
+	srv, _ := newSrv(0, 0)
+	defer srv.Stop()
+	c := cache.NewCCache()
+	defer c.Stop()
+	srv.BindCache(c)
+	store := mdata.NewMockStore()
+	srv.BindBackendStore(store)
+	for _, itgen := range itgens[2:] {
+		cwr := mdata.NewChunkWriteRequest(nil, metric, 0, itgen.T0, itgen.B, time.Now())
+		store.Add(&cwr)
+	}
+	srv.MetricIndex.AddOrUpdate(metric.MKey, md, 0)
+
+	hits, chunks, errs := ccachePeersHit.Peek(), ccachePeersChunks.Peek(), ccachePeersErrors.Peek()
+
+	req := reqRaw(metric.MKey, span, 6*span, span, 1, consolidation.None, 0, 0)
+	req.ArchInterval = 1
+	ctx := newRequestContext(test.NewContext(), &req, consolidation.None)
+	iters, err := srv.getSeriesCachedStore(ctx, 6*span)
+	if err != nil {
+		t.Fatalf("expected err nil, got %s", err)
+	}
+	if len(iters) != 5 {
+		t.Fatalf("expected 5 chunks, got %d", len(iters))
+	}
+	expTs := span
+	for _, it := range iters {
+		for it.Next() {
+			ts, _ := it.Values()
+			if ts != expTs {
+				t.Fatalf("expected ts %d, got %d", expTs, ts)
+			}
+			expTs++
+		}
+	}
+	if expTs != 6*span {
+		t.Fatalf("expected points up to %d, got up to %d", 6*span, expTs)
+	}
+
+	if ccachePeersHit.Peek()-hits != 1 || ccachePeersChunks.Peek()-chunks != 2 {
+		t.Fatalf("expected 1 peer hit of 2 chunks, got %d hits of %d chunks", ccachePeersHit.Peek()-hits, ccachePeersChunks.Peek()-chunks)
+	}
+	if ccachePeersErrors.Peek() != errs {
+		t.Fatalf("expected no errors, got %d", ccachePeersErrors.Peek()-errs)
+	}
+
+	// the chunks of the peer and the store are now cached locally
+	res, _ := c.Search(test.NewContext(), metric, span, 6*span)
+	if !res.Complete || len(res.Start) != 5 {
+		t.Fatalf("expected all chunks to be cached locally, got %d, complete %t", len(res.Start), res.Complete)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +33,18 @@ var (
 
 	// metric api.cluster.speculative.requests is how many speculative http requests made to peers
 	speculativeRequests = stats.NewCounter32("api.cluster.speculative.requests")
+
+	// metric api.cluster.hedged.requests is how many hedged http requests were made to peers, because a peer was slower than its usual latency
+	hedgedRequests = stats.NewCounter32("api.cluster.hedged.requests")
+
+	// metric api.cluster.hedged.wins is how many peer queries were answered by a hedged request
+	hedgedWins = stats.NewCounter32("api.cluster.hedged.wins")
+
+	// metric api.cluster.getdata.streamed is how many data requests to peers were answered with a stream of series
+	getDataStreamed = stats.NewCounter32("api.cluster.getdata.streamed")
+
+	// metric api.cluster.getdata.unstreamed is how many data requests to peers that were asked to stream the series, were answered with a single response, because the peer doesn't support streaming
+	getDataUnstreamed = stats.NewCounter32("api.cluster.getdata.unstreamed")
 )
 
 func (s *Server) explainPriority(ctx *middleware.Context) {
@@ -69,10 +82,12 @@ func (s *Server) appStatus(ctx *middleware.Context) {
 }
 
 func (s *Server) getClusterStatus(ctx *middleware.Context) {
+	members := cluster.Manager.MemberList(false, false)
 	status := models.ClusterStatus{
 		ClusterName: cluster.ClusterName,
 		NodeName:    cluster.Manager.ThisNode().GetName(),
-		Members:     cluster.Manager.MemberList(false, false),
+		Members:     members,
+		PeerHealth:  cluster.PeerHealthStatuses(members),
 	}
 	response.Write(ctx, response.NewJson(200, status, ""))
 }
@@ -339,6 +354,10 @@ func (s *Server) indexList(ctx *middleware.Context, req models.IndexList) {
 }
 
 func (s *Server) getData(ctx *middleware.Context, request models.GetData) {
+	if strings.Contains(ctx.Req.Header.Get("Accept"), models.GetDataStreamContentType) {
+		s.getDataStream(ctx, request)
+		return
+	}
 	series, err := s.getTargetsLocal(ctx.Req.Context(), request.Requests)
 	if err != nil {
 		// the only errors returned are from us catching panics, so we should treat them
@@ -350,6 +369,29 @@ func (s *Server) getData(ctx *middleware.Context, request models.GetData) {
 	response.Write(ctx, response.NewMsgp(200, &models.GetDataResp{Series: series}))
 }
 
+// getDataStream streams the series of the requests as they are ready.
+// once the stream has started, errors can no longer be sent as status code, so they are sent in the error frame instead.
+// if the coordinating node cancels the request, the request context is canceled, which aborts the requests.
+func (s *Server) getDataStream(ctx *middleware.Context, request models.GetData) {
+	ctx.Resp.Header().Set("Content-Type", models.GetDataStreamContentType)
+	ctx.Resp.WriteHeader(200)
+	w := models.NewGetDataStreamWriter(ctx.Resp)
+	err := s.getTargetsLocalEach(ctx.Req.Context(), request.Requests, func(series models.Series) error {
+		return w.WriteSeries(&series)
+	})
+	if err != nil {
+		if ctx.Req.Context().Err() != nil {
+			log.Debugf("HTTP getData() stream canceled: %s", err.Error())
+			return
+		}
+		log.Errorf("HTTP getData() %s", err.Error())
+		rErr := response.WrapError(err)
+		w.WriteError(rErr.Code(), rErr)
+		return
+	}
+	w.WriteEnd()
+}
+
 func (s *Server) indexDelete(ctx *middleware.Context, req models.IndexDelete) {
 
 	// nothing to do on query nodes.
@@ -460,6 +502,8 @@ func (s *Server) peerQuerySpeculative(ctx context.Context, data cluster.Traceabl
 // across the cluster. If any peer fails requests to other peers are aborted. If enough
 // peers have been heard from (based on speculation-threshold configuration), and we
 // are missing the others, try to speculatively query other members of the shard group.
+// If a peer takes longer than usual to respond (based on hedge-percentile configuration),
+// the next member of its shard group that wasn't queried yet is queried as well.
 // ctx:          request context
 // data:         request to be submitted
 // name:         name to be used in logging & tracing
@@ -491,9 +535,33 @@ func (s *Server) peerQuerySpeculativeChan(ctx context.Context, data cluster.Trac
 		}
 
 		responses := make(chan response)
+		askedPeers := make(map[string]struct{})
 		originalPeers := make(map[string]struct{}, len(peerGroups))
 		receivedResponses := make(map[int32]struct{}, len(peerGroups))
 
+		hedged := make(map[string]struct{})
+		hedges := make(chan int32)
+		hedge := func(shardGroup int32, peer cluster.Node) {
+			delay := cluster.GetPeerHealth(peer.GetName()).Percentile(hedgePercentile)
+			if delay == 0 {
+				// we don't know how fast this peer usually is
+				return
+			}
+			if delay < hedgeMinDelay {
+				delay = hedgeMinDelay
+			}
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-reqCtx.Done():
+			case <-timer.C:
+				select {
+				case <-reqCtx.Done():
+				case hedges <- shardGroup:
+				}
+			}
+		}
+
 		askPeer := func(shardGroup int32, peer cluster.Node) {
 			log.Debugf("HTTP Render querying %s%s", peer.GetName(), path)
 			buf, err := peer.Post(reqCtx, name, path, data)
@@ -513,7 +581,11 @@ func (s *Server) peerQuerySpeculativeChan(ctx context.Context, data cluster.Trac
 		for group, peers := range peerGroups {
 			peer := peers[0]
 			originalPeers[peer.GetName()] = struct{}{}
+			askedPeers[peer.GetName()] = struct{}{}
 			go askPeer(group, peer)
+			if hedgePercentile > 0 && len(peers) > 1 {
+				go hedge(group, peer)
+			}
 		}
 
 		var ticker *time.Ticker
@@ -543,6 +615,25 @@ func (s *Server) peerQuerySpeculativeChan(ctx context.Context, data cluster.Trac
 				resultChan <- resp.data
 				receivedResponses[resp.shardGroup] = struct{}{}
 				delete(originalPeers, resp.data.peer.GetName())
+				if _, ok := hedged[resp.data.peer.GetName()]; ok {
+					// the hedge won, rather than speculation
+					delete(originalPeers, peerGroups[resp.shardGroup][0].GetName())
+					hedgedWins.Inc()
+				}
+
+			case shardGroup := <-hedges:
+				if _, ok := receivedResponses[shardGroup]; ok {
+					continue
+				}
+				peer := nextPeer(peerGroups[shardGroup], askedPeers)
+				if peer == nil {
+					// speculation already asked all of them
+					continue
+				}
+				hedgedRequests.Inc()
+				hedged[peer.GetName()] = struct{}{}
+				askedPeers[peer.GetName()] = struct{}{}
+				go askPeer(shardGroup, peer)
 
 			case <-tickChan:
 				// Check if it's time to speculate!
@@ -555,9 +646,13 @@ func (s *Server) peerQuerySpeculativeChan(ctx context.Context, data cluster.Trac
 						if _, ok := receivedResponses[shardGroup]; ok {
 							continue
 						}
-						eligiblePeers := peers[1:]
-						for _, peer := range eligiblePeers {
+						for _, peer := range peers {
+							if _, ok := askedPeers[peer.GetName()]; ok {
+								// the original peer, or a hedge
+								continue
+							}
 							speculativeRequests.Inc()
+							askedPeers[peer.GetName()] = struct{}{}
 							go askPeer(shardGroup, peer)
 						}
 					}
@@ -573,6 +668,16 @@ func (s *Server) peerQuerySpeculativeChan(ctx context.Context, data cluster.Trac
 	return resultChan, errorChan
 }
 
+// nextPeer returns the first of the given peers that was not asked yet, or nil if all of them were
+func nextPeer(peers []cluster.Node, asked map[string]struct{}) cluster.Node {
+	for _, peer := range peers {
+		if _, ok := asked[peer.GetName()]; !ok {
+			return peer
+		}
+	}
+	return nil
+}
+
 func (s *Server) indexMetaTagRecordUpsert(ctx *middleware.Context, req models.IndexMetaTagRecordUpsert) {
 	if s.MetricIndex == nil {
 		response.Write(ctx, response.NewMsgp(200, &models.MetaTagRecordUpsertResult{}))
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/cluster"
+)
+
+func TestNextPeer(t *testing.T) {
+	peers := []cluster.Node{
+		cluster.NewMockNode(false, "a", []int32{0}, nil),
+		cluster.NewMockNode(false, "b", []int32{0}, nil),
+		cluster.NewMockNode(false, "c", []int32{0}, nil),
+	}
+	cases := []struct {
+		asked []string
+		exp   string
+	}{
+		{[]string{"a"}, "b"},
+		// e.g. speculation already asked b
+		{[]string{"a", "b"}, "c"},
+		{[]string{"b"}, "a"},
+		{[]string{"a", "b", "c"}, ""},
+	}
+	for i, c := range cases {
+		asked := make(map[string]struct{})
+		for _, name := range c.asked {
+			asked[name] = struct{}{}
+		}
+		var got string
+		if peer := nextPeer(peers, asked); peer != nil {
+			got = peer.GetName()
+		}
+		if got != c.exp {
+			t.Fatalf("case %d: expected peer %q, got %q", i, c.exp, got)
+		}
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"net"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/cluster/partitioner"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -28,9 +30,28 @@ var (
 	getTargetsConcurrency int
 	tagdbDefaultLimit     uint
 	speculationThreshold  float64
+	hedgePercentile       float64
+	hedgeMinDelay         time.Duration
+	getDataStream         bool
+	promNativeEngine      bool
+	cachePeers            bool
+	cachePeersTimeout     time.Duration
 
-	graphiteProxy *httputil.ReverseProxy
-	timeZone      *time.Location
+	renderCacheSize    int
+	renderCacheMaxAge  time.Duration
+	renderCacheOverlap time.Duration
+
+	importEnabled         bool
+	importMaxPoints       int
+	importPartitionHash   string
+	importPartitionScheme string
+	importPartitionTags   string
+	importNumPartitions   int
+
+	graphiteProxy     *httputil.ReverseProxy
+	timeZone          *time.Location
+	renderCache       *resultCache
+	importPartitioner partitioner.Partitioner
 )
 
 func ConfigSetup() {
@@ -49,6 +70,21 @@ func ConfigSetup() {
 	apiCfg.IntVar(&getTargetsConcurrency, "get-targets-concurrency", 20, "maximum number of concurrent threads for fetching data on the local node. Each thread handles a single series.")
 	apiCfg.UintVar(&tagdbDefaultLimit, "tagdb-default-limit", 100, "default limit for tagdb query results, can be overridden with query parameter \"limit\"")
 	apiCfg.Float64Var(&speculationThreshold, "speculation-threshold", 1, "ratio of peer responses after which speculation is used. Set to 1 to disable.")
+	apiCfg.Float64Var(&hedgePercentile, "hedge-percentile", 0, "when a peer hasn't responded within this percentile (0-100) of its recent latencies, also query another peer with the same data. e.g. 95. Set to 0 to disable.")
+	apiCfg.DurationVar(&hedgeMinDelay, "hedge-min-delay", 5*time.Millisecond, "minimum time to wait for a peer's response before hedging")
+	apiCfg.BoolVar(&getDataStream, "getdata-stream", true, "ask peers to stream the series of data requests as they are ready, rather than send them all in a single response. peers that don't support streaming yet, e.g. during a rolling upgrade, send a single response.")
+	apiCfg.BoolVar(&promNativeEngine, "prometheus-native-engine", true, "evaluate PromQL queries natively using rollups and the cluster fan-out. Queries it does not support fall back to the upstream promql engine.")
+	apiCfg.BoolVar(&cachePeers, "chunk-cache-peers", false, "before fetching chunks that are not in the local chunk cache from the store, ask the peers that own the same partition whether they have them in their chunk cache")
+	apiCfg.DurationVar(&cachePeersTimeout, "chunk-cache-peers-timeout", 50*time.Millisecond, "how long to wait for the chunk caches of peers, before fetching the chunks from the store")
+	apiCfg.IntVar(&renderCacheSize, "render-cache-size", 0, "maximum number of render targets to cache the output of, so that subsequent requests which move the time range forward only need to compute the new data. (0 disables the cache)")
+	apiCfg.DurationVar(&renderCacheMaxAge, "render-cache-max-age", 10*time.Minute, "maximum age of cached render output. after this, targets are computed in full again, which picks up any data that arrived later than the overlap")
+	apiCfg.DurationVar(&renderCacheOverlap, "render-cache-overlap", time.Minute, "how much of the most recent cached render output to recompute on every request, to pick up data that arrived late")
+	apiCfg.BoolVar(&importEnabled, "import-enabled", false, "enable the /import endpoint, which writes posted points of any time range directly into chunks in the store, along with their rollups, and adds the series to the index")
+	apiCfg.IntVar(&importMaxPoints, "import-max-points", 10000000, "limit of number of points an /import request can contain. Requests that exceed this limit will be rejected. (0 disables limit)")
+	apiCfg.StringVar(&importPartitionHash, "import-partition-hash", "kafka", "hash used to partition the imported series for the index. This should match the settings of the ingestion pipeline. (kafka|jump)")
+	apiCfg.StringVar(&importPartitionScheme, "import-partition-scheme", "bySeries", "method used to partition the imported series for the index. This should match the settings of the ingestion pipeline. (byOrg|bySeries|byTag, byTag requires the jump partition-hash)")
+	apiCfg.StringVar(&importPartitionTags, "import-partition-tags", "", "comma separated list of tags to partition the imported series by, when the import-partition-scheme is byTag")
+	apiCfg.IntVar(&importNumPartitions, "import-num-partitions", 1, "number of partitions of the ingestion pipeline, to partition the imported series for the index")
 	globalconf.Register("http", apiCfg, flag.ExitOnError)
 }
 
@@ -65,6 +101,28 @@ func ConfigProcess() {
 	}
 	graphiteProxy = NewGraphiteProxy(u)
 
+	if hedgePercentile < 0 || hedgePercentile > 100 {
+		log.Fatal("API hedge-percentile must be between 0 and 100")
+	}
+
+	if importEnabled {
+		var tags []string
+		if importPartitionTags != "" {
+			tags = strings.Split(importPartitionTags, ",")
+		}
+		importPartitioner, err = partitioner.New(importPartitionHash, importPartitionScheme, tags)
+		if err != nil {
+			log.Fatalf("API failed to instantiate the import partitioner: %s", err.Error())
+		}
+		if importNumPartitions < 1 {
+			log.Fatal("API import-num-partitions must be at least 1")
+		}
+	}
+
+	if renderCacheSize > 0 {
+		renderCache = newResultCache(renderCacheSize, renderCacheMaxAge, renderCacheOverlap)
+	}
+
 	if timeZoneStr == "local" {
 		timeZone = time.Local
 	} else {
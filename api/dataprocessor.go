@@ -4,14 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
+	"net/http"
 	"runtime"
 	"sync"
 	"time"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/mdata/chunk/tsz"
 	"github.com/grafana/metrictank/tracing"
 	"github.com/grafana/metrictank/util"
@@ -220,22 +226,20 @@ func (s *Server) getTargetsRemote(ctx context.Context, remoteReqs map[string][]m
 		go func(reqs []models.Req) {
 			defer wg.Done()
 			node := reqs[0].Node
-			buf, err := node.Post(rCtx, "getTargetsRemote", "/getdata", models.GetData{Requests: reqs})
-			if err != nil {
-				cancel()
-				responses <- getTargetsResp{nil, err}
-				return
+			var series []models.Series
+			var err error
+			if getDataStream {
+				series, err = getDataRemoteStream(rCtx, node, reqs)
+			} else {
+				series, err = getDataRemote(rCtx, node, reqs)
 			}
-			var resp models.GetDataResp
-			_, err = resp.UnmarshalMsg(buf)
 			if err != nil {
 				cancel()
-				log.Errorf("DP getTargetsRemote: error unmarshaling body from %s/getdata: %q", node.GetName(), err.Error())
 				responses <- getTargetsResp{nil, err}
 				return
 			}
-			log.Debugf("DP getTargetsRemote: %s returned %d series", node.GetName(), len(resp.Series))
-			responses <- getTargetsResp{resp.Series, nil}
+			log.Debugf("DP getTargetsRemote: %s returned %d series", node.GetName(), len(series))
+			responses <- getTargetsResp{series, nil}
 		}(nodeReqs)
 	}
 
@@ -256,8 +260,82 @@ func (s *Server) getTargetsRemote(ctx context.Context, remoteReqs map[string][]m
 	return out, nil
 }
 
+// getDataRemote gets the series of the requests from a peer, in a single response
+func getDataRemote(ctx context.Context, node cluster.Node, reqs []models.Req) ([]models.Series, error) {
+	buf, err := node.Post(ctx, "getTargetsRemote", "/getdata", models.GetData{Requests: reqs})
+	if err != nil {
+		return nil, err
+	}
+	var resp models.GetDataResp
+	_, err = resp.UnmarshalMsg(buf)
+	if err != nil {
+		log.Errorf("DP getTargetsRemote: error unmarshaling body from %s/getdata: %q", node.GetName(), err.Error())
+		return nil, err
+	}
+	return resp.Series, nil
+}
+
+// getDataRemoteStream gets the series of the requests from a peer, which streams them as they are ready.
+// peers that don't support streaming yet reply with a single response, which is read instead.
+func getDataRemoteStream(ctx context.Context, node cluster.Node, reqs []models.Req) ([]models.Series, error) {
+	body, contentType, err := node.PostStream(ctx, "getTargetsRemote", "/getdata", models.GetData{Requests: reqs}, models.GetDataStreamContentType)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	if contentType != models.GetDataStreamContentType {
+		getDataUnstreamed.Inc()
+		buf, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, response.NewError(http.StatusServiceUnavailable, fmt.Sprintf("error reading body from %s/getdata: %s", node.GetName(), err.Error()))
+		}
+		var resp models.GetDataResp
+		_, err = resp.UnmarshalMsg(buf)
+		if err != nil {
+			log.Errorf("DP getTargetsRemote: error unmarshaling body from %s/getdata: %q", node.GetName(), err.Error())
+			return nil, err
+		}
+		return resp.Series, nil
+	}
+	getDataStreamed.Inc()
+	var out []models.Series
+	r := models.NewGetDataStreamReader(body)
+	for {
+		series, err := r.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			if e, ok := err.(*models.GetDataStreamError); ok {
+				return nil, response.NewError(e.Code, e.Err)
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Errorf("DP getTargetsRemote: error reading stream from %s/getdata: %q", node.GetName(), err.Error())
+			return nil, response.NewError(http.StatusServiceUnavailable, fmt.Sprintf("error reading stream from %s/getdata: %s", node.GetName(), err.Error()))
+		}
+		out = append(out, series)
+	}
+}
+
 // error is the error of the first failing target request
 func (s *Server) getTargetsLocal(ctx context.Context, reqs []models.Req) ([]models.Series, error) {
+	out := make([]models.Series, 0, len(reqs))
+	err := s.getTargetsLocalEach(ctx, reqs, func(series models.Series) error {
+		out = append(out, series)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("DP getTargetsLocal: %d series found locally", len(out))
+	return out, nil
+}
+
+// getTargetsLocalEach is like getTargetsLocal, but calls each with every series as soon as it is ready.
+// if each returns an error, all other requests are canceled and the error is returned.
+func (s *Server) getTargetsLocalEach(ctx context.Context, reqs []models.Req, each func(models.Series) error) error {
 	log.Debugf("DP getTargetsLocal: handling %d reqs locally", len(reqs))
 	responses := make(chan getTargetsResp, len(reqs))
 
@@ -266,57 +344,59 @@ func (s *Server) getTargetsLocal(ctx context.Context, reqs []models.Req) ([]mode
 
 	rCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-LOOP:
-	for _, req := range reqs {
-		// if there are already getDataConcurrency goroutines running, then block
-		// until a slot becomes free or our context is canceled.
-		if !reqLimiter.Acquire(rCtx) {
-			//request canceled
-			break LOOP
-		}
-		wg.Add(1)
-		go func(req models.Req) {
-			rCtx, span := tracing.NewSpan(rCtx, s.Tracer, "getTargetsLocal")
-			req.Trace(span)
-			pre := time.Now()
-			points, interval, err := s.getTarget(rCtx, req)
-			if err != nil {
-				tags.Error.Set(span, true)
-				cancel() // cancel all other requests.
-				responses <- getTargetsResp{nil, err}
-			} else {
-				getTargetDuration.Value(time.Now().Sub(pre))
-				responses <- getTargetsResp{[]models.Series{{
-					Target:       req.Target, // always simply the metric name from index
-					Datapoints:   points,
-					Interval:     interval,
-					QueryPatt:    req.Pattern, // foo.* or foo.bar whatever the etName arg was
-					QueryFrom:    req.From,
-					QueryTo:      req.To,
-					QueryCons:    req.ConsReq,
-					Consolidator: req.Consolidator,
-				}}, nil}
-			}
-			wg.Done()
-			// pop an item of our limiter so that other requests can be processed.
-			reqLimiter.Release()
-			span.Finish()
-		}(req)
-	}
+	// dispatch the requests while we consume the responses, so that series are passed on as soon as they are ready
 	go func() {
+	LOOP:
+		for _, req := range reqs {
+			// if there are already getDataConcurrency goroutines running, then block
+			// until a slot becomes free or our context is canceled.
+			if !reqLimiter.Acquire(rCtx) {
+				//request canceled
+				break LOOP
+			}
+			wg.Add(1)
+			go func(req models.Req) {
+				rCtx, span := tracing.NewSpan(rCtx, s.Tracer, "getTargetsLocal")
+				req.Trace(span)
+				pre := time.Now()
+				points, interval, err := s.getTarget(rCtx, req)
+				if err != nil {
+					tags.Error.Set(span, true)
+					cancel() // cancel all other requests.
+					responses <- getTargetsResp{nil, err}
+				} else {
+					getTargetDuration.Value(time.Now().Sub(pre))
+					responses <- getTargetsResp{[]models.Series{{
+						Target:       req.Target, // always simply the metric name from index
+						Datapoints:   points,
+						Interval:     interval,
+						QueryPatt:    req.Pattern, // foo.* or foo.bar whatever the etName arg was
+						QueryFrom:    req.From,
+						QueryTo:      req.To,
+						QueryCons:    req.ConsReq,
+						Consolidator: req.Consolidator,
+					}}, nil}
+				}
+				wg.Done()
+				// pop an item of our limiter so that other requests can be processed.
+				reqLimiter.Release()
+				span.Finish()
+			}(req)
+		}
 		wg.Wait()
 		close(responses)
 	}()
-	out := make([]models.Series, 0, len(reqs))
 	for resp := range responses {
 		if resp.err != nil {
-			return nil, resp.err
+			return resp.err
+		}
+		for _, series := range resp.series {
+			if err := each(series); err != nil {
+				return err
+			}
 		}
-		out = append(out, resp.series...)
 	}
-	log.Debugf("DP getTargetsLocal: %d series found locally", len(out))
-	return out, nil
-
+	return nil
 }
 
 func (s *Server) getTarget(ctx context.Context, req models.Req) (points []schema.Point, interval uint32, err error) {
@@ -563,19 +643,47 @@ func (s *Server) getSeriesCachedStore(ctx *requestContext, until uint32) ([]tsz.
 	// the request cannot completely be served from cache, it will require store involvement
 	if !cacheRes.Complete {
 		if cacheRes.From != cacheRes.Until {
-			storeIterGens, err := s.BackendStore.Search(ctx.ctx, ctx.AMKey, ctx.Req.TTL, cacheRes.From, cacheRes.Until)
-			if err != nil {
-				return iters, err
+			// the chunks of the peers and the store, in chronological order
+			var itgens []chunk.IterGen
+			from, until := cacheRes.From, cacheRes.Until
+			var peerEnd []chunk.IterGen
+
+			if cachePeers {
+				peerRes := s.ccacheSearchPeers(ctx.ctx, ctx.AMKey, from, until)
+				if peerRes == nil {
+					ccachePeersMiss.Inc()
+				} else {
+					ccachePeersHit.Inc()
+					ccachePeersChunks.Add(len(peerRes.Start) + len(peerRes.End))
+					itgens = append(itgens, peerRes.Start...)
+					// the End slice is in reverse order
+					for i := len(peerRes.End) - 1; i >= 0; i-- {
+						peerEnd = append(peerEnd, peerRes.End[i])
+					}
+					from, until = peerRes.From, peerRes.Until
+					if peerRes.Complete {
+						from = until
+					}
+				}
 			}
-			// check to see if the request has been canceled, if so abort now.
-			select {
-			case <-ctx.ctx.Done():
-				//request canceled
-				return iters, nil
-			default:
+
+			if from != until {
+				storeIterGens, err := s.BackendStore.Search(ctx.ctx, ctx.AMKey, ctx.Req.TTL, from, until)
+				if err != nil {
+					return iters, err
+				}
+				// check to see if the request has been canceled, if so abort now.
+				select {
+				case <-ctx.ctx.Done():
+					//request canceled
+					return iters, nil
+				default:
+				}
+				itgens = append(itgens, storeIterGens...)
 			}
+			itgens = append(itgens, peerEnd...)
 
-			for i, itgen := range storeIterGens {
+			for i, itgen := range itgens {
 				iter, err := itgen.Get()
 				if err != nil {
 					// TODO(replay) figure out what to do if one piece is corrupt
@@ -583,7 +691,7 @@ func (s *Server) getSeriesCachedStore(ctx *requestContext, until uint32) ([]tsz.
 					tracing.Errorf(span, "itergen: error getting iter from store slice %+v", err)
 					if i > 0 {
 						// add all the iterators that are in good shape
-						s.Cache.AddRange(ctx.AMKey, prevts, storeIterGens[:i])
+						s.Cache.AddRange(ctx.AMKey, prevts, itgens[:i])
 					}
 					return iters, err
 				}
@@ -591,7 +699,7 @@ func (s *Server) getSeriesCachedStore(ctx *requestContext, until uint32) ([]tsz.
 			}
 			// it's important that the itgens get added in chronological order,
 			// currently we rely on store returning results in order
-			s.Cache.AddRange(ctx.AMKey, prevts, storeIterGens)
+			s.Cache.AddRange(ctx.AMKey, prevts, itgens)
 		}
 
 		// the End slice is in reverse order
@@ -1,13 +1,20 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
 	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/consolidation"
@@ -16,6 +23,7 @@ import (
 	"github.com/grafana/metrictank/mdata/cache/accnt"
 	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/test"
+	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/raintank/schema"
 )
 
@@ -753,6 +761,68 @@ func TestGetSeriesAggMetrics(t *testing.T) {
 	}
 }
 
+// blockingMetrics is a Metrics that blocks lookups of all but the first series until the first series has been emitted,
+// or a timeout expires
+type blockingMetrics struct {
+	mdata.Metrics
+	first    schema.MKey
+	emitted  chan struct{}
+	timedOut int32
+}
+
+func (m *blockingMetrics) Get(key schema.MKey) (mdata.Metric, bool) {
+	if key != m.first {
+		select {
+		case <-m.emitted:
+		case <-time.After(time.Second):
+			atomic.StoreInt32(&m.timedOut, 1)
+		}
+	}
+	return m.Metrics.Get(key)
+}
+
+// TestGetTargetsLocalEachStreams tests that series are passed on while the remaining requests are still waiting for the limiter
+func TestGetTargetsLocalEachStreams(t *testing.T) {
+	defer func(orig int) { getTargetsConcurrency = orig }(getTargetsConcurrency)
+	getTargetsConcurrency = 1
+
+	store := mdata.NewMockStore()
+	mdata.SetSingleAgg(conf.Avg)
+	mdata.SetSingleSchema(conf.NewRetentionMT(10, 100, 600, 10, 0))
+	metrics := mdata.NewAggMetrics(store, &cache.MockCache{}, false, 0, 0, 0)
+	blocking := &blockingMetrics{Metrics: metrics, first: test.GetMKey(0), emitted: make(chan struct{})}
+	srv, _ := NewServer()
+	srv.BindBackendStore(store)
+	srv.BindMemoryStore(blocking)
+
+	var reqs []models.Req
+	for i := 0; i < 4; i++ {
+		metric := metrics.GetOrCreate(test.GetMKey(i), 0, 0, 10)
+		metric.Add(10, 1)
+		metric.Add(20, 2)
+		metric.Add(30, 3)
+		reqs = append(reqs, reqOut(test.GetMKey(i), 20, 40, 1000, 10, consolidation.Avg, 0, 0, 0, 10, 100, 10, 1))
+	}
+
+	var n int
+	err := srv.getTargetsLocalEach(test.NewContext(), reqs, func(series models.Series) error {
+		if n == 0 {
+			close(blocking.emitted)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if n != len(reqs) {
+		t.Fatalf("expected %d series, got %d", len(reqs), n)
+	}
+	if atomic.LoadInt32(&blocking.timedOut) != 0 {
+		t.Fatalf("expected the first series to be emitted before the other series were computed")
+	}
+}
+
 var dummy []schema.Point
 
 func BenchmarkFix1M(b *testing.B) {
@@ -781,3 +851,53 @@ func BenchmarkDivide1M(b *testing.B) {
 	}
 	b.SetBytes(int64(l * 12))
 }
+
+// TestGetDataRemoteStream tests reading the series from peers that stream them, and from peers that don't support streaming yet
+func TestGetDataRemoteStream(t *testing.T) {
+	cluster.Tracer = opentracing.NoopTracer{}
+	ctx := opentracing.ContextWithSpan(context.Background(), cluster.Tracer.StartSpan("test"))
+	series := []models.Series{
+		{Target: "a", Tags: map[string]string{"name": "a"}, Datapoints: []schema.Point{{Val: 1, Ts: 60}}, Interval: 60},
+		{Target: "b", Tags: map[string]string{"name": "b"}, Datapoints: []schema.Point{{Val: 2, Ts: 60}}, Interval: 60},
+	}
+	var peerErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.Header.Get("Accept"))
+		sw := models.NewGetDataStreamWriter(w)
+		for i := range series {
+			if err := sw.WriteSeries(&series[i]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if peerErr != nil {
+			sw.WriteError(http.StatusServiceUnavailable, peerErr)
+			return
+		}
+		sw.WriteEnd()
+	}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	node := cluster.HTTPNode{Name: "test-stream", RemoteAddr: addr.IP.String(), ApiPort: addr.Port, ApiScheme: "http"}
+
+	got, err := getDataRemoteStream(ctx, node, nil)
+	if err != nil || !reflect.DeepEqual(got, series) {
+		t.Fatalf("expected the streamed series %v, got %v %v", series, got, err)
+	}
+
+	peerErr = errors.New("store unavailable")
+	_, err = getDataRemoteStream(ctx, node, nil)
+	if e, ok := err.(*response.ErrorResp); !ok || e.Code() != http.StatusServiceUnavailable || e.Error() != "store unavailable" {
+		t.Fatalf("expected the error of the peer, got %v", err)
+	}
+
+	// peers that don't support streaming send a single response
+	buf, err := (&models.GetDataResp{Series: series}).MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = getDataRemoteStream(ctx, cluster.NewMockNode(false, "test-unstreamed", []int32{0}, buf), nil)
+	if err != nil || !reflect.DeepEqual(got, series) {
+		t.Fatalf("expected the series of the single response %v, got %v %v", series, got, err)
+	}
+}
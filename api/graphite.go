@@ -200,7 +200,9 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 		// as graphite needs high-res data to perform its processing.
 		mdp = 0
 	}
-	plan, err := expr.NewPlan(exprs, fromUnix, toUnix, mdp, stable, nil)
+	// getFromTo already validated the timezone
+	loc, _ := getLocation(request.FromTo.Tz)
+	plan, err := expr.NewPlan(exprs, fromUnix, toUnix, mdp, stable, loc, nil)
 	if err != nil {
 		if fun, ok := err.(expr.ErrUnknownFunction); ok {
 			if request.NoProxy {
@@ -227,7 +229,13 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 	newctx, span := tracing.NewSpan(ctx.Req.Context(), s.Tracer, "executePlan")
 	defer span.Finish()
 	ctx.Req = macaron.Request{ctx.Req.WithContext(newctx)}
-	out, meta, err := s.executePlan(ctx.Req.Context(), ctx.OrgId, plan)
+	var outs [][]models.Series
+	var meta models.RenderMeta
+	if renderCache != nil {
+		outs, meta, err = s.executePlanCached(ctx.Req.Context(), ctx.OrgId, plan)
+	} else {
+		outs, meta, err = s.executePlan(ctx.Req.Context(), ctx.OrgId, plan, 0)
+	}
 	if err != nil {
 		err := response.WrapError(err)
 		if err.Code() != http.StatusBadRequest {
@@ -247,6 +255,10 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 	default:
 	}
 
+	var out []models.Series
+	for _, o := range outs {
+		out = append(out, o...)
+	}
 	noDataPoints := true
 	for _, o := range out {
 		if len(o.Datapoints) != 0 {
@@ -313,6 +325,10 @@ func (s *Server) metricsFind(ctx *middleware.Context, request models.GraphiteFin
 			}
 		}
 	}
+	if limit := orgLimits(ctx.OrgId).MaxFindResults; limit > 0 && len(nodes) > limit {
+		response.Write(ctx, response.WrapError(errMaxFindResults(ctx.OrgId, limit)))
+		return
+	}
 
 	switch request.Format {
 	case "", "treejson", "json":
@@ -638,19 +654,25 @@ func (s *Server) metricsDeleteRemote(ctx context.Context, orgId uint32, query st
 	return resp.DeletedDefs, nil
 }
 
-// executePlan looks up the needed data, retrieves it, and then invokes the processing
+// executePlan looks up the needed data, retrieves it, and then invokes the processing, returning the output of each target.
 // note if you do something like sum(foo.*) and all of those metrics happen to be on another node,
 // we will collect all the individual series from the peer, and then sum here. that could be optimized
-func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan) ([]models.Series, models.RenderMeta, error) {
+// alignShift extends the time range used to select the archives to read from backwards,
+// e.g. to compute the tail of a previously requested range at the same resolution as the full range.
+func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan, alignShift uint32) ([][]models.Series, models.RenderMeta, error) {
 	var meta models.RenderMeta
+	maxSeries := orgLimits(orgId).MaxSeriesPerReq
 
 	minFrom := uint32(math.MaxUint32)
 	var maxTo uint32
 	var reqs []models.Req
+	var origins []expr.Req // for each req, the expr.Req it was created for
 
 	// note that different patterns to query can have different from / to, so they require different index lookups
 	// e.g. target=movingAvg(foo.*, "1h")&target=foo.*
 	// note that in this case we fetch foo.* twice. can be optimized later
+	// the same goes for time shifted inputs, e.g. target=timeShift(foo.*, "1d")&target=foo.*
+	// identical requests have already been deduplicated by the planner, so we don't fetch those twice.
 	pre := time.Now()
 	for _, r := range plan.Reqs {
 		select {
@@ -671,7 +693,7 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 				return nil, meta, err
 			}
 
-			series, err = s.clusterFindByTag(ctx, orgId, exprs, int64(r.From), maxSeriesPerReq-len(reqs))
+			series, err = s.clusterFindByTag(ctx, orgId, exprs, int64(r.From), maxSeries-len(reqs))
 		} else {
 			series, err = s.findSeries(ctx, orgId, []string{r.Query}, int64(r.From))
 		}
@@ -706,9 +728,14 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 					newReq := models.NewReq(
 						archive.Id, archive.NameWithTags(), r.Query, r.From, r.To, plan.MaxDataPoints, uint32(archive.Interval), cons, consReq, s.Node, archive.SchemaId, archive.AggId)
 					reqs = append(reqs, newReq)
+					origins = append(origins, r)
 				}
 			}
 		}
+		// series found by tag are already limited by clusterFindByTag, but those found by pattern are not
+		if maxSeries > 0 && len(reqs) > maxSeries {
+			return nil, meta, errMaxSeriesPerReq(orgId, maxSeries)
+		}
 	}
 
 	meta.Stats.ResolveSeriesDuration = time.Since(pre)
@@ -728,12 +755,26 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 	meta.Stats.SeriesFetch = uint32(len(reqs))
 
 	// note: if 1 series has a movingAvg that requires a long time range extension, it may push other reqs into another archive. can be optimized later
+	// note: lookbacks are only applied after alignment, so they are not taken into account for the archive selection.
 	var err error
-	reqs, meta.Stats.PointsFetch, meta.Stats.PointsReturn, err = alignRequests(uint32(time.Now().Unix()), minFrom, maxTo, reqs)
+	if alignShift > 0 {
+		alignShift = util.Min(alignShift, minFrom)
+		for i := range reqs {
+			reqs[i].From -= util.Min(alignShift, reqs[i].From)
+		}
+	}
+	reqs, meta.Stats.PointsFetch, meta.Stats.PointsReturn, err = alignRequests(orgId, uint32(time.Now().Unix()), minFrom-alignShift, maxTo, reqs)
+	if alignShift > 0 {
+		for i := range reqs {
+			reqs[i].From = origins[i].From
+		}
+	}
 	if err != nil {
 		log.Errorf("HTTP Render alignReq error: %s", err.Error())
 		return nil, meta, err
 	}
+	reqsFor := applyLookbacks(reqs, origins)
+
 	span := opentracing.SpanFromContext(ctx)
 	span.SetTag("num_reqs", len(reqs))
 	span.SetTag("points_fetch", meta.Stats.PointsFetch)
@@ -760,7 +801,9 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 	data := make(map[expr.Req][]models.Series)
 	for _, serie := range out {
 		q := expr.NewReq(serie.QueryPatt, serie.QueryFrom, serie.QueryTo, serie.QueryCons)
-		data[q] = append(data[q], serie)
+		for _, origin := range reqsFor[q] {
+			data[origin] = append(data[origin], serie)
+		}
 	}
 
 	// Sort each merged series so that the output of a function is well-defined and repeatable.
@@ -770,10 +813,40 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 	meta.Stats.PrepareSeriesDuration = time.Since(b)
 
 	preRun := time.Now()
-	out, err = plan.Run(data)
+	outs, err := plan.RunTargets(data)
 	meta.Stats.PlanRunDuration = time.Since(preRun)
 	planRunDuration.Value(meta.Stats.PlanRunDuration)
-	return out, meta, err
+	return outs, meta, err
+}
+
+// applyLookbacks extends the time range of the requests that need a number of points before their from as well,
+// e.g. for movingAverage(foo, 10), now that alignment has determined the interval of the data they will return.
+// origins holds the expr.Req each req was created for.
+// the returned series will have the extended from as QueryFrom, so the returned map ties the query of the
+// (potentially extended) requests back to the expr.Reqs they belong to.
+func applyLookbacks(reqs []models.Req, origins []expr.Req) map[expr.Req][]expr.Req {
+	reqsFor := make(map[expr.Req][]expr.Req)
+	for i := range reqs {
+		req := &reqs[i]
+		origin := origins[i]
+		if origin.Lookback > 0 {
+			req.From -= util.Min(req.From, origin.Lookback*req.OutInterval)
+		}
+		q := expr.NewReq(req.Pattern, req.From, req.To, req.ConsReq)
+		if !containsReq(reqsFor[q], origin) {
+			reqsFor[q] = append(reqsFor[q], origin)
+		}
+	}
+	return reqsFor
+}
+
+func containsReq(reqs []expr.Req, req expr.Req) bool {
+	for _, r := range reqs {
+		if r == req {
+			return true
+		}
+	}
+	return false
 }
 
 // getTagQueryExpressions takes a query string which includes multiple tag query expressions
@@ -982,7 +1055,7 @@ func (s *Server) graphiteTagFindSeries(ctx *middleware.Context, request models.G
 		return
 	}
 
-	series, err := s.clusterFindByTag(reqCtx, ctx.OrgId, expressions, request.From, maxSeriesPerReq)
+	series, err := s.clusterFindByTag(reqCtx, ctx.OrgId, expressions, request.From, orgLimits(ctx.OrgId).MaxSeriesPerReq)
 	if err != nil {
 		response.Write(ctx, response.WrapError(err))
 		return
@@ -1004,6 +1077,7 @@ func (s *Server) graphiteTagFindSeries(ctx *middleware.Context, request models.G
 
 func (s *Server) clusterFindByTag(ctx context.Context, orgId uint32, expressions tagquery.Expressions, from int64, maxSeries int) ([]Series, error) {
 	data := models.IndexFindByTag{OrgId: orgId, Expr: expressions.Strings(), From: from}
+	limit := orgLimits(orgId).MaxSeriesPerReq
 	newCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	responseChan, errorChan := s.peerQuerySpeculativeChan(newCtx, data, "clusterFindByTag", "/index/find_by_tag")
@@ -1017,12 +1091,9 @@ func (s *Server) clusterFindByTag(ctx context.Context, orgId uint32, expressions
 			return nil, err
 		}
 
-		// 0 disables the check, so only check if maxSeriesPerReq > 0
-		if maxSeriesPerReq > 0 && len(resp.Metrics)+len(allSeries) > maxSeries {
-			return nil,
-				response.NewError(
-					http.StatusRequestEntityTooLarge,
-					fmt.Sprintf("Request exceeds max-series-per-req limit (%d). Reduce the number of targets or ask your admin to increase the limit.", maxSeriesPerReq))
+		// 0 disables the check, so only check if the max-series-per-req limit > 0
+		if limit > 0 && len(resp.Metrics)+len(allSeries) > maxSeries {
+			return nil, errMaxSeriesPerReq(orgId, limit)
 		}
 
 		for _, series := range resp.Metrics {
@@ -1292,7 +1363,9 @@ func (s *Server) showPlan(ctx *middleware.Context, request models.GraphiteRender
 	stable := request.Process == "stable"
 	mdp := request.MaxDataPoints
 
-	plan, err := expr.NewPlan(exprs, fromUnix, toUnix, mdp, stable, nil)
+	// getFromTo already validated the timezone
+	loc, _ := getLocation(request.FromTo.Tz)
+	plan, err := expr.NewPlan(exprs, fromUnix, toUnix, mdp, stable, loc, nil)
 	if err != nil {
 		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
 		return
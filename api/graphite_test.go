@@ -3,6 +3,9 @@ package api
 import (
 	"reflect"
 	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/expr"
 )
 
 func TestExpressionParsing(t *testing.T) {
@@ -132,3 +135,36 @@ func TestExpressionParsing(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyLookbacks(t *testing.T) {
+	plain := expr.NewReq("foo.*", 1000, 2000, 0)
+	moving := expr.NewReq("foo.*", 1000, 2000, 0)
+	moving.Lookback = 10
+
+	newReq := func(from, outInterval uint32) models.Req {
+		return models.Req{Pattern: "foo.*", From: from, To: 2000, OutInterval: outInterval}
+	}
+	reqs := []models.Req{
+		newReq(1000, 10),
+		newReq(1000, 10),
+		newReq(1000, 60),
+		newReq(1000, 600),
+	}
+	origins := []expr.Req{plain, moving, moving, moving}
+
+	reqsFor := applyLookbacks(reqs, origins)
+	for i, exp := range []uint32{1000, 900, 400, 0} {
+		if reqs[i].From != exp {
+			t.Fatalf("req %d: expected from %d, got %d", i, exp, reqs[i].From)
+		}
+	}
+	exp := map[expr.Req][]expr.Req{
+		plain:                              {plain},
+		expr.NewReq("foo.*", 900, 2000, 0): {moving},
+		expr.NewReq("foo.*", 400, 2000, 0): {moving},
+		expr.NewReq("foo.*", 0, 2000, 0):   {moving},
+	}
+	if !reflect.DeepEqual(reqsFor, exp) {
+		t.Fatalf("expected %v, got %v", exp, reqsFor)
+	}
+}
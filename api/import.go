@@ -0,0 +1,240 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/metrictank/api/middleware"
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/importer"
+	"github.com/grafana/metrictank/stats"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+	"github.com/tinylib/msgp/msgp"
+)
+
+var (
+	// metric api.import.series is the number of series written via the /import endpoint
+	importSeries = stats.NewCounter32("api.import.series")
+	// metric api.import.points is the number of points written via the /import endpoint
+	importPoints = stats.NewCounter32("api.import.points")
+	// metric api.import.chunks is the number of chunks, raw and rollups, written via the /import endpoint
+	importChunks = stats.NewCounter32("api.import.chunks")
+)
+
+// importSeriesData is the data of a series in an /import request
+type importSeriesData struct {
+	md     schema.MetricData
+	mkey   schema.MKey
+	points []schema.Point
+}
+
+// importData writes the posted points directly into chunks in the store, along with their rollups
+// according to the storage-schemas and storage-aggregation rules, and adds the series to the index.
+// unlike the ingestion of live data, this works for any time range: the points are merged into the chunks
+// that already exist in the store, replacing stored points with the same timestamp, and the rollup points
+// of their buckets are recomputed. note that the live data overwrites imported chunks it overlaps with.
+// only series of the partitions this node owns can be imported, as they are added to the index of this node.
+// the points are posted as a json array of MetricData (application/json), a msgp encoded MetricDataArray (application/x-msgpack)
+// or a snappy compressed prometheus remote write request (application/x-protobuf). as remote write requests
+// don't specify the interval, the interval query parameter is used for them. (default 15)
+func (s *Server) importData(ctx *middleware.Context) {
+	if s.BackendStore == nil || s.MetricIndex == nil {
+		response.Write(ctx, response.NewError(http.StatusServiceUnavailable, "this node has no store or index to import into"))
+		return
+	}
+	data, err := decodeImport(ctx)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	series := make(map[schema.MKey]*importSeriesData)
+	var keys []schema.MKey
+	var points int
+	for _, md := range data {
+		md.OrgId = int(ctx.OrgId)
+		md.SetId()
+		if err := md.Validate(); err != nil {
+			response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("invalid metric %q: %s", md.Name, err)))
+			return
+		}
+		if md.Time <= 0 || md.Time >= math.MaxInt32 {
+			response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("invalid metric %q: time %d out of range", md.Name, md.Time)))
+			return
+		}
+		mkey, err := schema.MKeyFromString(md.Id)
+		if err != nil {
+			response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("invalid metric %q: %s", md.Name, err)))
+			return
+		}
+		sd, ok := series[mkey]
+		if !ok {
+			sd = &importSeriesData{md: *md, mkey: mkey}
+			series[mkey] = sd
+			keys = append(keys, mkey)
+		}
+		sd.points = append(sd.points, schema.Point{Val: md.Value, Ts: uint32(md.Time)})
+		points++
+	}
+	if importMaxPoints > 0 && points > importMaxPoints {
+		response.Write(ctx, response.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("request contains %d points, which exceeds the limit of %d", points, importMaxPoints)))
+		return
+	}
+
+	resp := models.ImportResp{
+		Series: len(keys),
+		Points: points,
+	}
+	// the series are indexed by the nodes that consume their partition. we can only add them to our own index,
+	// so reject series of partitions we don't own before writing anything
+	owned := make(map[int32]struct{})
+	for _, part := range cluster.Manager.GetPartitions() {
+		owned[part] = struct{}{}
+	}
+	partitions := make(map[schema.MKey]int32)
+	for _, mkey := range keys {
+		sd := series[mkey]
+		partition, err := importPartitioner.Partition(&sd.md, int32(importNumPartitions))
+		if err != nil {
+			response.Write(ctx, response.NewError(http.StatusInternalServerError, fmt.Sprintf("failed to partition metric %q: %s", sd.md.Name, err)))
+			return
+		}
+		if _, ok := owned[partition]; !ok {
+			response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("metric %q belongs to partition %d, which this node doesn't own. import it via a node that does", sd.md.Name, partition)))
+			return
+		}
+		partitions[mkey] = partition
+	}
+
+	reqs := make([]*importer.ArchiveRequest, 0, len(keys))
+	for _, mkey := range keys {
+		sd := series[mkey]
+		ar, err := importer.NewArchiveRequestFromPoints(ctx.Req.Context(), sd.md, sd.points, mdata.Schemas, mdata.Aggregations, s.BackendStore)
+		if err != nil {
+			response.Write(ctx, response.NewError(http.StatusInternalServerError, fmt.Sprintf("failed to merge metric %q with its stored chunks: %s", sd.md.Name, err)))
+			return
+		}
+		reqs = append(reqs, ar)
+	}
+
+	var wg sync.WaitGroup
+	for i, mkey := range keys {
+		for _, cwr := range reqs[i].ChunkWriteRequests {
+			wg.Add(1)
+			cwrWithOrg := cwr.GetChunkWriteRequest(wg.Done, mkey)
+			s.BackendStore.Add(&cwrWithOrg)
+		}
+		resp.Chunks += len(reqs[i].ChunkWriteRequests)
+	}
+	wg.Wait()
+
+	// only index the series once all their chunks have been saved
+	for i, mkey := range keys {
+		md := &reqs[i].MetricData
+		// don't move the last update of series back in time, if they're already known
+		if archive, ok := s.MetricIndex.Get(mkey); !ok || archive.LastUpdate < md.Time {
+			s.MetricIndex.AddOrUpdate(mkey, md, partitions[mkey])
+		}
+	}
+
+	// the chunk cache may hold the chunks we rewrote
+	if s.Cache != nil {
+		for _, mkey := range keys {
+			s.Cache.DelMetric(mkey)
+		}
+	}
+	importSeries.Add(resp.Series)
+	importPoints.Add(resp.Points)
+	importChunks.Add(resp.Chunks)
+	log.Debugf("HTTP importData: wrote %d chunks for %d points of %d series", resp.Chunks, resp.Points, resp.Series)
+	response.Write(ctx, response.NewJson(http.StatusOK, resp, ""))
+}
+
+// decodeImport decodes the points of an /import request, based on its content type
+func decodeImport(ctx *middleware.Context) (schema.MetricDataArray, error) {
+	var data schema.MetricDataArray
+	body := ctx.Req.Request.Body
+	contentType := ctx.Req.Header.Get("Content-Type")
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	switch strings.TrimSpace(contentType) {
+	case "application/json":
+		err := json.NewDecoder(body).Decode(&data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode json: %s", err)
+		}
+	case "application/x-msgpack":
+		err := data.DecodeMsg(msgp.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode msgp: %s", err)
+		}
+	case "application/x-protobuf":
+		interval := ctx.QueryInt("interval")
+		if interval == 0 {
+			interval = 15
+		}
+		return decodePrometheusWrite(body, interval)
+	default:
+		return nil, fmt.Errorf("unsupported content type %q. must be one of application/json, application/x-msgpack or application/x-protobuf", contentType)
+	}
+	return data, nil
+}
+
+// decodePrometheusWrite decodes a snappy compressed prometheus remote write request
+func decodePrometheusWrite(body io.Reader, interval int) (schema.MetricDataArray, error) {
+	compressed, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %s", err)
+	}
+	buf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode request body: %s", err)
+	}
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(buf, &req); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal write request: %s", err)
+	}
+
+	var data schema.MetricDataArray
+	for _, ts := range req.Timeseries {
+		var name string
+		var tags []string
+		for _, l := range ts.Labels {
+			if l.Name == model.MetricNameLabel {
+				name = l.Value
+			} else {
+				tags = append(tags, l.Name+"="+l.Value)
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid series: %s label can not be empty", model.MetricNameLabel)
+		}
+		for _, sample := range ts.Samples {
+			data = append(data, &schema.MetricData{
+				Name:     name,
+				Interval: interval,
+				Value:    sample.Value,
+				Unit:     "unknown",
+				Time:     sample.Timestamp / 1000,
+				Mtype:    "gauge",
+				Tags:     tags,
+			})
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/cluster/partitioner"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/idx/memory"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/raintank/schema"
+)
+
+// importStore is a MockStore that confirms the chunks it saves
+type importStore struct {
+	*mdata.MockStore
+}
+
+func (s importStore) Add(cwr *mdata.ChunkWriteRequest) {
+	s.MockStore.Add(cwr)
+	if cwr.Callback != nil {
+		cwr.Callback()
+	}
+}
+
+func newImportSrv(t *testing.T) (*Server, *mdata.MockStore, *cache.MockCache) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPriority(0)
+	cluster.Manager.SetReady()
+	cluster.Manager.SetPartitions([]int32{0, 1, 2, 3, 4, 5, 6, 7})
+	importEnabled = true
+	importPartitioner, _ = partitioner.New("kafka", "bySeries", nil)
+	importNumPartitions = 8
+
+	srv, _ := NewServer()
+	srv.RegisterRoutes()
+
+	mdata.SetSingleAgg(conf.Avg, conf.Max)
+	mdata.SetSingleSchema(conf.NewRetentionMT(10, 86400, 600, 10, 0), conf.NewRetentionMT(60, 86400*7, 3600, 2, 0))
+
+	store := mdata.NewMockStore()
+	srv.BindBackendStore(importStore{store})
+	mockCache := cache.NewMockCache()
+	srv.BindCache(mockCache)
+	metricIndex := memory.New()
+	metricIndex.Init()
+	srv.BindMetricIndex(metricIndex)
+	return srv, store, mockCache
+}
+
+func TestImportJson(t *testing.T) {
+	srv, store, mockCache := newImportSrv(t)
+	defer func() { importEnabled = false }()
+	defer srv.Stop()
+
+	var data schema.MetricDataArray
+	for ts := int64(36010); ts <= 37800; ts += 10 {
+		data = append(data, &schema.MetricData{Name: "a", Interval: 10, Value: 1, Time: ts, Mtype: "gauge"})
+		data = append(data, &schema.MetricData{Name: "b", Interval: 10, Value: 2, Time: ts, Mtype: "gauge", Tags: []string{"foo=bar"}})
+	}
+	body, _ := json.Marshal(data)
+
+	ts := httptest.NewServer(srv.Macaron)
+	defer ts.Close()
+	res, err := http.Post(ts.URL+"/import", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("There was an error in the request: %s", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	var resp models.ImportResp
+	json.NewDecoder(res.Body).Decode(&resp)
+
+	// per series, 4 raw chunks and a chunk for each of sum, cnt and max
+	exp := models.ImportResp{Series: 2, Points: 360, Chunks: 14}
+	if resp != exp {
+		t.Fatalf("expected response %v, got %v", exp, resp)
+	}
+	if store.Items() != 14 {
+		t.Fatalf("expected 14 chunks in the store, got %d", store.Items())
+	}
+	if len(mockCache.DelMetricKeys) != 2 {
+		t.Fatalf("expected the chunks of 2 series to be deleted from the cache, got %d", len(mockCache.DelMetricKeys))
+	}
+
+	md := schema.MetricData{Name: "b", OrgId: 1, Interval: 10, Mtype: "gauge", Tags: []string{"foo=bar"}}
+	md.SetId()
+	mkey, _ := schema.MKeyFromString(md.Id)
+	archive, ok := srv.MetricIndex.Get(mkey)
+	if !ok {
+		t.Fatalf("expected series %s to be added to the index", md.Id)
+	}
+	if archive.LastUpdate != 37800 {
+		t.Fatalf("expected last update 37800, got %d", archive.LastUpdate)
+	}
+
+	itgens, err := store.Search(context.Background(), schema.AMKey{MKey: mkey, Archive: schema.NewArchive(schema.Cnt, 60)}, 0, 36000, 40000)
+	if err != nil || len(itgens) != 1 {
+		t.Fatalf("expected 1 cnt rollup chunk, got %d (err: %v)", len(itgens), err)
+	}
+	it, _ := itgens[0].Get()
+	var n int
+	for it.Next() {
+		_, val := it.Values()
+		if val != 6 {
+			t.Fatalf("expected rollup count 6, got %f", val)
+		}
+		n++
+	}
+	if n != 30 {
+		t.Fatalf("expected 30 rollup points, got %d", n)
+	}
+}
+
+func TestImportPrometheus(t *testing.T) {
+	srv, store, _ := newImportSrv(t)
+	defer func() { importEnabled = false }()
+	defer srv.Stop()
+
+	req := prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{{
+			Labels: []*prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "test"}},
+			Samples: []*prompb.Sample{
+				{Value: 1, Timestamp: 36060 * 1000},
+				{Value: 1, Timestamp: 36120 * 1000},
+			},
+		}},
+	}
+	buf, _ := proto.Marshal(&req)
+
+	ts := httptest.NewServer(srv.Macaron)
+	defer ts.Close()
+	res, err := http.Post(ts.URL+"/import?interval=10", "application/x-protobuf", bytes.NewReader(snappy.Encode(nil, buf)))
+	if err != nil {
+		t.Fatalf("There was an error in the request: %s", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+
+	md := schema.MetricData{Name: "up", OrgId: 1, Interval: 10, Unit: "unknown", Mtype: "gauge", Tags: []string{"job=test"}}
+	md.SetId()
+	mkey, _ := schema.MKeyFromString(md.Id)
+	if _, ok := srv.MetricIndex.Get(mkey); !ok {
+		t.Fatalf("expected series %s to be added to the index", md.Id)
+	}
+	// 1 raw chunk and a chunk for each of sum, cnt and max
+	if store.Items() != 4 {
+		t.Fatalf("expected 4 chunks in the store, got %d", store.Items())
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	srv, store, _ := newImportSrv(t)
+	defer func() { importEnabled = false }()
+	defer srv.Stop()
+
+	ts := httptest.NewServer(srv.Macaron)
+	defer ts.Close()
+
+	cases := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", "a 1 36010"},
+		{"application/json", "not json"},
+		{"application/json", `[{"name": "", "interval": 10, "value": 1, "time": 36010, "mtype": "gauge"}]`},
+	}
+	for _, c := range cases {
+		res, err := http.Post(ts.URL+"/import", c.contentType, bytes.NewReader([]byte(c.body)))
+		if err != nil {
+			t.Fatalf("There was an error in the request: %s", err)
+		}
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s %q, got %d", c.contentType, c.body, res.StatusCode)
+		}
+	}
+	if store.Items() != 0 {
+		t.Fatalf("expected nothing to be written, got %d chunks", store.Items())
+	}
+}
+
+func postImport(t *testing.T, url string, data schema.MetricDataArray) *http.Response {
+	t.Helper()
+	body, _ := json.Marshal(data)
+	res, err := http.Post(url+"/import", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("There was an error in the request: %s", err)
+	}
+	return res
+}
+
+func TestImportOverlap(t *testing.T) {
+	srv, store, _ := newImportSrv(t)
+	defer func() { importEnabled = false }()
+	defer srv.Stop()
+
+	ts := httptest.NewServer(srv.Macaron)
+	defer ts.Close()
+
+	var data schema.MetricDataArray
+	for ts := int64(36000); ts < 36600; ts += 10 {
+		data = append(data, &schema.MetricData{Name: "a", Interval: 10, Value: 1, Time: ts, Mtype: "gauge"})
+	}
+	if res := postImport(t, ts.URL, data); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	// a single point into the range we just imported
+	data = schema.MetricDataArray{{Name: "a", Interval: 10, Value: 5, Time: 36300, Mtype: "gauge"}}
+	if res := postImport(t, ts.URL, data); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+
+	md := schema.MetricData{Name: "a", OrgId: 1, Interval: 10, Mtype: "gauge"}
+	md.SetId()
+	mkey, _ := schema.MKeyFromString(md.Id)
+	raw, err := mdata.StoredPoints(context.Background(), store, schema.AMKey{MKey: mkey}, 86400, 36000, 36600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 60 {
+		t.Fatalf("expected the stored raw points to be kept, got %d points", len(raw))
+	}
+	for _, p := range raw {
+		exp := 1.0
+		if p.Ts == 36300 {
+			exp = 5
+		}
+		if p.Val != exp {
+			t.Fatalf("expected value %f at %d, got %f", exp, p.Ts, p.Val)
+		}
+	}
+
+	// the bucket the point falls in is recomputed, the others are kept
+	cases := []struct {
+		method     schema.Method
+		recomputed float64
+		kept       float64
+	}{
+		{schema.Sum, 10, 6},
+		{schema.Cnt, 6, 6},
+		{schema.Max, 5, 1},
+	}
+	for _, c := range cases {
+		rollups, err := mdata.StoredPoints(context.Background(), store, schema.AMKey{MKey: mkey, Archive: schema.NewArchive(c.method, 60)}, 86400*7, 36060, 36600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rollups) != 9 {
+			t.Fatalf("expected the stored %s rollup points to be kept, got %d points", c.method, len(rollups))
+		}
+		for _, p := range rollups {
+			exp := c.kept
+			if p.Ts == 36300 {
+				exp = c.recomputed
+			}
+			if p.Val != exp {
+				t.Fatalf("expected %s rollup %f at %d, got %f", c.method, exp, p.Ts, p.Val)
+			}
+		}
+	}
+}
+
+func TestImportPartitionNotOwned(t *testing.T) {
+	srv, store, _ := newImportSrv(t)
+	defer func() { importEnabled = false }()
+	defer srv.Stop()
+	cluster.Manager.SetPartitions([]int32{})
+
+	ts := httptest.NewServer(srv.Macaron)
+	defer ts.Close()
+
+	data := schema.MetricDataArray{{Name: "a", Interval: 10, Value: 1, Time: 36010, Mtype: "gauge"}}
+	if res := postImport(t, ts.URL, data); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", res.StatusCode)
+	}
+	if store.Items() != 0 {
+		t.Fatalf("expected nothing to be written, got %d chunks", store.Items())
+	}
+	md := schema.MetricData{Name: "a", OrgId: 1, Interval: 10, Mtype: "gauge"}
+	md.SetId()
+	mkey, _ := schema.MKeyFromString(md.Id)
+	if _, ok := srv.MetricIndex.Get(mkey); ok {
+		t.Fatalf("expected series not to be added to the index")
+	}
+}
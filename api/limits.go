@@ -0,0 +1,38 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/limits"
+)
+
+// orgLimits returns the limits in effect for the given org.
+// the series and points limits the org has no limit configured for fall back to the http settings
+func orgLimits(orgId uint32) conf.Limits {
+	l := limits.Get(orgId)
+	if l.MaxSeriesPerReq == conf.LimitUnset {
+		l.MaxSeriesPerReq = maxSeriesPerReq
+	}
+	if l.MaxPointsPerReqSoft == conf.LimitUnset {
+		l.MaxPointsPerReqSoft = maxPointsPerReqSoft
+	}
+	if l.MaxPointsPerReqHard == conf.LimitUnset {
+		l.MaxPointsPerReqHard = maxPointsPerReqHard
+	}
+	return l
+}
+
+// errMaxSeriesPerReq records the rejection of a request of the given org, and returns the error to respond with
+func errMaxSeriesPerReq(orgId uint32, limit int) error {
+	limits.Rejected(orgId, "max-series-per-req")
+	return response.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Request exceeds max-series-per-req limit (%d). Reduce the number of targets or ask your admin to increase the limit.", limit))
+}
+
+// errMaxFindResults records the rejection of a request of the given org, and returns the error to respond with
+func errMaxFindResults(orgId uint32, limit int) error {
+	limits.Rejected(orgId, "max-find-results")
+	return response.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Request exceeds max-find-results limit (%d). Use a more specific query or ask your admin to increase the limit.", limit))
+}
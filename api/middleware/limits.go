@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/metrictank/limits"
+	"gopkg.in/macaron.v1"
+)
+
+type orgLimiter struct {
+	inflight int32
+	rate     limits.RateLimiter
+}
+
+type orgLimiters struct {
+	sync.Mutex
+	orgs map[uint32]*orgLimiter
+}
+
+func (o *orgLimiters) get(orgId uint32) *orgLimiter {
+	o.Lock()
+	l, ok := o.orgs[orgId]
+	if !ok {
+		l = &orgLimiter{}
+		o.orgs[orgId] = l
+	}
+	o.Unlock()
+	return l
+}
+
+// Limits returns a middleware that enforces the max-reqs-per-sec and max-concurrent-reqs limits of the org.
+// it must come after RequireOrg.
+// requests that exceed a limit are rejected with a 429, so that a single org can't starve the others.
+func Limits() macaron.Handler {
+	limiters := orgLimiters{
+		orgs: make(map[uint32]*orgLimiter),
+	}
+
+	return func(c *Context) {
+		lim := limits.Get(c.OrgId)
+		l := limiters.get(c.OrgId)
+
+		if !l.rate.Allow(time.Now(), lim.MaxReqsPerSec, 1) {
+			limits.Rejected(c.OrgId, "max-reqs-per-sec")
+			c.PlainText(429, []byte(fmt.Sprintf("request rate exceeds the max-reqs-per-sec limit (%d) of your org. Try again later or ask your admin to increase the limit.", lim.MaxReqsPerSec)))
+			return
+		}
+
+		if lim.MaxConcurrentReqs <= 0 {
+			return
+		}
+		if atomic.AddInt32(&l.inflight, 1) > int32(lim.MaxConcurrentReqs) {
+			atomic.AddInt32(&l.inflight, -1)
+			limits.Rejected(c.OrgId, "max-concurrent-reqs")
+			c.PlainText(429, []byte(fmt.Sprintf("request exceeds the max-concurrent-reqs limit (%d) of your org. Try again later or ask your admin to increase the limit.", lim.MaxConcurrentReqs)))
+			return
+		}
+		defer atomic.AddInt32(&l.inflight, -1)
+		c.Next()
+	}
+}
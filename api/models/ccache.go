@@ -1,9 +1,15 @@
 package models
 
 import (
+	"github.com/grafana/metrictank/mdata/chunk"
 	opentracing "github.com/opentracing/opentracing-go"
 )
 
+//go:generate msgp
+//msgp:ignore CCacheDelete
+//msgp:ignore CCacheDeleteResp
+//msgp:ignore CCacheSearch
+
 type CCacheDelete struct {
 	// patterns with name globbing
 	Patterns []string `json:"patterns" form:"patterns" `
@@ -36,3 +42,30 @@ func (c *CCacheDeleteResp) AddError(err error) {
 	}
 	c.Errors++
 }
+
+// CCacheSearch asks a peer for the chunks of a series it has in its chunk cache
+type CCacheSearch struct {
+	Key   string `json:"key" form:"key" binding:"Required"`
+	From  uint32 `json:"from" form:"from"`
+	Until uint32 `json:"until" form:"until"`
+}
+
+func (cs CCacheSearch) Trace(span opentracing.Span) {
+	span.SetTag("key", cs.Key)
+	span.SetTag("from", cs.From)
+	span.SetTag("until", cs.Until)
+}
+
+func (cs CCacheSearch) TraceDebug(span opentracing.Span) {
+}
+
+// CCacheSearchResp holds the chunks a peer has cached for a CCacheSearch, like cache.CCSearchResult.
+// Start holds the chunks from the start of the range in ascending order, End the ones up to the end of the range
+// in descending order. From and Until are the range that is not covered by them
+type CCacheSearchResp struct {
+	From     uint32          `json:"from"`
+	Until    uint32          `json:"until"`
+	Complete bool            `json:"complete"`
+	Start    []chunk.IterGen `json:"start"`
+	End      []chunk.IterGen `json:"end"`
+}
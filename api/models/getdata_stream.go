@@ -0,0 +1,174 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// GetDataStreamContentType is the content type of a streamed /getdata response.
+// peers that support it, stream the series when the request accepts this content type.
+// the stream is a sequence of msgp encoded frames: the frame type, followed by its payload.
+// each series is sent in a series frame as soon as it's ready, and the stream ends with either
+// an end frame, which holds the number of series sent, or an error frame, which holds the status code and the error.
+// a stream without either was aborted.
+const GetDataStreamContentType = "application/x-metrictank-getdata-stream"
+
+const (
+	getDataFrameSeries uint8 = iota + 1
+	getDataFrameEnd
+	getDataFrameError
+)
+
+// GetDataStreamError is the error sent by a peer in the error frame of a stream
+type GetDataStreamError struct {
+	Code int
+	Err  string
+}
+
+func (e *GetDataStreamError) Error() string {
+	return e.Err
+}
+
+// GetDataStreamWriter writes a streamed /getdata response
+type GetDataStreamWriter struct {
+	w     *msgp.Writer
+	f     http.Flusher
+	count int
+}
+
+// NewGetDataStreamWriter returns a writer of frames to w.
+// if w is an http.Flusher, it is flushed after every frame.
+func NewGetDataStreamWriter(w io.Writer) *GetDataStreamWriter {
+	f, _ := w.(http.Flusher)
+	return &GetDataStreamWriter{
+		w: msgp.NewWriter(w),
+		f: f,
+	}
+}
+
+// WriteSeries writes a series frame
+func (s *GetDataStreamWriter) WriteSeries(series *Series) error {
+	err := s.w.WriteUint8(getDataFrameSeries)
+	if err != nil {
+		return err
+	}
+	err = series.EncodeMsg(s.w)
+	if err != nil {
+		return err
+	}
+	s.count++
+	return s.flush()
+}
+
+// WriteEnd writes the end frame
+func (s *GetDataStreamWriter) WriteEnd() error {
+	err := s.w.WriteUint8(getDataFrameEnd)
+	if err != nil {
+		return err
+	}
+	err = s.w.WriteInt(s.count)
+	if err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// WriteError writes the error frame
+func (s *GetDataStreamWriter) WriteError(code int, e error) error {
+	err := s.w.WriteUint8(getDataFrameError)
+	if err != nil {
+		return err
+	}
+	err = s.w.WriteInt(code)
+	if err != nil {
+		return err
+	}
+	err = s.w.WriteString(e.Error())
+	if err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *GetDataStreamWriter) flush() error {
+	err := s.w.Flush()
+	if err != nil {
+		return err
+	}
+	if s.f != nil {
+		s.f.Flush()
+	}
+	return nil
+}
+
+// GetDataStreamReader reads a streamed /getdata response
+type GetDataStreamReader struct {
+	r     *msgp.Reader
+	count int
+}
+
+func NewGetDataStreamReader(r io.Reader) *GetDataStreamReader {
+	return &GetDataStreamReader{
+		r: msgp.NewReader(deferEOF{r}),
+	}
+}
+
+// deferEOF returns an error that is returned along with data, on the next read instead.
+// the msgp reader doesn't read the data it has buffered once it has seen an error,
+// and response bodies return the end of the data along with io.EOF.
+type deferEOF struct {
+	r io.Reader
+}
+
+func (d deferEOF) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if n > 0 {
+		return n, nil
+	}
+	return n, err
+}
+
+// Next returns the next series of the stream, or io.EOF after the end frame.
+// if the peer sent an error frame, the error is a *GetDataStreamError.
+func (s *GetDataStreamReader) Next() (Series, error) {
+	var series Series
+	frame, err := s.r.ReadUint8()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return series, err
+	}
+	switch frame {
+	case getDataFrameSeries:
+		err = series.DecodeMsg(s.r)
+		if err != nil {
+			return series, err
+		}
+		s.count++
+		return series, nil
+	case getDataFrameEnd:
+		count, err := s.r.ReadInt()
+		if err != nil {
+			return series, err
+		}
+		if count != s.count {
+			return series, fmt.Errorf("stream ended after %d series, but peer sent %d", s.count, count)
+		}
+		return series, io.EOF
+	case getDataFrameError:
+		code, err := s.r.ReadInt()
+		if err != nil {
+			return series, err
+		}
+		msg, err := s.r.ReadString()
+		if err != nil {
+			return series, err
+		}
+		return series, &GetDataStreamError{Code: code, Err: msg}
+	}
+	return series, fmt.Errorf("unknown frame type %d", frame)
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+
+	"github.com/raintank/schema"
+)
+
+func TestGetDataStream(t *testing.T) {
+	series := []Series{
+		{Target: "a", Tags: map[string]string{"name": "a"}, Datapoints: []schema.Point{{Val: 1, Ts: 60}, {Val: 2, Ts: 120}}, Interval: 60, QueryFrom: 60, QueryTo: 180},
+		{Target: "b", Tags: map[string]string{"name": "b"}, Datapoints: []schema.Point{{Val: 3, Ts: 60}}, Interval: 60, QueryFrom: 60, QueryTo: 120},
+	}
+	var buf bytes.Buffer
+	w := NewGetDataStreamWriter(&buf)
+	for i := range series {
+		if err := w.WriteSeries(&series[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	complete := buf.Bytes()
+
+	// response bodies return io.EOF along with the last data
+	r := NewGetDataStreamReader(iotest.DataErrReader(bytes.NewReader(complete)))
+	var got []Series
+	for {
+		s, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got = append(got, s)
+	}
+	if !reflect.DeepEqual(got, series) {
+		t.Fatalf("expected %v, got %v", series, got)
+	}
+
+	// a stream that was cut off, is not a complete response
+	r = NewGetDataStreamReader(bytes.NewReader(complete[:len(complete)-2]))
+	var err error
+	for err == nil {
+		_, err = r.Next()
+	}
+	if err == io.EOF {
+		t.Fatalf("expected error for a truncated stream")
+	}
+
+	// errors of the peer are passed on
+	buf.Reset()
+	w = NewGetDataStreamWriter(&buf)
+	w.WriteSeries(&series[0])
+	w.WriteError(503, errors.New("store unavailable"))
+	r = NewGetDataStreamReader(&buf)
+	if _, err := r.Next(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, err = r.Next()
+	if e, ok := err.(*GetDataStreamError); !ok || e.Code != 503 || e.Err != "store unavailable" {
+		t.Fatalf("expected the error of the peer, got %v", err)
+	}
+}
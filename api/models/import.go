@@ -0,0 +1,8 @@
+package models
+
+// ImportResp is the response of an /import request
+type ImportResp struct {
+	Series int `json:"series"`
+	Points int `json:"points"`
+	Chunks int `json:"chunks"`
+}
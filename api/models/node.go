@@ -14,6 +14,8 @@ type ClusterStatus struct {
 	ClusterName string         `json:"clusterName"`
 	NodeName    string         `json:"nodeName"`
 	Members     []cluster.Node `json:"members"`
+	// health of the requests to the other members, by name
+	PeerHealth map[string]cluster.PeerHealthStatus `json:"peerHealth"`
 }
 
 type ClusterMembers struct {
@@ -135,6 +135,16 @@ func (s *Server) prometheusQueryRange(ctx *middleware.Context, request models.Pr
 		return
 	}
 
+	if promNativeEngine {
+		val, err := s.promQueryNative(ctx.Req.Context(), ctx.OrgId, request.Query, start, end, step)
+		if err != errPromUnsupported {
+			promNativeQueries.Inc()
+			writePromResult(ctx, val, err)
+			return
+		}
+		promFallbackQueries.Inc()
+	}
+
 	qry, err := s.PromQueryEngine.NewRangeQuery(request.Query, start, end, step)
 
 	if err != nil {
@@ -179,6 +189,16 @@ func (s *Server) prometheusQueryInstant(ctx *middleware.Context, request models.
 		return
 	}
 
+	if promNativeEngine {
+		val, err := s.promQueryNativeInstant(ctx.Req.Context(), ctx.OrgId, request.Query, ts)
+		if err != errPromUnsupported {
+			promNativeQueries.Inc()
+			writePromResult(ctx, val, err)
+			return
+		}
+		promFallbackQueries.Inc()
+	}
+
 	qry, err := s.PromQueryEngine.NewInstantQuery(request.Query, ts)
 
 	if err != nil {
@@ -216,6 +236,35 @@ func (s *Server) prometheusQueryInstant(ctx *middleware.Context, request models.
 	))
 }
 
+// writePromResult writes the result of a natively evaluated query, or the error that occurred
+func writePromResult(ctx *middleware.Context, val promql.Value, err error) {
+	if err != nil {
+		switch err.(type) {
+		case *promql.ParseErr:
+			response.Write(ctx, promQueryResultBadData(fmt.Errorf("query failed: %v", err)))
+		case promql.ErrQueryCanceled:
+			response.Write(ctx, promQueryResultCanceled(fmt.Errorf("query failed: %v", err)))
+		case response.Error:
+			// e.g. limits such as max-series-per-req being hit
+			response.Write(ctx, response.WrapError(err))
+		default:
+			response.Write(ctx, promQueryResultExecError(fmt.Errorf("query failed: %v", err)))
+		}
+		return
+	}
+
+	response.Write(ctx, response.NewJson(200,
+		prometheusQueryResult{
+			Data: prometheusQueryData{
+				ResultType: val.Type(),
+				Result:     val,
+			},
+			Status: statusSuccess,
+		},
+		"",
+	))
+}
+
 func (s *Server) prometheusQuerySeries(ctx *middleware.Context, request models.PrometheusSeriesQuery) {
 	start, err := parseTime(request.Start)
 	if err != nil {
@@ -0,0 +1,962 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/expr/tagquery"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// metric api.request.prometheus.native is the number of prometheus queries evaluated by the native engine
+	promNativeQueries = stats.NewCounter32("api.request.prometheus.native")
+	// metric api.request.prometheus.fallback is the number of prometheus queries that used constructs the native engine does not support and were handed to the upstream promql engine
+	promFallbackQueries = stats.NewCounter32("api.request.prometheus.fallback")
+
+	// errPromUnsupported signals that an expression can't be evaluated natively
+	errPromUnsupported = errors.New("expression not supported by native engine")
+)
+
+// promLookback is how far back a vector selector looks for the most recent sample.
+// this matches the staleness delta of the upstream promql engine.
+const promLookback = 5 * 60
+
+// promSeries is a series of values, one per evaluation step. absent values are NaN.
+type promSeries struct {
+	labels labels.Labels
+	vals   []float64
+}
+
+// promValue is the result of evaluating a node of the expression tree.
+// scalars have a single series without labels.
+type promValue struct {
+	scalar bool
+	series []promSeries
+}
+
+// fetchedSeries is a series as loaded from the cluster, before it's evaluated at the steps
+type fetchedSeries struct {
+	labels   labels.Labels
+	points   []schema.Point
+	interval uint32
+}
+
+// promEvaluator evaluates PromQL expressions natively on top of the MetricIndex and Store:
+// series are resolved through the cluster-wide find-by-tag path, rollup archives are chosen
+// based on the evaluation step, and data is fetched via getTargets, which fans out to peers and uses the chunk cache.
+// constructs it doesn't support result in errPromUnsupported, so the caller can fall back to the upstream engine.
+type promEvaluator struct {
+	s     *Server
+	ctx   context.Context
+	orgId uint32
+	start uint32
+	end   uint32
+	step  uint32
+	steps int
+
+	// selectors that appear multiple times in an expression are only fetched once
+	fetched map[string][]fetchedSeries
+}
+
+func newPromEvaluator(ctx context.Context, s *Server, orgId uint32, start, end time.Time, step time.Duration) *promEvaluator {
+	stepSec := uint32(step / time.Second)
+	if stepSec == 0 {
+		stepSec = 1
+	}
+	e := &promEvaluator{
+		s:       s,
+		ctx:     ctx,
+		orgId:   orgId,
+		start:   uint32(start.Unix()),
+		end:     uint32(end.Unix()),
+		step:    stepSec,
+		fetched: make(map[string][]fetchedSeries),
+	}
+	e.steps = int((e.end-e.start)/e.step) + 1
+	return e
+}
+
+// ts returns the timestamp of the given step
+func (e *promEvaluator) ts(i int) uint32 {
+	return e.start + uint32(i)*e.step
+}
+
+// tsOffset returns the timestamp of the given step, shifted back by offset
+func (e *promEvaluator) tsOffset(i int, offset uint32) uint32 {
+	if t := e.ts(i); t > offset {
+		return t - offset
+	}
+	return 0
+}
+
+// promQueryNative evaluates the query over the given range and returns the result as a Matrix
+func (s *Server) promQueryNative(ctx context.Context, orgId uint32, query string, start, end time.Time, step time.Duration) (promql.Value, error) {
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("end timestamp must not be before start time")
+	}
+	e := newPromEvaluator(ctx, s, orgId, start, end, step)
+	val, err := e.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	return e.matrix(val), nil
+}
+
+// promQueryNativeInstant evaluates the query at the given time and returns the result as a Vector or Scalar
+func (s *Server) promQueryNativeInstant(ctx context.Context, orgId uint32, query string, ts time.Time) (promql.Value, error) {
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	e := newPromEvaluator(ctx, s, orgId, ts, ts, time.Second)
+	val, err := e.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	t := int64(e.start) * 1000
+	if val.scalar {
+		return promql.Scalar{T: t, V: val.series[0].vals[0]}, nil
+	}
+	vec := promql.Vector{}
+	for _, serie := range val.series {
+		if math.IsNaN(serie.vals[0]) {
+			continue
+		}
+		vec = append(vec, promql.Sample{Metric: serie.labels, Point: promql.Point{T: t, V: serie.vals[0]}})
+	}
+	return vec, nil
+}
+
+// matrix converts the evaluated value into a Matrix, leaving out absent values and empty series
+func (e *promEvaluator) matrix(val promValue) promql.Matrix {
+	out := promql.Matrix{}
+	for _, serie := range val.series {
+		var points []promql.Point
+		for i, v := range serie.vals {
+			if math.IsNaN(v) {
+				continue
+			}
+			points = append(points, promql.Point{T: int64(e.ts(i)) * 1000, V: v})
+		}
+		if len(points) == 0 {
+			continue
+		}
+		out = append(out, promql.Series{Metric: serie.labels, Points: points})
+	}
+	sort.Sort(out)
+	return out
+}
+
+func (e *promEvaluator) eval(node promql.Expr) (promValue, error) {
+	select {
+	case <-e.ctx.Done():
+		return promValue{}, promql.ErrQueryCanceled("native evaluation")
+	default:
+	}
+	switch n := node.(type) {
+	case *promql.NumberLiteral:
+		return e.scalar(n.Val), nil
+	case *promql.ParenExpr:
+		return e.eval(n.Expr)
+	case *promql.UnaryExpr:
+		val, err := e.eval(n.Expr)
+		if err != nil || n.Op.String() != "-" {
+			return val, err
+		}
+		return mapValues(val, func(v float64) float64 { return -v }, false), nil
+	case *promql.VectorSelector:
+		return e.evalVectorSelector(n)
+	case *promql.Call:
+		return e.evalCall(n)
+	case *promql.AggregateExpr:
+		return e.evalAggregate(n)
+	case *promql.BinaryExpr:
+		return e.evalBinary(n)
+	}
+	return promValue{}, errPromUnsupported
+}
+
+func (e *promEvaluator) scalar(v float64) promValue {
+	vals := make([]float64, e.steps)
+	for i := range vals {
+		vals[i] = v
+	}
+	return promValue{scalar: true, series: []promSeries{{vals: vals}}}
+}
+
+// matchersToExpressions converts prometheus label matchers into tag query expressions
+func matchersToExpressions(matchers []*labels.Matcher) (tagquery.Expressions, error) {
+	expressions := make([]string, 0, len(matchers))
+	for _, matcher := range matchers {
+		name := matcher.Name
+		if name == model.MetricNameLabel {
+			name = "name"
+		}
+		if matcher.Type == labels.MatchNotRegexp {
+			expressions = append(expressions, fmt.Sprintf("%s!=~%s", name, matcher.Value))
+		} else {
+			expressions = append(expressions, fmt.Sprintf("%s%s%s", name, matcher.Type, matcher.Value))
+		}
+	}
+	return tagquery.ParseExpressions(expressions)
+}
+
+func fetchKey(matchers []*labels.Matcher, rng, offset uint32, counter bool) string {
+	return fmt.Sprintf("%v|%d|%d|%t", matchers, rng, offset, counter)
+}
+
+// fetch resolves the matchers to series and loads their data, covering the evaluation range
+// shifted by offset and extended backwards by rng.
+func (e *promEvaluator) fetch(matchers []*labels.Matcher, rng, offset uint32, counter bool) ([]fetchedSeries, error) {
+	key := fetchKey(matchers, rng, offset, counter)
+	if series, ok := e.fetched[key]; ok {
+		return series, nil
+	}
+
+	from := int64(e.start) - int64(offset) - int64(rng)
+	if from < 0 {
+		from = 0
+	}
+	to := int64(e.end) - int64(offset) + 1
+	if to <= from {
+		return nil, nil
+	}
+
+	series, err := e.s.fetchPromSeries(e.ctx, e.orgId, matchers, uint32(from), uint32(to), e.step, counter)
+	if err != nil {
+		return nil, err
+	}
+	e.fetched[key] = series
+	return series, nil
+}
+
+// fetchPromSeries finds the series matching the matchers across the cluster, and loads their data for the range from (inclusive) - to (exclusive).
+// the step is passed on to alignRequestsStep, to use the coarsest suitable rollup. 0 means use the regular archive selection.
+// counters are read from the "lst" rollups where available, so that rates over rollups stay correct.
+func (s *Server) fetchPromSeries(ctx context.Context, orgId uint32, matchers []*labels.Matcher, from, to, step uint32, counter bool) ([]fetchedSeries, error) {
+	exprs, err := matchersToExpressions(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := s.clusterFindByTag(ctx, orgId, exprs, int64(from), orgLimits(orgId).MaxSeriesPerReq)
+	if err != nil {
+		return nil, err
+	}
+
+	var consReq consolidation.Consolidator
+	if counter {
+		consReq = consolidation.Lst
+	}
+	var reqs []models.Req
+	for _, f := range found {
+		for _, metric := range f.Series {
+			for _, archive := range metric.Defs {
+				aggMethods := mdata.Aggregations.Get(archive.AggId).AggregationMethod
+				cons := consolidation.Consolidator(aggMethods[0])
+				if counter {
+					cons = closestAggMethod(consReq, aggMethods)
+				}
+				reqs = append(reqs, models.NewReq(archive.Id, archive.NameWithTags(), "", from, to, 0, uint32(archive.Interval), cons, consReq, f.Node, archive.SchemaId, archive.AggId))
+			}
+		}
+	}
+	reqRenderSeriesCount.Value(len(reqs))
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+
+	reqs, _, _, err = alignRequestsStep(orgId, uint32(time.Now().Unix()), from, to, step, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.getTargets(ctx, reqs)
+	if err != nil {
+		return nil, err
+	}
+	out = mergeSeries(out)
+
+	series := make([]fetchedSeries, 0, len(out))
+	for _, serie := range out {
+		series = append(series, fetchedSeries{
+			labels:   labels.FromMap(buildTagSet(serie.Target)),
+			points:   serie.Datapoints,
+			interval: serie.Interval,
+		})
+	}
+	return series, nil
+}
+
+// window returns the non-null points within (to-rng, to]
+func window(points []schema.Point, to, rng uint32) []schema.Point {
+	var from uint32
+	if to > rng {
+		from = to - rng
+	}
+	lo := sort.Search(len(points), func(i int) bool { return points[i].Ts > from })
+	hi := sort.Search(len(points), func(i int) bool { return points[i].Ts > to })
+	out := make([]schema.Point, 0, hi-lo)
+	for _, p := range points[lo:hi] {
+		if !math.IsNaN(p.Val) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (e *promEvaluator) evalVectorSelector(n *promql.VectorSelector) (promValue, error) {
+	offset := uint32(n.Offset / time.Second)
+	series, err := e.fetch(n.LabelMatchers, promLookback, offset, false)
+	if err != nil {
+		return promValue{}, err
+	}
+	out := promValue{series: make([]promSeries, 0, len(series))}
+	for _, serie := range series {
+		// when reading from coarse rollups, the most recent point may be older than the regular lookback
+		lookback := uint32(promLookback)
+		if serie.interval > lookback {
+			lookback = serie.interval
+		}
+		vals := make([]float64, e.steps)
+		for i := range vals {
+			t := e.tsOffset(i, offset)
+			points := window(serie.points, t, lookback)
+			if len(points) == 0 {
+				vals[i] = math.NaN()
+				continue
+			}
+			vals[i] = points[len(points)-1].Val
+		}
+		out.series = append(out.series, promSeries{labels: serie.labels, vals: vals})
+	}
+	return out, nil
+}
+
+// rangeFuncs are the functions that operate on a matrix selector.
+// each one returns the value for the given points, which lie within a window of rng seconds ending at t
+var rangeFuncs = map[string]func(points []schema.Point, t, rng uint32) float64{
+	"rate": func(points []schema.Point, t, rng uint32) float64 {
+		return extrapolatedDelta(points, t, rng, true, true)
+	},
+	"increase": func(points []schema.Point, t, rng uint32) float64 {
+		return extrapolatedDelta(points, t, rng, true, false)
+	},
+	"delta": func(points []schema.Point, t, rng uint32) float64 {
+		return extrapolatedDelta(points, t, rng, false, false)
+	},
+	"irate": func(points []schema.Point, t, rng uint32) float64 {
+		if len(points) < 2 {
+			return math.NaN()
+		}
+		last, prev := points[len(points)-1], points[len(points)-2]
+		diff := last.Val - prev.Val
+		if diff < 0 {
+			// counter reset
+			diff = last.Val
+		}
+		return diff / float64(last.Ts-prev.Ts)
+	},
+	"avg_over_time": overTime(func(points []schema.Point) float64 {
+		return sumPoints(points) / float64(len(points))
+	}),
+	"sum_over_time": overTime(sumPoints),
+	"count_over_time": overTime(func(points []schema.Point) float64 {
+		return float64(len(points))
+	}),
+	"min_over_time": overTime(func(points []schema.Point) float64 {
+		min := points[0].Val
+		for _, p := range points[1:] {
+			min = math.Min(min, p.Val)
+		}
+		return min
+	}),
+	"max_over_time": overTime(func(points []schema.Point) float64 {
+		max := points[0].Val
+		for _, p := range points[1:] {
+			max = math.Max(max, p.Val)
+		}
+		return max
+	}),
+}
+
+// overTime wraps a function that aggregates the points of a window into a range function.
+// empty windows result in NaN.
+func overTime(fn func(points []schema.Point) float64) func(points []schema.Point, t, rng uint32) float64 {
+	return func(points []schema.Point, t, rng uint32) float64 {
+		if len(points) == 0 {
+			return math.NaN()
+		}
+		return fn(points)
+	}
+}
+
+func sumPoints(points []schema.Point) float64 {
+	var sum float64
+	for _, p := range points {
+		sum += p.Val
+	}
+	return sum
+}
+
+// extrapolatedDelta computes the delta of the points in the window (t-rng, t], extrapolated to the window boundaries
+// the same way the upstream promql engine does it. isCounter makes it account for counter resets, isRate divides by the window length.
+func extrapolatedDelta(points []schema.Point, t, rng uint32, isCounter, isRate bool) float64 {
+	if len(points) < 2 {
+		return math.NaN()
+	}
+	first, last := points[0], points[len(points)-1]
+	delta := last.Val - first.Val
+	if isCounter {
+		prev := first.Val
+		for _, p := range points[1:] {
+			if p.Val < prev {
+				delta += prev
+			}
+			prev = p.Val
+		}
+	}
+
+	rangeStart := float64(t) - float64(rng)
+	rangeEnd := float64(t)
+	durationToStart := float64(first.Ts) - rangeStart
+	durationToEnd := rangeEnd - float64(last.Ts)
+	sampledInterval := float64(last.Ts - first.Ts)
+	avgDurationBetweenSamples := sampledInterval / float64(len(points)-1)
+
+	if isCounter && delta > 0 && first.Val >= 0 {
+		// counters can't go negative, so don't extrapolate to before the counter was zero
+		durationToZero := sampledInterval * (first.Val / delta)
+		if durationToZero < durationToStart {
+			durationToStart = durationToZero
+		}
+	}
+
+	extrapolationThreshold := avgDurationBetweenSamples * 1.1
+	extrapolateToInterval := sampledInterval
+	if durationToStart < extrapolationThreshold {
+		extrapolateToInterval += durationToStart
+	} else {
+		extrapolateToInterval += avgDurationBetweenSamples / 2
+	}
+	if durationToEnd < extrapolationThreshold {
+		extrapolateToInterval += durationToEnd
+	} else {
+		extrapolateToInterval += avgDurationBetweenSamples / 2
+	}
+	delta = delta * (extrapolateToInterval / sampledInterval)
+	if isRate {
+		delta = delta / float64(rng)
+	}
+	return delta
+}
+
+// mathFuncs are the functions that transform each value of their vector argument
+var mathFuncs = map[string]func(float64) float64{
+	"abs":   math.Abs,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"exp":   math.Exp,
+	"sqrt":  math.Sqrt,
+	"ln":    math.Log,
+	"log2":  math.Log2,
+	"log10": math.Log10,
+}
+
+// counterFuncs are the range functions that expect counters as input
+var counterFuncs = map[string]bool{
+	"rate":     true,
+	"irate":    true,
+	"increase": true,
+}
+
+func (e *promEvaluator) evalCall(n *promql.Call) (promValue, error) {
+	name := n.Func.Name
+	if fn, ok := rangeFuncs[name]; ok {
+		sel, ok := n.Args[0].(*promql.MatrixSelector)
+		if !ok {
+			return promValue{}, errPromUnsupported
+		}
+		return e.evalRange(sel, fn, counterFuncs[name])
+	}
+	if fn, ok := mathFuncs[name]; ok {
+		val, err := e.eval(n.Args[0])
+		if err != nil {
+			return val, err
+		}
+		return mapValues(val, fn, true), nil
+	}
+	switch name {
+	case "histogram_quantile":
+		q, err := e.eval(n.Args[0])
+		if err != nil {
+			return q, err
+		}
+		val, err := e.eval(n.Args[1])
+		if err != nil {
+			return val, err
+		}
+		return e.histogramQuantile(q.series[0].vals, val), nil
+	case "time":
+		out := e.scalar(0)
+		for i := range out.series[0].vals {
+			out.series[0].vals[i] = float64(e.ts(i))
+		}
+		return out, nil
+	case "vector":
+		val, err := e.eval(n.Args[0])
+		if err != nil {
+			return val, err
+		}
+		return promValue{series: []promSeries{{labels: labels.Labels{}, vals: val.series[0].vals}}}, nil
+	case "scalar":
+		val, err := e.eval(n.Args[0])
+		if err != nil {
+			return val, err
+		}
+		out := e.scalar(math.NaN())
+		for i := range out.series[0].vals {
+			var found int
+			for _, serie := range val.series {
+				if !math.IsNaN(serie.vals[i]) {
+					out.series[0].vals[i] = serie.vals[i]
+					found++
+				}
+			}
+			if found != 1 {
+				out.series[0].vals[i] = math.NaN()
+			}
+		}
+		return out, nil
+	}
+	return promValue{}, errPromUnsupported
+}
+
+func (e *promEvaluator) evalRange(sel *promql.MatrixSelector, fn func(points []schema.Point, t, rng uint32) float64, counter bool) (promValue, error) {
+	rng := uint32(sel.Range / time.Second)
+	offset := uint32(sel.Offset / time.Second)
+	series, err := e.fetch(sel.LabelMatchers, rng, offset, counter)
+	if err != nil {
+		return promValue{}, err
+	}
+	out := promValue{series: make([]promSeries, 0, len(series))}
+	for _, serie := range series {
+		// rollups may be too coarse to have 2 points within the requested range.
+		// in that case, widen the window to cover 2 intervals: with a step of this size
+		// the higher resolution data would not have been visible anyway.
+		serieRng := rng
+		if 2*serie.interval > serieRng {
+			serieRng = 2 * serie.interval
+		}
+		vals := make([]float64, e.steps)
+		for i := range vals {
+			t := e.tsOffset(i, offset)
+			vals[i] = fn(window(serie.points, t, serieRng), t, serieRng)
+		}
+		out.series = append(out.series, promSeries{labels: dropMetricName(serie.labels), vals: vals})
+	}
+	return out, nil
+}
+
+func dropMetricName(lbls labels.Labels) labels.Labels {
+	return labels.NewBuilder(lbls).Del(model.MetricNameLabel).Labels()
+}
+
+// mapValues applies fn to all values of val. dropName removes the metric name from the output series
+func mapValues(val promValue, fn func(float64) float64, dropName bool) promValue {
+	out := promValue{scalar: val.scalar, series: make([]promSeries, len(val.series))}
+	for i, serie := range val.series {
+		vals := make([]float64, len(serie.vals))
+		for j, v := range serie.vals {
+			vals[j] = fn(v)
+		}
+		lbls := serie.labels
+		if dropName && !val.scalar {
+			lbls = dropMetricName(lbls)
+		}
+		out.series[i] = promSeries{labels: lbls, vals: vals}
+	}
+	return out
+}
+
+// groupLabels returns the labels identifying the group the given labels belong to
+func groupLabels(lbls labels.Labels, grouping []string, without bool) labels.Labels {
+	b := labels.NewBuilder(lbls)
+	if without {
+		b.Del(grouping...)
+		b.Del(model.MetricNameLabel)
+		return b.Labels()
+	}
+	keep := make(map[string]struct{}, len(grouping))
+	for _, g := range grouping {
+		keep[g] = struct{}{}
+	}
+	for _, l := range lbls {
+		if _, ok := keep[l.Name]; !ok {
+			b.Del(l.Name)
+		}
+	}
+	return b.Labels()
+}
+
+// groupSeries buckets the series by their group labels, in order of first appearance
+func groupSeries(series []promSeries, grouping []string, without bool) ([]labels.Labels, [][]promSeries) {
+	var keys []labels.Labels
+	var groups [][]promSeries
+	pos := make(map[uint64]int)
+	for _, serie := range series {
+		key := groupLabels(serie.labels, grouping, without)
+		h := key.Hash()
+		i, ok := pos[h]
+		if !ok {
+			i = len(keys)
+			pos[h] = i
+			keys = append(keys, key)
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], serie)
+	}
+	return keys, groups
+}
+
+// aggregators reduce the non-NaN values of the series in a group at one step into one value
+var aggregators = map[string]func(vals []float64) float64{
+	"sum": func(vals []float64) float64 {
+		var sum float64
+		for _, v := range vals {
+			sum += v
+		}
+		return sum
+	},
+	"avg": func(vals []float64) float64 {
+		var sum float64
+		for _, v := range vals {
+			sum += v
+		}
+		return sum / float64(len(vals))
+	},
+	"count": func(vals []float64) float64 {
+		return float64(len(vals))
+	},
+	"min": func(vals []float64) float64 {
+		min := vals[0]
+		for _, v := range vals[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+	"max": func(vals []float64) float64 {
+		max := vals[0]
+		for _, v := range vals[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+	"stddev": func(vals []float64) float64 {
+		// computed from the deviations from the average, rather than the average of the squares,
+		// which can come out slightly below the squared average due to rounding
+		var sum float64
+		for _, v := range vals {
+			sum += v
+		}
+		n := float64(len(vals))
+		avg := sum / n
+		var sumDeviationsSquared float64
+		for _, v := range vals {
+			deviation := v - avg
+			sumDeviationsSquared += deviation * deviation
+		}
+		return math.Sqrt(sumDeviationsSquared / n)
+	},
+}
+
+func (e *promEvaluator) evalAggregate(n *promql.AggregateExpr) (promValue, error) {
+	fn, ok := aggregators[n.Op.String()]
+	if !ok || n.Param != nil {
+		return promValue{}, errPromUnsupported
+	}
+	val, err := e.eval(n.Expr)
+	if err != nil {
+		return val, err
+	}
+	keys, groups := groupSeries(val.series, n.Grouping, n.Without)
+	out := promValue{series: make([]promSeries, 0, len(keys))}
+	buf := make([]float64, 0, len(val.series))
+	for g, key := range keys {
+		vals := make([]float64, e.steps)
+		for i := range vals {
+			buf = buf[:0]
+			for _, serie := range groups[g] {
+				if !math.IsNaN(serie.vals[i]) {
+					buf = append(buf, serie.vals[i])
+				}
+			}
+			if len(buf) == 0 {
+				vals[i] = math.NaN()
+				continue
+			}
+			vals[i] = fn(buf)
+		}
+		out.series = append(out.series, promSeries{labels: key, vals: vals})
+	}
+	return out, nil
+}
+
+// bucket is a histogram bucket, identified by its upper bound
+type bucket struct {
+	upperBound float64
+	count      float64
+}
+
+// bucketQuantile calculates the quantile q from the given cumulative histogram buckets, the same way the upstream promql engine does.
+// the buckets must be sorted by upper bound and the last one must be +Inf.
+func bucketQuantile(q float64, buckets []bucket) float64 {
+	if q < 0 {
+		return math.Inf(-1)
+	}
+	if q > 1 {
+		return math.Inf(+1)
+	}
+	if len(buckets) < 2 || !math.IsInf(buckets[len(buckets)-1].upperBound, +1) {
+		return math.NaN()
+	}
+	// the counts of a consistent histogram can't decrease. with rates computed over slightly different windows, they sometimes do.
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i].count < buckets[i-1].count {
+			buckets[i].count = buckets[i-1].count
+		}
+	}
+	observations := buckets[len(buckets)-1].count
+	if observations == 0 {
+		return math.NaN()
+	}
+	rank := q * observations
+	b := sort.Search(len(buckets)-1, func(i int) bool { return buckets[i].count >= rank })
+
+	if b == len(buckets)-1 {
+		return buckets[len(buckets)-2].upperBound
+	}
+	if b == 0 && buckets[0].upperBound <= 0 {
+		return buckets[0].upperBound
+	}
+	var bucketStart float64
+	bucketEnd := buckets[b].upperBound
+	count := buckets[b].count
+	if b > 0 {
+		bucketStart = buckets[b-1].upperBound
+		count -= buckets[b-1].count
+		rank -= buckets[b-1].count
+	}
+	return bucketStart + (bucketEnd-bucketStart)*(rank/count)
+}
+
+func (e *promEvaluator) histogramQuantile(q []float64, val promValue) promValue {
+	type histogram struct {
+		series []promSeries
+		les    []float64
+	}
+	var keys []labels.Labels
+	histograms := make(map[uint64]*histogram)
+	for _, serie := range val.series {
+		le, err := strconv.ParseFloat(serie.labels.Get(model.BucketLabel), 64)
+		if err != nil {
+			log.Debugf("HTTP Prometheus: ignoring series %s with invalid %q label", serie.labels, model.BucketLabel)
+			continue
+		}
+		key := labels.NewBuilder(serie.labels).Del(model.BucketLabel, model.MetricNameLabel).Labels()
+		h, ok := histograms[key.Hash()]
+		if !ok {
+			h = &histogram{}
+			histograms[key.Hash()] = h
+			keys = append(keys, key)
+		}
+		h.series = append(h.series, serie)
+		h.les = append(h.les, le)
+	}
+
+	out := promValue{series: make([]promSeries, 0, len(keys))}
+	for _, key := range keys {
+		h := histograms[key.Hash()]
+		vals := make([]float64, e.steps)
+		buckets := make([]bucket, 0, len(h.series))
+		for i := range vals {
+			buckets = buckets[:0]
+			for j, serie := range h.series {
+				if !math.IsNaN(serie.vals[i]) {
+					buckets = append(buckets, bucket{h.les[j], serie.vals[i]})
+				}
+			}
+			sort.Slice(buckets, func(a, b int) bool { return buckets[a].upperBound < buckets[b].upperBound })
+			vals[i] = bucketQuantile(q[i], buckets)
+		}
+		out.series = append(out.series, promSeries{labels: key, vals: vals})
+	}
+	return out
+}
+
+// binaryOps are the supported arithmetic and comparison operators.
+// comparisons return 1 or 0, and whether the comparison held.
+var binaryOps = map[string]func(a, b float64) (float64, bool){
+	"+":  func(a, b float64) (float64, bool) { return a + b, true },
+	"-":  func(a, b float64) (float64, bool) { return a - b, true },
+	"*":  func(a, b float64) (float64, bool) { return a * b, true },
+	"/":  func(a, b float64) (float64, bool) { return a / b, true },
+	"%":  func(a, b float64) (float64, bool) { return math.Mod(a, b), true },
+	"^":  func(a, b float64) (float64, bool) { return math.Pow(a, b), true },
+	"==": func(a, b float64) (float64, bool) { return boolToFloat(a == b), a == b },
+	"!=": func(a, b float64) (float64, bool) { return boolToFloat(a != b), a != b },
+	">":  func(a, b float64) (float64, bool) { return boolToFloat(a > b), a > b },
+	"<":  func(a, b float64) (float64, bool) { return boolToFloat(a < b), a < b },
+	">=": func(a, b float64) (float64, bool) { return boolToFloat(a >= b), a >= b },
+	"<=": func(a, b float64) (float64, bool) { return boolToFloat(a <= b), a <= b },
+}
+
+var comparisonOps = map[string]bool{
+	"==": true,
+	"!=": true,
+	">":  true,
+	"<":  true,
+	">=": true,
+	"<=": true,
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (e *promEvaluator) evalBinary(n *promql.BinaryExpr) (promValue, error) {
+	op := n.Op.String()
+	fn, ok := binaryOps[op]
+	if !ok {
+		return promValue{}, errPromUnsupported
+	}
+	lhs, err := e.eval(n.LHS)
+	if err != nil {
+		return lhs, err
+	}
+	rhs, err := e.eval(n.RHS)
+	if err != nil {
+		return rhs, err
+	}
+	filter := comparisonOps[op] && !n.ReturnBool
+
+	// apply computes the value of one step. for filtering comparisons, the vector side's value is kept
+	apply := func(a, b, keep float64) float64 {
+		if math.IsNaN(a) || math.IsNaN(b) {
+			return math.NaN()
+		}
+		v, ok := fn(a, b)
+		if filter {
+			if !ok {
+				return math.NaN()
+			}
+			return keep
+		}
+		return v
+	}
+
+	switch {
+	case lhs.scalar && rhs.scalar:
+		out := e.scalar(0)
+		for i := range out.series[0].vals {
+			out.series[0].vals[i] = apply(lhs.series[0].vals[i], rhs.series[0].vals[i], lhs.series[0].vals[i])
+		}
+		return out, nil
+	case lhs.scalar || rhs.scalar:
+		vec, sc := lhs, rhs
+		if lhs.scalar {
+			vec, sc = rhs, lhs
+		}
+		out := promValue{series: make([]promSeries, len(vec.series))}
+		for s, serie := range vec.series {
+			vals := make([]float64, e.steps)
+			for i := range vals {
+				if lhs.scalar {
+					vals[i] = apply(sc.series[0].vals[i], serie.vals[i], serie.vals[i])
+				} else {
+					vals[i] = apply(serie.vals[i], sc.series[0].vals[i], serie.vals[i])
+				}
+			}
+			lbls := serie.labels
+			if !filter {
+				lbls = dropMetricName(lbls)
+			}
+			out.series[s] = promSeries{labels: lbls, vals: vals}
+		}
+		return out, nil
+	}
+
+	// vector to vector: only one-to-one matching is supported
+	var matching []string
+	var on bool
+	if vm := n.VectorMatching; vm != nil {
+		if vm.Card != promql.CardOneToOne {
+			return promValue{}, errPromUnsupported
+		}
+		matching, on = vm.MatchingLabels, vm.On
+	}
+	signature := func(lbls labels.Labels) uint64 {
+		if on {
+			return groupLabels(lbls, matching, false).Hash()
+		}
+		return groupLabels(lbls, matching, true).Hash()
+	}
+	rhsBySig := make(map[uint64]promSeries, len(rhs.series))
+	for _, serie := range rhs.series {
+		sig := signature(serie.labels)
+		if _, ok := rhsBySig[sig]; ok {
+			return promValue{}, fmt.Errorf("many-to-many matching not allowed: found duplicate series on the right hand-side of the operation")
+		}
+		rhsBySig[sig] = serie
+	}
+	out := promValue{}
+	matched := make(map[uint64]struct{}, len(lhs.series))
+	for _, l := range lhs.series {
+		sig := signature(l.labels)
+		r, ok := rhsBySig[sig]
+		if !ok {
+			continue
+		}
+		if _, ok := matched[sig]; ok {
+			return promValue{}, fmt.Errorf("many-to-many matching not allowed: found duplicate series on the left hand-side of the operation")
+		}
+		matched[sig] = struct{}{}
+		vals := make([]float64, e.steps)
+		for i := range vals {
+			vals[i] = apply(l.vals[i], r.vals[i], l.vals[i])
+		}
+		// like upstream, on() only keeps the matching labels and ignoring() drops them
+		lbls := l.labels
+		if !filter {
+			lbls = dropMetricName(lbls)
+		}
+		if on {
+			lbls = groupLabels(lbls, matching, false)
+		} else {
+			lbls = labels.NewBuilder(lbls).Del(matching...).Labels()
+		}
+		out.series = append(out.series, promSeries{labels: lbls, vals: vals})
+	}
+	return out, nil
+}
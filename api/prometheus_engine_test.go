@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/raintank/schema"
+)
+
+// newTestPromEvaluator returns an evaluator for the range 1000-1600 with a step of 60,
+// with the given data pre-loaded for every selector of the expression
+func newTestPromEvaluator(t *testing.T, query string, data []fetchedSeries) (*promEvaluator, promql.Expr) {
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %s", query, err)
+	}
+	e := newPromEvaluator(context.Background(), nil, 1, time.Unix(1000, 0), time.Unix(1600, 0), time.Minute)
+	promql.Inspect(expr, func(node promql.Node) bool {
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			e.fetched[fetchKey(n.LabelMatchers, promLookback, uint32(n.Offset/time.Second), false)] = data
+		case *promql.Call:
+			if sel, ok := n.Args[0].(*promql.MatrixSelector); ok {
+				e.fetched[fetchKey(sel.LabelMatchers, uint32(sel.Range/time.Second), uint32(sel.Offset/time.Second), counterFuncs[n.Func.Name])] = data
+			}
+		}
+		return true
+	})
+	return e, expr
+}
+
+// counter returns a series that increases by rate per second, with a point every 10 seconds
+func counter(lbls labels.Labels, rate float64) fetchedSeries {
+	var points []schema.Point
+	for ts := uint32(0); ts <= 1600; ts += 10 {
+		points = append(points, schema.Point{Val: rate * float64(ts), Ts: ts})
+	}
+	return fetchedSeries{labels: lbls, points: points, interval: 10}
+}
+
+// checkPromValue checks that the series of val that have values, have the expected value at every step
+func checkPromValue(t *testing.T, name string, val promValue, exp map[string]float64) {
+	var series []promSeries
+	for _, serie := range val.series {
+		for _, v := range serie.vals {
+			if !math.IsNaN(v) {
+				series = append(series, serie)
+				break
+			}
+		}
+	}
+	if len(series) != len(exp) {
+		t.Fatalf("%s: expected %d series, got %d", name, len(exp), len(series))
+	}
+	for _, serie := range series {
+		expVal, ok := exp[serie.labels.String()]
+		if !ok {
+			t.Fatalf("%s: unexpected series %s", name, serie.labels)
+		}
+		for i, v := range serie.vals {
+			if math.Abs(v-expVal) > 0.0001 {
+				t.Fatalf("%s: series %s step %d: expected %f, got %f", name, serie.labels, i, expVal, v)
+			}
+		}
+	}
+}
+
+func TestPromEvaluatorRateSumBy(t *testing.T) {
+	data := []fetchedSeries{
+		counter(labels.FromStrings("__name__", "reqs", "dc", "a", "host", "1"), 1),
+		counter(labels.FromStrings("__name__", "reqs", "dc", "a", "host", "2"), 2),
+		counter(labels.FromStrings("__name__", "reqs", "dc", "b", "host", "3"), 4),
+	}
+	e, expr := newTestPromEvaluator(t, `sum by (dc) (rate(reqs[1m]))`, data)
+	val, err := e.eval(expr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkPromValue(t, "sum by rate", val, map[string]float64{
+		`{dc="a"}`: 3,
+		`{dc="b"}`: 4,
+	})
+}
+
+func TestPromEvaluatorIncreaseWithReset(t *testing.T) {
+	serie := counter(labels.FromStrings("__name__", "reqs"), 1)
+	// restart the counter every 30 seconds. it still increases by 1 per second.
+	for i := range serie.points {
+		serie.points[i].Val = float64(serie.points[i].Ts%30) + 10
+	}
+	e, expr := newTestPromEvaluator(t, `increase(reqs[1m])`, []fetchedSeries{serie})
+	val, err := e.eval(expr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkPromValue(t, "increase", val, map[string]float64{
+		`{}`: 60,
+	})
+}
+
+func TestPromEvaluatorBinary(t *testing.T) {
+	data := []fetchedSeries{
+		counter(labels.FromStrings("__name__", "reqs", "host", "1"), 1),
+		counter(labels.FromStrings("__name__", "reqs", "host", "2"), 2),
+	}
+	e, expr := newTestPromEvaluator(t, `rate(reqs[1m]) * 100 > 150`, data)
+	val, err := e.eval(expr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkPromValue(t, "filter", val, map[string]float64{
+		`{host="2"}`: 200,
+	})
+
+	e, expr = newTestPromEvaluator(t, `rate(reqs[1m]) / on(host) rate(reqs[1m])`, data)
+	val, err = e.eval(expr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkPromValue(t, "vector matching", val, map[string]float64{
+		`{host="1"}`: 1,
+		`{host="2"}`: 1,
+	})
+}
+
+func TestPromEvaluatorBinaryIgnoring(t *testing.T) {
+	data := []fetchedSeries{
+		counter(labels.FromStrings("__name__", "reqs", "host", "1", "dc", "a"), 1),
+		counter(labels.FromStrings("__name__", "reqs", "host", "2", "dc", "b"), 2),
+	}
+	e, expr := newTestPromEvaluator(t, `rate(reqs[1m]) / ignoring(host) rate(reqs[1m])`, data)
+	val, err := e.eval(expr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkPromValue(t, "ignoring", val, map[string]float64{
+		`{dc="a"}`: 1,
+		`{dc="b"}`: 1,
+	})
+
+	// both series on the left match the single series on the right
+	data[1] = counter(labels.FromStrings("__name__", "reqs", "host", "2", "dc", "a"), 2)
+	e, expr = newTestPromEvaluator(t, `rate(reqs[1m]) / ignoring(host) rate(total[1m])`, data)
+	promql.Inspect(expr, func(node promql.Node) bool {
+		if sel, ok := node.(*promql.MatrixSelector); ok && sel.Name == "total" {
+			e.fetched[fetchKey(sel.LabelMatchers, uint32(sel.Range/time.Second), 0, true)] = []fetchedSeries{
+				counter(labels.FromStrings("__name__", "total", "dc", "a"), 3),
+			}
+		}
+		return true
+	})
+	_, err = e.eval(expr)
+	if err == nil || !strings.Contains(err.Error(), "left hand-side") {
+		t.Fatalf("expected many-to-many matching error for the left hand-side, got %v", err)
+	}
+}
+
+func TestPromEvaluatorHistogramQuantile(t *testing.T) {
+	data := []fetchedSeries{
+		counter(labels.FromStrings("__name__", "lat_bucket", "le", "0.1"), 1),
+		counter(labels.FromStrings("__name__", "lat_bucket", "le", "0.5"), 3),
+		counter(labels.FromStrings("__name__", "lat_bucket", "le", "1"), 4),
+		counter(labels.FromStrings("__name__", "lat_bucket", "le", "+Inf"), 4),
+	}
+	e, expr := newTestPromEvaluator(t, `histogram_quantile(0.5, sum by (le) (rate(lat_bucket[1m])))`, data)
+	val, err := e.eval(expr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// rank 2 falls halfway in the 0.1-0.5 bucket
+	checkPromValue(t, "histogram_quantile", val, map[string]float64{
+		`{}`: 0.3,
+	})
+}
+
+func TestPromEvaluatorUnsupported(t *testing.T) {
+	e, expr := newTestPromEvaluator(t, `topk(3, reqs)`, nil)
+	_, err := e.eval(expr)
+	if err != errPromUnsupported {
+		t.Fatalf("expected errPromUnsupported, got %v", err)
+	}
+}
+
+func TestAggregatorStddev(t *testing.T) {
+	cases := []struct {
+		vals []float64
+		exp  float64
+	}{
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 2},
+		{[]float64{3}, 0},
+		// the average of the squares of these is rounded to less than the squared average
+		{[]float64{0.1, 0.1, 0.1}, 0},
+		{[]float64{1e8 + 0.1, 1e8 + 0.1, 1e8 + 0.1}, 0},
+	}
+	for i, c := range cases {
+		if got := aggregators["stddev"](c.vals); math.IsNaN(got) || math.Abs(got-c.exp) > 1e-6 {
+			t.Errorf("case %d: expected %f, got %f", i, c.exp, got)
+		}
+	}
+}
+
+func TestBucketQuantile(t *testing.T) {
+	cases := []struct {
+		q       float64
+		buckets []bucket
+		exp     float64
+	}{
+		{0.5, []bucket{{1, 0}, {2, 10}, {math.Inf(1), 10}}, 1.5},
+		{0.99, []bucket{{1, 5}, {2, 5}, {math.Inf(1), 10}}, 2},
+		{0.5, []bucket{{1, 5}}, math.NaN()},
+		{2, []bucket{{1, 5}, {math.Inf(1), 10}}, math.Inf(1)},
+	}
+	for i, c := range cases {
+		got := bucketQuantile(c.q, c.buckets)
+		if got != c.exp && !(math.IsNaN(got) && math.IsNaN(c.exp)) {
+			t.Errorf("case %d: expected %f, got %f", i, c.exp, got)
+		}
+	}
+}
@@ -53,24 +53,12 @@ func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error)
 	var target string
 	var reqs []models.Req
 
-	expressions := []string{}
-	for _, matcher := range matchers {
-		if matcher.Name == model.MetricNameLabel {
-			matcher.Name = "name"
-		}
-		if matcher.Type == labels.MatchNotRegexp {
-			expressions = append(expressions, fmt.Sprintf("%s!=~%s", matcher.Name, matcher.Value))
-		} else {
-			expressions = append(expressions, fmt.Sprintf("%s%s%s", matcher.Name, matcher.Type, matcher.Value))
-		}
-	}
-
-	parsedExpressions, err := tagquery.ParseExpressions(expressions)
+	parsedExpressions, err := matchersToExpressions(matchers)
 	if err != nil {
 		return nil, err
 	}
 
-	series, err := q.clusterFindByTag(q.ctx, q.OrgID, parsedExpressions, 0, maxSeriesPerReq)
+	series, err := q.clusterFindByTag(q.ctx, q.OrgID, parsedExpressions, 0, orgLimits(q.OrgID).MaxSeriesPerReq)
 	if err != nil {
 		return nil, err
 	}
@@ -107,7 +95,7 @@ func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error)
 	}
 
 	// note: if 1 series has a movingAvg that requires a long time range extension, it may push other reqs into another archive. can be optimized later
-	reqs, _, _, err = alignRequests(uint32(time.Now().Unix()), minFrom, maxTo, reqs)
+	reqs, _, _, err = alignRequests(q.OrgID, uint32(time.Now().Unix()), minFrom, maxTo, reqs)
 	if err != nil {
 		log.Errorf("HTTP Render alignReq error: %s", err.Error())
 		return nil, err
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/metrictank/api/middleware"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/stats"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	// metric api.request.prometheus_read.queries is the number of queries received via prometheus remote read requests
+	promReadQueries = stats.NewCounter32("api.request.prometheus_read.queries")
+	// metric api.request.prometheus_read.series is the number of series returned by prometheus remote read queries
+	promReadSeries = stats.NewMeter32("api.request.prometheus_read.series", false)
+)
+
+// prometheusRead implements the prometheus remote read protocol, so that prometheus can use metrictank as long term storage.
+// note that the vendored prometheus protocol definitions predate the streamed chunked response type,
+// so all queries are answered in a single snappy compressed ReadResponse, the "samples" response type.
+func (s *Server) prometheusRead(ctx *middleware.Context) {
+	compressed, err := ioutil.ReadAll(ctx.Req.Request.Body)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("failed to read request body: %s", err)))
+		return
+	}
+	buf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("failed to decode request body: %s", err)))
+		return
+	}
+	var req prompb.ReadRequest
+	if err := proto.Unmarshal(buf, &req); err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("failed to unmarshal read request: %s", err)))
+		return
+	}
+
+	resp := prompb.ReadResponse{
+		Results: make([]*prompb.QueryResult, len(req.Queries)),
+	}
+	for i, query := range req.Queries {
+		resp.Results[i], err = s.prometheusReadQuery(ctx.Req.Context(), ctx.OrgId, query)
+		if err != nil {
+			response.Write(ctx, response.WrapError(err))
+			return
+		}
+	}
+	response.Write(ctx, response.NewSnappyProtobuf(http.StatusOK, &resp))
+}
+
+// prometheusReadQuery looks up the series matching the query throughout the cluster and returns their samples
+func (s *Server) prometheusReadQuery(ctx context.Context, orgId uint32, query *prompb.Query) (*prompb.QueryResult, error) {
+	promReadQueries.Inc()
+	matchers, err := fromLabelMatchers(query.Matchers)
+	if err != nil {
+		return nil, response.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	start := query.StartTimestampMs
+	end := query.EndTimestampMs
+	if end < start {
+		return nil, response.NewError(http.StatusBadRequest, "end timestamp must not be before start time")
+	}
+	// our ranges are in seconds and exclusive at the end
+	from := uint32(start / 1000)
+	to := uint32(end/1000) + 1
+
+	series, err := s.fetchPromSeries(ctx, orgId, matchers, from, to, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	promReadSeries.Value(len(series))
+
+	result := &prompb.QueryResult{
+		Timeseries: make([]*prompb.TimeSeries, 0, len(series)),
+	}
+	for _, serie := range series {
+		ts := &prompb.TimeSeries{
+			Labels: make([]*prompb.Label, 0, len(serie.labels)),
+		}
+		for _, l := range serie.labels {
+			ts.Labels = append(ts.Labels, &prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		for _, p := range serie.points {
+			t := int64(p.Ts) * 1000
+			if math.IsNaN(p.Val) || t < start || t > end {
+				continue
+			}
+			ts.Samples = append(ts.Samples, &prompb.Sample{Value: p.Val, Timestamp: t})
+		}
+		result.Timeseries = append(result.Timeseries, ts)
+	}
+	return result, nil
+}
+
+// fromLabelMatchers converts the matchers of a remote read query into prometheus label matchers
+func fromLabelMatchers(in []*prompb.LabelMatcher) ([]*labels.Matcher, error) {
+	out := make([]*labels.Matcher, 0, len(in))
+	for _, m := range in {
+		var t labels.MatchType
+		switch m.Type {
+		case prompb.LabelMatcher_EQ:
+			t = labels.MatchEqual
+		case prompb.LabelMatcher_NEQ:
+			t = labels.MatchNotEqual
+		case prompb.LabelMatcher_RE:
+			t = labels.MatchRegexp
+		case prompb.LabelMatcher_NRE:
+			t = labels.MatchNotRegexp
+		default:
+			return nil, fmt.Errorf("invalid matcher type %d", m.Type)
+		}
+		matcher, err := labels.NewMatcher(t, m.Name, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, matcher)
+	}
+	return out, nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestFromLabelMatchers(t *testing.T) {
+	in := []*prompb.LabelMatcher{
+		{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "foo"},
+		{Type: prompb.LabelMatcher_NEQ, Name: "dc", Value: "a"},
+		{Type: prompb.LabelMatcher_RE, Name: "host", Value: "web.*"},
+		{Type: prompb.LabelMatcher_NRE, Name: "env", Value: "dev|test"},
+	}
+	matchers, err := fromLabelMatchers(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exprs, err := matchersToExpressions(matchers)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp := []string{"name=foo", "dc!=a", "host=~^(?:web.*)", "env!=~^(?:dev|test)"}
+	got := exprs.Strings()
+	if len(got) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("expected %v, got %v", exp, got)
+		}
+	}
+
+	_, err = fromLabelMatchers([]*prompb.LabelMatcher{{Type: prompb.LabelMatcher_RE, Name: "host", Value: "("}})
+	if err == nil {
+		t.Fatalf("expected error for invalid regex, got nil")
+	}
+}
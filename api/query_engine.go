@@ -5,7 +5,9 @@ import (
 
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/limits"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/stats"
 	"github.com/grafana/metrictank/util"
@@ -26,8 +28,16 @@ var (
 
 // alignRequests updates the requests with all details for fetching, making sure all metrics are in the same, optimal interval
 // note: it is assumed that all requests have the same maxDataPoints, from & to.
+// the points limits of the requesting org are enforced. (the series themselves may belong to the public org)
 // also takes a "now" value which we compare the TTL against
-func alignRequests(now, from, to uint32, reqs []models.Req) ([]models.Req, uint32, uint32, error) {
+func alignRequests(orgId, now, from, to uint32, reqs []models.Req) ([]models.Req, uint32, uint32, error) {
+	return alignRequestsStep(orgId, now, from, to, 0, reqs)
+}
+
+// alignRequestsStep is like alignRequests, but additionally takes the step at which the caller will evaluate the data.
+// rollup archives with an interval not exceeding the step are preferred over higher resolution archives,
+// since the extra resolution would be thrown away anyway. a step of 0 disables this.
+func alignRequestsStep(orgId, now, from, to, step uint32, reqs []models.Req) ([]models.Req, uint32, uint32, error) {
 	tsRange := to - from
 
 	var listIntervals []uint32
@@ -45,11 +55,12 @@ func alignRequests(now, from, to uint32, reqs []models.Req) ([]models.Req, uint3
 	minIntervalSoft := uint32(0)
 	minIntervalHard := uint32(0)
 
-	if maxPointsPerReqSoft > 0 {
-		minIntervalSoft = uint32(math.Ceil(float64(tsRange) / (float64(maxPointsPerReqSoft) / float64(numTargets))))
+	lim := orgLimits(orgId)
+	if lim.MaxPointsPerReqSoft > 0 {
+		minIntervalSoft = uint32(math.Ceil(float64(tsRange) / (float64(lim.MaxPointsPerReqSoft) / float64(numTargets))))
 	}
-	if maxPointsPerReqHard > 0 {
-		minIntervalHard = uint32(math.Ceil(float64(tsRange) / (float64(maxPointsPerReqHard) / float64(numTargets))))
+	if lim.MaxPointsPerReqHard > 0 {
+		minIntervalHard = uint32(math.Ceil(float64(tsRange) / (float64(lim.MaxPointsPerReqHard) / float64(numTargets))))
 	}
 
 	// set preliminary settings. may be adjusted further down
@@ -75,7 +86,7 @@ func alignRequests(now, from, to uint32, reqs []models.Req) ([]models.Req, uint3
 				req.ArchInterval = uint32(ret.SecondsPerPoint)
 			}
 
-			if req.TTL >= minTTL && req.ArchInterval >= minIntervalSoft {
+			if req.TTL >= minTTL && req.ArchInterval >= minIntervalSoft && !coarserArchiveFits(retentions, i, from, step) {
 				break
 			}
 		}
@@ -94,6 +105,7 @@ func alignRequests(now, from, to uint32, reqs []models.Req) ([]models.Req, uint3
 	interval := util.Lcm(listIntervals)
 
 	if interval < minIntervalHard {
+		limits.Rejected(orgId, "max-points-per-req-hard")
 		return nil, 0, 0, errMaxPointsPerReq
 	}
 
@@ -156,3 +168,13 @@ func alignRequests(now, from, to uint32, reqs []models.Req) ([]models.Req, uint3
 
 	return reqs, pointsFetch, pointsReturn, nil
 }
+
+// coarserArchiveFits returns whether the archive following archive i is ready and has an interval
+// that does not exceed the given step.
+func coarserArchiveFits(retentions conf.Retentions, i int, from, step uint32) bool {
+	if step == 0 || i+1 >= len(retentions) {
+		return false
+	}
+	next := retentions[i+1]
+	return next.Ready <= from && uint32(next.SecondsPerPoint) <= step
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"fmt"
 	"math"
 	"regexp"
 	"testing"
@@ -8,7 +9,10 @@ import (
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/limits"
 	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
 	"github.com/grafana/metrictank/test"
 )
 
@@ -30,7 +34,7 @@ func testAlign(reqs []models.Req, retentions [][]conf.Retention, outReqs []model
 	}
 
 	mdata.Schemas = conf.NewSchemas(schemas)
-	out, _, _, err := alignRequests(now, reqs[0].From, reqs[0].To, reqs)
+	out, _, _, err := alignRequests(1, now, reqs[0].From, reqs[0].To, reqs)
 	if err != outErr {
 		t.Errorf("different err value expected: %v, got: %v", outErr, err)
 	}
@@ -454,7 +458,7 @@ func testMaxPointsPerReq(maxPointsSoft, maxPointsHard int, reqs []models.Req, t
 		}),
 	}})
 
-	out, _, _, err := alignRequests(30*day, reqs[0].From, reqs[0].To, reqs)
+	out, _, _, err := alignRequests(1, 30*day, reqs[0].From, reqs[0].To, reqs)
 	maxPointsPerReqSoft = origMaxPointsPerReqSoft
 	maxPointsPerReqHard = origMaxPointsPerReqHard
 	return out, err
@@ -547,7 +551,77 @@ func BenchmarkAlignRequests(b *testing.B) {
 	})
 
 	for n := 0; n < b.N; n++ {
-		res, _, _, _ = alignRequests(14*24*3600, 0, 3600*24*7, reqs)
+		res, _, _, _ = alignRequests(1, 14*24*3600, 0, 3600*24*7, reqs)
 	}
 	result = res
 }
+
+// with a step of 600, the 600s rollup gives all the resolution that is needed, so it's preferred over raw data.
+// the 3600s rollup is coarser than the step, so it's not used.
+func TestAlignRequestsStep(t *testing.T) {
+	mdata.Schemas = conf.NewSchemas([]conf.Schema{
+		{
+			Pattern: regexp.MustCompile(".*"),
+			Retentions: conf.Retentions([]conf.Retention{
+				conf.NewRetentionMT(10, 86400, 0, 0, 0),
+				conf.NewRetentionMT(600, 86400, 0, 0, 0),
+				conf.NewRetentionMT(3600, 86400, 0, 0, 0),
+			}),
+		},
+	})
+	reqs := []models.Req{
+		reqRaw(test.GetMKey(1), 0, 7200, 0, 10, consolidation.Avg, 0, 0),
+	}
+	out, _, _, err := alignRequestsStep(1, 7200, 0, 7200, 600, reqs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp := reqOut(test.GetMKey(1), 0, 7200, 0, 10, consolidation.Avg, 0, 0, 1, 600, 86400, 600, 1)
+	if !exp.Equals(out[0]) {
+		t.Fatalf("expected: %v\n     got: %v", exp.DebugString(), out[0].DebugString())
+	}
+}
+
+// the points limits of the requesting org apply, falling back to the http settings if the org has none.
+// this is also the case for series of the public org.
+func TestAlignRequestsOrgLimits(t *testing.T) {
+	mdata.Schemas = conf.NewSchemas([]conf.Schema{
+		{
+			Pattern: regexp.MustCompile(".*"),
+			Retentions: conf.Retentions([]conf.Retention{
+				conf.NewRetentionMT(10, 86400, 0, 0, 0),
+			}),
+		},
+	})
+	tenantLimits := conf.NewTenantLimits()
+	tenantLimits.Orgs[2] = conf.Limits{MaxPointsPerReqHard: 100}
+	limits.Set(tenantLimits)
+	defer limits.Set(conf.NewTenantLimits())
+
+	cases := []struct {
+		org       uint32
+		seriesOrg uint32
+		expErr    error
+	}{
+		{1, 1, nil},
+		{1, idx.OrgIdPublic, nil},
+		{2, 2, errMaxPointsPerReq},
+		{2, idx.OrgIdPublic, errMaxPointsPerReq},
+	}
+	for i, c := range cases {
+		rejected := stats.NewCounter32(fmt.Sprintf("limits.org.%d.rejected.max-points-per-req-hard", c.org))
+		before := rejected.Peek()
+		key := test.GetMKey(1)
+		key.Org = c.seriesOrg
+		reqs := []models.Req{
+			reqRaw(key, 0, 7200, 0, 10, consolidation.Avg, 0, 0),
+		}
+		_, _, _, err := alignRequests(c.org, 7200, 0, 7200, reqs)
+		if err != c.expErr {
+			t.Fatalf("case %d: expected err %v, got %v", i, c.expErr, err)
+		}
+		if exp := before + map[bool]uint32{true: 1}[c.expErr != nil]; rejected.Peek() != exp {
+			t.Fatalf("case %d: expected %d rejections of org %d, got %d", i, exp, c.org, rejected.Peek())
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package response
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+)
+
+// SnappyProtobuf is a snappy compressed protobuf message, as used by the prometheus remote storage protocol
+type SnappyProtobuf struct {
+	code int
+	body proto.Message
+	buf  []byte
+}
+
+func NewSnappyProtobuf(code int, body proto.Message) *SnappyProtobuf {
+	return &SnappyProtobuf{
+		code: code,
+		body: body,
+		buf:  BufferPool.Get(),
+	}
+}
+
+func (r *SnappyProtobuf) Code() int {
+	return r.code
+}
+
+func (r *SnappyProtobuf) Close() {
+	BufferPool.Put(r.buf)
+}
+
+func (r *SnappyProtobuf) Body() ([]byte, error) {
+	data, err := proto.Marshal(r.body)
+	if err != nil {
+		return nil, err
+	}
+	r.buf = snappy.Encode(r.buf[:cap(r.buf)], data)
+	return r.buf, nil
+}
+
+func (r *SnappyProtobuf) Headers() (headers map[string]string) {
+	headers = map[string]string{
+		"content-type":     "application/x-protobuf",
+		"content-encoding": "snappy",
+	}
+	return headers
+}
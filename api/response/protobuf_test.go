@@ -0,0 +1,41 @@
+package response
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestSnappyProtobufRoundTrip(t *testing.T) {
+	in := &prompb.ReadResponse{
+		Results: []*prompb.QueryResult{
+			{
+				Timeseries: []*prompb.TimeSeries{
+					{
+						Labels:  []*prompb.Label{{Name: "__name__", Value: "foo"}},
+						Samples: []*prompb.Sample{{Value: 1.5, Timestamp: 1000}, {Value: 2.5, Timestamp: 2000}},
+					},
+				},
+			},
+		},
+	}
+	resp := NewSnappyProtobuf(200, in)
+	defer resp.Close()
+	body, err := resp.Body()
+	if err != nil {
+		t.Fatalf("failed to get body: %s", err)
+	}
+	buf, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("failed to decode body: %s", err)
+	}
+	var out prompb.ReadResponse
+	if err := proto.Unmarshal(buf, &out); err != nil {
+		t.Fatalf("failed to unmarshal body: %s", err)
+	}
+	if !proto.Equal(in, &out) {
+		t.Fatalf("expected %v, got %v", in, &out)
+	}
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/expr"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/util"
+	"github.com/raintank/schema"
+)
+
+var (
+	// metric api.request.render.cache.hit is how many render targets were served from the render cache, only computing the tail of the data
+	renderCacheHit = stats.NewCounter32("api.request.render.cache.hit")
+	// metric api.request.render.cache.miss is how many render targets could not be served from the render cache and were computed in full
+	renderCacheMiss = stats.NewCounter32("api.request.render.cache.miss")
+	// metric api.request.render.cache.mismatch is how many render targets had a cache entry that could not be combined with the newly computed tail.
+	// this happens when the tail is returned at a different resolution than the cached data.
+	renderCacheMismatch = stats.NewCounter32("api.request.render.cache.mismatch")
+	// metric api.request.render.cache.entries is the number of entries in the render cache
+	renderCacheEntries = stats.NewGauge32("api.request.render.cache.entries")
+)
+
+// resultCacheWarmup is the number of steps before the recomputed part of the cached data, that the tail computation starts at,
+// so that functions which need preceding points, such as derivative, have them.
+const resultCacheWarmup = 3
+
+// resultCacheKey identifies the output of a render target.
+// requests with the same key only differ in the time range they cover.
+// note that the consolidation is captured by the target (e.g. consolidateBy) and the step is part of the entry.
+// entries are only used for ranges of the same length, as along with maxDataPoints, it determines the resolution.
+type resultCacheKey struct {
+	orgId  uint32
+	target string // canonical form of the target
+	mdp    uint32
+}
+
+// resultCacheEntry is the output of a render target for a given time range.
+// the points must not be modified, as they may be returned to several requests.
+type resultCacheEntry struct {
+	key     resultCacheKey
+	from    uint32
+	to      uint32
+	step    uint32 // the interval of all series
+	series  []models.Series
+	created time.Time // when the oldest data in the entry was computed
+}
+
+// resultCache caches the output of render targets, so that requests which only move the time range forward,
+// such as dashboards that refresh every few seconds, only need to fetch data for and compute the new tail.
+// the last overlap of the cached data is always recomputed, to pick up data that arrived late.
+// entries are evicted in LRU order, and once they are older than maxAge.
+type resultCache struct {
+	sync.Mutex
+	maxEntries int
+	maxAge     time.Duration
+	overlap    uint32
+	entries    map[resultCacheKey]*list.Element
+	lru        *list.List
+}
+
+func newResultCache(maxEntries int, maxAge, overlap time.Duration) *resultCache {
+	return &resultCache{
+		maxEntries: maxEntries,
+		maxAge:     maxAge,
+		overlap:    uint32(overlap.Seconds()),
+		entries:    make(map[resultCacheKey]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// get returns the entry for the key, if it can be used for a request for the given time range:
+// a range of the same length, that starts within the cached range.
+func (c *resultCache) get(key resultCacheKey, from, to uint32, now time.Time) (*resultCacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*resultCacheEntry)
+	if now.Sub(entry.created) > c.maxAge {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	// a range of a different length may be returned at a different resolution
+	if entry.to-entry.from != to-from {
+		return nil, false
+	}
+	// the cached data must cover the start of the requested range, and leave something to reuse after recomputing the overlap
+	if entry.from > from || entry.to > to || entry.to < from+c.overlap+entry.step {
+		return nil, false
+	}
+	return entry, true
+}
+
+// add adds the entry to the cache, replacing any entry with the same key
+func (c *resultCache) add(entry *resultCacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+	renderCacheEntries.SetUint32(uint32(c.lru.Len()))
+}
+
+func (c *resultCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*resultCacheEntry).key)
+	renderCacheEntries.SetUint32(uint32(c.lru.Len()))
+}
+
+// newResultCacheEntry returns an entry for the given output, or false if it can't be cached
+// because the series have different intervals.
+func newResultCacheEntry(key resultCacheKey, from, to uint32, series []models.Series, created time.Time) (*resultCacheEntry, bool) {
+	entry := resultCacheEntry{
+		key:     key,
+		from:    from,
+		to:      to,
+		series:  make([]models.Series, len(series)),
+		created: created,
+	}
+	for i, serie := range series {
+		if i > 0 && serie.Interval != entry.step {
+			return nil, false
+		}
+		entry.step = serie.Interval
+		entry.series[i] = serie
+		entry.series[i].Datapoints = append([]schema.Point(nil), serie.Datapoints...)
+	}
+	return &entry, true
+}
+
+// cut returns the timestamp from which the cached data should be recomputed
+func (e *resultCacheEntry) cut(overlap uint32) uint32 {
+	return e.to - overlap - e.step
+}
+
+// splice returns the output for the range starting at from, by combining the cached data before cut
+// with the tail: the output for a range that starts at least resultCacheWarmup steps before cut.
+// the tail is consolidated to the cached step if needed.
+// it returns false if the tail can't be combined with the cached data, e.g. because it has a different set of series,
+// or the points don't line up.
+func (e *resultCacheEntry) splice(tail []models.Series, from, cut uint32) ([]models.Series, bool) {
+	if len(tail) != len(e.series) {
+		return nil, false
+	}
+	out := make([]models.Series, len(tail))
+	for i, serie := range tail {
+		cached := e.series[i]
+		if serie.Target != cached.Target {
+			return nil, false
+		}
+		points := serie.Datapoints
+		if serie.Interval != e.step {
+			if serie.Interval == 0 || e.step%serie.Interval != 0 {
+				return nil, false
+			}
+			cons := serie.Consolidator
+			if cons == 0 {
+				cons = consolidation.Avg
+			}
+			// consolidate a copy, as the input may be shared with other outputs
+			points = append([]schema.Point(nil), points...)
+			points, _ = consolidation.ConsolidateStableAggNum(points, serie.Interval, e.step/serie.Interval, cons)
+		}
+
+		// find the first cached point to recompute, and the same point in the tail
+		c := len(cached.Datapoints)
+		for j, p := range cached.Datapoints {
+			if p.Ts >= cut {
+				c = j
+				break
+			}
+		}
+		if c == len(cached.Datapoints) {
+			return nil, false
+		}
+		t := -1
+		for j, p := range points {
+			if p.Ts == cached.Datapoints[c].Ts {
+				t = j
+				break
+			}
+		}
+		if t < 1 {
+			// not found, or the tail doesn't have any points before the cut, so they may not be reliable
+			return nil, false
+		}
+
+		s := 0
+		for s < c && cached.Datapoints[s].Ts < from {
+			s++
+		}
+		out[i] = serie
+		out[i].Interval = e.step
+		out[i].Datapoints = make([]schema.Point, 0, c-s+len(points)-t)
+		out[i].Datapoints = append(out[i].Datapoints, cached.Datapoints[s:c]...)
+		out[i].Datapoints = append(out[i].Datapoints, points[t:]...)
+	}
+	return out, true
+}
+
+// executePlanCached is like executePlan, but uses the render cache for targets that can be evaluated incrementally:
+// for targets with a usable cache entry, only the tail of the data is fetched and computed.
+// all other targets are computed in full, and if possible added to the cache.
+func (s *Server) executePlanCached(ctx context.Context, orgId uint32, plan expr.Plan) ([][]models.Series, models.RenderMeta, error) {
+	var meta models.RenderMeta
+	now := time.Now()
+	canonical := plan.Canonical()
+	incremental := plan.Incremental()
+	outs := make([][]models.Series, len(canonical))
+
+	keys := make([]resultCacheKey, len(canonical))
+	entries := make([]*resultCacheEntry, len(canonical))
+	var hits, misses []int
+	tailFrom := plan.To
+	for i, target := range canonical {
+		keys[i] = resultCacheKey{orgId, target, plan.MaxDataPoints}
+		if !incremental[i] {
+			misses = append(misses, i)
+			continue
+		}
+		entry, ok := renderCache.get(keys[i], plan.From, plan.To, now)
+		if !ok {
+			renderCacheMiss.Inc()
+			misses = append(misses, i)
+			continue
+		}
+		entries[i] = entry
+		hits = append(hits, i)
+		cut := entry.cut(renderCache.overlap)
+		tailFrom = util.Min(tailFrom, cut-util.Min(cut, resultCacheWarmup*entry.step))
+	}
+
+	if len(hits) > 0 {
+		// the tail is computed without maxDataPoints, as we need to consolidate it the same way as the cached data
+		tailPlan, err := plan.Replan(hits, tailFrom, plan.To, 0)
+		if err != nil {
+			return nil, meta, err
+		}
+		var alignShift uint32
+		if tailFrom > plan.From {
+			alignShift = tailFrom - plan.From
+		}
+		tailOuts, tailMeta, err := s.executePlan(ctx, orgId, tailPlan, alignShift)
+		if err != nil || ctx.Err() != nil {
+			return nil, meta, err
+		}
+		meta = addRenderMeta(meta, tailMeta)
+		if tailOuts == nil {
+			// no series were found
+			tailOuts = make([][]models.Series, len(hits))
+		}
+		for j, i := range hits {
+			entry := entries[i]
+			out, ok := entry.splice(tailOuts[j], plan.From, entry.cut(renderCache.overlap))
+			if !ok {
+				renderCacheMismatch.Inc()
+				misses = append(misses, i)
+				continue
+			}
+			renderCacheHit.Inc()
+			outs[i] = out
+			// the spliced output doesn't share any points with the tail, so we can cache it as is
+			renderCache.add(&resultCacheEntry{keys[i], plan.From, plan.To, entry.step, out, entry.created})
+		}
+	}
+
+	if len(misses) > 0 {
+		// targets that couldn't be combined with their cache entry were appended out of order
+		sort.Ints(misses)
+		missPlan := plan
+		if len(misses) < len(canonical) {
+			var err error
+			missPlan, err = plan.Replan(misses, plan.From, plan.To, plan.MaxDataPoints)
+			if err != nil {
+				return nil, meta, err
+			}
+		}
+		missOuts, missMeta, err := s.executePlan(ctx, orgId, missPlan, 0)
+		if err != nil || ctx.Err() != nil {
+			return nil, meta, err
+		}
+		meta = addRenderMeta(meta, missMeta)
+		if missOuts == nil {
+			// no series were found
+			missOuts = make([][]models.Series, len(misses))
+		}
+		for j, i := range misses {
+			outs[i] = missOuts[j]
+			if !incremental[i] {
+				continue
+			}
+			if entry, ok := newResultCacheEntry(keys[i], plan.From, plan.To, missOuts[j], now); ok {
+				renderCache.add(entry)
+			}
+		}
+	}
+	return outs, meta, nil
+}
+
+// addRenderMeta returns the combined stats of two plan executions
+func addRenderMeta(a, b models.RenderMeta) models.RenderMeta {
+	a.Stats.ResolveSeriesDuration += b.Stats.ResolveSeriesDuration
+	a.Stats.GetTargetsDuration += b.Stats.GetTargetsDuration
+	a.Stats.PrepareSeriesDuration += b.Stats.PrepareSeriesDuration
+	a.Stats.PlanRunDuration += b.Stats.PlanRunDuration
+	a.Stats.SeriesFetch += b.Stats.SeriesFetch
+	a.Stats.PointsFetch += b.Stats.PointsFetch
+	a.Stats.PointsReturn += b.Stats.PointsReturn
+	return a
+}
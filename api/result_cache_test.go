@@ -0,0 +1,175 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+// resultCacheSeries returns a series with a point every interval between from and to (inclusive), with the value of ts + offset
+func resultCacheSeries(target string, from, to, interval uint32, offset float64) models.Series {
+	var points []schema.Point
+	for ts := from; ts <= to; ts += interval {
+		points = append(points, schema.Point{Val: float64(ts) + offset, Ts: ts})
+	}
+	return models.Series{
+		Target:     target,
+		Interval:   interval,
+		Datapoints: points,
+	}
+}
+
+func TestResultCacheGet(t *testing.T) {
+	now := time.Now()
+	c := newResultCache(10, time.Minute, 60*time.Second)
+	key := resultCacheKey{1, "foo", 800}
+	entry, ok := newResultCacheEntry(key, 100, 1000, []models.Series{resultCacheSeries("foo", 100, 990, 10, 0)}, now)
+	if !ok {
+		t.Fatal("expected entry to be created")
+	}
+	c.add(entry)
+
+	cases := []struct {
+		key  resultCacheKey
+		from uint32
+		to   uint32
+		now  time.Time
+		exp  bool
+	}{
+		{key, 100, 1000, now, true},
+		{key, 110, 1010, now, true},
+		{key, 900, 1800, now, true},
+		{key, 900, 1500, now, false}, // a shorter range, so a finer resolution for the same maxDataPoints
+		{key, 100, 1500, now, false}, // a longer range, so a coarser resolution
+		{resultCacheKey{2, "foo", 800}, 110, 1010, now, false},
+		{resultCacheKey{1, "foo", 0}, 110, 1010, now, false},
+		{key, 90, 1010, now, false},  // starts before the cached data
+		{key, 110, 990, now, false},  // ends before the cached data
+		{key, 950, 1850, now, false}, // nothing left to reuse after recomputing the overlap
+		{key, 110, 1010, now.Add(2 * time.Minute), false},
+		{key, 110, 1010, now, false}, // the previous lookup removed the expired entry
+	}
+	for i, tc := range cases {
+		_, ok := c.get(tc.key, tc.from, tc.to, tc.now)
+		if ok != tc.exp {
+			t.Fatalf("case %d: expected %t, got %t", i, tc.exp, ok)
+		}
+	}
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	now := time.Now()
+	c := newResultCache(2, time.Minute, 0)
+	for _, target := range []string{"a", "b", "a", "c"} {
+		entry, _ := newResultCacheEntry(resultCacheKey{1, target, 0}, 100, 1000, []models.Series{resultCacheSeries(target, 100, 990, 10, 0)}, now)
+		c.add(entry)
+	}
+	// b was the least recently used
+	for target, exp := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(resultCacheKey{1, target, 0}, 100, 1000, now); ok != exp {
+			t.Fatalf("target %s: expected %t, got %t", target, exp, ok)
+		}
+	}
+}
+
+func TestNewResultCacheEntryMixedIntervals(t *testing.T) {
+	series := []models.Series{
+		resultCacheSeries("a", 100, 990, 10, 0),
+		resultCacheSeries("b", 100, 990, 30, 0),
+	}
+	if _, ok := newResultCacheEntry(resultCacheKey{1, "*", 0}, 100, 1000, series, time.Now()); ok {
+		t.Fatal("expected series with different intervals not to be cacheable")
+	}
+}
+
+func TestResultCacheSplice(t *testing.T) {
+	cases := []struct {
+		name   string
+		cached []models.Series
+		step   uint32
+		tail   []models.Series
+		exp    []models.Series
+		expOk  bool
+	}{
+		{
+			name:   "same interval",
+			cached: []models.Series{resultCacheSeries("a", 10, 100, 10, 0)},
+			step:   10,
+			tail:   []models.Series{resultCacheSeries("a", 30, 110, 10, 1000)},
+			exp: []models.Series{{
+				Target:   "a",
+				Interval: 10,
+				Datapoints: append(
+					resultCacheSeries("a", 20, 60, 10, 0).Datapoints,
+					resultCacheSeries("a", 70, 110, 10, 1000).Datapoints...,
+				),
+			}},
+			expOk: true,
+		},
+		{
+			// the tail consists of buckets 30-40, 50-60, 70-80, 90-100 and the partial bucket 110
+			name:   "consolidated",
+			cached: []models.Series{resultCacheSeries("a", 20, 100, 20, 0)},
+			step:   20,
+			tail:   []models.Series{resultCacheSeries("a", 30, 110, 10, 0)},
+			exp: []models.Series{{
+				Target:   "a",
+				Interval: 20,
+				Datapoints: []schema.Point{
+					{Val: 20, Ts: 20},
+					{Val: 40, Ts: 40},
+					{Val: 60, Ts: 60},
+					{Val: 75, Ts: 80},
+					{Val: 95, Ts: 100},
+					{Val: 110, Ts: 120},
+				},
+			}},
+			expOk: true,
+		},
+		{
+			name:   "different series",
+			cached: []models.Series{resultCacheSeries("a", 10, 100, 10, 0)},
+			step:   10,
+			tail:   []models.Series{resultCacheSeries("b", 30, 110, 10, 0)},
+			expOk:  false,
+		},
+		{
+			name:   "new series",
+			cached: []models.Series{resultCacheSeries("a", 10, 100, 10, 0)},
+			step:   10,
+			tail:   []models.Series{resultCacheSeries("a", 30, 110, 10, 0), resultCacheSeries("b", 30, 110, 10, 0)},
+			expOk:  false,
+		},
+		{
+			name:   "tail starts at the cut",
+			cached: []models.Series{resultCacheSeries("a", 10, 100, 10, 0)},
+			step:   10,
+			tail:   []models.Series{resultCacheSeries("a", 70, 110, 10, 0)},
+			expOk:  false,
+		},
+		{
+			name:   "points don't line up",
+			cached: []models.Series{resultCacheSeries("a", 10, 100, 10, 0)},
+			step:   10,
+			tail:   []models.Series{resultCacheSeries("a", 35, 115, 10, 0)},
+			expOk:  false,
+		},
+	}
+	for _, c := range cases {
+		entry, ok := newResultCacheEntry(resultCacheKey{1, "a", 0}, 10, 101, c.cached, time.Now())
+		if !ok || entry.step != c.step {
+			t.Fatalf("case %q: expected entry with step %d, got %v", c.name, c.step, entry)
+		}
+		// cut is 61: points from 61 onwards are recomputed
+		out, ok := entry.splice(c.tail, 20, entry.cut(40-c.step))
+		if ok != c.expOk {
+			t.Fatalf("case %q: expected ok %t, got %t", c.name, c.expOk, ok)
+		}
+		if ok && !reflect.DeepEqual(out, c.exp) {
+			t.Fatalf("case %q: expected %v, got %v", c.name, c.exp, out)
+		}
+	}
+}
@@ -22,6 +22,7 @@ func (s *Server) RegisterRoutes() {
 	form := binding.Form
 	bind := binding.Bind
 	withOrg := middleware.RequireOrg()
+	limited := middleware.Limits()
 	cBody := middleware.CaptureBody
 	ready := middleware.NodeReady()
 	noTrace := middleware.DisableTracing
@@ -51,6 +52,7 @@ func (s *Server) RegisterRoutes() {
 	r.Combo("/index/metaTags/upsert", ready, bind(models.IndexMetaTagRecordUpsert{})).Get(s.indexMetaTagRecordUpsert).Post(s.indexMetaTagRecordUpsert)
 
 	r.Combo("/ccache/delete", bind(models.CCacheDelete{})).Post(s.ccacheDelete).Get(s.ccacheDelete)
+	r.Combo("/ccache/search", bind(models.CCacheSearch{})).Post(s.ccacheSearch).Get(s.ccacheSearch)
 
 	r.Options("/*", func(ctx *macaron.Context) {
 		ctx.Write(nil)
@@ -59,13 +61,13 @@ func (s *Server) RegisterRoutes() {
 	r.Combo("/showplan", cBody, withOrg, ready, bind(models.GraphiteRender{})).Get(s.showPlan).Post(s.showPlan)
 
 	// Graphite endpoints
-	r.Combo("/render", cBody, withOrg, ready, bind(models.GraphiteRender{})).Get(s.renderMetrics).Post(s.renderMetrics)
-	r.Combo("/metrics/find", withOrg, ready, bind(models.GraphiteFind{})).Get(s.metricsFind).Post(s.metricsFind)
+	r.Combo("/render", cBody, withOrg, limited, ready, bind(models.GraphiteRender{})).Get(s.renderMetrics).Post(s.renderMetrics)
+	r.Combo("/metrics/find", withOrg, limited, ready, bind(models.GraphiteFind{})).Get(s.metricsFind).Post(s.metricsFind)
 	r.Get("/metrics/index.json", withOrg, ready, s.metricsIndex)
 	r.Post("/metrics/delete", withOrg, ready, bind(models.MetricsDelete{}), s.metricsDelete)
 	r.Combo("/tags", withOrg, ready, bind(models.GraphiteTags{})).Get(s.graphiteTags).Post(s.graphiteTags)
 	r.Combo("/tags/:tag([0-9a-zA-Z]+)", withOrg, ready, bind(models.GraphiteTagDetails{})).Get(s.graphiteTagDetails).Post(s.graphiteTagDetails)
-	r.Combo("/tags/findSeries", withOrg, ready, bind(models.GraphiteTagFindSeries{})).Get(s.graphiteTagFindSeries).Post(s.graphiteTagFindSeries)
+	r.Combo("/tags/findSeries", withOrg, limited, ready, bind(models.GraphiteTagFindSeries{})).Get(s.graphiteTagFindSeries).Post(s.graphiteTagFindSeries)
 	r.Combo("/tags/autoComplete/tags", withOrg, ready, bind(models.GraphiteAutoCompleteTags{})).Get(s.graphiteAutoCompleteTags).Post(s.graphiteAutoCompleteTags)
 	r.Combo("/tags/autoComplete/values", withOrg, ready, bind(models.GraphiteAutoCompleteTagValues{})).Get(s.graphiteAutoCompleteTagValues).Post(s.graphiteAutoCompleteTagValues)
 	r.Post("/tags/delSeries", withOrg, ready, bind(models.GraphiteTagDelSeries{}), s.graphiteTagDelSeries)
@@ -76,10 +78,15 @@ func (s *Server) RegisterRoutes() {
 	r.Post("/metaTags/upsert", withOrg, ready, bind(models.MetaTagRecordUpsert{}), s.metaTagRecordUpsert)
 	r.Get("/metaTags", withOrg, ready, s.getMetaTagRecords)
 
+	if importEnabled {
+		r.Post("/import", withOrg, ready, s.importData)
+	}
+
 	// Prometheus endpoints
-	r.Combo("/prometheus/api/v1/query_range", cBody, withOrg, ready, form(models.PrometheusRangeQuery{})).Get(s.prometheusQueryRange).Post(s.prometheusQueryRange)
-	r.Combo("/prometheus/api/v1/query", cBody, withOrg, ready, form(models.PrometheusQueryInstant{})).Get(s.prometheusQueryInstant).Post(s.prometheusQueryInstant)
-	r.Combo("/prometheus/api/v1/series", cBody, withOrg, ready, form(models.PrometheusSeriesQuery{})).Get(s.prometheusQuerySeries).Post(s.prometheusQuerySeries)
+	r.Combo("/prometheus/api/v1/query_range", cBody, withOrg, limited, ready, form(models.PrometheusRangeQuery{})).Get(s.prometheusQueryRange).Post(s.prometheusQueryRange)
+	r.Combo("/prometheus/api/v1/query", cBody, withOrg, limited, ready, form(models.PrometheusQueryInstant{})).Get(s.prometheusQueryInstant).Post(s.prometheusQueryInstant)
+	r.Combo("/prometheus/api/v1/series", cBody, withOrg, limited, ready, form(models.PrometheusSeriesQuery{})).Get(s.prometheusQuerySeries).Post(s.prometheusQuerySeries)
 	r.Get("/prometheus/api/v1/label/:name/values", cBody, withOrg, ready, s.prometheusLabelValues)
+	r.Post("/prometheus/api/v1/read", withOrg, limited, ready, s.prometheusRead)
 	r.Get("/prometheus/metrics", promhttp.Handler())
 }
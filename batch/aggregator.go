@@ -188,3 +188,45 @@ func Sum(in []schema.Point) float64 {
 	}
 	return sum
 }
+
+// Percentile returns an AggFunc that computes the n-th percentile of the non-NaN values,
+// the same way graphite does. see PercentileOfSorted
+func Percentile(n float64, interpolate bool) AggFunc {
+	return func(in []schema.Point) float64 {
+		vals := make([]float64, 0, len(in))
+		for _, v := range in {
+			if !math.IsNaN(v.Val) {
+				vals = append(vals, v.Val)
+			}
+		}
+		sort.Float64s(vals)
+		return PercentileOfSorted(vals, n, interpolate)
+	}
+}
+
+// PercentileOfSorted returns the n-th percentile of the given sorted values, or NaN if there are none.
+// the value is picked by nearest rank, unless interpolate is set, in which case
+// it is linearly interpolated between the two closest ranks.
+// percentiles above 100 return the largest value.
+func PercentileOfSorted(sorted []float64, n float64, interpolate bool) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	fractionalRank := n / 100 * float64(len(sorted)+1)
+	rank := int(fractionalRank)
+	rankFraction := fractionalRank - float64(rank)
+	if !interpolate {
+		rank += int(math.Ceil(rankFraction))
+	}
+	if rank == 0 {
+		return sorted[0]
+	}
+	if rank >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	percentile := sorted[rank-1]
+	if interpolate {
+		percentile += rankFraction * (sorted[rank] - percentile)
+	}
+	return percentile
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
+	log "github.com/sirupsen/logrus"
 )
 
 var counter uint32
@@ -41,8 +42,14 @@ func Init(name, version string, started time.Time, apiScheme string, apiPort int
 	}
 	if Mode == ModeDev {
 		Manager = NewSingleNodeManager(thisNode)
-	} else { // Shard or Query mode
+	} else if discovery == "gossip" { // Shard or Query mode
 		Manager = NewMemberlistManager(thisNode)
+	} else {
+		discoverer, err := NewDiscoverer()
+		if err != nil {
+			log.Fatalf("CLU Init: %s", err.Error())
+		}
+		Manager = NewDiscoveryManager(thisNode, discoverer, discoveryInterval)
 	}
 	// initialize our "primary" state metric.
 	nodePrimary.Set(primary)
@@ -66,8 +73,8 @@ type partitionCandidates struct {
 // (a[0,1], b[0,1], c[2,3], d[2,3] as opposed to a[0,1], b[0,2], c[1,3], d[2,3]),
 // only 1 member per partition is returned.
 // The nodes are selected based on priority, preferring thisNode if it
-// has the lowest prio, otherwise using a random selection from all
-// nodes with the lowest prio.
+// has the lowest prio, otherwise selecting the fastest and least loaded node
+// from all nodes with the lowest prio (see PeerHealth).
 func MembersForQuery() ([]Node, error) {
 	thisNode := Manager.ThisNode()
 	// If we are running in dev mode, just return thisNode
@@ -143,11 +150,11 @@ LOOP:
 			}
 		}
 
-		// if no nodes have been selected yet then grab a node from
-		// the set of available nodes in such a way that nodes are
+		// if no nodes have been selected yet then grab the best node from
+		// the set of available nodes. nodes that are equally good are
 		// weighted fairly across MembersForQuery calls
 
-		selected := candidates.nodes[count%len(candidates.nodes)]
+		selected := selectReplica(candidates.nodes, count)
 		selectedMembers[selected.GetName()] = struct{}{}
 		answer = append(answer, selected)
 	}
@@ -192,8 +199,16 @@ func MembersForSpeculativeQuery() (map[int32][]Node, error) {
 			j := rand.Intn(i + 1)
 			shard[i], shard[j] = shard[j], shard[i]
 		}
-		sort.Slice(shard, func(i, j int) bool {
-			return shard[i].GetPriority() < shard[j].GetPriority()
+		// within a priority, prefer the fastest and least loaded peers
+		scores := make(map[string]float64, len(shard))
+		for _, n := range shard {
+			scores[n.GetName()] = GetPeerHealth(n.GetName()).score()
+		}
+		sort.SliceStable(shard, func(i, j int) bool {
+			if shard[i].GetPriority() != shard[j].GetPriority() {
+				return shard[i].GetPriority() < shard[j].GetPriority()
+			}
+			return scores[shard[i].GetName()] < scores[shard[j].GetName()]
 		})
 	}
 
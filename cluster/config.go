@@ -27,6 +27,14 @@ var (
 
 	gossipSettlePeriodStr string
 
+	discovery         = "gossip"
+	discoveryInterval time.Duration
+	discoveryDNSName  string
+	discoveryFile     string
+
+	circuitBreakerFailures   = 5
+	circuitBreakerOpenPeriod = 10 * time.Second
+
 	swimUseConfig               = "default-lan"
 	swimAdvertiseAddrStr        string
 	swimAdvertiseAddr           *net.TCPAddr
@@ -72,13 +80,19 @@ func ConfigSetup() {
 	clusterCfg := flag.NewFlagSet("cluster", flag.ExitOnError)
 	clusterCfg.StringVar(&ClusterName, "name", "metrictank", "Unique name of the cluster.")
 	clusterCfg.BoolVar(&primary, "primary-node", false, "the primary node writes data to cassandra. There should only be 1 primary node per shardGroup.")
-	clusterCfg.StringVar(&peersStr, "peers", "", "TCP addresses of other nodes, comma separated. use this if you shard your data and want to query other instances")
+	clusterCfg.StringVar(&peersStr, "peers", "", "TCP addresses of other nodes, comma separated. use this if you shard your data and want to query other instances. with static discovery, these are the http api addresses of the nodes, optionally prefixed with http:// or https://")
 	clusterCfg.StringVar(&mode, "mode", "dev", "Operating mode of this instance within the cluster. (dev|shard|query)")
 	clusterCfg.DurationVar(&httpTimeout, "http-timeout", time.Second*60, "How long to wait before aborting http requests to cluster peers and returning a http 503 service unavailable")
 	clusterCfg.IntVar(&maxPrio, "max-priority", 10, "maximum priority before a node should be considered not-ready.")
 	clusterCfg.IntVar(&minAvailableShards, "min-available-shards", 0, "minimum number of shards that must be available for a query to be handled.")
 	clusterCfg.IntVar(&gcPercentNotReady, "gc-percent-not-ready", gcPercent, "GOGC value to use when node is not ready.  Defaults to GOGC")
 	clusterCfg.StringVar(&gossipSettlePeriodStr, "gossip-settle-period", "10s", "duration until when the cluster topology can be considered up-to-date and this node to be ready to serve requests (when gossip enabled).")
+	clusterCfg.StringVar(&discovery, "discovery", "gossip", "how to discover the other nodes in shard/query mode. (gossip|static|dns|file)")
+	clusterCfg.DurationVar(&discoveryInterval, "discovery-interval", 5*time.Second, "interval between discoveries, and the timeout for polling the state of the discovered nodes (when discovery is not gossip)")
+	clusterCfg.StringVar(&discoveryDNSName, "discovery-dns-name", "", "name of which the SRV records point to the http api of the nodes (when discovery is dns)")
+	clusterCfg.StringVar(&discoveryFile, "discovery-file", "", "path of a file with the http api addresses of the nodes, one per line. changes to the file are picked up (when discovery is file)")
+	clusterCfg.IntVar(&circuitBreakerFailures, "circuit-breaker-failures", 5, "number of consecutive failed requests to a peer after which its circuit opens, and it is avoided for queries if other peers have its data. 0 disables")
+	clusterCfg.DurationVar(&circuitBreakerOpenPeriod, "circuit-breaker-open-period", 10*time.Second, "how long the circuit of a peer stays open, before requests are sent to it again to see whether it recovered")
 	globalconf.Register("cluster", clusterCfg, flag.ExitOnError)
 
 	swimCfg := flag.NewFlagSet("swim", flag.ExitOnError)
@@ -134,6 +148,31 @@ func ConfigProcess() {
 		return
 	}
 
+	switch discovery {
+	case "gossip":
+	case "static":
+		if peersStr == "" {
+			log.Fatal("CLU Config: peers must be set when discovery is static")
+		}
+	case "dns":
+		if discoveryDNSName == "" {
+			log.Fatal("CLU Config: discovery-dns-name must be set when discovery is dns")
+		}
+	case "file":
+		if discoveryFile == "" {
+			log.Fatal("CLU Config: discovery-file must be set when discovery is file")
+		}
+	default:
+		log.Fatalf("CLU Config: invalid discovery %q. should be gossip|static|dns|file", discovery)
+	}
+	if discovery != "gossip" {
+		if discoveryInterval <= 0 {
+			log.Fatal("CLU Config: discovery-interval must be a non-zero duration string like 5s")
+		}
+		// there is no gossip to settle, and the first discovery is done before starting up. swim settings are not used.
+		return
+	}
+
 	GossipSettlePeriod, err = time.ParseDuration(gossipSettlePeriodStr)
 	if err != nil {
 		log.Fatalf("CLU Config: invalid gossip-settle-period: %s", err.Error())
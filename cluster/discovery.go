@@ -0,0 +1,127 @@
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Discoverer finds the addresses of the http api of the nodes in the cluster,
+// for cluster managers that don't use gossip.
+// addresses are host:port, optionally prefixed with the scheme (http:// or https://). the default scheme is http.
+// the list may include the address of this node.
+type Discoverer interface {
+	Discover() ([]string, error)
+}
+
+// NewDiscoverer returns the discoverer for the configured discovery method, which must not be gossip
+func NewDiscoverer() (Discoverer, error) {
+	switch discovery {
+	case "static":
+		return NewStaticDiscoverer(strings.Split(peersStr, ",")), nil
+	case "dns":
+		return NewDNSDiscoverer(discoveryDNSName), nil
+	case "file":
+		return NewFileDiscoverer(discoveryFile), nil
+	}
+	return nil, fmt.Errorf("invalid discovery %q", discovery)
+}
+
+// StaticDiscoverer returns a fixed list of addresses
+type StaticDiscoverer struct {
+	addrs []string
+}
+
+func NewStaticDiscoverer(addrs []string) *StaticDiscoverer {
+	d := &StaticDiscoverer{}
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			d.addrs = append(d.addrs, addr)
+		}
+	}
+	return d
+}
+
+func (d *StaticDiscoverer) Discover() ([]string, error) {
+	return d.addrs, nil
+}
+
+// DNSDiscoverer returns the targets of the SRV records of a name
+type DNSDiscoverer struct {
+	name      string
+	lookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+}
+
+func NewDNSDiscoverer(name string) *DNSDiscoverer {
+	return &DNSDiscoverer{
+		name:      name,
+		lookupSRV: net.LookupSRV,
+	}
+}
+
+func (d *DNSDiscoverer) Discover() ([]string, error) {
+	_, records, err := d.lookupSRV("", "", d.name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(records))
+	for _, r := range records {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// FileDiscoverer returns the addresses in a file, one per line. empty lines and lines starting with # are ignored.
+// the file is read on every discovery, so changes to it are picked up.
+type FileDiscoverer struct {
+	path string
+}
+
+func NewFileDiscoverer(path string) *FileDiscoverer {
+	return &FileDiscoverer{path: path}
+}
+
+func (d *FileDiscoverer) Discover() ([]string, error) {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var addrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, scanner.Err()
+}
+
+// parsePeerAddr parses an address as returned by a Discoverer into its scheme, host and port
+func parsePeerAddr(addr string) (string, string, int, error) {
+	scheme := "http"
+	if i := strings.Index(addr, "://"); i >= 0 {
+		scheme = addr[:i]
+		addr = addr[i+3:]
+	}
+	if scheme != "http" && scheme != "https" {
+		return "", "", 0, fmt.Errorf("invalid scheme %q", scheme)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return scheme, host, port, nil
+}
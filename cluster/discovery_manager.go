@@ -0,0 +1,352 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// metric cluster.discovery.errors is how many discoveries of the cluster nodes failed
+	discoveryErrors = stats.NewCounter32("cluster.discovery.errors")
+	// metric cluster.discovery.poll_errors is how many times polling the state of a discovered node failed
+	discoveryPollErrors = stats.NewCounter32("cluster.discovery.poll_errors")
+)
+
+// DiscoveryManager is a ClusterManager that doesn't use gossip.
+// the nodes are found using a Discoverer, and their state is polled from their /node endpoint.
+// likewise, other nodes learn about the state of this node by polling it.
+type DiscoveryManager struct {
+	sync.RWMutex
+	members    map[string]HTTPNode // all members in the cluster, guaranteed to always have this node
+	nodeName   string
+	discoverer Discoverer
+	interval   time.Duration
+	peers      map[string]string // discovered address -> name of the node at that address
+	joined     []string          // addresses added with Join
+	shutdown   chan struct{}
+	wg         sync.WaitGroup
+
+	refreshLock sync.Mutex // serializes refreshes
+}
+
+func NewDiscoveryManager(thisNode HTTPNode, discoverer Discoverer, interval time.Duration) *DiscoveryManager {
+	return &DiscoveryManager{
+		members: map[string]HTTPNode{
+			thisNode.Name: thisNode,
+		},
+		nodeName:   thisNode.Name,
+		discoverer: discoverer,
+		interval:   interval,
+		peers:      make(map[string]string),
+		shutdown:   make(chan struct{}),
+	}
+}
+
+// Start does the first discovery, so that the cluster topology is known once it returns,
+// and then keeps refreshing it in the background.
+func (c *DiscoveryManager) Start() {
+	log.Infof("CLU Start: Starting cluster discovery with interval %s", c.interval)
+	c.refresh()
+	c.wg.Add(1)
+	go c.run()
+}
+
+func (c *DiscoveryManager) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.shutdown:
+			return
+		case <-ticker.C:
+			c.refresh()
+		}
+	}
+}
+
+// refresh discovers the nodes, and polls their state.
+// nodes that are no longer discovered are removed, nodes that can't be polled are marked unreachable.
+func (c *DiscoveryManager) refresh() {
+	c.refreshLock.Lock()
+	defer c.refreshLock.Unlock()
+	discovered, err := c.discoverer.Discover()
+	if err != nil {
+		// keep the nodes we know about, until we can discover them again
+		discoveryErrors.Inc()
+		log.Errorf("CLU discovery: failed to discover nodes: %s", err.Error())
+		return
+	}
+	c.RLock()
+	addrs := make([]string, 0, len(discovered)+len(c.joined))
+	addrs = append(addrs, discovered...)
+	addrs = append(addrs, c.joined...)
+	c.RUnlock()
+	sort.Strings(addrs)
+	uniq := addrs[:0]
+	for i, addr := range addrs {
+		if i == 0 || addr != addrs[i-1] {
+			uniq = append(uniq, addr)
+		}
+	}
+	nodes, errs := c.poll(uniq)
+
+	now := time.Now()
+	c.Lock()
+	defer c.Unlock()
+	seen := make(map[string]struct{})
+	peers := make(map[string]string)
+	for i, addr := range uniq {
+		if errs[i] != nil {
+			discoveryPollErrors.Inc()
+			name, ok := c.peers[addr]
+			if !ok {
+				log.Debugf("CLU discovery: failed to poll node at %s: %s", addr, errs[i].Error())
+				continue
+			}
+			peers[addr] = name
+			member, ok := c.members[name]
+			if !ok || name == c.nodeName {
+				continue
+			}
+			seen[name] = struct{}{}
+			if member.State != NodeUnreachable {
+				log.Warnf("CLU discovery: HTTPNode %s at %s is unreachable: %s", name, addr, errs[i].Error())
+				member.State = NodeUnreachable
+				member.StateChange = now
+				c.members[name] = member
+				eventsUpdate.Inc()
+			}
+			continue
+		}
+		member := nodes[i]
+		peers[addr] = member.Name
+		// we never want anyone else in the cluster to tell us anything about ourselves
+		// cause we know ourself best.
+		if member.Name == c.nodeName {
+			continue
+		}
+		seen[member.Name] = struct{}{}
+		existing, ok := c.members[member.Name]
+		if !ok {
+			log.Infof("CLU discovery: HTTPNode %s with address %s has joined the cluster", member.Name, addr)
+			eventsJoin.Inc()
+		} else if member.Updated.After(existing.Updated) || existing.State == NodeUnreachable || existing.RemoteURL() != member.RemoteURL() {
+			log.Infof("CLU discovery: HTTPNode %s at %s has been updated", member.Name, addr)
+			eventsUpdate.Inc()
+		}
+		c.members[member.Name] = member
+	}
+	for name := range c.members {
+		if _, ok := seen[name]; !ok && name != c.nodeName {
+			log.Infof("CLU discovery: HTTPNode %s has left the cluster", name)
+			eventsLeave.Inc()
+			delete(c.members, name)
+		}
+	}
+	c.peers = peers
+	clusterStats(c.members)
+}
+
+// poll gets the state of the nodes at the given addresses concurrently
+func (c *DiscoveryManager) poll(addrs []string) ([]HTTPNode, []error) {
+	nodes := make([]HTTPNode, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			nodes[i], errs[i] = c.pollNode(addr)
+			wg.Done()
+		}(i, addr)
+	}
+	wg.Wait()
+	return nodes, errs
+}
+
+// pollNode gets the state of the node at the given address.
+// the address the node is reachable at, is the address it was discovered with.
+func (c *DiscoveryManager) pollNode(addr string) (HTTPNode, error) {
+	var node HTTPNode
+	scheme, host, port, err := parsePeerAddr(addr)
+	if err != nil {
+		return node, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.interval)
+	defer cancel()
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/node", scheme, net.JoinHostPort(host, strconv.Itoa(port))), nil)
+	if err != nil {
+		return node, err
+	}
+	rsp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return node, err
+	}
+	defer rsp.Body.Close()
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return node, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return node, fmt.Errorf("unexpected status %d: %s", rsp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, &node); err != nil {
+		unmarshalErrUpdate.Inc()
+		return node, err
+	}
+	if node.Name == "" {
+		return node, fmt.Errorf("no node name in response")
+	}
+	node.RemoteAddr = host
+	node.ApiPort = port
+	node.ApiScheme = scheme
+	node.local = false
+	return node, nil
+}
+
+func (c *DiscoveryManager) ThisNode() Node {
+	return c.thisNode()
+}
+
+func (c *DiscoveryManager) thisNode() HTTPNode {
+	c.RLock()
+	defer c.RUnlock()
+	return c.members[c.nodeName]
+}
+
+func (c *DiscoveryManager) MemberList(isReady, hasData bool) []Node {
+	return toIf(c.memberList(isReady, hasData))
+}
+
+func (c *DiscoveryManager) memberList(isReady, hasData bool) []HTTPNode {
+	c.RLock()
+	list := make([]HTTPNode, 0, len(c.members))
+	for _, p := range c.members {
+		if isReady && !p.IsReady() {
+			continue
+		}
+		if hasData && !p.HasData() {
+			continue
+		}
+		list = append(list, p)
+	}
+	c.RUnlock()
+	sort.Sort(HTTPNodesByName(list))
+	return list
+}
+
+// Join adds the given addresses to the discovered ones, and returns how many of them could be polled.
+// the addresses are the http api addresses of the nodes.
+func (c *DiscoveryManager) Join(peers []string) (int, error) {
+	for _, addr := range peers {
+		if _, _, _, err := parsePeerAddr(addr); err != nil {
+			return 0, fmt.Errorf("invalid address %q: %s", addr, err.Error())
+		}
+	}
+	_, errs := c.poll(peers)
+	c.Lock()
+	c.joined = append(c.joined, peers...)
+	c.Unlock()
+	c.refresh()
+	var n int
+	for _, err := range errs {
+		if err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Returns true if this node is a ready to accept requests
+// from users.
+func (c *DiscoveryManager) IsReady() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.members[c.nodeName].IsReady()
+}
+
+// mark this node as ready to accept requests from users.
+func (c *DiscoveryManager) SetReady() {
+	c.SetState(NodeReady)
+}
+
+// Set the state of this node.
+func (c *DiscoveryManager) SetState(state NodeState) {
+	c.Lock()
+	node := c.members[c.nodeName]
+	if !node.SetState(state) {
+		c.Unlock()
+		return
+	}
+	c.members[c.nodeName] = node
+	c.Unlock()
+	nodeReady.Set(state == NodeReady)
+}
+
+// Returns true if the this node is a set as a primary node that should write data to cassandra.
+func (c *DiscoveryManager) IsPrimary() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.members[c.nodeName].Primary
+}
+
+// SetPrimary sets the primary status of this node
+func (c *DiscoveryManager) SetPrimary(primary bool) {
+	c.Lock()
+	node := c.members[c.nodeName]
+	if !node.SetPrimary(primary) {
+		c.Unlock()
+		return
+	}
+	c.members[c.nodeName] = node
+	c.Unlock()
+	nodePrimary.Set(primary)
+}
+
+// set the partitions that this node is handling.
+func (c *DiscoveryManager) SetPartitions(part []int32) {
+	sort.Slice(part, func(i, j int) bool { return part[i] < part[j] })
+	c.Lock()
+	node := c.members[c.nodeName]
+	node.SetPartitions(part)
+	c.members[c.nodeName] = node
+	c.Unlock()
+	nodePartitions.Set(len(part))
+}
+
+// get the partitions that this node is handling.
+func (c *DiscoveryManager) GetPartitions() []int32 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.members[c.nodeName].Partitions
+}
+
+// set the priority of this node.
+// lower values == higher priority
+func (c *DiscoveryManager) SetPriority(prio int) {
+	c.Lock()
+	node := c.members[c.nodeName]
+	if !node.SetPriority(prio) {
+		c.Unlock()
+		return
+	}
+	c.members[c.nodeName] = node
+	c.Unlock()
+	nodePriority.Set(prio)
+}
+
+func (c *DiscoveryManager) Stop() {
+	close(c.shutdown)
+	c.wg.Wait()
+}
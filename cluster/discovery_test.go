@@ -0,0 +1,225 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStaticDiscoverer(t *testing.T) {
+	d := NewStaticDiscoverer([]string{"a:6060", " https://b:6060", ""})
+	addrs, err := d.Discover()
+	if err != nil || !reflect.DeepEqual(addrs, []string{"a:6060", "https://b:6060"}) {
+		t.Fatalf("unexpected discovery %v %v", addrs, err)
+	}
+}
+
+func TestDNSDiscoverer(t *testing.T) {
+	d := NewDNSDiscoverer("_http._tcp.metrictank.example.com")
+	d.lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_http._tcp.metrictank.example.com" {
+			return "", nil, errors.New("no such host")
+		}
+		return "", []*net.SRV{{Target: "mt-1.example.com.", Port: 6060}, {Target: "mt-0.example.com.", Port: 6061}}, nil
+	}
+	addrs, err := d.Discover()
+	if err != nil || !reflect.DeepEqual(addrs, []string{"mt-0.example.com:6061", "mt-1.example.com:6060"}) {
+		t.Fatalf("unexpected discovery %v %v", addrs, err)
+	}
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	f, err := ioutil.TempFile("", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	d := NewFileDiscoverer(f.Name())
+	assertAddrs := func(content string, exp []string) {
+		t.Helper()
+		if err := ioutil.WriteFile(f.Name(), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		addrs, err := d.Discover()
+		if err != nil || !reflect.DeepEqual(addrs, exp) {
+			t.Fatalf("expected %v, got %v %v", exp, addrs, err)
+		}
+	}
+	assertAddrs("# metrictank nodes\na:6060\n\n  b:6060  \n", []string{"a:6060", "b:6060"})
+	assertAddrs("a:6060\n", []string{"a:6060"})
+
+	os.Remove(f.Name())
+	if _, err := d.Discover(); err == nil {
+		t.Fatalf("expected error for a missing file")
+	}
+}
+
+func TestParsePeerAddr(t *testing.T) {
+	scheme, host, port, err := parsePeerAddr("https://mt-0:6060")
+	if err != nil || scheme != "https" || host != "mt-0" || port != 6060 {
+		t.Fatalf("unexpected result %s %s %d %v", scheme, host, port, err)
+	}
+	scheme, host, port, err = parsePeerAddr("[::1]:6060")
+	if err != nil || scheme != "http" || host != "::1" || port != 6060 {
+		t.Fatalf("unexpected result %s %s %d %v", scheme, host, port, err)
+	}
+	for _, addr := range []string{"mt-0", "mt-0:foo", "mt-0:0", "ftp://mt-0:6060"} {
+		if _, _, _, err := parsePeerAddr(addr); err == nil {
+			t.Fatalf("expected error for %q", addr)
+		}
+	}
+}
+
+// fakePeer serves the /node endpoint of a node
+type fakePeer struct {
+	sync.Mutex
+	node HTTPNode
+	srv  *httptest.Server
+}
+
+func newFakePeer(node HTTPNode) *fakePeer {
+	p := &fakePeer{node: node}
+	p.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/node" {
+			http.NotFound(w, r)
+			return
+		}
+		p.Lock()
+		defer p.Unlock()
+		json.NewEncoder(w).Encode(p.node)
+	}))
+	return p
+}
+
+func (p *fakePeer) addr() string {
+	return p.srv.Listener.Addr().String()
+}
+
+// staticAddrs is a discoverer of which the addresses can be changed
+type staticAddrs struct {
+	sync.Mutex
+	addrs []string
+	err   error
+}
+
+func (s *staticAddrs) Discover() ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.addrs, s.err
+}
+
+func (s *staticAddrs) set(addrs []string, err error) {
+	s.Lock()
+	s.addrs = addrs
+	s.err = err
+	s.Unlock()
+}
+
+func TestDiscoveryManager(t *testing.T) {
+	Mode = ModeShard
+	maxPrio = 10
+	now := time.Now()
+	peer := func(name string, partitions ...int32) HTTPNode {
+		return HTTPNode{
+			Name:       name,
+			Primary:    true,
+			Partitions: partitions,
+			Mode:       ModeShard,
+			State:      NodeReady,
+			Priority:   0,
+			ApiPort:    1234, // must be overridden by the discovered address
+			ApiScheme:  "http",
+			Updated:    now,
+		}
+	}
+	self := newFakePeer(peer("node1", 1, 2))
+	defer self.srv.Close()
+	node2 := newFakePeer(peer("node2", 3, 4))
+	defer node2.srv.Close()
+	node3 := newFakePeer(peer("node3", 5, 6))
+	defer node3.srv.Close()
+
+	discoverer := &staticAddrs{addrs: []string{self.addr(), node2.addr(), "http://" + node3.addr()}}
+	thisNode := peer("node1", 1, 2)
+	thisNode.local = true
+	mgr := NewDiscoveryManager(thisNode, discoverer, time.Hour)
+	Manager = mgr
+	mgr.Start()
+	defer mgr.Stop()
+
+	assertMembers := func(exp ...string) {
+		t.Helper()
+		var names []string
+		for _, n := range Manager.MemberList(true, true) {
+			names = append(names, n.GetName())
+		}
+		if !reflect.DeepEqual(names, exp) {
+			t.Fatalf("expected ready members %v, got %v", exp, names)
+		}
+	}
+	assertMembers("node1", "node2", "node3")
+	// this node's state comes from the manager, not from polling it
+	if !Manager.ThisNode().IsLocal() {
+		t.Fatalf("expected this node to be local")
+	}
+	selected, err := MembersForQuery()
+	if err != nil || len(selected) != 3 {
+		t.Fatalf("expected all 3 nodes to be selected for queries, got %v %v", selected, err)
+	}
+	for _, n := range mgr.memberList(false, false) {
+		if n.Name == "node2" && n.RemoteURL() != "http://"+node2.addr() {
+			t.Fatalf("expected the remote url of node2 to be its discovered address, got %s", n.RemoteURL())
+		}
+	}
+
+	// state changes of the nodes are picked up
+	node3.Lock()
+	node3.node.State = NodeNotReady
+	node3.node.Updated = time.Now()
+	node3.Unlock()
+	mgr.refresh()
+	assertMembers("node1", "node2")
+
+	// unreachable nodes are kept, but not ready
+	node2.srv.Close()
+	mgr.refresh()
+	assertMembers("node1")
+	if len(mgr.memberList(false, false)) != 3 {
+		t.Fatalf("expected unreachable nodes to be kept as members")
+	}
+
+	// failed discoveries keep the known nodes
+	discoverer.set(nil, errors.New("dns failure"))
+	mgr.refresh()
+	if len(mgr.memberList(false, false)) != 3 {
+		t.Fatalf("expected the members to be kept when discovery fails")
+	}
+
+	// nodes that are no longer discovered are removed
+	discoverer.set([]string{self.addr()}, nil)
+	mgr.refresh()
+	if members := mgr.memberList(false, false); len(members) != 1 || members[0].Name != "node1" {
+		t.Fatalf("expected only this node to remain, got %v", members)
+	}
+
+	// joined nodes are added to the discovered ones
+	n, err := Manager.Join([]string{node3.addr()})
+	if err != nil || n != 1 {
+		t.Fatalf("expected to join 1 node, got %d %v", n, err)
+	}
+	if len(mgr.memberList(false, false)) != 2 {
+		t.Fatalf("expected the joined node to be a member")
+	}
+	if _, err := Manager.Join([]string{"foo"}); err == nil {
+		t.Fatalf("expected error for an invalid address")
+	}
+}
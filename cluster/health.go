@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// metric cluster.circuit_breaker.opened is how many times the circuit of a peer was opened, because requests to it kept failing
+var circuitOpened = stats.NewCounter32("cluster.circuit_breaker.opened")
+
+const (
+	// weight of a new sample in the moving averages
+	ewmaAlpha = 0.1
+	// number of most recent latencies to compute the percentiles from
+	latencyWindow = 128
+)
+
+// PeerHealth tracks the health of a peer, based on the requests we send to it:
+// moving averages of their latency and error rate, the number of requests in flight,
+// and a circuit breaker that opens when requests to the peer keep failing.
+type PeerHealth struct {
+	sync.Mutex
+	latency   float64 // moving average of the latency of successful requests, in ns
+	errorRate float64 // moving average of the ratio of failed requests
+	inflight  int
+	failures  int       // number of consecutive failures
+	openUntil time.Time // until when the circuit is open
+
+	latencies    [latencyWindow]time.Duration // ring buffer of the most recent latencies
+	numLatencies int
+	pos          int
+
+	// metric cluster.peer.%s.latency is the moving average of the latency of successful requests to the peer, in ns
+
+	// metric cluster.peer.%s.error_rate is the moving average of the percentage of requests to the peer that failed
+
+	// metric cluster.peer.%s.inflight is the number of requests to the peer that are in flight
+
+	// metric cluster.peer.%s.circuit_open is whether the circuit of the peer is open, meaning it is avoided for queries
+	latencyGauge   *stats.Gauge64
+	errorRateGauge *stats.Gauge32
+	inflightGauge  *stats.Gauge32
+	openGauge      *stats.Bool
+}
+
+// PeerHealthStatus is the state of a PeerHealth, as shown by the api
+type PeerHealthStatus struct {
+	Latency     time.Duration `json:"latency"`
+	LatencyP95  time.Duration `json:"latencyP95"`
+	ErrorRate   float64       `json:"errorRate"`
+	Inflight    int           `json:"inflight"`
+	Failures    int           `json:"failures"`
+	CircuitOpen bool          `json:"circuitOpen"`
+}
+
+var peerHealth = struct {
+	sync.Mutex
+	peers map[string]*PeerHealth
+}{peers: make(map[string]*PeerHealth)}
+
+// GetPeerHealth returns the health of the peer with the given name
+func GetPeerHealth(name string) *PeerHealth {
+	peerHealth.Lock()
+	defer peerHealth.Unlock()
+	h, ok := peerHealth.peers[name]
+	if !ok {
+		h = &PeerHealth{
+			latencyGauge:   stats.NewGauge64(fmt.Sprintf("cluster.peer.%s.latency", name)),
+			errorRateGauge: stats.NewGauge32(fmt.Sprintf("cluster.peer.%s.error_rate", name)),
+			inflightGauge:  stats.NewGauge32(fmt.Sprintf("cluster.peer.%s.inflight", name)),
+			openGauge:      stats.NewBool(fmt.Sprintf("cluster.peer.%s.circuit_open", name)),
+		}
+		peerHealth.peers[name] = h
+	}
+	return h
+}
+
+// begin registers the start of a request to the peer
+func (h *PeerHealth) begin() {
+	h.Lock()
+	h.inflight++
+	h.inflightGauge.Set(h.inflight)
+	h.Unlock()
+}
+
+// end registers the end of a request to the peer, which took the given time.
+// canceled requests don't say anything about the peer, so they only count as no longer in flight.
+func (h *PeerHealth) end(name string, latency time.Duration, failed, canceled bool) {
+	h.Lock()
+	defer h.Unlock()
+	h.inflight--
+	h.inflightGauge.Set(h.inflight)
+	if canceled {
+		return
+	}
+	if failed {
+		h.errorRate = ewma(h.errorRate, 1)
+		h.errorRateGauge.Set(int(h.errorRate * 100))
+		h.failures++
+		if circuitBreakerFailures > 0 && h.failures >= circuitBreakerFailures {
+			if h.openUntil.IsZero() {
+				log.Warnf("CLU health: %d consecutive requests to %s failed. opening its circuit", h.failures, name)
+				circuitOpened.Inc()
+			}
+			h.openUntil = time.Now().Add(circuitBreakerOpenPeriod)
+			h.openGauge.Set(true)
+		}
+		return
+	}
+	h.errorRate = ewma(h.errorRate, 0)
+	h.errorRateGauge.Set(int(h.errorRate * 100))
+	if h.numLatencies == 0 {
+		h.latency = float64(latency)
+	} else {
+		h.latency = ewma(h.latency, float64(latency))
+	}
+	h.latencyGauge.Set(int(h.latency))
+	h.latencies[h.pos] = latency
+	h.pos = (h.pos + 1) % latencyWindow
+	if h.numLatencies < latencyWindow {
+		h.numLatencies++
+	}
+	if !h.openUntil.IsZero() {
+		log.Infof("CLU health: request to %s succeeded. closing its circuit", name)
+		h.openUntil = time.Time{}
+		h.openGauge.Set(false)
+	}
+	h.failures = 0
+}
+
+func ewma(avg, sample float64) float64 {
+	return avg + ewmaAlpha*(sample-avg)
+}
+
+// CircuitOpen returns whether the circuit of the peer is open.
+// once the open period has passed, requests may be sent to the peer again: if one succeeds, the circuit is closed,
+// if it fails, it stays open for another period.
+func (h *PeerHealth) CircuitOpen() bool {
+	h.Lock()
+	defer h.Unlock()
+	return time.Now().Before(h.openUntil)
+}
+
+// Percentile returns the given percentile (0-100) of the most recent latencies of the peer, or 0 if there are none
+func (h *PeerHealth) Percentile(p float64) time.Duration {
+	h.Lock()
+	latencies := make([]time.Duration, h.numLatencies)
+	copy(latencies, h.latencies[:h.numLatencies])
+	h.Unlock()
+	return percentile(latencies, p)
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	i := int(math.Ceil(p/100*float64(len(latencies)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return latencies[i]
+}
+
+// score returns how much we'd rather not send a request to the peer: the expected latency, given its
+// load and error rate. lower is better. peers we have no latencies of yet score 0, so that they get requests.
+// peers with an open circuit score +Inf.
+func (h *PeerHealth) score() float64 {
+	h.Lock()
+	defer h.Unlock()
+	if time.Now().Before(h.openUntil) {
+		return math.Inf(1)
+	}
+	return h.latency * float64(h.inflight+1) * (1 + h.errorRate)
+}
+
+// Status returns the current state
+func (h *PeerHealth) Status() PeerHealthStatus {
+	p95 := h.Percentile(95)
+	h.Lock()
+	defer h.Unlock()
+	return PeerHealthStatus{
+		Latency:     time.Duration(h.latency),
+		LatencyP95:  p95,
+		ErrorRate:   h.errorRate,
+		Inflight:    h.inflight,
+		Failures:    h.failures,
+		CircuitOpen: time.Now().Before(h.openUntil),
+	}
+}
+
+// PeerHealthStatuses returns the health status of the given peers, by name
+func PeerHealthStatuses(peers []Node) map[string]PeerHealthStatus {
+	statuses := make(map[string]PeerHealthStatus, len(peers))
+	for _, peer := range peers {
+		if peer.IsLocal() {
+			continue
+		}
+		statuses[peer.GetName()] = GetPeerHealth(peer.GetName()).Status()
+	}
+	return statuses
+}
+
+// selectReplica returns the best node to send a request to: the one with the lowest score.
+// nodes with the same score are selected in turn across calls, based on count.
+func selectReplica(nodes []Node, count int) Node {
+	var best Node
+	var bestScore float64
+	for i := range nodes {
+		node := nodes[(count+i)%len(nodes)]
+		score := GetPeerHealth(node.GetName()).score()
+		if best == nil || score < bestScore {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,153 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	if p := percentile(latencies, 95); p != 95*time.Millisecond {
+		t.Fatalf("expected p95 of 95ms, got %s", p)
+	}
+	if p := percentile(latencies, 0); p != time.Millisecond {
+		t.Fatalf("expected p0 of 1ms, got %s", p)
+	}
+	if p := percentile(nil, 95); p != 0 {
+		t.Fatalf("expected 0 without latencies, got %s", p)
+	}
+}
+
+func TestPeerHealth(t *testing.T) {
+	h := GetPeerHealth("test-health")
+	if h != GetPeerHealth("test-health") {
+		t.Fatalf("expected the same health for the same peer")
+	}
+	for i := 0; i < 200; i++ {
+		h.begin()
+		h.end("test-health", time.Duration(i%100+1)*time.Millisecond, false, false)
+	}
+	status := h.Status()
+	if status.LatencyP95 < 90*time.Millisecond || status.LatencyP95 > 100*time.Millisecond {
+		t.Fatalf("expected p95 of the recent latencies around 95ms, got %s", status.LatencyP95)
+	}
+	if status.Latency <= 0 || status.Latency > 100*time.Millisecond || status.Inflight != 0 || status.ErrorRate != 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// canceled requests don't count
+	h.begin()
+	h.end("test-health", time.Second, true, true)
+	if h.Status().ErrorRate != 0 {
+		t.Fatalf("expected canceled requests not to count as errors")
+	}
+
+	// the circuit opens after consecutive failures, and closes after a success
+	for i := 0; i < circuitBreakerFailures; i++ {
+		if h.CircuitOpen() {
+			t.Fatalf("expected circuit to be closed after %d failures", i)
+		}
+		h.begin()
+		h.end("test-health", time.Millisecond, true, false)
+	}
+	if !h.CircuitOpen() || h.Status().ErrorRate == 0 {
+		t.Fatalf("expected circuit to be open, got %+v", h.Status())
+	}
+	h.begin()
+	h.end("test-health", time.Millisecond, false, false)
+	if h.CircuitOpen() {
+		t.Fatalf("expected circuit to be closed after a successful request")
+	}
+}
+
+func TestSelectReplica(t *testing.T) {
+	fast := NewMockNode(false, "test-select-fast", []int32{1}, nil)
+	slow := NewMockNode(false, "test-select-slow", []int32{1}, nil)
+	other := NewMockNode(false, "test-select-other", []int32{1}, nil)
+
+	// without latencies, nodes are selected in turn
+	nodes := []Node{fast, slow}
+	if selectReplica(nodes, 0) != fast || selectReplica(nodes, 1) != slow {
+		t.Fatalf("expected nodes without latencies to be selected in turn")
+	}
+
+	record := func(n Node, latency time.Duration, failed bool) {
+		h := GetPeerHealth(n.GetName())
+		h.begin()
+		h.end(n.GetName(), latency, failed, false)
+	}
+	record(fast, time.Millisecond, false)
+	record(slow, 10*time.Millisecond, false)
+	for count := 0; count < 2; count++ {
+		if n := selectReplica(nodes, count); n != fast {
+			t.Fatalf("expected the fastest node to be selected, got %s", n.GetName())
+		}
+	}
+
+	// load counts
+	fastHealth := GetPeerHealth(fast.GetName())
+	for i := 0; i < 20; i++ {
+		fastHealth.begin()
+	}
+	if n := selectReplica(nodes, 0); n != slow {
+		t.Fatalf("expected the least loaded node to be selected, got %s", n.GetName())
+	}
+	for i := 0; i < 20; i++ {
+		fastHealth.end(fast.GetName(), 0, false, true)
+	}
+
+	// nodes with an open circuit are avoided, unless there is no alternative
+	for i := 0; i < circuitBreakerFailures; i++ {
+		record(other, 0, true)
+	}
+	if n := selectReplica([]Node{other, slow}, 0); n != slow {
+		t.Fatalf("expected node with open circuit to be avoided, got %s", n.GetName())
+	}
+	if n := selectReplica([]Node{other}, 0); n != other {
+		t.Fatalf("expected node with open circuit to be selected without alternatives, got %s", n.GetName())
+	}
+}
+
+type testBody struct{}
+
+func (b testBody) Trace(span opentracing.Span)      {}
+func (b testBody) TraceDebug(span opentracing.Span) {}
+
+// TestPostHealth tests that requests to peers are tracked, and that only server errors count as failures
+func TestPostHealth(t *testing.T) {
+	Tracer = opentracing.NoopTracer{}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	_, host, port, _ := parsePeerAddr(srv.Listener.Addr().String())
+	node := HTTPNode{Name: "test-post", RemoteAddr: host, ApiPort: port, ApiScheme: "http"}
+	ctx := opentracing.ContextWithSpan(context.Background(), Tracer.StartSpan("test"))
+
+	if _, err := node.Post(ctx, "test", "/", testBody{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	status = http.StatusBadRequest
+	if _, err := node.Post(ctx, "test", "/", testBody{}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if s := GetPeerHealth("test-post").Status(); s.Failures != 0 || s.Inflight != 0 || s.LatencyP95 == 0 {
+		t.Fatalf("expected client errors not to count as failures, got %+v", s)
+	}
+	status = http.StatusServiceUnavailable
+	if _, err := node.Post(ctx, "test", "/", testBody{}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if s := GetPeerHealth("test-post").Status(); s.Failures != 1 || s.ErrorRate == 0 {
+		t.Fatalf("expected server errors to count as failures, got %+v", s)
+	}
+}
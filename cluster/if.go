@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"context"
+	"io"
 )
 
 type Node interface {
@@ -11,5 +12,6 @@ type Node interface {
 	GetPriority() int
 	HasData() bool
 	Post(context.Context, string, string, Traceable) ([]byte, error)
+	PostStream(context.Context, string, string, Traceable, string) (io.ReadCloser, string, error)
 	GetName() string
 }
@@ -186,8 +186,8 @@ func (c *MemberlistManager) Join(peers []string) (int, error) {
 }
 
 // report the cluster stats every time there is a change to the cluster state.
-// it is assumed that the lock is acquired before calling this method.
-func (c *MemberlistManager) clusterStats() {
+// it is assumed that the lock protecting the members is acquired before calling this function.
+func clusterStats(members map[string]HTTPNode) {
 	primReady := 0
 	primNotReady := 0
 	secReady := 0
@@ -195,7 +195,7 @@ func (c *MemberlistManager) clusterStats() {
 	queryReady := 0
 	queryNotReady := 0
 	partitions := make(map[int32]int)
-	for _, p := range c.members {
+	for _, p := range members {
 		if p.Primary {
 			if p.IsReady() {
 				primReady++
@@ -260,7 +260,7 @@ func (c *MemberlistManager) NotifyJoin(node *memberlist.Node) {
 		return
 	}
 	c.members[node.Name] = member
-	c.clusterStats()
+	clusterStats(c.members)
 }
 
 func (c *MemberlistManager) NotifyLeave(node *memberlist.Node) {
@@ -272,7 +272,7 @@ func (c *MemberlistManager) NotifyLeave(node *memberlist.Node) {
 	defer c.Unlock()
 	log.Infof("CLU manager: HTTPNode %s has left the cluster", node.Name)
 	delete(c.members, node.Name)
-	c.clusterStats()
+	clusterStats(c.members)
 }
 
 func (c *MemberlistManager) NotifyUpdate(node *memberlist.Node) {
@@ -314,7 +314,7 @@ func (c *MemberlistManager) NotifyUpdate(node *memberlist.Node) {
 	}
 	c.members[node.Name] = member
 	log.Infof("CLU manager: HTTPNode %s at %s has been updated - %s", node.Name, node.Addr.String(), node.Meta)
-	c.clusterStats()
+	clusterStats(c.members)
 }
 
 func (c *MemberlistManager) BroadcastUpdate() {
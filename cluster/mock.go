@@ -1,7 +1,10 @@
 package cluster
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"io/ioutil"
 	"sort"
 	"time"
 )
@@ -39,6 +42,11 @@ func (n MockNode) Post(ctx context.Context, name, path string, body Traceable) (
 	return n.postResponse, nil
 }
 
+// PostStream returns the post response, as a peer that doesn't support streaming would
+func (n MockNode) PostStream(ctx context.Context, name, path string, body Traceable, accept string) (io.ReadCloser, string, error) {
+	return ioutil.NopCloser(bytes.NewReader(n.postResponse)), "application/msgpack", nil
+}
+
 func (n *MockNode) GetName() string {
 	return n.name
 }
@@ -12,6 +12,7 @@ import (
 	"os"
 	"runtime/debug"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/grafana/metrictank/tracing"
@@ -261,7 +262,16 @@ func (n HTTPNode) Post(ctx context.Context, name, path string, body Traceable) (
 	tags.PeerAddress.Set(span, n.RemoteAddr)
 	tags.PeerHostname.Set(span, n.Name)
 	body.Trace(span)
+	health := GetPeerHealth(n.Name)
+	health.begin()
+	canceled := false
 	defer func(pre time.Time) {
+		// errors of the request itself say nothing about the health of the peer
+		failed := err != nil
+		if e, ok := err.(*Error); ok && e.code < http.StatusInternalServerError {
+			failed = false
+		}
+		health.end(n.Name, time.Since(pre), failed, canceled)
 		if err != nil {
 			tags.Error.Set(span, true)
 		}
@@ -309,6 +319,7 @@ func (n HTTPNode) Post(ctx context.Context, name, path string, body Traceable) (
 		log.Debugf("CLU HTTPNode: context canceled. terminating request to peer %s", n.Name)
 		transport.CancelRequest(req)
 		<-c // Wait for client.Do but ignore result
+		canceled = true
 	case resp := <-c:
 		err := resp.err
 		rsp := resp.r
@@ -323,6 +334,107 @@ func (n HTTPNode) Post(ctx context.Context, name, path string, body Traceable) (
 	return nil, nil
 }
 
+// PostStream is like Post, but returns the response body while it is being received, along with its content type,
+// so the caller can process the response while the peer is still sending it.
+// the peer is asked to reply with the accept content type. peers that don't support it reply with their usual content type.
+// canceling the context aborts the request, also while the body is being read. the caller must close the body.
+func (n HTTPNode) PostStream(ctx context.Context, name, path string, body Traceable, accept string) (io.ReadCloser, string, error) {
+	ctx, span := tracing.NewSpan(ctx, Tracer, name)
+	tags.SpanKindRPCClient.Set(span)
+	tags.PeerService.Set(span, "metrictank")
+	tags.PeerAddress.Set(span, n.RemoteAddr)
+	tags.PeerHostname.Set(span, n.Name)
+	body.Trace(span)
+	health := GetPeerHealth(n.Name)
+	health.begin()
+	pre := time.Now()
+	finish := func(err error) {
+		canceled := ctx.Err() != nil
+		// errors of the request itself say nothing about the health of the peer
+		failed := err != nil && !canceled
+		if e, ok := err.(*Error); ok && e.code < http.StatusInternalServerError {
+			failed = false
+		}
+		health.end(n.Name, time.Since(pre), failed, canceled)
+		if err != nil {
+			tags.Error.Set(span, true)
+		}
+		if err != nil || time.Since(pre) > 10*time.Second {
+			body.TraceDebug(span)
+		}
+		span.Finish()
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		err = NewError(http.StatusInternalServerError, err)
+		finish(err)
+		return nil, "", err
+	}
+	req, err := http.NewRequest("POST", n.RemoteURL()+path, bytes.NewReader(b))
+	if err != nil {
+		err = NewError(http.StatusInternalServerError, err)
+		finish(err)
+		return nil, "", err
+	}
+	carrier := opentracing.HTTPHeadersCarrier(req.Header)
+	err = Tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier)
+	if err != nil {
+		log.Errorf("CLU failed to inject span into headers: %s", err.Error())
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", accept)
+	// the peer would buffer a compressed response, rather than send it as it goes
+	req.Header.Add("Accept-Encoding", "identity")
+
+	rsp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Debugf("CLU HTTPNode: context canceled. terminated request to peer %s", n.Name)
+			finish(ctx.Err())
+			return nil, "", ctx.Err()
+		}
+		log.Errorf("CLU HTTPNode: error trying to talk to peer %s: %s", n.Name, err.Error())
+		err = NewError(http.StatusServiceUnavailable, errors.New("error trying to talk to peer"))
+		finish(err)
+		return nil, "", err
+	}
+	if rsp.StatusCode != 200 {
+		// Read in body so that the connection can be reused
+		io.Copy(ioutil.Discard, rsp.Body)
+		rsp.Body.Close()
+		err = NewError(rsp.StatusCode, errors.New(rsp.Status))
+		finish(err)
+		return nil, "", err
+	}
+	return &streamBody{ReadCloser: rsp.Body, finish: finish}, rsp.Header.Get("Content-Type"), nil
+}
+
+// streamBody is the body of a response to PostStream.
+// the request is finished when it is closed, failed if reading it failed.
+type streamBody struct {
+	io.ReadCloser
+	err    error
+	finish func(err error)
+	once   sync.Once
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.finish(b.err)
+	})
+	return err
+}
+
 func (n HTTPNode) GetName() string {
 	return n.Name
 }
@@ -0,0 +1,65 @@
+package cluster
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// TestPostStream tests that the response is received as the peer sends it, and that canceling the context aborts the request on the peer
+func TestPostStream(t *testing.T) {
+	Tracer = opentracing.NoopTracer{}
+	peerCanceled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/x-test" || r.Header.Get("Accept-Encoding") != "identity" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-test")
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			close(peerCanceled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	_, host, port, _ := parsePeerAddr(srv.Listener.Addr().String())
+	node := HTTPNode{Name: "test-post-stream", RemoteAddr: host, ApiPort: port, ApiScheme: "http"}
+	ctx, cancel := context.WithCancel(opentracing.ContextWithSpan(context.Background(), Tracer.StartSpan("test")))
+
+	body, contentType, err := node.PostStream(ctx, "test", "/", testBody{}, "application/x-test")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if contentType != "application/x-test" {
+		t.Fatalf("expected the content type of the response, got %q", contentType)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(body, buf); err != nil || string(buf) != "first" {
+		t.Fatalf("expected the first part of the response before the peer finished it, got %q %v", buf, err)
+	}
+	if s := GetPeerHealth("test-post-stream").Status(); s.Inflight != 1 {
+		t.Fatalf("expected the request to be in flight while the body is read, got %+v", s)
+	}
+
+	cancel()
+	if _, err := body.Read(buf); err == nil {
+		t.Fatalf("expected error reading the body after cancellation")
+	}
+	body.Close()
+	select {
+	case <-peerCanceled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the request on the peer to be canceled")
+	}
+	if s := GetPeerHealth("test-post-stream").Status(); s.Inflight != 0 || s.Failures != 0 {
+		t.Fatalf("expected the canceled request not to count as failure, got %+v", s)
+	}
+}
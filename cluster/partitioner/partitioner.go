@@ -2,6 +2,8 @@ package partitioner
 
 import (
 	"fmt"
+	"hash/fnv"
+	"strings"
 
 	"github.com/Shopify/sarama"
 	"github.com/raintank/schema"
@@ -11,6 +13,18 @@ type Partitioner interface {
 	Partition(schema.PartitionedMetric, int32) (int32, error)
 }
 
+// New returns a partitioner using the given hash (kafka|jump) and partitionBy setting.
+// the tags are only used by the byTag setting, which is only supported by the jump hash.
+func New(hash, partitionBy string, tags []string) (Partitioner, error) {
+	switch hash {
+	case "kafka":
+		return NewKafka(partitionBy)
+	case "jump":
+		return NewJump(partitionBy, tags)
+	}
+	return nil, fmt.Errorf("partition hash must be one of 'kafka|jump'. got %s", hash)
+}
+
 type Kafka struct {
 	PartitionBy string
 	Partitioner sarama.Partitioner
@@ -38,7 +52,64 @@ func (k *Kafka) Partition(m schema.PartitionedMetric, numPartitions int32) (int3
 }
 
 func (k *Kafka) GetPartitionKey(m schema.PartitionedMetric, b []byte) ([]byte, error) {
-	switch k.PartitionBy {
+	return partitionKey(k.PartitionBy, nil, m, b)
+}
+
+// Jump is a partitioner using jump consistent hashing (https://arxiv.org/abs/1406.2294).
+// unlike with the kafka hash, when partitions are added, series only move to the new partitions,
+// and only as many as needed to balance them: going from n to n+1 partitions moves 1/(n+1) of the series.
+type Jump struct {
+	PartitionBy string
+	Tags        []string // tags to partition by, for byTag
+}
+
+func NewJump(partitionBy string, tags []string) (*Jump, error) {
+	switch partitionBy {
+	case "byOrg":
+	case "bySeries":
+	case "byTag":
+		if len(tags) == 0 {
+			return nil, fmt.Errorf("partitionBy byTag requires tags to partition by")
+		}
+	default:
+		return nil, fmt.Errorf("partitionBy must be one of 'byOrg|bySeries|byTag'. got %s", partitionBy)
+	}
+	return &Jump{
+		PartitionBy: partitionBy,
+		Tags:        tags,
+	}, nil
+}
+
+func (j *Jump) Partition(m schema.PartitionedMetric, numPartitions int32) (int32, error) {
+	if numPartitions <= 0 {
+		return 0, fmt.Errorf("invalid number of partitions %d", numPartitions)
+	}
+	key, err := j.GetPartitionKey(m, nil)
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(key)
+	return jumpHash(h.Sum64(), numPartitions), nil
+}
+
+func (j *Jump) GetPartitionKey(m schema.PartitionedMetric, b []byte) ([]byte, error) {
+	return partitionKey(j.PartitionBy, j.Tags, m, b)
+}
+
+// jumpHash returns the bucket of the key, in the range [0, numBuckets)
+func jumpHash(key uint64, numBuckets int32) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
+
+func partitionKey(partitionBy string, tags []string, m schema.PartitionedMetric, b []byte) ([]byte, error) {
+	switch partitionBy {
 	case "byOrg":
 		// partition by organisation: metrics for the same org should go to the same
 		// partition/MetricTank (optimize for locality~performance)
@@ -47,6 +118,40 @@ func (k *Kafka) GetPartitionKey(m schema.PartitionedMetric, b []byte) ([]byte, e
 		// partition by series: metrics are distrubted across all metrictank instances
 		// to allow horizontal scalability
 		return m.KeyBySeries(b), nil
+	case "byTag":
+		// partition by the values of the given tags: metrics with the same values for these tags
+		// go to the same partition, e.g. to keep all series of a host together.
+		// series that have none of the tags are partitioned by series.
+		return keyByTags(m, tags, b)
 	}
 	return b, fmt.Errorf("unknown partitionBy setting.")
 }
+
+func keyByTags(m schema.PartitionedMetric, tags []string, b []byte) ([]byte, error) {
+	var metricTags []string
+	switch m := m.(type) {
+	case *schema.MetricData:
+		metricTags = m.Tags
+	case *schema.MetricDefinition:
+		metricTags = m.Tags
+	default:
+		return b, fmt.Errorf("partitioning by tag is not supported for %T", m)
+	}
+	start := len(b)
+	b = m.KeyByOrgId(b)
+	var found bool
+	for _, tag := range tags {
+		b = append(b, 0)
+		for _, t := range metricTags {
+			if strings.HasPrefix(t, tag) && len(t) > len(tag) && t[len(tag)] == '=' {
+				b = append(b, t[len(tag)+1:]...)
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return m.KeyBySeries(b[:start]), nil
+	}
+	return b, nil
+}
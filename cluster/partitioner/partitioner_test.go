@@ -0,0 +1,108 @@
+package partitioner
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func newMetric(name string, tags ...string) *schema.MetricData {
+	return &schema.MetricData{OrgId: 1, Name: name, Interval: 10, Tags: tags}
+}
+
+func TestJumpDistribution(t *testing.T) {
+	p, err := NewJump("bySeries", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numSeries = 10000
+	counts := make([]int, 8)
+	for i := 0; i < numSeries; i++ {
+		part, err := p.Partition(newMetric(fmt.Sprintf("some.metric.%d", i)), 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[part]++
+	}
+	for part, count := range counts {
+		if count < numSeries/8*8/10 || count > numSeries/8*12/10 {
+			t.Fatalf("partition %d has %d series, expected about %d. distribution %v", part, count, numSeries/8, counts)
+		}
+	}
+}
+
+// TestJumpMoves tests that when adding partitions, series only move to the new partitions, and only as many as needed
+func TestJumpMoves(t *testing.T) {
+	p, _ := NewJump("bySeries", nil)
+	const numSeries = 10000
+	var moved int
+	for i := 0; i < numSeries; i++ {
+		m := newMetric(fmt.Sprintf("some.metric.%d", i))
+		before, _ := p.Partition(m, 8)
+		after, _ := p.Partition(m, 10)
+		if before == after {
+			continue
+		}
+		if after < 8 {
+			t.Fatalf("series %s moved from partition %d to existing partition %d", m.Name, before, after)
+		}
+		moved++
+	}
+	// 2/10 of the series should move
+	if moved < numSeries*2/10*8/10 || moved > numSeries*2/10*12/10 {
+		t.Fatalf("expected about %d series to move, got %d", numSeries*2/10, moved)
+	}
+}
+
+func TestJumpByTag(t *testing.T) {
+	if _, err := NewJump("byTag", nil); err == nil {
+		t.Fatalf("expected error for byTag without tags")
+	}
+	p, err := NewJump("byTag", []string{"dc", "host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partition := func(m schema.PartitionedMetric) int32 {
+		t.Helper()
+		part, err := p.Partition(m, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return part
+	}
+	a := partition(newMetric("cpu.user", "host=a", "dc=us", "core=1"))
+	if b := partition(newMetric("mem.free", "dc=us", "host=a")); a != b {
+		t.Fatalf("expected series with the same tag values to be in the same partition, got %d and %d", a, b)
+	}
+	mdef := schema.MetricDefinitionFromMetricData(newMetric("disk.used", "host=a", "dc=us", "hostname=b"))
+	if b := partition(mdef); a != b {
+		t.Fatalf("expected metric definitions to be partitioned like metric data, got %d and %d", a, b)
+	}
+	// series without any of the tags are partitioned by series
+	bySeries, _ := NewJump("bySeries", nil)
+	m := newMetric("cpu.user", "hostname=a")
+	exp, _ := bySeries.Partition(m, 1000)
+	if got := partition(m); got != exp {
+		t.Fatalf("expected series without the tags to be partitioned by series, got %d, expected %d", got, exp)
+	}
+	// the key is appended to the given buffer
+	key, _ := p.GetPartitionKey(m, []byte("prefix"))
+	if string(key) != "prefixcpu.user" {
+		t.Fatalf("expected key to be appended to the buffer, got %q", key)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("kafka", "byTag", []string{"dc"}); err == nil {
+		t.Fatalf("expected error for byTag with the kafka hash")
+	}
+	if _, err := New("foo", "bySeries", nil); err == nil {
+		t.Fatalf("expected error for an unknown hash")
+	}
+	if p, err := New("jump", "byOrg", nil); err != nil {
+		t.Fatal(err)
+	} else if _, ok := p.(*Jump); !ok {
+		t.Fatalf("expected jump partitioner, got %T", p)
+	}
+}
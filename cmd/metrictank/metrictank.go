@@ -28,7 +28,9 @@ import (
 	inCarbon "github.com/grafana/metrictank/input/carbon"
 	inKafkaMdm "github.com/grafana/metrictank/input/kafkamdm"
 	inPrometheus "github.com/grafana/metrictank/input/prometheus"
+	"github.com/grafana/metrictank/input/wal"
 	"github.com/grafana/metrictank/jaeger"
+	"github.com/grafana/metrictank/limits"
 	"github.com/grafana/metrictank/logger"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/mdata/cache"
@@ -37,6 +39,9 @@ import (
 	statsConfig "github.com/grafana/metrictank/stats/config"
 	bigtableStore "github.com/grafana/metrictank/store/bigtable"
 	cassandraStore "github.com/grafana/metrictank/store/cassandra"
+	localStore "github.com/grafana/metrictank/store/local"
+	objStore "github.com/grafana/metrictank/store/objstore"
+	tieredStore "github.com/grafana/metrictank/store/tiered"
 	"github.com/raintank/dur"
 	log "github.com/sirupsen/logrus"
 )
@@ -50,6 +55,8 @@ var (
 	metricIndex idx.MetricIndex
 	apiServer   *api.Server
 	inputs      []input.Plugin
+	walog       *wal.WAL
+	ccache      *cache.CCache
 	store       mdata.Store
 
 	// Misc:
@@ -109,6 +116,7 @@ func main() {
 	inCarbon.ConfigSetup()
 	inKafkaMdm.ConfigSetup()
 	inPrometheus.ConfigSetup()
+	wal.ConfigSetup()
 
 	// load config for metricIndexers
 	memory.ConfigSetup()
@@ -118,6 +126,9 @@ func main() {
 	// load config for API
 	api.ConfigSetup()
 
+	// per-org limits
+	limits.ConfigSetup()
+
 	// load config for cluster
 	cluster.ConfigSetup()
 
@@ -133,6 +144,15 @@ func main() {
 	// bigtable store
 	bigtableStore.ConfigSetup()
 
+	// local store
+	localStore.ConfigSetup()
+
+	// object store
+	objStore.ConfigSetup()
+
+	// tiered store
+	tieredStore.ConfigSetup()
+
 	jaeger.ConfigSetup()
 
 	config.ParseAll()
@@ -181,13 +201,18 @@ func main() {
 	inKafkaMdm.ConfigProcess(*instance)
 	memory.ConfigProcess()
 	inPrometheus.ConfigProcess()
+	wal.ConfigProcess()
 	notifierKafka.ConfigProcess(*instance)
 	statsConfig.ConfigProcess(*instance)
 	mdata.ConfigProcess()
 	cassandra.ConfigProcess()
 	bigtable.ConfigProcess()
 	bigtableStore.ConfigProcess(mdata.MaxChunkSpan())
+	localStore.ConfigProcess()
+	objStore.ConfigProcess()
+	tieredStore.ConfigProcess()
 	jaeger.ConfigProcess()
+	limits.ConfigProcess()
 
 	inputEnabled := inCarbon.Enabled || inKafkaMdm.Enabled || inPrometheus.Enabled
 	wantInput := cluster.Mode == cluster.ModeDev || cluster.Mode == cluster.ModeShard
@@ -197,6 +222,9 @@ func main() {
 	if inputEnabled && !wantInput {
 		log.Fatal("you should not have an input enabled in 'query' cluster mode")
 	}
+	if statsConfig.SelfEnabled() && !wantInput {
+		log.Fatal("the self stats output can not be used in 'query' cluster mode")
+	}
 
 	sec := dur.MustParseNDuration("warm-up-period", *warmUpPeriodStr)
 	warmupPeriod = time.Duration(sec) * time.Second
@@ -255,41 +283,73 @@ func main() {
 	/***********************************
 		Initialize our backendStore
 	***********************************/
-	if cassandraStore.CliConfig.Enabled && bigtableStore.CliConfig.Enabled {
-		log.Fatal("only 1 backend store plugin can be enabled at once.")
+	storesEnabled := 0
+	for _, enabled := range []bool{cassandraStore.CliConfig.Enabled, bigtableStore.CliConfig.Enabled, localStore.CliConfig.Enabled, objStore.CliConfig.Enabled} {
+		if enabled {
+			storesEnabled++
+		}
+	}
+	if storesEnabled > 1 && !tieredStore.CliConfig.Enabled {
+		log.Fatal("only 1 backend store plugin can be enabled at once, unless the tiered store is enabled.")
 	}
 	if wantInput {
-		if !cassandraStore.CliConfig.Enabled && !bigtableStore.CliConfig.Enabled {
+		if storesEnabled == 0 {
 			log.Fatal("at least 1 backend store plugin needs to be enabled in 'dev' or 'shard' cluster mode")
 		}
 	} else {
-		if cassandraStore.CliConfig.Enabled || bigtableStore.CliConfig.Enabled {
+		if storesEnabled > 0 {
 			log.Fatal("no backend store plugin may be enabled in 'query' cluster mode")
 		}
 	}
+	backendStores := make(map[string]mdata.Store)
 	if bigtableStore.CliConfig.Enabled {
 		schemaMaxChunkSpan := mdata.MaxChunkSpan()
-		store, err = bigtableStore.NewStore(bigtableStore.CliConfig, mdata.TTLs(), schemaMaxChunkSpan)
+		backendStores["bigtable"], err = bigtableStore.NewStore(bigtableStore.CliConfig, mdata.TTLs(), schemaMaxChunkSpan)
 		if err != nil {
 			log.Fatalf("failed to initialize bigtable backend store. %s", err)
 		}
-		store.SetTracer(tracer)
 	}
 	if cassandraStore.CliConfig.Enabled {
-		store, err = cassandraStore.NewCassandraStore(cassandraStore.CliConfig, mdata.TTLs())
+		backendStores["cassandra"], err = cassandraStore.NewCassandraStore(cassandraStore.CliConfig, mdata.TTLs())
 		if err != nil {
 			log.Fatalf("failed to initialize cassandra backend store. %s", err)
 		}
+	}
+	if localStore.CliConfig.Enabled {
+		backendStores["local"], err = localStore.NewStore(localStore.CliConfig)
+		if err != nil {
+			log.Fatalf("failed to initialize local backend store. %s", err)
+		}
+	}
+	if objStore.CliConfig.Enabled {
+		backendStores["object"], err = objStore.NewStore(objStore.CliConfig)
+		if err != nil {
+			log.Fatalf("failed to initialize object backend store. %s", err)
+		}
+	}
+	if tieredStore.CliConfig.Enabled {
+		store, err = tieredStore.NewStore(tieredStore.CliConfig, backendStores)
+		if err != nil {
+			log.Fatalf("failed to initialize tiered store. %s", err)
+		}
+	} else {
+		for _, backendStore := range backendStores {
+			store = backendStore
+		}
+	}
+	if store != nil {
 		store.SetTracer(tracer)
 	}
 
 	/***********************************
 		Initialize the Chunk Cache
 	***********************************/
-	var ccache *cache.CCache
 	if inputEnabled {
 		ccache = cache.NewCCache()
 		ccache.SetTracer(tracer)
+		if err := ccache.LoadSnapshot(); err != nil {
+			log.Warnf("failed to restore chunk cache snapshot: %s", err)
+		}
 	}
 
 	/***********************************
@@ -314,7 +374,7 @@ func main() {
 
 	if inKafkaMdm.Enabled {
 		sarama.Logger = l.New(os.Stdout, "[Sarama] ", l.LstdFlags)
-		inputs = append(inputs, inKafkaMdm.New())
+		inputs = append(inputs, inKafkaMdm.New(metrics))
 	}
 
 	if cluster.Mode == cluster.ModeShard && len(inputs) > 1 {
@@ -364,6 +424,7 @@ func main() {
 	/***********************************
 		Initialize our API server
 	***********************************/
+	limits.Start()
 	apiServer, err = api.NewServer()
 	if err != nil {
 		log.Fatalf("Failed to start API. %s", err.Error())
@@ -405,6 +466,24 @@ func main() {
 		log.Fatal("you should disable notifier plugins in 'query' cluster mode")
 	}
 
+	/***********************************
+		Replay the write-ahead log
+		this must happen before we start our inputs and become ready,
+		and after the notifiers have processed their backlog, so we know which chunks have been saved.
+	***********************************/
+	if wal.Enabled && (inCarbon.Enabled || inPrometheus.Enabled) {
+		walog, err = wal.New(metrics)
+		if err != nil {
+			log.Fatalf("failed to open write-ahead log: %s", err.Error())
+		}
+		mdata.AddChunkSaveListener(walog)
+		err = walog.Replay(input.NewDefaultHandler(metrics, metricIndex, "wal"))
+		if err != nil {
+			log.Fatalf("failed to replay write-ahead log: %s", err.Error())
+		}
+		walog.Start()
+	}
+
 	/***********************************
 		Start our inputs
 	***********************************/
@@ -413,7 +492,15 @@ func main() {
 		if carbonPlugin, ok := plugin.(*inCarbon.Carbon); ok {
 			carbonPlugin.IntervalGetter(inCarbon.NewIndexIntervalGetter(metricIndex))
 		}
-		err = plugin.Start(input.NewDefaultHandler(metrics, metricIndex, plugin.Name()), cancel)
+		if kafkaMdmPlugin, ok := plugin.(*inKafkaMdm.KafkaMdm); ok {
+			kafkaMdmPlugin.BindMetricIndex(metricIndex)
+		}
+		var handler input.Handler = input.NewDefaultHandler(metrics, metricIndex, plugin.Name())
+		// kafka-mdm can replay its data from kafka itself
+		if _, ok := plugin.(*inKafkaMdm.KafkaMdm); walog != nil && !ok {
+			handler = walog.Handler(handler)
+		}
+		err = plugin.Start(handler, cancel)
 		if err != nil {
 			shutdown()
 			return
@@ -422,6 +509,23 @@ func main() {
 		apiServer.BindPrioritySetter(plugin)
 	}
 
+	// ingest our own instrumentation. the self stats output buffers its reports until we're ready and primary:
+	// the points only go into this node, so only a primary node saves their chunks. they are not replicated to
+	// other nodes owning the same partition.
+	if statsConfig.SelfEnabled() {
+		var partition int32
+		if partitions := cluster.Manager.GetPartitions(); len(partitions) > 0 {
+			partition = partitions[0]
+		}
+		if !cluster.Manager.IsPrimary() {
+			log.Warn("stats: the self output only ingests the instrumentation while this node is primary")
+		}
+		ready := func() bool {
+			return cluster.Manager.IsReady() && cluster.Manager.IsPrimary()
+		}
+		statsConfig.StartSelf(input.NewDefaultHandler(metrics, metricIndex, "self"), partition, ready)
+	}
+
 	// metric cluster.self.promotion_wait is how long a candidate (secondary node) has to wait until it can become a primary
 	// When the timer becomes 0 it means the in-memory buffer has been able to fully populate so that if you stop a primary
 	// and it was able to save its complete chunks, this node will be able to take over without dataloss.
@@ -494,6 +598,15 @@ func shutdown() {
 		timer.Stop()
 	}
 
+	if walog != nil {
+		log.Info("closing write-ahead log")
+		walog.Close()
+	}
+
+	if err := ccache.SaveSnapshot(); err != nil {
+		log.Errorf("failed to save chunk cache snapshot: %s", err)
+	}
+
 	if cluster.Mode != cluster.ModeQuery {
 		log.Info("closing store")
 		store.Stop()
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,7 +25,9 @@ var (
 	dstKeyspace     = flag.String("dst-keyspace", "raintank", "Cassandra keyspace in use on destination.")
 	srcTable        = flag.String("src-table", "metric_idx", "Cassandra table name in use on source.")
 	dstTable        = flag.String("dst-table", "metric_idx", "Cassandra table name in use on destination.")
-	partitionScheme = flag.String("partition-scheme", "byOrg", "method used for partitioning metrics. (byOrg|bySeries)")
+	partitionScheme = flag.String("partition-scheme", "byOrg", "method used for partitioning metrics. (byOrg|bySeries|byTag, byTag requires the jump partition-hash)")
+	partitionHash   = flag.String("partition-hash", "kafka", "hash used for partitioning metrics. (kafka|jump)")
+	partitionTags   = flag.String("partition-tags", "", "comma separated list of tags to partition by, when the partition-scheme is byTag")
 	numPartitions   = flag.Int("num-partitions", 1, "number of partitions in cluster")
 	schemaFile      = flag.String("schema-file", "/etc/metrictank/schema-idx-cassandra.toml", "File containing the needed schemas in case database needs initializing")
 
@@ -152,7 +155,7 @@ func getDefs(session *gocql.Session, defsChan chan *schema.MetricDefinition, idx
 	log.Info("starting read thread")
 	defer wg.Done()
 	defer close(defsChan)
-	partitioner, err := partitioner.NewKafka(*partitionScheme)
+	partitioner, err := partitioner.New(*partitionHash, *partitionScheme, partitionTagList())
 	if err != nil {
 		log.Fatalf("failed to initialize partitioner. %s", err.Error())
 	}
@@ -194,3 +197,10 @@ func getDefs(session *gocql.Session, defsChan chan *schema.MetricDefinition, idx
 		defsChan <- &mdef
 	}
 }
+
+func partitionTagList() []string {
+	if *partitionTags == "" {
+		return nil
+	}
+	return strings.Split(*partitionTags, ",")
+}
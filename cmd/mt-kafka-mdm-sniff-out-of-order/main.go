@@ -209,7 +209,7 @@ func main() {
 
 	stats.NewDevnull() // make sure metrics don't pile up without getting discarded
 
-	mdm := inKafkaMdm.New()
+	mdm := inKafkaMdm.New(nil)
 	ctx, cancel := context.WithCancel(context.Background())
 	mdm.Start(newInputOOOFinder(*format), cancel)
 	sigChan := make(chan os.Signal, 1)
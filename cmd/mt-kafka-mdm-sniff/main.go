@@ -156,7 +156,7 @@ func main() {
 
 	stats.NewDevnull() // make sure metrics don't pile up without getting discarded
 
-	mdm := inKafkaMdm.New()
+	mdm := inKafkaMdm.New(nil)
 	ctx, cancel := context.WithCancel(context.Background())
 	mdm.Start(newInputPrinter(*formatMd, *formatP), cancel)
 	sigChan := make(chan os.Signal, 1)
@@ -25,9 +25,14 @@ func (a byTTL) Len() int           { return len(a) }
 func (a byTTL) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a byTTL) Less(i, j int) bool { return a[i].ttl < a[j].ttl }
 
-// shows an overview of all keys and their ttls and closes the iter
-// iter must return rows of key and ttl.
-func showKeyTTL(iter *gocql.Iter, groupTTL string) {
+// buckets counts keys by their ttl, rounded to the groupTTL unit
+type buckets struct {
+	groupTTL string
+	roundTTL int
+	counts   map[bucket]int
+}
+
+func newBuckets(groupTTL string) *buckets {
 	roundTTL := 1
 	switch groupTTL {
 	case "m":
@@ -37,27 +42,43 @@ func showKeyTTL(iter *gocql.Iter, groupTTL string) {
 	case "d":
 		roundTTL = 60 * 60 * 24
 	}
-
-	var b bucket
-	bucketMap := make(map[bucket]int)
-	for iter.Scan(&b.key, &b.ttl) {
-		b.ttl /= roundTTL
-		bucketMap[b] += 1
+	return &buckets{
+		groupTTL: groupTTL,
+		roundTTL: roundTTL,
+		counts:   make(map[bucket]int),
 	}
+}
 
+func (b *buckets) add(key string, ttl int) {
+	b.counts[bucket{key, ttl / b.roundTTL}]++
+}
+
+// print shows an overview of all keys and their ttls
+func (b *buckets) print() {
 	var bucketList []bucketWithCount
-	for b, count := range bucketMap {
+	for bu, count := range b.counts {
 		bucketList = append(bucketList, bucketWithCount{
-			b.key,
-			b.ttl,
+			bu.key,
+			bu.ttl,
 			count,
 		})
 	}
 
 	sort.Sort(byTTL(bucketList))
-	for _, b := range bucketList {
-		fmt.Printf("%s %d%s %d\n", b.key, b.ttl, groupTTL, b.c)
+	for _, bu := range bucketList {
+		fmt.Printf("%s %d%s %d\n", bu.key, bu.ttl, b.groupTTL, bu.c)
+	}
+}
+
+// shows an overview of all keys and their ttls and closes the iter
+// iter must return rows of key and ttl.
+func showKeyTTL(iter *gocql.Iter, groupTTL string) {
+	buckets := newBuckets(groupTTL)
+	var b bucket
+	for iter.Scan(&b.key, &b.ttl) {
+		buckets.add(b.key, b.ttl)
 	}
+	buckets.print()
 	err := iter.Close()
 	if err != nil {
 		log.Errorf("cassandra query error. %s", err)
@@ -14,6 +14,7 @@ import (
 	"github.com/grafana/metrictank/jaeger"
 	"github.com/grafana/metrictank/logger"
 	"github.com/grafana/metrictank/store/cassandra"
+	"github.com/grafana/metrictank/store/local"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/raintank/dur"
 	"github.com/raintank/schema"
@@ -37,6 +38,7 @@ var (
 	groupTTL    = flag.String("groupTTL", "d", "group chunks in TTL buckets: s (second. means unbucketed), m (minute), h (hour) or d (day). only for chunk-summary format")
 	timeZoneStr = flag.String("time-zone", "local", "time-zone to use for interpreting from/to when needed. (check your config)")
 	archiveStr  = flag.String("archive", "", "archive to fetch for given metric. e.g. 'sum_1800'")
+	storeType   = flag.String("store", "cassandra", "store to read from: cassandra or local")
 	verbose     bool
 
 	printTime func(ts uint32) string
@@ -53,6 +55,7 @@ func init() {
 func main() {
 	storeConfig := cassandra.NewStoreConfig()
 	idxConfig := cassandra_idx.NewIdxConfig()
+	localStoreConfig := local.NewStoreConfig()
 	// flags from cassandra/config.go, Cassandra
 	flag.StringVar(&storeConfig.Addrs, "cassandra-addrs", storeConfig.Addrs, "cassandra host (may be given multiple times as comma-separated list)")
 	flag.StringVar(&storeConfig.Keyspace, "cassandra-keyspace", storeConfig.Keyspace, "cassandra keyspace to use for storing the metric data table")
@@ -81,11 +84,13 @@ func main() {
 	flag.DurationVar(&idxConfig.Timeout, "index-timeout", idxConfig.Timeout, "cassandra request timeout")
 	flag.IntVar(&idxConfig.InitLoadConcurrency, "index-init-load-concurrency", idxConfig.InitLoadConcurrency, "Number of partitions to load concurrently on startup.")
 	flag.StringVar(&idxConfig.SchemaFile, "index-schema-file", idxConfig.SchemaFile, "File containing the needed index schemas in case database needs initializing")
+	// flags from local/config.go, local store. window-factor is shared with cassandra
+	flag.StringVar(&localStoreConfig.Dir, "local-store-dir", localStoreConfig.Dir, "directory of the local store")
 
 	flag.Usage = func() {
 		fmt.Println("mt-store-cat")
 		fmt.Println()
-		fmt.Println("Retrieves timeseries data from the cassandra store. Either raw or with minimal processing")
+		fmt.Println("Retrieves timeseries data from the cassandra or local store. Either raw or with minimal processing")
 		fmt.Println()
 		fmt.Println("Usage:")
 		fmt.Println()
@@ -105,6 +110,7 @@ func main() {
 		fmt.Println("mt-store-cat -cassandra-keyspace metrictank -from='-1month' '*' 'prefix:fake' point-summary")
 		fmt.Println("mt-store-cat -cassandra-keyspace metrictank '*' 'prefix:fake' chunk-summary")
 		fmt.Println("mt-store-cat -groupTTL h -cassandra-keyspace metrictank 'metric_512' '1.37cf8e3731ee4c79063c1d55280d1bbe' chunk-summary")
+		fmt.Println("mt-store-cat -store local -local-store-dir /var/lib/metrictank/store -from='-1h' '*' '1.77c8c77afa22b67ef5b700c2a2b88d5f' points")
 		fmt.Println("Flags:")
 		flag.PrintDefaults()
 		fmt.Println("Notes:")
@@ -114,6 +120,8 @@ func main() {
 		fmt.Println(" * When using chunk-summary, if there's data that should have been expired by cassandra, but for some reason didn't, we won't see or report it")
 		fmt.Println(" * Doesn't automatically return data for aggregated series. It's up to you to query for an AMKey (id_<rollup>_<span>) when appropriate")
 		fmt.Println(" * (rollup is one of sum, cnt, lst, max, min and span is a number in seconds)")
+		fmt.Println(" * The local store has no index, so it doesn't support the prefix, substr and glob metric-selectors, and shows no metric names.")
+		fmt.Println("   The TTL's it reports are relative to the time of the data, rather than to the time of writing")
 	}
 	flag.Parse()
 
@@ -185,39 +193,30 @@ func main() {
 		}
 	}
 
-	store, err := cassandra.NewCassandraStore(storeConfig, nil)
-	if err != nil {
-		log.Fatalf("failed to initialize cassandra. %s", err.Error())
+	if *storeType != "cassandra" && *storeType != "local" {
+		log.Fatal("store must be one of cassandra/local")
 	}
 
-	idxConfig.Hosts = storeConfig.Addrs
-	idxConfig.Keyspace = storeConfig.Keyspace
-	idxConfig.Consistency = storeConfig.Consistency
-	idxConfig.NumConns = storeConfig.ReadConcurrency
-	idxConfig.ProtoVer = storeConfig.CqlProtocolVersion
-	idxConfig.CreateKeyspace = storeConfig.CreateKeyspace
-	idxConfig.DisableInitialHostLookup = storeConfig.DisableInitialHostLookup
-	idxConfig.SSL = storeConfig.SSL
-	idxConfig.CaPath = storeConfig.CaPath
-	idxConfig.HostVerification = storeConfig.HostVerification
-	idxConfig.Auth = storeConfig.Auth
-	idxConfig.Username = storeConfig.Username
-	idxConfig.Password = storeConfig.Password
-	idx := cassandra_idx.New(idxConfig)
-	err = idx.InitBare()
-	if err != nil {
-		log.Fatal(err.Error())
-	}
 	tracer, traceCloser, err := jaeger.Get()
 	if err != nil {
 		log.Fatalf("Could not initialize jaeger tracer: %s", err.Error())
 	}
 	defer traceCloser.Close()
-	store.SetTracer(tracer)
 
-	err = store.FindExistingTables(storeConfig.Keyspace)
-	if err != nil {
-		log.Fatalf("failed to read tables from cassandra. %s", err.Error())
+	var store Store
+	var idx *cassandra_idx.CasIdx
+	var localStore *local.Store
+	if *storeType == "local" {
+		localStoreConfig.WindowFactor = storeConfig.WindowFactor
+		localStore, err = local.NewReadOnlyStore(localStoreConfig)
+		if err != nil {
+			log.Fatalf("failed to open local store. %s", err.Error())
+		}
+		defer localStore.Stop()
+		localStore.SetTracer(tracer)
+		store = localCatStore{localStore}
+	} else {
+		store, idx = initCassandra(storeConfig, idxConfig, tracer)
 	}
 
 	var archive schema.Archive
@@ -242,7 +241,7 @@ func main() {
 	// format: points, point-summary, chunk-summary or chunk-csv
 
 	if format == "chunk-csv" && (tableSelector == "*" || tableSelector == "") {
-		log.Fatal("chunk-csv format can be used with 1 cassandra table only")
+		log.Fatal("chunk-csv format can be used with 1 table only")
 	}
 
 	tables, err := getTables(store, tableSelector)
@@ -274,7 +273,9 @@ func main() {
 		}
 		// chunk-summary doesn't need an explicit listing. it knows if metrics is empty, to query all
 		// but the other two do need an explicit listing.
-		if format == "points" || format == "point-summary" {
+		if (format == "points" || format == "point-summary") && localStore != nil {
+			metrics = getStoreMetrics(localStore, tables, archive)
+		} else if format == "points" || format == "point-summary" {
 			metrics, err = getMetrics(idx, "", "", "", archive)
 			if err != nil {
 				log.Errorf("cassandra query error. %s", err.Error())
@@ -282,6 +283,9 @@ func main() {
 			}
 		}
 	} else if strings.HasPrefix(metricSelector, "prefix:") || strings.HasPrefix(metricSelector, "substr:") || strings.HasPrefix(metricSelector, "glob:") {
+		if localStore != nil {
+			log.Fatal("prefix/substr/glob need an index, which the local store does not have")
+		}
 		var prefix, substr, glob string
 		if strings.HasPrefix(metricSelector, "prefix:") {
 			prefix = strings.Replace(metricSelector, "prefix:", "", 1)
@@ -316,10 +320,15 @@ func main() {
 			fmt.Println("# Looking for this metric:")
 		}
 
-		metrics, err = getMetric(idx, amkey)
-		if err != nil {
-			log.Errorf("cassandra query error. %s", err.Error())
-			return
+		if localStore != nil {
+			// without an index we can't look up the name, nor whether the metric exists
+			metrics = []Metric{{AMKey: amkey}}
+		} else {
+			metrics, err = getMetric(idx, amkey)
+			if err != nil {
+				log.Errorf("cassandra query error. %s", err.Error())
+				return
+			}
 		}
 		if len(metrics) == 0 {
 			fmt.Printf("metric id %s not found", amkey.MKey.String())
@@ -332,7 +341,7 @@ func main() {
 		}
 	}
 
-	if verbose {
+	if verbose && localStore == nil {
 		fmt.Printf("# Keyspace %q:\n", storeConfig.Keyspace)
 	}
 
@@ -345,8 +354,44 @@ func main() {
 	case "point-summary":
 		printPointSummary(ctx, store, tables, metrics, fromUnix, toUnix, uint32(*fix))
 	case "chunk-summary":
-		printChunkSummary(ctx, store, tables, metrics, *groupTTL)
+		for _, table := range tables {
+			store.ChunkSummary(ctx, table, metrics, *groupTTL)
+		}
 	case "chunk-csv":
-		printChunkCsv(ctx, store, tables[0], metrics, fromUnix, toUnix)
+		store.ChunkCsv(ctx, tables[0], metrics, fromUnix, toUnix)
+	}
+}
+
+// initCassandra initializes the cassandra store and index
+func initCassandra(storeConfig *cassandra.StoreConfig, idxConfig *cassandra_idx.IdxConfig, tracer opentracing.Tracer) (Store, *cassandra_idx.CasIdx) {
+	store, err := cassandra.NewCassandraStore(storeConfig, nil)
+	if err != nil {
+		log.Fatalf("failed to initialize cassandra. %s", err.Error())
+	}
+
+	idxConfig.Hosts = storeConfig.Addrs
+	idxConfig.Keyspace = storeConfig.Keyspace
+	idxConfig.Consistency = storeConfig.Consistency
+	idxConfig.NumConns = storeConfig.ReadConcurrency
+	idxConfig.ProtoVer = storeConfig.CqlProtocolVersion
+	idxConfig.CreateKeyspace = storeConfig.CreateKeyspace
+	idxConfig.DisableInitialHostLookup = storeConfig.DisableInitialHostLookup
+	idxConfig.SSL = storeConfig.SSL
+	idxConfig.CaPath = storeConfig.CaPath
+	idxConfig.HostVerification = storeConfig.HostVerification
+	idxConfig.Auth = storeConfig.Auth
+	idxConfig.Username = storeConfig.Username
+	idxConfig.Password = storeConfig.Password
+	idx := cassandra_idx.New(idxConfig)
+	err = idx.InitBare()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	store.SetTracer(tracer)
+
+	err = store.FindExistingTables(storeConfig.Keyspace)
+	if err != nil {
+		log.Fatalf("failed to read tables from cassandra. %s", err.Error())
 	}
+	return cassandraCatStore{store}, idx
 }
@@ -10,6 +10,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/grafana/metrictank/idx/cassandra"
+	"github.com/grafana/metrictank/store/local"
 )
 
 type Metric struct {
@@ -96,3 +97,26 @@ func getMetric(idx *cassandra.CasIdx, amkey schema.AMKey) ([]Metric, error) {
 	}
 	return metrics, nil
 }
+
+// getStoreMetrics lists all metrics of the given archive in the given tables of the local store.
+// the local store has no index, so the metrics have no names.
+func getStoreMetrics(store *local.Store, tables []Table, archive schema.Archive) []Metric {
+	seen := make(map[schema.AMKey]struct{})
+	for _, table := range tables {
+		tbl, err := store.GetTable(table.Name)
+		if err != nil {
+			continue
+		}
+		store.Chunks(tbl, nil, func(c local.ChunkInfo) {
+			if c.Key.Archive == archive {
+				seen[c.Key] = struct{}{}
+			}
+		})
+	}
+	metrics := make([]Metric, 0, len(seen))
+	for key := range seen {
+		metrics = append(metrics, Metric{AMKey: key})
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].AMKey.String() < metrics[j].AMKey.String() })
+	return metrics
+}
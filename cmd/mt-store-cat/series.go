@@ -8,12 +8,11 @@ import (
 
 	"github.com/grafana/metrictank/api"
 	"github.com/grafana/metrictank/mdata/chunk"
-	"github.com/grafana/metrictank/store/cassandra"
 	"github.com/raintank/schema"
 )
 
 // printPoints prints points in the store corresponding to the given requirements
-func printPoints(ctx context.Context, store *cassandra.CassandraStore, tables []cassandra.Table, metrics []Metric, fromUnix, toUnix, fix uint32) {
+func printPoints(ctx context.Context, store Store, tables []Table, metrics []Metric, fromUnix, toUnix, fix uint32) {
 	for _, metric := range metrics {
 		fmt.Println("## Metric", metric)
 		for _, table := range tables {
@@ -33,7 +32,7 @@ func printPoints(ctx context.Context, store *cassandra.CassandraStore, tables []
 }
 
 // printPointSummary prints a summarized view of the points in the store corresponding to the given requirements
-func printPointSummary(ctx context.Context, store *cassandra.CassandraStore, tables []cassandra.Table, metrics []Metric, fromUnix, toUnix, fix uint32) {
+func printPointSummary(ctx context.Context, store Store, tables []Table, metrics []Metric, fromUnix, toUnix, fix uint32) {
 	for _, metric := range metrics {
 		fmt.Println("## Metric", metric)
 		for _, table := range tables {
@@ -52,7 +51,7 @@ func printPointSummary(ctx context.Context, store *cassandra.CassandraStore, tab
 	}
 }
 
-func getSeries(ctx context.Context, store *cassandra.CassandraStore, table cassandra.Table, amkey schema.AMKey, fromUnix, toUnix, interval uint32) []schema.Point {
+func getSeries(ctx context.Context, store Store, table Table, amkey schema.AMKey, fromUnix, toUnix, interval uint32) []schema.Point {
 	var points []schema.Point
 	itgens, err := store.SearchTable(ctx, amkey, table, fromUnix, toUnix)
 	if err != nil {
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/store/cassandra"
+	"github.com/grafana/metrictank/store/local"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// Table is a table of the store.
+// TTL is in hours, and is the lower limit of the TTL's of the data in the table.
+type Table struct {
+	Name string
+	TTL  uint32
+}
+
+// Store is the backend store we print the contents of
+type Store interface {
+	// Tables returns all tables of the store in TTL asc order
+	Tables() []Table
+	// SearchTable returns the chunks of the given key in the table, like mdata.Store.Search
+	SearchTable(ctx context.Context, key schema.AMKey, table Table, start, end uint32) ([]chunk.IterGen, error)
+	// ChunkSummary prints the keys and ttl's of the chunks of the given metrics (or of all metrics, if none are given), grouped in buckets of groupTTL size by their TTL
+	ChunkSummary(ctx context.Context, table Table, metrics []Metric, groupTTL string)
+	// ChunkCsv prints the chunks of the given metrics as csv, for importing into cassandra
+	ChunkCsv(ctx context.Context, table Table, metrics []Metric, start, end uint32)
+}
+
+type cassandraCatStore struct {
+	*cassandra.CassandraStore
+}
+
+func (c cassandraCatStore) Tables() []Table {
+	var tables []Table
+	for _, table := range c.TTLTables {
+		if !cassandra.IsStoreTable(table.Name) {
+			continue
+		}
+		tables = append(tables, Table{Name: table.Name, TTL: table.TTL})
+	}
+	sortTables(tables)
+	return tables
+}
+
+func (c cassandraCatStore) SearchTable(ctx context.Context, key schema.AMKey, table Table, start, end uint32) ([]chunk.IterGen, error) {
+	for _, t := range c.TTLTables {
+		if t.Name == table.Name {
+			return c.CassandraStore.SearchTable(ctx, key, t, start, end)
+		}
+	}
+	return nil, fmt.Errorf("table %q not found", table.Name)
+}
+
+func (c cassandraCatStore) ChunkSummary(ctx context.Context, table Table, metrics []Metric, groupTTL string) {
+	printChunkSummary(ctx, c.CassandraStore, table, metrics, groupTTL)
+}
+
+func (c cassandraCatStore) ChunkCsv(ctx context.Context, table Table, metrics []Metric, start, end uint32) {
+	printChunkCsv(ctx, c.CassandraStore, table, metrics, start, end)
+}
+
+type localCatStore struct {
+	*local.Store
+}
+
+func (l localCatStore) Tables() []Table {
+	var tables []Table
+	for _, table := range l.Store.Tables() {
+		tables = append(tables, Table{Name: table.Name, TTL: table.TTL})
+	}
+	return tables
+}
+
+func (l localCatStore) SearchTable(ctx context.Context, key schema.AMKey, table Table, start, end uint32) ([]chunk.IterGen, error) {
+	tbl, err := l.GetTable(table.Name)
+	if err != nil {
+		return nil, err
+	}
+	return l.Store.SearchTable(ctx, key, tbl, start, end)
+}
+
+func (l localCatStore) ChunkSummary(ctx context.Context, table Table, metrics []Metric, groupTTL string) {
+	tbl, err := l.GetTable(table.Name)
+	if err != nil {
+		return
+	}
+	fmt.Println("## Table", table.Name)
+	now := uint32(time.Now().Unix())
+	buckets := newBuckets(groupTTL)
+	l.Chunks(tbl, metricKeys(metrics), func(c local.ChunkInfo) {
+		// unlike cassandra, the ttl is relative to the data, not to the time of writing
+		var ttl int
+		if c.T0+c.TTL > now {
+			ttl = int(c.T0 + c.TTL - now)
+		}
+		// show row keys like cassandra does, to make them easy to compare
+		buckets.add(fmt.Sprintf("%s_%d", c.Key.String(), c.T0/cassandra.Month_sec), ttl)
+	})
+	buckets.print()
+}
+
+func (l localCatStore) ChunkCsv(ctx context.Context, table Table, metrics []Metric, start, end uint32) {
+	tbl, err := l.GetTable(table.Name)
+	if err != nil {
+		return
+	}
+	for _, metric := range metrics {
+		itgens, err := l.Store.SearchTable(ctx, metric.AMKey, tbl, start, end)
+		if err != nil {
+			log.Fatalf("query failure: %v", err)
+		}
+		for _, itgen := range itgens {
+			fmt.Printf("%s_%d,%d,0x%x\n", metric.AMKey.String(), itgen.T0/cassandra.Month_sec, itgen.T0, itgen.B)
+		}
+	}
+}
+
+func metricKeys(metrics []Metric) []schema.AMKey {
+	keys := make([]schema.AMKey, len(metrics))
+	for i, m := range metrics {
+		keys[i] = m.AMKey
+	}
+	return keys
+}
@@ -33,7 +33,9 @@ var (
 	exitOnError     = flag.Bool("exit-on-error", false, "Exit with a message when there's an error")
 	httpEndpoint    = flag.String("http-endpoint", "0.0.0.0:8080", "The http endpoint to listen on")
 	ttlsStr         = flag.String("ttls", "35d", "list of ttl strings used by MT separated by ','")
-	partitionScheme = flag.String("partition-scheme", "bySeries", "method used for partitioning metrics. This should match the settings of tsdb-gw. (byOrg|bySeries)")
+	partitionScheme = flag.String("partition-scheme", "bySeries", "method used for partitioning metrics. This should match the settings of tsdb-gw. (byOrg|bySeries|byTag, byTag requires the jump partition-hash)")
+	partitionHash   = flag.String("partition-hash", "kafka", "hash used for partitioning metrics. This should match the settings of tsdb-gw. (kafka|jump)")
+	partitionTags   = flag.String("partition-tags", "", "comma separated list of tags to partition by, when the partition-scheme is byTag")
 	uriPath         = flag.String("uri-path", "/metrics/import", "the URI on which we expect chunks to get posted")
 	numPartitions   = flag.Int("num-partitions", 1, "Number of Partitions")
 	logLevel        = flag.String("log-level", "info", "log level. panic|fatal|error|warning|info|debug")
@@ -134,7 +136,7 @@ func main() {
 		}
 	}
 
-	p, err := partitioner.NewKafka(*partitionScheme)
+	p, err := partitioner.New(*partitionHash, *partitionScheme, partitionTagList())
 	if err != nil {
 		panic(fmt.Sprintf("Failed to instantiate partitioner: %q", err))
 	}
@@ -248,3 +250,10 @@ func getOrgId(req *http.Request) (int, error) {
 	}
 	return 0, errors.New("Missing X-Org-Id header")
 }
+
+func partitionTagList() []string {
+	if *partitionTags == "" {
+		return nil
+	}
+	return strings.Split(*partitionTags, ",")
+}
@@ -0,0 +1,135 @@
+package conf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alyu/configparser"
+)
+
+// LimitUnset is the value of a limit that is not configured.
+// it is up to the user of the limit to decide what that means (e.g. falling back to a global setting, or no limit)
+const LimitUnset = -1
+
+// Limits holds the limits that apply to a single org.
+// a limit of 0 disables the limit.
+type Limits struct {
+	MaxSeriesPerReq     int
+	MaxPointsPerReqSoft int
+	MaxPointsPerReqHard int
+	MaxConcurrentReqs   int
+	MaxReqsPerSec       int
+	MaxFindResults      int
+	MaxActiveSeries     int
+	MaxSamplesPerSec    int
+}
+
+// TenantLimits holds the default limits and the per-org overrides
+type TenantLimits struct {
+	Default Limits
+	Orgs    map[uint32]Limits
+}
+
+// NewLimits returns limits with all limits unset
+func NewLimits() Limits {
+	return Limits{
+		MaxSeriesPerReq:     LimitUnset,
+		MaxPointsPerReqSoft: LimitUnset,
+		MaxPointsPerReqHard: LimitUnset,
+		MaxConcurrentReqs:   LimitUnset,
+		MaxReqsPerSec:       LimitUnset,
+		MaxFindResults:      LimitUnset,
+		MaxActiveSeries:     LimitUnset,
+		MaxSamplesPerSec:    LimitUnset,
+	}
+}
+
+// NewTenantLimits returns tenant limits without any limits set
+func NewTenantLimits() TenantLimits {
+	return TenantLimits{
+		Default: NewLimits(),
+		Orgs:    make(map[uint32]Limits),
+	}
+}
+
+// ReadTenantLimits returns the limits defined in a tenant-limits.conf file.
+// the [default] section applies to all orgs, and sections named after an org id override
+// the default limits for that org. limits not set in an org section are taken from the default section.
+func ReadTenantLimits(file string) (TenantLimits, error) {
+	config, err := configparser.Read(file)
+	if err != nil {
+		return TenantLimits{}, err
+	}
+	sections, err := config.AllSections()
+	if err != nil {
+		return TenantLimits{}, err
+	}
+
+	result := NewTenantLimits()
+	orgSections := make(map[uint32]*configparser.Section)
+
+	for _, s := range sections {
+		name := strings.Trim(strings.SplitN(s.String(), "\n", 2)[0], " []")
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if name == "default" {
+			result.Default, err = parseLimits(s, result.Default)
+			if err != nil {
+				return TenantLimits{}, fmt.Errorf("[%s]: %s", name, err.Error())
+			}
+			continue
+		}
+		orgId, err := strconv.ParseUint(name, 10, 32)
+		if err != nil || orgId == 0 {
+			return TenantLimits{}, fmt.Errorf("[%s]: section name must be 'default' or an org id", name)
+		}
+		orgSections[uint32(orgId)] = s
+	}
+
+	for orgId, s := range orgSections {
+		result.Orgs[orgId], err = parseLimits(s, result.Default)
+		if err != nil {
+			return TenantLimits{}, fmt.Errorf("[%d]: %s", orgId, err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+// parseLimits returns the limits set in the given section, using base for the ones that are not set
+func parseLimits(s *configparser.Section, base Limits) (Limits, error) {
+	limits := base
+	for _, opt := range []struct {
+		key string
+		val *int
+	}{
+		{"max-series-per-req", &limits.MaxSeriesPerReq},
+		{"max-points-per-req-soft", &limits.MaxPointsPerReqSoft},
+		{"max-points-per-req-hard", &limits.MaxPointsPerReqHard},
+		{"max-concurrent-reqs", &limits.MaxConcurrentReqs},
+		{"max-reqs-per-sec", &limits.MaxReqsPerSec},
+		{"max-find-results", &limits.MaxFindResults},
+		{"max-active-series", &limits.MaxActiveSeries},
+		{"max-samples-per-sec", &limits.MaxSamplesPerSec},
+	} {
+		if !s.Exists(opt.key) {
+			continue
+		}
+		val, err := strconv.Atoi(strings.TrimSpace(s.ValueOf(opt.key)))
+		if err != nil || val < 0 {
+			return Limits{}, fmt.Errorf("failed to parse %s %q: must be a non-negative integer", opt.key, s.ValueOf(opt.key))
+		}
+		*opt.val = val
+	}
+	return limits, nil
+}
+
+// Get returns the limits for the given org
+func (t TenantLimits) Get(orgId uint32) Limits {
+	if l, ok := t.Orgs[orgId]; ok {
+		return l
+	}
+	return t.Default
+}
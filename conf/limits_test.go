@@ -0,0 +1,157 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadTenantLimits(t *testing.T) {
+	cases := []struct {
+		in     string
+		expErr bool
+		exp    TenantLimits
+	}{
+		{
+			in: `
+[default]
+max-concurrent-reqs = 10
+max-reqs-per-sec = 100
+
+[12]
+max-concurrent-reqs = 2
+max-series-per-req = 1000
+max-active-series = 5000
+max-samples-per-sec = 500
+
+[13]
+max-reqs-per-sec = 0
+`,
+			exp: TenantLimits{
+				Default: Limits{
+					MaxSeriesPerReq:     LimitUnset,
+					MaxPointsPerReqSoft: LimitUnset,
+					MaxPointsPerReqHard: LimitUnset,
+					MaxConcurrentReqs:   10,
+					MaxReqsPerSec:       100,
+					MaxFindResults:      LimitUnset,
+					MaxActiveSeries:     LimitUnset,
+					MaxSamplesPerSec:    LimitUnset,
+				},
+				Orgs: map[uint32]Limits{
+					12: {
+						MaxSeriesPerReq:     1000,
+						MaxPointsPerReqSoft: LimitUnset,
+						MaxPointsPerReqHard: LimitUnset,
+						MaxConcurrentReqs:   2,
+						MaxReqsPerSec:       100,
+						MaxFindResults:      LimitUnset,
+						MaxActiveSeries:     5000,
+						MaxSamplesPerSec:    500,
+					},
+					13: {
+						MaxSeriesPerReq:     LimitUnset,
+						MaxPointsPerReqSoft: LimitUnset,
+						MaxPointsPerReqHard: LimitUnset,
+						MaxConcurrentReqs:   10,
+						MaxReqsPerSec:       0,
+						MaxFindResults:      LimitUnset,
+						MaxActiveSeries:     LimitUnset,
+						MaxSamplesPerSec:    LimitUnset,
+					},
+				},
+			},
+		},
+		{
+			// org sections inherit from the default section, even if it comes later
+			in: `
+[5]
+max-find-results = 50
+
+[default]
+max-find-results = 500
+max-points-per-req-hard = 1000
+`,
+			exp: TenantLimits{
+				Default: Limits{
+					MaxSeriesPerReq:     LimitUnset,
+					MaxPointsPerReqSoft: LimitUnset,
+					MaxPointsPerReqHard: 1000,
+					MaxConcurrentReqs:   LimitUnset,
+					MaxReqsPerSec:       LimitUnset,
+					MaxFindResults:      500,
+					MaxActiveSeries:     LimitUnset,
+					MaxSamplesPerSec:    LimitUnset,
+				},
+				Orgs: map[uint32]Limits{
+					5: {
+						MaxSeriesPerReq:     LimitUnset,
+						MaxPointsPerReqSoft: LimitUnset,
+						MaxPointsPerReqHard: 1000,
+						MaxConcurrentReqs:   LimitUnset,
+						MaxReqsPerSec:       LimitUnset,
+						MaxFindResults:      50,
+						MaxActiveSeries:     LimitUnset,
+						MaxSamplesPerSec:    LimitUnset,
+					},
+				},
+			},
+		},
+		{
+			in: `
+[foo]
+max-find-results = 50
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[default]
+max-find-results = -5
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[default]
+max-reqs-per-sec = lots
+`,
+			expErr: true,
+		},
+	}
+	for i, c := range cases {
+		tmpfile, err := ioutil.TempFile("", "limits-test-readtenantlimits")
+		if err != nil {
+			panic(err)
+		}
+		if _, err := tmpfile.Write([]byte(c.in)); err != nil {
+			panic(err)
+		}
+		if err := tmpfile.Close(); err != nil {
+			panic(err)
+		}
+
+		limits, err := ReadTenantLimits(tmpfile.Name())
+		if (err != nil) != c.expErr {
+			t.Fatalf("case %d, exp err %t, got err %v", i, c.expErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(limits, c.exp) {
+			t.Fatalf("case %d, exp limits %v, got %v", i, c.exp, limits)
+		}
+
+		os.Remove(tmpfile.Name())
+	}
+}
+
+func TestTenantLimitsGet(t *testing.T) {
+	limits := NewTenantLimits()
+	limits.Default.MaxConcurrentReqs = 10
+	limits.Orgs[12] = Limits{MaxConcurrentReqs: 2}
+	if got := limits.Get(12).MaxConcurrentReqs; got != 2 {
+		t.Fatalf("expected limit of org 12 to be 2, got %d", got)
+	}
+	if got := limits.Get(13).MaxConcurrentReqs; got != 10 {
+		t.Fatalf("expected limit of org 13 to be the default of 10, got %d", got)
+	}
+}
@@ -78,6 +78,12 @@ func AggEvery(numPoints, maxPoints uint32) uint32 {
 // interval is the interval between the input points
 func ConsolidateStable(points []schema.Point, interval, maxDataPoints uint32, consolidator Consolidator) ([]schema.Point, uint32) {
 	aggNum := AggEvery(uint32(len(points)), maxDataPoints)
+	return ConsolidateStableAggNum(points, interval, aggNum, consolidator)
+}
+
+// ConsolidateStableAggNum is like ConsolidateStable, but consolidates aggNum points at a time,
+// irrespective of how many points there are. e.g. to consolidate new points the same way as previously returned data.
+func ConsolidateStableAggNum(points []schema.Point, interval, aggNum uint32, consolidator Consolidator) ([]schema.Point, uint32) {
 	// note that the amount of points to strip is always < 1 postAggInterval's worth.
 	// there's 2 important considerations here:
 	// 1) we shouldn't make any too drastic alterations of the timerange returned compared to the requested time range
@@ -190,6 +190,33 @@ func TestConsolidationFunctions(t *testing.T) {
 				{Val: 7, Ts: 1449178161},
 			},
 		},
+		{
+			[]schema.Point{
+				{Val: 1, Ts: 1449178131},
+				{Val: 4, Ts: 1449178141},
+				{Val: 2, Ts: 1449178151},
+				{Val: 3, Ts: 1449178161},
+			},
+			P75,
+			4,
+			[]schema.Point{
+				{Val: 4, Ts: 1449178161},
+			},
+		},
+		{
+			[]schema.Point{
+				{Val: 1, Ts: 1449178131},
+				{Val: 4, Ts: 1449178141},
+				{Val: 2, Ts: 1449178151},
+				{Val: 3, Ts: 1449178161},
+			},
+			P90,
+			2,
+			[]schema.Point{
+				{Val: 4, Ts: 1449178141},
+				{Val: 3, Ts: 1449178161},
+			},
+		},
 	}
 	validate(cases, t)
 }
@@ -337,6 +364,41 @@ func TestConsolidateStableABitMoreDataEven(t *testing.T) {
 		50,
 		t)
 }
+func TestConsolidateStableAggNum(t *testing.T) {
+	// same input and nudging as TestConsolidateStableABitMoreData, but with the aggNum given directly
+	in := []schema.Point{
+		{Val: 2, Ts: 20},   // incomplete. shall be nudged out
+		{Val: 3, Ts: 30},   // incomplete. shall be nudged out
+		{Val: 4, Ts: 40},   // incomplete. shall be nudged out
+		{Val: 5, Ts: 50},   // incomplete. shall be nudged out
+		{Val: 6, Ts: 60},   // bucket 1
+		{Val: 7, Ts: 70},   // bucket 1
+		{Val: 8, Ts: 80},   // bucket 1
+		{Val: 9, Ts: 90},   // bucket 1
+		{Val: 10, Ts: 100}, // bucket 1
+		{Val: 11, Ts: 110}, // bucket 2
+		{Val: 12, Ts: 120}, // bucket 2
+		{Val: 13, Ts: 130}, // bucket 2
+		{Val: 14, Ts: 140}, // bucket 2
+	}
+	expOut := []schema.Point{
+		{Val: 40, Ts: 100},
+		{Val: 50, Ts: 150},
+	}
+	out, outInt := ConsolidateStableAggNum(in, 10, 5, Sum)
+	if outInt != 50 {
+		t.Fatalf("output interval mismatch: expected: %v, got: %v", 50, outInt)
+	}
+	if len(out) != len(expOut) {
+		t.Fatalf("output mismatch: expected: %v, got: %v", expOut, out)
+	}
+	for j := 0; j < len(out); j++ {
+		if out[j] != expOut[j] {
+			t.Fatalf("output mismatch: expected: %v, got: %v", expOut, out)
+		}
+	}
+}
+
 func testConsolidateStable(in []schema.Point, inInt uint32, mdp uint32, expOut []schema.Point, expOutInt uint32, t *testing.T) {
 	out, outInt := ConsolidateStable(in, inInt, mdp, Sum)
 	if outInt != expOutInt {
@@ -30,6 +30,11 @@ const (
 	Diff
 	StdDev
 	Range
+	P75
+	P90
+	P95
+	P99
+	P999
 )
 
 // String provides human friendly names
@@ -59,6 +64,16 @@ func (c Consolidator) String() string {
 		return "RangeConsolidator"
 	case Sum:
 		return "SumConsolidator"
+	case P75:
+		return "P75Consolidator"
+	case P90:
+		return "P90Consolidator"
+	case P95:
+		return "P95Consolidator"
+	case P99:
+		return "P99Consolidator"
+	case P999:
+		return "P999Consolidator"
 	}
 	panic(fmt.Sprintf("Consolidator.String(): unknown consolidator %d", c))
 }
@@ -85,6 +100,23 @@ func (c Consolidator) Archive() schema.Method {
 	panic(fmt.Sprintf("Consolidator.Archive(): unknown consolidator %q", c))
 }
 
+// Percentile returns the percentile computed by a percentile consolidator, or 0 for any other consolidator
+func (c Consolidator) Percentile() float64 {
+	switch c {
+	case P75:
+		return 75
+	case P90:
+		return 90
+	case P95:
+		return 95
+	case P99:
+		return 99
+	case P999:
+		return 99.9
+	}
+	return 0
+}
+
 func FromArchive(archive schema.Method) Consolidator {
 	switch archive {
 	case schema.Cnt:
@@ -125,6 +157,16 @@ func FromConsolidateBy(c string) Consolidator {
 		return Range
 	case "sum", "total":
 		return Sum
+	case "p75":
+		return P75
+	case "p90":
+		return P90
+	case "p95":
+		return P95
+	case "p99":
+		return P99
+	case "p999":
+		return P999
 	}
 	return None
 }
@@ -155,6 +197,8 @@ func GetAggFunc(consolidator Consolidator) batch.AggFunc {
 		consFunc = batch.Range
 	case Sum:
 		consFunc = batch.Sum
+	case P75, P90, P95, P99, P999:
+		consFunc = batch.Percentile(consolidator.Percentile(), false)
 	}
 	return consFunc
 }
@@ -170,7 +214,8 @@ func Validate(fn string) error {
 		fn == "diff" ||
 		fn == "stddev" ||
 		fn == "range" || fn == "rangeOf" ||
-		fn == "sum" || fn == "total" {
+		fn == "sum" || fn == "total" ||
+		fn == "p75" || fn == "p90" || fn == "p95" || fn == "p99" || fn == "p999" {
 		return nil
 	}
 	return errUnknownConsolidationFunction
@@ -3,6 +3,8 @@ package expr
 import (
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -55,6 +57,38 @@ func (e expr) Print(indent int) string {
 	return "HUH-SHOULD-NEVER-HAPPEN"
 }
 
+// Canonical returns the expression in a normalized form, such that
+// expressions that only differ in formatting (whitespace, quotes, order of keyword arguments) have the same canonical form.
+func (e expr) Canonical() string {
+	switch e.etype {
+	case etName:
+		return e.str
+	case etBool:
+		return strconv.FormatBool(e.bool)
+	case etFunc:
+		args := make([]string, 0, len(e.args)+len(e.namedArgs))
+		for _, a := range e.args {
+			args = append(args, a.Canonical())
+		}
+		keys := make([]string, 0, len(e.namedArgs))
+		for k := range e.namedArgs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			args = append(args, k+"="+e.namedArgs[k].Canonical())
+		}
+		return e.str + "(" + strings.Join(args, ",") + ")"
+	case etInt:
+		return strconv.FormatInt(e.int, 10)
+	case etFloat:
+		return strconv.FormatFloat(e.float, 'g', -1, 64)
+	case etString:
+		return strconv.Quote(e.str)
+	}
+	return "HUH-SHOULD-NEVER-HAPPEN"
+}
+
 // consumeBasicArg verifies that the argument at given pos matches the expected arg
 // it's up to the caller to assure that given pos is valid before calling.
 // if arg allows for multiple arguments, pos is advanced to cover all accepted arguments.
@@ -283,6 +317,27 @@ func (e expr) consumeSeriesArg(pos int, exp Arg, context Context, stable bool, r
 	return pos, reqs, nil
 }
 
+// consumeSeriesArgs sets up all series input arguments of the function, using the given context
+func (e expr) consumeSeriesArgs(argsExp []Arg, context Context, stable bool, reqs []Req) ([]Req, error) {
+	var err error
+	pos := 0
+	for _, argExp := range argsExp {
+		if pos >= len(e.args) {
+			break // no more args specified. we're done.
+		}
+		switch argExp.(type) {
+		case ArgSeries, ArgSeriesList, ArgSeriesLists, ArgIn:
+			pos, reqs, err = e.consumeSeriesArg(pos, argExp, context, stable, reqs)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			pos++
+		}
+	}
+	return reqs, nil
+}
+
 // consumeKwarg consumes the kwarg (by key k) and verifies it
 // if the specified argument is valid, it is saved in exp.val
 // where exp is the arg specified by the function that has the given key
@@ -0,0 +1,102 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+// FuncAliasQuery names every input series after the last value of the query obtained by
+// replacing search with replace in its name.
+// see subQuery for how the needed data is planned: the replacement is also applied to the input pattern.
+type FuncAliasQuery struct {
+	in      GraphiteFunc
+	search  *regexp.Regexp
+	replace string
+	newName string
+
+	subQuery
+}
+
+func NewAliasQuery() GraphiteFunc {
+	return &FuncAliasQuery{}
+}
+
+func (s *FuncAliasQuery) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgRegex{key: "search", val: &s.search},
+		ArgString{key: "replace", val: &s.replace},
+		ArgString{key: "newName", val: &s.newName},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncAliasQuery) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncAliasQuery) planSub(context Context, stable bool, reqs []Req) ([]Req, error) {
+	pattern, err := s.inputPattern(s.in, "aliasQuery")
+	if err != nil {
+		return nil, err
+	}
+	return s.plan("aliasQuery", s.query(pattern), context, stable, reqs)
+}
+
+// query returns the query for the given name
+func (s *FuncAliasQuery) query(name string) string {
+	// support native graphite (python) groups like \3 by turning them into ${3}
+	replace := groupPython.ReplaceAllString(s.replace, "$${$1}")
+	return s.search.ReplaceAllString(name, replace)
+}
+
+func (s *FuncAliasQuery) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		query := s.query(strings.SplitN(serie.Target, ";", 2)[0])
+		result, err := s.exec(cache, query)
+		if err != nil {
+			return nil, err
+		}
+		if len(result) == 0 {
+			return nil, fmt.Errorf("No series found with query: %s", query)
+		}
+		last := math.NaN()
+		for _, p := range result[0].Datapoints {
+			if !math.IsNaN(p.Val) {
+				last = p.Val
+			}
+		}
+		if math.IsNaN(last) {
+			return nil, fmt.Errorf("Cannot get last value of result of query: %s", query)
+		}
+
+		name := formatPython(s.newName, last)
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["name"] = name
+		serie.Target = name
+		serie.QueryPatt = name
+		serie.Tags = tags
+		outputs = append(outputs, serie)
+	}
+	return outputs, nil
+}
+
+// formatPython formats val using a python style format string with a single verb such as %d, %i, %f or %g
+func formatPython(format string, val float64) string {
+	if strings.Contains(format, "%d") || strings.Contains(format, "%i") {
+		return fmt.Sprintf(strings.Replace(format, "%i", "%d", -1), int64(val))
+	}
+	return fmt.Sprintf(format, val)
+}
@@ -0,0 +1,60 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+func TestAliasQuery(t *testing.T) {
+	out := runPlan(t,
+		`aliasQuery(servers.*.cpu, "servers\.(.*)\.cpu", "servers.\1.cores", "cpu with %d cores")`,
+		[]string{"servers.*.cpu", "servers.*.cores"},
+		map[string][]models.Series{
+			"servers.*.cpu": {
+				constSeries("servers.a.cpu", 50),
+				constSeries("servers.b.cpu", 80),
+			},
+			"servers.*.cores": {
+				constSeries("servers.a.cores", 4),
+				{
+					Interval:   10,
+					Target:     "servers.b.cores",
+					Datapoints: []schema.Point{{Val: 8, Ts: 1000}, {Val: 16, Ts: 1010}},
+				},
+			},
+		},
+	)
+	exp := []string{"cpu with 4 cores", "cpu with 16 cores"}
+	if len(out) != len(exp) {
+		t.Fatalf("expected %d series, got %d", len(exp), len(out))
+	}
+	for i, serie := range out {
+		if serie.Target != exp[i] || serie.Tags["name"] != exp[i] {
+			t.Errorf("series %d: expected name %q, got target %q and tags %v", i, exp[i], serie.Target, serie.Tags)
+		}
+		if serie.Datapoints[0].Val != float64(50+30*i) {
+			t.Errorf("series %d: expected the input datapoints, got %v", i, serie.Datapoints)
+		}
+	}
+}
+
+func TestAliasQueryNoSeries(t *testing.T) {
+	exprs, err := ParseMany([]string{`aliasQuery(servers.*.cpu, "cpu", "cores", "%d")`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := NewPlan(exprs, 1000, 2000, 0, true, time.UTC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[Req][]models.Series{
+		plan.Reqs[0]: {constSeries("servers.a.cpu", 50)},
+	}
+	_, err = plan.Run(data)
+	if err == nil || err.Error() != "No series found with query: servers.a.cores" {
+		t.Fatalf("expected error about missing series, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package expr
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+// FuncApplyByNode evaluates a template for every distinct prefix (the first nodeNum+1 nodes) of the input series,
+// with the prefix substituted for every % in the template.
+// see subQuery for how the needed data is planned.
+type FuncApplyByNode struct {
+	in               GraphiteFunc
+	nodeNum          int64
+	templateFunction string
+	newName          string
+
+	subQuery
+}
+
+func NewApplyByNode() GraphiteFunc {
+	return &FuncApplyByNode{}
+}
+
+func (s *FuncApplyByNode) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgInt{key: "nodeNum", val: &s.nodeNum},
+		ArgString{key: "templateFunction", val: &s.templateFunction},
+		ArgString{key: "newName", opt: true, val: &s.newName},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncApplyByNode) Context(context Context) Context {
+	return context
+}
+
+// planSub plans the template with the prefix of the input pattern substituted, which covers all prefixes of the input series
+func (s *FuncApplyByNode) planSub(context Context, stable bool, reqs []Req) ([]Req, error) {
+	pattern, err := s.inputPattern(s.in, "applyByNode")
+	if err != nil {
+		return nil, err
+	}
+	return s.plan("applyByNode", s.target(nodePrefix(pattern, s.nodeNum)), context, stable, reqs)
+}
+
+// target returns the template for the given prefix
+func (s *FuncApplyByNode) target(prefix string) string {
+	return strings.Replace(s.templateFunction, "%", prefix, -1)
+}
+
+// nodePrefix returns the first nodeNum+1 nodes of the given name or pattern
+func nodePrefix(name string, nodeNum int64) string {
+	parts := strings.Split(strings.SplitN(name, ";", 2)[0], ".")
+	if nodeNum+1 < int64(len(parts)) {
+		parts = parts[:nodeNum+1]
+	}
+	return strings.Join(parts, ".")
+}
+
+func (s *FuncApplyByNode) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	var prefixes []string
+	for _, serie := range series {
+		prefix := nodePrefix(serie.Target, s.nodeNum)
+		if _, ok := seen[prefix]; !ok {
+			seen[prefix] = struct{}{}
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+
+	var outputs []models.Series
+	for _, prefix := range prefixes {
+		out, err := s.exec(cache, s.target(prefix))
+		if err != nil {
+			return nil, err
+		}
+		for _, serie := range out {
+			if s.newName != "" {
+				name := strings.Replace(s.newName, "%", prefix, -1)
+				tags := make(map[string]string, len(serie.Tags)+1)
+				for k, v := range serie.Tags {
+					tags[k] = v
+				}
+				tags["name"] = name
+				serie.Target = name
+				serie.Tags = tags
+			}
+			serie.QueryPatt = prefix
+			outputs = append(outputs, serie)
+		}
+	}
+	return outputs, nil
+}
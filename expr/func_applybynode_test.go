@@ -0,0 +1,126 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+// runPlan plans the target, verifies the requests and runs it with the given input, keyed by query
+func runPlan(t *testing.T, target string, expReqs []string, input map[string][]models.Series) []models.Series {
+	exprs, err := ParseMany([]string{target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := NewPlan(exprs, 1000, 2000, 0, true, time.UTC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queries []string
+	for _, req := range plan.Reqs {
+		queries = append(queries, req.Query)
+	}
+	if !reflect.DeepEqual(queries, expReqs) {
+		t.Fatalf("expected requests %v, got %v", expReqs, queries)
+	}
+	data := make(map[Req][]models.Series)
+	for _, req := range plan.Reqs {
+		for _, serie := range input[req.Query] {
+			serie.QueryPatt = req.Query
+			serie.Datapoints = getCopy(serie.Datapoints)
+			data[req] = append(data[req], serie)
+		}
+	}
+	out, err := plan.Run(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func constSeries(target string, val float64) models.Series {
+	return models.Series{
+		Interval:   10,
+		Target:     target,
+		Datapoints: []schema.Point{{Val: val, Ts: 1000}, {Val: val, Ts: 1010}},
+	}
+}
+
+func TestApplyByNode(t *testing.T) {
+	used := []models.Series{
+		constSeries("servers.a.disk.used", 1),
+		constSeries("servers.b.disk.used", 3),
+	}
+	all := []models.Series{
+		constSeries("servers.a.disk.used", 1),
+		constSeries("servers.a.disk.free", 3),
+		constSeries("servers.b.disk.used", 3),
+		constSeries("servers.b.disk.free", 1),
+	}
+	out := runPlan(t,
+		`applyByNode(servers.*.disk.used, 1, "divideSeries(%.disk.used, sumSeries(%.disk.*))", "%.disk.pct")`,
+		[]string{"servers.*.disk.used", "servers.*.disk.*"},
+		map[string][]models.Series{
+			"servers.*.disk.used": used,
+			"servers.*.disk.*":    all,
+		},
+	)
+	exp := map[string]float64{
+		"servers.a.disk.pct": 0.25,
+		"servers.b.disk.pct": 0.75,
+	}
+	if len(out) != len(exp) {
+		t.Fatalf("expected %d series, got %d: %v", len(exp), len(out), out)
+	}
+	for _, serie := range out {
+		val, ok := exp[serie.Target]
+		if !ok {
+			t.Fatalf("unexpected series %q", serie.Target)
+		}
+		for _, p := range serie.Datapoints {
+			if p.Val != val {
+				t.Fatalf("series %q: expected %f, got %f", serie.Target, val, p.Val)
+			}
+		}
+	}
+}
+
+func TestApplyByNodeUnsupportedInput(t *testing.T) {
+	exprs, err := ParseMany([]string{`applyByNode(sumSeries(servers.*.cpu), 1, "%.cpu")`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPlan(exprs, 1000, 2000, 0, true, time.UTC, nil)
+	if err != ErrUnknownFunction("applyByNode") {
+		t.Fatalf("expected applyByNode to be left to graphite, got error %v", err)
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"a.*.c", "a.b.c", true},
+		{"a.*.c", "a.b.b.c", false},
+		{"a.{b,x}.c", "a.b.c", true},
+		{"a.{b,x}.c", "a.y.c", false},
+		{"a.b?.c", "a.b1.c", true},
+		{"a.[0-9].c", "a.5.c", true},
+		{"a.b+c", "a.b+c", true},
+		{"a.b+c", "a.bbc", false},
+	}
+	for _, c := range cases {
+		re, err := globToRegexp(c.pattern)
+		if err != nil {
+			t.Fatalf("%q: %s", c.pattern, err)
+		}
+		if re.MatchString(c.name) != c.match {
+			t.Errorf("%q matching %q: expected %t", c.pattern, c.name, c.match)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package expr
+
+import (
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+type FuncGroupByNodes struct {
+	in         GraphiteFunc
+	aggregator string
+	nodes      []expr
+
+	single  bool   // groupByNode, which takes a single node or tag followed by an optional aggregator
+	nodeNum int64  // the node, for groupByNode
+	tag     string // the tag, for groupByNode
+}
+
+func NewGroupByNodesConstructor(single bool) func() GraphiteFunc {
+	return func() GraphiteFunc {
+		return &FuncGroupByNodes{single: single, aggregator: "average"}
+	}
+}
+
+func (s *FuncGroupByNodes) Signature() ([]Arg, []Arg) {
+	if s.single {
+		return []Arg{
+			ArgSeriesList{val: &s.in},
+			ArgIn{
+				key: "nodeNum",
+				args: []Arg{
+					ArgInt{val: &s.nodeNum},
+					ArgString{val: &s.tag},
+				},
+			},
+			ArgString{key: "callback", opt: true, val: &s.aggregator, validator: []Validator{IsAggFunc}},
+		}, []Arg{ArgSeriesList{}}
+	}
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "callback", val: &s.aggregator, validator: []Validator{IsAggFunc}},
+		ArgStringsOrInts{val: &s.nodes},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncGroupByNodes) Context(context Context) Context {
+	if s.single {
+		if s.tag != "" {
+			s.nodes = []expr{{etype: etString, str: s.tag}}
+		} else {
+			s.nodes = []expr{{etype: etInt, int: s.nodeNum}}
+		}
+	}
+	return context
+}
+
+// Exec groups the series by the given nodes and tags (see aggKey), and aggregates each group into a series named after the group key.
+// groups are returned in the order they were first seen.
+func (s *FuncGroupByNodes) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	groups := make(map[string][]models.Series)
+	for _, serie := range series {
+		key := aggKey(serie, s.nodes)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], serie)
+	}
+
+	aggFunc := getCrossSeriesAggFunc(s.aggregator)
+	output := make([]models.Series, 0, len(keys))
+	for _, key := range keys {
+		groupSeries := groups[key]
+		cons, queryCons := summarizeCons(groupSeries)
+
+		newSeries := models.Series{
+			Target:       key,
+			QueryPatt:    key,
+			Tags:         getCommonTags(groupSeries),
+			Interval:     groupSeries[0].Interval,
+			Consolidator: cons,
+			QueryCons:    queryCons,
+		}
+		newSeries.Tags["name"] = key
+		newSeries.Datapoints = pointSlicePool.Get().([]schema.Point)
+		aggFunc(groupSeries, &newSeries.Datapoints)
+		cache[Req{}] = append(cache[Req{}], newSeries)
+
+		output = append(output, newSeries)
+	}
+
+	return output, nil
+}
@@ -0,0 +1,149 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+func groupByNodesInput() []models.Series {
+	serie := func(target string, val float64) models.Series {
+		s := models.Series{
+			Interval:   10,
+			QueryPatt:  "servers.*.*",
+			Target:     target,
+			Datapoints: []schema.Point{{Val: val, Ts: 10}, {Val: val, Ts: 20}},
+		}
+		s.SetTags()
+		return s
+	}
+	return []models.Series{
+		serie("servers.b.cpu;dc=us", 1),
+		serie("servers.a.cpu;dc=eu", 2),
+		serie("servers.b.mem;dc=us", 3),
+		serie("servers.a.mem;dc=eu", 4),
+	}
+}
+
+func TestGroupByNode(t *testing.T) {
+	f := NewGroupByNodesConstructor(true)().(*FuncGroupByNodes)
+	f.in = NewMock(groupByNodesInput())
+	f.nodeNum = 1
+	f.aggregator = "sumSeries"
+	f.Context(Context{})
+
+	// groups come out in the order they were first seen
+	out := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "b",
+			Target:     "b",
+			Datapoints: []schema.Point{{Val: 4, Ts: 10}, {Val: 4, Ts: 20}},
+		},
+		{
+			Interval:   10,
+			QueryPatt:  "a",
+			Target:     "a",
+			Datapoints: []schema.Point{{Val: 6, Ts: 10}, {Val: 6, Ts: 20}},
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Tags["dc"] != "us" || got[0].Tags["name"] != "b" {
+		t.Fatalf("expected common tags and name to be set. got tags %v", got[0].Tags)
+	}
+}
+
+func TestGroupByNodeTag(t *testing.T) {
+	f := NewGroupByNodesConstructor(true)().(*FuncGroupByNodes)
+	f.in = NewMock(groupByNodesInput())
+	f.tag = "dc"
+	f.Context(Context{})
+
+	out := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "us",
+			Target:     "us",
+			Datapoints: []schema.Point{{Val: 2, Ts: 10}, {Val: 2, Ts: 20}},
+		},
+		{
+			Interval:   10,
+			QueryPatt:  "eu",
+			Target:     "eu",
+			Datapoints: []schema.Point{{Val: 3, Ts: 10}, {Val: 3, Ts: 20}},
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupByNodes(t *testing.T) {
+	f := NewGroupByNodesConstructor(false)().(*FuncGroupByNodes)
+	f.in = NewMock(groupByNodesInput())
+	f.aggregator = "max"
+	f.nodes = []expr{{etype: etInt, int: 2}, {etype: etString, str: "dc"}}
+	f.Context(Context{})
+
+	out := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "cpu.us",
+			Target:     "cpu.us",
+			Datapoints: []schema.Point{{Val: 1, Ts: 10}, {Val: 1, Ts: 20}},
+		},
+		{
+			Interval:   10,
+			QueryPatt:  "cpu.eu",
+			Target:     "cpu.eu",
+			Datapoints: []schema.Point{{Val: 2, Ts: 10}, {Val: 2, Ts: 20}},
+		},
+		{
+			Interval:   10,
+			QueryPatt:  "mem.us",
+			Target:     "mem.us",
+			Datapoints: []schema.Point{{Val: 3, Ts: 10}, {Val: 3, Ts: 20}},
+		},
+		{
+			Interval:   10,
+			QueryPatt:  "mem.eu",
+			Target:     "mem.eu",
+			Datapoints: []schema.Point{{Val: 4, Ts: 10}, {Val: 4, Ts: 20}},
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupByNodePlan(t *testing.T) {
+	cases := []struct {
+		target string
+		err    error
+	}{
+		{`groupByNode(a.*, 1)`, nil},
+		{`groupByNode(a.*, "dc", "sum")`, nil},
+		{`groupByNode(a.*, 1, callback="averageSeries")`, nil},
+		{`groupByNode(a.*, 1, "bogus")`, generateValidatorError("callback", ErrInvalidAggFunc)},
+		{`groupByNodes(a.*, "sum", 1, "dc")`, nil},
+		{`groupByNodes(a.*, "sum", 1.5)`, ErrTooManyArg},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewPlan(exprs, 1000, 2000, 800, true, time.UTC, nil)
+		if !reflect.DeepEqual(err, c.err) {
+			t.Errorf("%q: expected error %v, got %v", c.target, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"github.com/raintank/schema"
+)
+
+// holtWinters holds the settings shared by all Holt-Winters functions.
+// the analysis needs to be bootstrapped with data from before the requested range,
+// so Context extends from backwards by the bootstrap interval, and the output is trimmed back to the original from,
+// except for the points our own consumer requested as lookback, like movingWindow does.
+type holtWinters struct {
+	in                GraphiteFunc
+	bootstrapInterval string
+	seasonality       string
+
+	from uint32 // the from of the original request, before extending it by the bootstrap interval
+	keep uint32 // the number of points before from to keep in the output, as requested by the consumer
+}
+
+func newHoltWinters() holtWinters {
+	return holtWinters{bootstrapInterval: "7d", seasonality: "1d"}
+}
+
+func (h *holtWinters) context(context Context) Context {
+	h.from = context.from
+	h.keep = context.lookback
+	bootstrap, _ := dur.ParseDuration(h.bootstrapInterval)
+	if context.from > bootstrap {
+		context.from -= bootstrap
+	} else {
+		context.from = 0
+	}
+	return context
+}
+
+// start returns the index of the first point to output: the first point within the original requested range,
+// preceded by the points the consumer requested as lookback
+func (h *holtWinters) start(points []schema.Point) int {
+	start := len(points)
+	for i, p := range points {
+		if p.Ts >= h.from {
+			start = i
+			break
+		}
+	}
+	if start > int(h.keep) {
+		return start - int(h.keep)
+	}
+	return 0
+}
+
+// holtWintersAnalysis computes the predictions and deviations for the given series,
+// using the same algorithm and parameters as graphite
+func holtWintersAnalysis(points []schema.Point, interval, seasonality uint32) ([]float64, []float64) {
+	const alpha, gamma = 0.1, 0.1
+	const beta = 0.0035
+
+	var seasonLength int
+	if interval > 0 {
+		seasonLength = int(seasonality / interval)
+	}
+
+	intercepts := make([]float64, len(points))
+	slopes := make([]float64, len(points))
+	seasonals := make([]float64, len(points))
+	predictions := make([]float64, len(points))
+	deviations := make([]float64, len(points))
+
+	lastSeason := func(values []float64, i int) float64 {
+		j := i - seasonLength
+		if j >= 0 && j < len(values) {
+			return values[j]
+		}
+		return 0
+	}
+
+	nextPred := math.NaN()
+	for i, p := range points {
+		actual := p.Val
+		if math.IsNaN(actual) {
+			// missing input values break all the math, do the best we can and move on
+			intercepts[i] = math.NaN()
+			predictions[i] = nextPred
+			nextPred = math.NaN()
+			continue
+		}
+
+		var lastIntercept, lastSlope, prediction float64
+		if i == 0 {
+			lastIntercept = actual
+			prediction = actual
+		} else {
+			lastIntercept = intercepts[i-1]
+			lastSlope = slopes[i-1]
+			if math.IsNaN(lastIntercept) {
+				lastIntercept = actual
+			}
+			prediction = nextPred
+		}
+
+		lastSeasonal := lastSeason(seasonals, i)
+		nextLastSeasonal := lastSeason(seasonals, i+1)
+		lastSeasonalDev := lastSeason(deviations, i)
+
+		intercept := alpha*(actual-lastSeasonal) + (1-alpha)*(lastIntercept+lastSlope)
+		slope := beta*(intercept-lastIntercept) + (1-beta)*lastSlope
+		seasonal := gamma*(actual-intercept) + (1-gamma)*lastSeasonal
+		nextPred = intercept + slope + nextLastSeasonal
+
+		predictionForDev := prediction
+		if math.IsNaN(predictionForDev) {
+			predictionForDev = 0
+		}
+		deviation := gamma*math.Abs(actual-predictionForDev) + (1-gamma)*lastSeasonalDev
+
+		intercepts[i] = intercept
+		slopes[i] = slope
+		seasonals[i] = seasonal
+		predictions[i] = prediction
+		deviations[i] = deviation
+	}
+	return predictions, deviations
+}
+
+// confidenceBands returns the trimmed lower and upper confidence bands of the given series
+func (h *holtWinters) confidenceBands(serie models.Series, delta float64) ([]schema.Point, []schema.Point) {
+	seasonality, _ := dur.ParseDuration(h.seasonality)
+	predictions, deviations := holtWintersAnalysis(serie.Datapoints, serie.Interval, seasonality)
+
+	lower := pointSlicePool.Get().([]schema.Point)
+	upper := pointSlicePool.Get().([]schema.Point)
+	for i := h.start(serie.Datapoints); i < len(serie.Datapoints); i++ {
+		p := serie.Datapoints[i]
+		if math.IsNaN(predictions[i]) {
+			lower = append(lower, schema.Point{Val: math.NaN(), Ts: p.Ts})
+			upper = append(upper, schema.Point{Val: math.NaN(), Ts: p.Ts})
+			continue
+		}
+		scaledDeviation := delta * deviations[i]
+		lower = append(lower, schema.Point{Val: predictions[i] - scaledDeviation, Ts: p.Ts})
+		upper = append(upper, schema.Point{Val: predictions[i] + scaledDeviation, Ts: p.Ts})
+	}
+	return lower, upper
+}
+
+// newHoltWintersSeries returns a series derived from serie, named fn(target) and tagged with fn=1
+func newHoltWintersSeries(serie models.Series, fn string, points []schema.Point) models.Series {
+	out := models.Series{
+		Target:       fmt.Sprintf("%s(%s)", fn, serie.Target),
+		QueryPatt:    fmt.Sprintf("%s(%s)", fn, serie.QueryPatt),
+		Tags:         make(map[string]string, len(serie.Tags)+1),
+		Datapoints:   points,
+		Interval:     serie.Interval,
+		Consolidator: serie.Consolidator,
+		QueryCons:    serie.QueryCons,
+	}
+	for k, v := range serie.Tags {
+		out.Tags[k] = v
+	}
+	out.Tags[fn] = "1"
+	return out
+}
+
+type FuncHoltWintersForecast struct {
+	holtWinters
+}
+
+func NewHoltWintersForecast() GraphiteFunc {
+	return &FuncHoltWintersForecast{newHoltWinters()}
+}
+
+func (s *FuncHoltWintersForecast) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "bootstrapInterval", opt: true, val: &s.bootstrapInterval, validator: []Validator{IsIntervalString}},
+		ArgString{key: "seasonality", opt: true, val: &s.seasonality, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHoltWintersForecast) Context(context Context) Context {
+	return s.context(context)
+}
+
+func (s *FuncHoltWintersForecast) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	seasonality, _ := dur.ParseDuration(s.seasonality)
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		predictions, _ := holtWintersAnalysis(serie.Datapoints, serie.Interval, seasonality)
+		out := pointSlicePool.Get().([]schema.Point)
+		for i := s.start(serie.Datapoints); i < len(serie.Datapoints); i++ {
+			out = append(out, schema.Point{Val: predictions[i], Ts: serie.Datapoints[i].Ts})
+		}
+		output := newHoltWintersSeries(serie, "holtWintersForecast", out)
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+type FuncHoltWintersConfidenceBands struct {
+	holtWinters
+	delta float64
+}
+
+func NewHoltWintersConfidenceBands() GraphiteFunc {
+	return &FuncHoltWintersConfidenceBands{newHoltWinters(), 3}
+}
+
+func (s *FuncHoltWintersConfidenceBands) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "delta", opt: true, val: &s.delta},
+		ArgString{key: "bootstrapInterval", opt: true, val: &s.bootstrapInterval, validator: []Validator{IsIntervalString}},
+		ArgString{key: "seasonality", opt: true, val: &s.seasonality, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHoltWintersConfidenceBands) Context(context Context) Context {
+	return s.context(context)
+}
+
+func (s *FuncHoltWintersConfidenceBands) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]models.Series, 0, 2*len(series))
+	for _, serie := range series {
+		lower, upper := s.confidenceBands(serie, s.delta)
+		lowerSeries := newHoltWintersSeries(serie, "holtWintersConfidenceLower", lower)
+		upperSeries := newHoltWintersSeries(serie, "holtWintersConfidenceUpper", upper)
+		outputs = append(outputs, lowerSeries, upperSeries)
+		cache[Req{}] = append(cache[Req{}], lowerSeries, upperSeries)
+	}
+	return outputs, nil
+}
+
+type FuncHoltWintersAberration struct {
+	holtWinters
+	delta float64
+}
+
+func NewHoltWintersAberration() GraphiteFunc {
+	return &FuncHoltWintersAberration{newHoltWinters(), 3}
+}
+
+func (s *FuncHoltWintersAberration) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "delta", opt: true, val: &s.delta},
+		ArgString{key: "bootstrapInterval", opt: true, val: &s.bootstrapInterval, validator: []Validator{IsIntervalString}},
+		ArgString{key: "seasonality", opt: true, val: &s.seasonality, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHoltWintersAberration) Context(context Context) Context {
+	return s.context(context)
+}
+
+func (s *FuncHoltWintersAberration) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		lower, upper := s.confidenceBands(serie, s.delta)
+
+		out := pointSlicePool.Get().([]schema.Point)
+		for i, p := range serie.Datapoints[s.start(serie.Datapoints):] {
+			aberration := 0.0
+			if !math.IsNaN(p.Val) {
+				if p.Val > upper[i].Val {
+					aberration = p.Val - upper[i].Val
+				} else if p.Val < lower[i].Val {
+					aberration = p.Val - lower[i].Val
+				}
+			}
+			out = append(out, schema.Point{Val: aberration, Ts: p.Ts})
+		}
+		// the bands are not needed anymore once the aberration is computed
+		pointSlicePool.Put(lower[:0])
+		pointSlicePool.Put(upper[:0])
+
+		output := newHoltWintersSeries(serie, "holtWintersAberration", out)
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,155 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+// holtWintersInput returns a series with a constant value of 10 between 10 and 200, except for a spike of 100 at 200.
+func holtWintersInput() []models.Series {
+	var points []schema.Point
+	for ts := uint32(10); ts <= 200; ts += 10 {
+		points = append(points, schema.Point{Val: 10, Ts: ts})
+	}
+	points[len(points)-1].Val = 100
+	return []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "a",
+			Target:     "a",
+			Datapoints: points,
+		},
+	}
+}
+
+// constant returns points between from and to (inclusive) with the given value, except for the last point
+func constant(from, to uint32, val, last float64) []schema.Point {
+	var points []schema.Point
+	for ts := from; ts <= to; ts += 10 {
+		points = append(points, schema.Point{Val: val, Ts: ts})
+	}
+	points[len(points)-1].Val = last
+	return points
+}
+
+func TestHoltWintersContext(t *testing.T) {
+	f := NewHoltWintersForecast()
+	ctx := f.Context(Context{from: 700000, to: 800000})
+	if ctx.from != 700000-7*24*3600 || ctx.to != 800000 {
+		t.Fatalf("expected context to be extended by the default bootstrap of 7d. got from %d, to %d", ctx.from, ctx.to)
+	}
+	ctx = f.Context(Context{from: 1000, to: 2000})
+	if ctx.from != 0 {
+		t.Fatalf("expected from to be clamped to 0, got %d", ctx.from)
+	}
+}
+
+func TestHoltWintersForecast(t *testing.T) {
+	f := NewHoltWintersForecast().(*FuncHoltWintersForecast)
+	f.in = NewMock(holtWintersInput())
+	f.bootstrapInterval = "100s"
+	f.Context(Context{from: 110, to: 210})
+
+	// the spike can't be predicted, all predictions are 10
+	out := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "holtWintersForecast(a)",
+			Target:     "holtWintersForecast(a)",
+			Datapoints: constant(110, 200, 10, 10),
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Tags["holtWintersForecast"] != "1" {
+		t.Fatalf("expected holtWintersForecast tag to be set. got tags %v", got[0].Tags)
+	}
+}
+
+// the points before from that a consumer requested as lookback must be kept
+func TestHoltWintersForecastLookback(t *testing.T) {
+	f := NewHoltWintersForecast().(*FuncHoltWintersForecast)
+	f.in = NewMock(holtWintersInput())
+	f.bootstrapInterval = "100s"
+	outer := &FuncMovingWindow{movingWindow: movingWindow{windowPoints: 3, in: f}, fn: "average", single: true}
+	f.Context(outer.Context(Context{from: 110, to: 210}))
+
+	out := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "movingAverage(holtWintersForecast(a),3)",
+			Target:     "movingAverage(holtWintersForecast(a),3)",
+			Datapoints: constant(110, 200, 10, 10),
+		},
+	}
+	got, err := outer.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHoltWintersConfidenceBands(t *testing.T) {
+	f := NewHoltWintersConfidenceBands().(*FuncHoltWintersConfidenceBands)
+	f.in = NewMock(holtWintersInput())
+	f.bootstrapInterval = "100s"
+	f.Context(Context{from: 110, to: 210})
+
+	// up to the spike, there is no deviation, so the bands equal the forecast.
+	// at the spike, the deviation is 0.1*|100-10| = 9, scaled by delta=3
+	out := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "holtWintersConfidenceLower(a)",
+			Target:     "holtWintersConfidenceLower(a)",
+			Datapoints: constant(110, 200, 10, 10-27),
+		},
+		{
+			Interval:   10,
+			QueryPatt:  "holtWintersConfidenceUpper(a)",
+			Target:     "holtWintersConfidenceUpper(a)",
+			Datapoints: constant(110, 200, 10, 10+27),
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHoltWintersAberration(t *testing.T) {
+	f := NewHoltWintersAberration().(*FuncHoltWintersAberration)
+	f.in = NewMock(holtWintersInput())
+	f.bootstrapInterval = "100s"
+	f.Context(Context{from: 110, to: 210})
+
+	out := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "holtWintersAberration(a)",
+			Target:     "holtWintersAberration(a)",
+			Datapoints: constant(110, 200, 0, 100-37),
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHoltWintersAnalysisWithNulls(t *testing.T) {
+	points := constant(10, 100, 10, 10)
+	points[3].Val = math.NaN()
+	predictions, deviations := holtWintersAnalysis(points, 10, 86400)
+	// the point after a missing value has no prediction, after that it recovers
+	if !math.IsNaN(predictions[4]) {
+		t.Fatalf("expected NaN prediction after missing value, got %f", predictions[4])
+	}
+	if predictions[5] != 10 || deviations[5] != 0 {
+		t.Fatalf("expected prediction 10 with deviation 0, got %f and %f", predictions[5], deviations[5])
+	}
+}
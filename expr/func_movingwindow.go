@@ -0,0 +1,267 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/batch"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/raintank/dur"
+	"github.com/raintank/schema"
+)
+
+// movingWindow holds the window shared by all moving window functions, such as movingAverage and exponentialMovingAverage.
+// the window is either a number of points, or a duration string such as "5min".
+// each output point needs a full window of data before it, so Context extends the request backwards:
+// a duration directly moves from, whereas a number of points is passed on as lookback, because the time range
+// covered by those points depends on the interval of the data, which is only known once the data is fetched.
+// the output is trimmed back to the original from, except for the points our own consumer requested as lookback,
+// e.g. the inner function of movingAverage(movingSum(foo, 3), 3) keeps 3 points before from.
+type movingWindow struct {
+	in           GraphiteFunc
+	windowPoints int64  // the window as a number of points. only used if windowSize is not set
+	windowSize   string // the window as a duration
+
+	from uint32 // the from of the original request, before extending it by the window
+	keep uint32 // the number of points before from to keep in the output, as requested by the consumer
+}
+
+func (w *movingWindow) windowArg() Arg {
+	return ArgIn{
+		key: "windowSize",
+		args: []Arg{
+			ArgInt{val: &w.windowPoints, validator: []Validator{IntPositive}},
+			ArgString{val: &w.windowSize, validator: []Validator{IsIntervalString}},
+		},
+	}
+}
+
+func (w *movingWindow) context(context Context) Context {
+	w.from = context.from
+	w.keep = context.lookback
+	if w.windowSize == "" {
+		context.lookback += uint32(w.windowPoints)
+		return context
+	}
+	window, err := dur.ParseDuration(w.windowSize)
+	if err != nil {
+		// will be reported by Exec
+		return context
+	}
+	if context.from > window {
+		context.from -= window
+	} else {
+		context.from = 0
+	}
+	return context
+}
+
+// points returns the number of points in the window, for data of the given interval
+func (w *movingWindow) points(interval uint32) (int, error) {
+	if w.windowSize == "" {
+		return int(w.windowPoints), nil
+	}
+	window, err := dur.ParseDuration(w.windowSize)
+	if err != nil {
+		return 0, err
+	}
+	if interval == 0 {
+		return 0, nil
+	}
+	return int(window / interval), nil
+}
+
+// start returns the index of the first point to output: the first point within the original requested range,
+// preceded by the points the consumer requested as lookback
+func (w *movingWindow) start(points []schema.Point) int {
+	start := len(points)
+	for i, p := range points {
+		if p.Ts >= w.from {
+			start = i
+			break
+		}
+	}
+	if start > int(w.keep) {
+		return start - int(w.keep)
+	}
+	return 0
+}
+
+// tagValue returns the window as it was specified
+func (w *movingWindow) tagValue() string {
+	if w.windowSize == "" {
+		return fmt.Sprintf("%d", w.windowPoints)
+	}
+	return w.windowSize
+}
+
+// newSeries returns the output series of fn for serie, named and tagged like graphite does.
+// e.g. movingAverage(foo,10) or movingAverage(foo,"5min") with tag movingAverage=10 or movingAverage=5min
+func (w *movingWindow) newSeries(serie models.Series, fn string, points []schema.Point) models.Series {
+	format := "%s(%s,%s)"
+	if w.windowSize != "" {
+		format = "%s(%s,\"%s\")"
+	}
+	out := newTaggedSeries(serie, fmt.Sprintf(format, fn, serie.Target, w.tagValue()), points, map[string]string{fn: w.tagValue()})
+	out.QueryPatt = fmt.Sprintf(format, fn, serie.QueryPatt, w.tagValue())
+	return out
+}
+
+type FuncMovingWindow struct {
+	movingWindow
+	fn           string
+	xFilesFactor float64
+
+	single bool // movingAverage, movingMedian, etc, which don't take the aggregation function as argument
+}
+
+// NewMovingWindowConstructor returns a constructor for movingWindow if fn is empty,
+// and for a function that always uses the given aggregation function, such as movingAverage, otherwise.
+func NewMovingWindowConstructor(fn string) func() GraphiteFunc {
+	return func() GraphiteFunc {
+		if fn == "" {
+			return &FuncMovingWindow{fn: "average"}
+		}
+		return &FuncMovingWindow{fn: fn, single: true}
+	}
+}
+
+func (s *FuncMovingWindow) Signature() ([]Arg, []Arg) {
+	xFilesFactor := ArgFloat{key: "xFilesFactor", opt: true, val: &s.xFilesFactor, validator: []Validator{WithinZeroOneInclusiveInterval}}
+	if s.single {
+		return []Arg{
+			ArgSeriesList{val: &s.in},
+			s.windowArg(),
+			xFilesFactor,
+		}, []Arg{ArgSeriesList{}}
+	}
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		s.windowArg(),
+		ArgString{key: "func", opt: true, val: &s.fn, validator: []Validator{IsConsolFunc}},
+		xFilesFactor,
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncMovingWindow) Context(context Context) Context {
+	return s.context(context)
+}
+
+// Exec computes for each point the aggregate of the window of points before it, like graphite does.
+// windows that have no values, or fewer than xFilesFactor of the window, result in null.
+func (s *FuncMovingWindow) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	aggFunc := consolidation.GetAggFunc(consolidation.FromConsolidateBy(s.fn))
+	if aggFunc == nil {
+		return nil, ErrInvalidAggFunc
+	}
+	// graphite's tag and function name, e.g. movingAverage for "average" and movingAvg for "avg"
+	name := "moving" + strings.ToUpper(s.fn[:1]) + strings.ToLower(s.fn[1:])
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		windowPoints, err := s.points(serie.Interval)
+		if err != nil {
+			return nil, err
+		}
+		out := pointSlicePool.Get().([]schema.Point)
+		for i := s.start(serie.Datapoints); i < len(serie.Datapoints); i++ {
+			lo := i - windowPoints
+			if lo < 0 {
+				lo = 0
+			}
+			window := serie.Datapoints[lo:i]
+			val := math.NaN()
+			var nonNull int
+			for _, p := range window {
+				if !math.IsNaN(p.Val) {
+					nonNull++
+				}
+			}
+			if nonNull > 0 && float64(nonNull)/float64(windowPoints) >= s.xFilesFactor {
+				val = aggFunc(window)
+			}
+			out = append(out, schema.Point{Val: val, Ts: serie.Datapoints[i].Ts})
+		}
+		output := s.newSeries(serie, name, out)
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+type FuncExponentialMovingAverage struct {
+	movingWindow
+}
+
+func NewExponentialMovingAverage() GraphiteFunc {
+	return &FuncExponentialMovingAverage{}
+}
+
+func (s *FuncExponentialMovingAverage) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		s.windowArg(),
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncExponentialMovingAverage) Context(context Context) Context {
+	return s.context(context)
+}
+
+// Exec computes the exponential moving average with a smoothing constant of 2 / (windowPoints + 1),
+// seeded with the average of the window before the requested range.
+// like graphite, each output point is the average as of the point before it, and values are rounded to 6 decimals.
+func (s *FuncExponentialMovingAverage) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		windowPoints, err := s.points(serie.Interval)
+		if err != nil {
+			return nil, err
+		}
+		constant := 2 / float64(windowPoints+1)
+		start := s.start(serie.Datapoints)
+		lo := start - windowPoints
+		if lo < 0 {
+			lo = 0
+		}
+		ema := batch.Avg(serie.Datapoints[lo:start])
+		if math.IsNaN(ema) {
+			ema = 0
+		}
+
+		out := pointSlicePool.Get().([]schema.Point)
+		for i := start; i < len(serie.Datapoints); i++ {
+			val := roundDecimals(ema, 6)
+			if i > start {
+				prev := serie.Datapoints[i-1].Val
+				if math.IsNaN(prev) {
+					val = math.NaN()
+				} else {
+					ema = constant*prev + (1-constant)*ema
+					val = roundDecimals(ema, 6)
+				}
+			}
+			out = append(out, schema.Point{Val: val, Ts: serie.Datapoints[i].Ts})
+		}
+		output := s.newSeries(serie, "exponentialMovingAverage", out)
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+func roundDecimals(val float64, decimals int) float64 {
+	pow := math.Pow(10, float64(decimals))
+	return math.Round(val*pow) / pow
+}
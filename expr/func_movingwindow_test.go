@@ -0,0 +1,216 @@
+package expr
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+// movingWindowInput returns a series with values 1, 2, 3, ..., 10 at 10, 20, 30, ..., 100, with a null at 70
+func movingWindowInput() []models.Series {
+	var points []schema.Point
+	for i := 1; i <= 10; i++ {
+		points = append(points, schema.Point{Val: float64(i), Ts: uint32(i * 10)})
+	}
+	points[6].Val = math.NaN()
+	return []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "a",
+			Target:     "a",
+			Datapoints: points,
+		},
+	}
+}
+
+func TestMovingWindowPlan(t *testing.T) {
+	cases := []struct {
+		target   string
+		from     uint32
+		lookback uint32
+	}{
+		{"movingAverage(a, 3)", 1000, 3},
+		{"movingAverage(a, '1min')", 940, 0},
+		{"movingSum(movingMax(a, 2), '1min')", 940, 2},
+		{"movingWindow(a, 5, 'median', 0.5)", 1000, 5},
+		{"exponentialMovingAverage(a, '2h')", 0, 0},
+		{"movingAverage(movingSum(a, 3), 3)", 1000, 6},
+		{"movingAverage(summarize(a, '1min'), 3)", 820, 0},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := NewPlan(exprs, 1000, 2000, 0, true, time.UTC, nil)
+		if err != nil {
+			t.Fatalf("case %q: %s", c.target, err)
+		}
+		exp := NewReq("a", c.from, 2000, 0)
+		exp.Lookback = c.lookback
+		if len(plan.Reqs) != 1 || plan.Reqs[0] != exp {
+			t.Fatalf("case %q: expected reqs %v, got %v", c.target, []Req{exp}, plan.Reqs)
+		}
+	}
+}
+
+func TestMovingWindowInvalidArgs(t *testing.T) {
+	for _, target := range []string{
+		"movingAverage(a, 0)",
+		"movingAverage(a, 'foo')",
+		"movingAverage(a, 3, 1.5)",
+		"movingWindow(a, 3, 'foo')",
+	} {
+		exprs, err := ParseMany([]string{target})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewPlan(exprs, 1000, 2000, 0, true, time.UTC, nil)
+		if err == nil {
+			t.Fatalf("case %q: expected error, got none", target)
+		}
+	}
+}
+
+func TestMovingWindow(t *testing.T) {
+	nan := math.NaN()
+	cases := []struct {
+		name string
+		fn   GraphiteFunc
+		exp  []float64
+	}{
+		{
+			name: `movingAverage(a,3)`,
+			fn:   &FuncMovingWindow{movingWindow: movingWindow{windowPoints: 3}, fn: "average", single: true},
+			exp:  []float64{2, 3, 4, 5, 5.5, 7, 8.5},
+		},
+		{
+			name: `movingSum(a,"30s")`,
+			fn:   &FuncMovingWindow{movingWindow: movingWindow{windowSize: "30s"}, fn: "sum", single: true},
+			exp:  []float64{6, 9, 12, 15, 11, 14, 17},
+		},
+		{
+			name: `movingMax(a,2)`,
+			fn:   &FuncMovingWindow{movingWindow: movingWindow{windowPoints: 2}, fn: "max", single: true},
+			exp:  []float64{3, 4, 5, 6, 6, 8, 9},
+		},
+		{
+			// the windows that include the null only have 1 out of 2 values, which doesn't meet the xFilesFactor
+			name: `movingMin(a,2)`,
+			fn:   &FuncMovingWindow{movingWindow: movingWindow{windowPoints: 2}, fn: "min", xFilesFactor: 0.6, single: true},
+			exp:  []float64{2, 3, 4, 5, nan, nan, 8},
+		},
+		{
+			name: `movingMedian(a,"20s")`,
+			fn:   &FuncMovingWindow{movingWindow: movingWindow{windowSize: "20s"}, fn: "median", single: true},
+			exp:  []float64{2.5, 3.5, 4.5, 5.5, 6, 8, 8.5},
+		},
+	}
+	for _, c := range cases {
+		f := c.fn.(*FuncMovingWindow)
+		f.in = NewMock(movingWindowInput())
+		f.Context(Context{from: 40, to: 110})
+		var points []schema.Point
+		for i, v := range c.exp {
+			points = append(points, schema.Point{Val: v, Ts: uint32(40 + i*10)})
+		}
+		out := []models.Series{
+			{
+				Interval:   10,
+				QueryPatt:  c.name,
+				Target:     c.name,
+				Datapoints: points,
+			},
+		}
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err := equalOutput(out, got, nil, err); err != nil {
+			t.Fatalf("case %q: %s", c.name, err)
+		}
+	}
+}
+
+// the inner moving window must keep the points before from that the outer one needs for its window
+func TestMovingWindowNested(t *testing.T) {
+	var points []schema.Point
+	for i := 1; i <= 10; i++ {
+		points = append(points, schema.Point{Val: float64(i), Ts: uint32(i * 10)})
+	}
+	inner := &FuncMovingWindow{movingWindow: movingWindow{windowPoints: 3}, fn: "sum", single: true}
+	inner.in = NewMock([]models.Series{{Interval: 10, QueryPatt: "a", Target: "a", Datapoints: points}})
+	outer := &FuncMovingWindow{movingWindow: movingWindow{windowPoints: 3, in: inner}, fn: "average", single: true}
+	context := outer.Context(Context{from: 70, to: 110})
+	context = inner.Context(context)
+	if context.from != 70 || context.lookback != 6 {
+		t.Fatalf("expected from 70 with a lookback of 6 points, got from %d with a lookback of %d", context.from, context.lookback)
+	}
+
+	// movingSum outputs 6, 9, 12, 15, 18, 21, 24 at 40 through 100
+	out := []models.Series{
+		{
+			Interval:  10,
+			QueryPatt: "movingAverage(movingSum(a,3),3)",
+			Target:    "movingAverage(movingSum(a,3),3)",
+			Datapoints: []schema.Point{
+				{Val: 9, Ts: 70},
+				{Val: 12, Ts: 80},
+				{Val: 15, Ts: 90},
+				{Val: 18, Ts: 100},
+			},
+		},
+	}
+	got, err := outer.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMovingWindowTags(t *testing.T) {
+	f := NewMovingWindowConstructor("")().(*FuncMovingWindow)
+	f.in = NewMock(movingWindowInput())
+	f.windowSize = "30s"
+	f.fn = "avg"
+	f.Context(Context{from: 40, to: 110})
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Target != `movingAvg(a,"30s")` || got[0].Tags["movingAvg"] != "30s" {
+		t.Fatalf("expected movingAvg(a,\"30s\") with tag movingAvg=30s, got %q with tags %v", got[0].Target, got[0].Tags)
+	}
+}
+
+func TestExponentialMovingAverage(t *testing.T) {
+	f := NewExponentialMovingAverage().(*FuncExponentialMovingAverage)
+	f.in = NewMock(movingWindowInput())
+	f.windowPoints = 3
+	f.Context(Context{from: 40, to: 110})
+
+	// the constant is 2/(3+1) = 0.5, and the average is seeded with the average of 1, 2 and 3.
+	// like graphite, each point has the average up until the point before it.
+	out := []models.Series{
+		{
+			Interval:  10,
+			QueryPatt: "exponentialMovingAverage(a,3)",
+			Target:    "exponentialMovingAverage(a,3)",
+			Datapoints: []schema.Point{
+				{Val: 2, Ts: 40},
+				{Val: 3, Ts: 50},
+				{Val: 4, Ts: 60},
+				{Val: 5, Ts: 70},
+				{Val: math.NaN(), Ts: 80},
+				{Val: 6.5, Ts: 90},
+				{Val: 7.75, Ts: 100},
+			},
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Tags["exponentialMovingAverage"] != "3" {
+		t.Fatalf("expected exponentialMovingAverage tag to be set. got tags %v", got[0].Tags)
+	}
+}
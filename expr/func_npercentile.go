@@ -0,0 +1,70 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+type FuncNPercentile struct {
+	in GraphiteFunc
+	n  float64
+}
+
+func NewNPercentile() GraphiteFunc {
+	return &FuncNPercentile{}
+}
+
+func (s *FuncNPercentile) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "n", val: &s.n, validator: []Validator{NonNegativePercent}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncNPercentile) Context(context Context) Context {
+	return context
+}
+
+// Exec returns, for every input series that has values, a series that has the n-th percentile of its values at every point
+func (s *FuncNPercentile) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []models.Series
+
+	for _, serie := range series {
+		percentile := getPercentileValue(serie.Datapoints, s.n, nil)
+		if math.IsNaN(percentile) {
+			continue
+		}
+
+		out := pointSlicePool.Get().([]schema.Point)
+		for _, p := range serie.Datapoints {
+			out = append(out, schema.Point{Val: percentile, Ts: p.Ts})
+		}
+
+		name := fmt.Sprintf("nPercentile(%s, %g)", serie.Target, s.n)
+		output := models.Series{
+			Target:       name,
+			QueryPatt:    name,
+			Tags:         make(map[string]string, len(serie.Tags)+1),
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		for k, v := range serie.Tags {
+			output.Tags[k] = v
+		}
+		output.Tags["nPercentile"] = fmt.Sprintf("%g", s.n)
+		outputs = append(outputs, output)
+	}
+	cache[Req{}] = append(cache[Req{}], outputs...)
+
+	return outputs, nil
+}
@@ -0,0 +1,45 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+func TestNPercentile(t *testing.T) {
+	f := NewNPercentile().(*FuncNPercentile)
+	f.in = NewMock([]models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "a",
+			Target:     "a",
+			Datapoints: []schema.Point{{Val: 3, Ts: 10}, {Val: 1, Ts: 20}, {Val: math.NaN(), Ts: 30}, {Val: 2, Ts: 40}},
+		},
+		{
+			Interval:   10,
+			QueryPatt:  "b",
+			Target:     "b",
+			Datapoints: []schema.Point{{Val: math.NaN(), Ts: 10}, {Val: math.NaN(), Ts: 20}},
+		},
+	})
+	f.n = 50
+
+	// series without values are dropped
+	out := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "nPercentile(a, 50)",
+			Target:     "nPercentile(a, 50)",
+			Datapoints: []schema.Point{{Val: 2, Ts: 10}, {Val: 2, Ts: 20}, {Val: 2, Ts: 30}, {Val: 2, Ts: 40}},
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Tags["nPercentile"] != "50" {
+		t.Fatalf("expected nPercentile tag to be set. got tags %v", got[0].Tags)
+	}
+}
@@ -0,0 +1,59 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+type FuncPercentileOfSeries struct {
+	in          GraphiteFunc
+	n           float64
+	interpolate bool
+}
+
+func NewPercentileOfSeries() GraphiteFunc {
+	return &FuncPercentileOfSeries{}
+}
+
+func (s *FuncPercentileOfSeries) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "n", val: &s.n, validator: []Validator{NonNegativePercent}},
+		ArgBool{key: "interpolate", opt: true, val: &s.interpolate},
+	}, []Arg{ArgSeries{}}
+}
+
+func (s *FuncPercentileOfSeries) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncPercentileOfSeries) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(series) == 0 {
+		return series, nil
+	}
+
+	out := pointSlicePool.Get().([]schema.Point)
+	crossSeriesPercentile(s.n, s.interpolate)(series, &out)
+
+	cons, queryCons := summarizeCons(series)
+	name := fmt.Sprintf("percentileOfSeries(%s,%g)", series[0].QueryPatt, s.n)
+	output := models.Series{
+		Target:       name,
+		QueryPatt:    name,
+		Tags:         getCommonTags(series),
+		Datapoints:   out,
+		Interval:     series[0].Interval,
+		Consolidator: cons,
+		QueryCons:    queryCons,
+	}
+	cache[Req{}] = append(cache[Req{}], output)
+
+	return []models.Series{output}, nil
+}
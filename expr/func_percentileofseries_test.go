@@ -0,0 +1,71 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+func percentileInput() []models.Series {
+	return []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "foo.*",
+			Target:     "foo.x",
+			Datapoints: []schema.Point{{Val: 1, Ts: 10}, {Val: 2, Ts: 20}, {Val: 3, Ts: 30}},
+		},
+		{
+			Interval:   10,
+			QueryPatt:  "foo.*",
+			Target:     "foo.y",
+			Datapoints: []schema.Point{{Val: 2, Ts: 10}, {Val: math.NaN(), Ts: 20}, {Val: 6, Ts: 30}},
+		},
+		{
+			Interval:   10,
+			QueryPatt:  "foo.*",
+			Target:     "foo.z",
+			Datapoints: []schema.Point{{Val: 3, Ts: 10}, {Val: 4, Ts: 20}, {Val: math.NaN(), Ts: 30}},
+		},
+	}
+}
+
+func TestPercentileOfSeries(t *testing.T) {
+	cases := []struct {
+		interpolate bool
+		exp         []schema.Point
+	}{
+		{false, []schema.Point{{Val: 2, Ts: 10}, {Val: 4, Ts: 20}, {Val: 6, Ts: 30}}},
+		{true, []schema.Point{{Val: 2, Ts: 10}, {Val: 3, Ts: 20}, {Val: 4.5, Ts: 30}}},
+	}
+	for _, c := range cases {
+		f := NewPercentileOfSeries().(*FuncPercentileOfSeries)
+		f.in = NewMock(percentileInput())
+		f.n = 50
+		f.interpolate = c.interpolate
+
+		out := []models.Series{
+			{
+				Interval:   10,
+				QueryPatt:  "percentileOfSeries(foo.*,50)",
+				Target:     "percentileOfSeries(foo.*,50)",
+				Datapoints: c.exp,
+			},
+		}
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err := equalOutput(out, got, nil, err); err != nil {
+			t.Fatalf("interpolate %t: %s", c.interpolate, err)
+		}
+	}
+}
+
+func TestPercentileOfSeriesNoInput(t *testing.T) {
+	f := NewPercentileOfSeries().(*FuncPercentileOfSeries)
+	f.in = NewMock(nil)
+	f.n = 50
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput([]models.Series{}, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+}
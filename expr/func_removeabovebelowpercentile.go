@@ -8,6 +8,7 @@ import (
 	"github.com/raintank/schema"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/batch"
 )
 
 type FuncRemoveAboveBelowPercentile struct {
@@ -88,7 +89,7 @@ func (s *FuncRemoveAboveBelowPercentile) Exec(cache map[Req][]models.Series) ([]
 }
 
 // sortedDatapointVals is an empty slice to be used for sorting datapoints.
-// n must be > 0. if n > 100, the largest value is returned.
+// if n > 100, the largest value is returned. if there are no values, NaN is returned.
 func getPercentileValue(datapoints []schema.Point, n float64, sortedDatapointVals []float64) float64 {
 	sortedDatapointVals = sortedDatapointVals[:0]
 	for _, p := range datapoints {
@@ -99,7 +100,5 @@ func getPercentileValue(datapoints []schema.Point, n float64, sortedDatapointVal
 
 	sort.Float64s(sortedDatapointVals)
 
-	index := math.Min(math.Ceil(n/100.0*float64(len(sortedDatapointVals)+1)), float64(len(sortedDatapointVals))) - 1
-
-	return sortedDatapointVals[int(index)]
+	return batch.PercentileOfSorted(sortedDatapointVals, n, false)
 }
@@ -0,0 +1,69 @@
+package expr
+
+import (
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+type FuncRemoveBetweenPercentile struct {
+	in GraphiteFunc
+	n  float64
+}
+
+func NewRemoveBetweenPercentile() GraphiteFunc {
+	return &FuncRemoveBetweenPercentile{}
+}
+
+func (s *FuncRemoveBetweenPercentile) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "n", val: &s.n, validator: []Validator{NonNegativePercent}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncRemoveBetweenPercentile) Context(context Context) Context {
+	return context
+}
+
+// Exec removes the series that have all their values between the (100-n)-th and n-th percentile of all series, at every timestamp.
+// in other words: it keeps the series that have at least one value that is not strictly between those percentiles.
+// n values below 50 are treated as 100-n.
+func (s *FuncRemoveBetweenPercentile) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(series) == 0 {
+		return series, nil
+	}
+
+	n := s.n
+	if n < 50 {
+		n = 100 - n
+	}
+
+	lows := pointSlicePool.Get().([]schema.Point)
+	highs := pointSlicePool.Get().([]schema.Point)
+	crossSeriesPercentile(100-n, false)(series, &lows)
+	crossSeriesPercentile(n, false)(series, &highs)
+
+	var outputs []models.Series
+	for _, serie := range series {
+		for i, p := range serie.Datapoints {
+			if math.IsNaN(p.Val) {
+				continue
+			}
+			if !(lows[i].Val < p.Val && p.Val < highs[i].Val) {
+				outputs = append(outputs, serie)
+				break
+			}
+		}
+	}
+	pointSlicePool.Put(lows[:0])
+	pointSlicePool.Put(highs[:0])
+
+	return outputs, nil
+}
@@ -0,0 +1,41 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+func TestRemoveBetweenPercentile(t *testing.T) {
+	serie := func(name string, v1, v2 float64) models.Series {
+		return models.Series{
+			Interval:   10,
+			QueryPatt:  name,
+			Target:     name,
+			Datapoints: []schema.Point{{Val: v1, Ts: 10}, {Val: v2, Ts: 20}},
+		}
+	}
+	input := []models.Series{
+		serie("a", 1, 1),
+		serie("b", 2, 2),
+		serie("c", 3, 3),
+		serie("d", 4, 10),
+	}
+	// the 30th percentiles are 2 and 2, the 70th percentiles are 4 and 10.
+	// only c lies strictly between them at all times.
+	out := []models.Series{
+		serie("a", 1, 1),
+		serie("b", 2, 2),
+		serie("d", 4, 10),
+	}
+	for _, n := range []float64{30, 70} {
+		f := NewRemoveBetweenPercentile().(*FuncRemoveBetweenPercentile)
+		f.in = NewMock(input)
+		f.n = n
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err := equalOutput(out, got, nil, err); err != nil {
+			t.Fatalf("n %f: %s", n, err)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package expr
 
-import "github.com/grafana/metrictank/api/models"
+import (
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+)
 
 type FuncSmartSummarize struct {
 	in          GraphiteFunc
@@ -24,7 +27,8 @@ func (s *FuncSmartSummarize) Signature() ([]Arg, []Arg) {
 
 func (s *FuncSmartSummarize) Context(context Context) Context {
 	context.consol = 0
-	return context
+	interval, _ := dur.ParseDuration(s.interval)
+	return context.lookbackToFrom(interval)
 }
 
 func (s *FuncSmartSummarize) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
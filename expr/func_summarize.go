@@ -33,7 +33,8 @@ func (s *FuncSummarize) Signature() ([]Arg, []Arg) {
 
 func (s *FuncSummarize) Context(context Context) Context {
 	context.consol = 0
-	return context
+	interval, _ := dur.ParseDuration(s.intervalString)
+	return context.lookbackToFrom(interval)
 }
 
 func (s *FuncSummarize) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
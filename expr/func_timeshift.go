@@ -0,0 +1,191 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"github.com/raintank/schema"
+)
+
+// normalizeTimeShift returns the shift with an explicit sign.
+// like graphite, shifts without a sign are shifts into the past.
+func normalizeTimeShift(shift string) string {
+	if shift != "" && shift[0] >= '0' && shift[0] <= '9' {
+		return "-" + shift
+	}
+	return shift
+}
+
+// parseTimeShift returns the number of seconds the given shift (e.g. "1d", "-1d", "+1h") moves a timeframe by
+func parseTimeShift(shift string) (int64, error) {
+	shift = normalizeTimeShift(shift)
+	sign := int64(1)
+	if strings.HasPrefix(shift, "-") {
+		sign = -1
+	}
+	seconds, err := dur.ParseDuration(strings.TrimLeft(shift, "+-"))
+	return sign * int64(seconds), err
+}
+
+// shiftContext returns the context moved by delta seconds
+func shiftContext(context Context, delta int64) Context {
+	shift := func(ts uint32) uint32 {
+		shifted := int64(ts) + delta
+		if shifted < 0 {
+			return 0
+		}
+		return uint32(shifted)
+	}
+	context.from = shift(context.from)
+	context.to = shift(context.to)
+	return context
+}
+
+// shiftPoints returns the points of a series that was fetched for a context moved by delta,
+// moved back into the original timeframe. points at or after to are dropped, unless to is 0
+func shiftPoints(in []schema.Point, delta int64, to uint32) []schema.Point {
+	out := pointSlicePool.Get().([]schema.Point)
+	for _, p := range in {
+		ts := int64(p.Ts) - delta
+		if to != 0 && ts >= int64(to) {
+			break
+		}
+		out = append(out, schema.Point{Val: p.Val, Ts: uint32(ts)})
+	}
+	return out
+}
+
+// newTaggedSeries returns a series derived from serie with the given name and points, and its tags plus the given extra tags
+func newTaggedSeries(serie models.Series, name string, points []schema.Point, tags map[string]string) models.Series {
+	out := models.Series{
+		Target:       name,
+		QueryPatt:    name,
+		Tags:         make(map[string]string, len(serie.Tags)+len(tags)),
+		Datapoints:   points,
+		Interval:     serie.Interval,
+		Consolidator: serie.Consolidator,
+		QueryCons:    serie.QueryCons,
+	}
+	for k, v := range serie.Tags {
+		out.Tags[k] = v
+	}
+	for k, v := range tags {
+		out.Tags[k] = v
+	}
+	return out
+}
+
+type FuncTimeShift struct {
+	in        GraphiteFunc
+	timeShift string
+	resetEnd  bool
+
+	delta int64
+	to    uint32 // the to of the original request, before shifting it
+}
+
+func NewTimeShift() GraphiteFunc {
+	return &FuncTimeShift{resetEnd: true}
+}
+
+func (s *FuncTimeShift) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "timeShift", val: &s.timeShift, validator: []Validator{IsSignedIntervalString}},
+		ArgBool{key: "resetEnd", opt: true, val: &s.resetEnd},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncTimeShift) Context(context Context) Context {
+	s.delta, _ = parseTimeShift(s.timeShift)
+	s.to = context.to
+	return shiftContext(context, s.delta)
+}
+
+func (s *FuncTimeShift) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var to uint32
+	if s.resetEnd {
+		to = s.to
+	}
+	shift := normalizeTimeShift(s.timeShift)
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		name := fmt.Sprintf("timeShift(%s, \"%s\")", serie.Target, shift)
+		output := newTaggedSeries(serie, name, shiftPoints(serie.Datapoints, s.delta, to), map[string]string{"timeShift": shift})
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+type FuncTimeStack struct {
+	in             GraphiteFunc
+	timeShiftUnit  string
+	timeShiftStart int64
+	timeShiftEnd   int64
+
+	ins []GraphiteFunc // one input per shift, from timeShiftStart up to but not including timeShiftEnd
+}
+
+func NewTimeStack() GraphiteFunc {
+	return &FuncTimeStack{timeShiftUnit: "1d", timeShiftEnd: 7}
+}
+
+func (s *FuncTimeStack) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "timeShiftUnit", opt: true, val: &s.timeShiftUnit, validator: []Validator{IsSignedIntervalString}},
+		ArgInt{key: "timeShiftStart", opt: true, val: &s.timeShiftStart},
+		ArgInt{key: "timeShiftEnd", opt: true, val: &s.timeShiftEnd},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncTimeStack) Context(context Context) Context {
+	return context
+}
+
+// Contexts returns a context for every shift of the stack
+func (s *FuncTimeStack) Contexts(context Context) []Context {
+	unit, _ := parseTimeShift(s.timeShiftUnit)
+	var contexts []Context
+	for shift := s.timeShiftStart; shift < s.timeShiftEnd; shift++ {
+		contexts = append(contexts, shiftContext(context, shift*unit))
+	}
+	return contexts
+}
+
+func (s *FuncTimeStack) collectInputs() {
+	s.ins = append(s.ins, s.in)
+}
+
+func (s *FuncTimeStack) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	unit, _ := parseTimeShift(s.timeShiftUnit)
+
+	var outputs []models.Series
+	for i, in := range s.ins {
+		series, err := in.Exec(cache)
+		if err != nil {
+			return nil, err
+		}
+		shift := s.timeShiftStart + int64(i)
+		for _, serie := range series {
+			// like graphite, the unit is shown as specified
+			name := fmt.Sprintf("timeShift(%s, %s, %d)", serie.Target, s.timeShiftUnit, shift)
+			tags := map[string]string{
+				"timeShiftUnit": s.timeShiftUnit,
+				"timeShift":     fmt.Sprint(shift),
+			}
+			output := newTaggedSeries(serie, name, shiftPoints(serie.Datapoints, shift*unit, 0), tags)
+			outputs = append(outputs, output)
+			cache[Req{}] = append(cache[Req{}], output)
+		}
+	}
+	return outputs, nil
+}
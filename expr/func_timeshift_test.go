@@ -0,0 +1,155 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+func TestParseTimeShift(t *testing.T) {
+	cases := []struct {
+		in    string
+		exp   int64
+		expOk bool
+	}{
+		{"1d", -86400, true},
+		{"-1h", -3600, true},
+		{"+2min", 120, true},
+		{"foo", 0, false},
+	}
+	for _, c := range cases {
+		got, err := parseTimeShift(c.in)
+		if (err == nil) != c.expOk || (c.expOk && got != c.exp) {
+			t.Errorf("%q: expected %d (ok %t), got %d (err %v)", c.in, c.exp, c.expOk, got, err)
+		}
+	}
+}
+
+func TestTimeShift(t *testing.T) {
+	f := NewTimeShift().(*FuncTimeShift)
+	f.timeShift = "100s"
+	ctx := f.Context(Context{from: 1000, to: 1030})
+	if ctx.from != 900 || ctx.to != 930 {
+		t.Fatalf("expected context to be shifted to 900-930, got %d-%d", ctx.from, ctx.to)
+	}
+	f.in = NewMock([]models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "a",
+			Target:     "a",
+			Datapoints: []schema.Point{{Val: 1, Ts: 900}, {Val: 2, Ts: 910}, {Val: 3, Ts: 920}},
+		},
+	})
+
+	out := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  `timeShift(a, "-100s")`,
+			Target:     `timeShift(a, "-100s")`,
+			Datapoints: []schema.Point{{Val: 1, Ts: 1000}, {Val: 2, Ts: 1010}, {Val: 3, Ts: 1020}},
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Tags["timeShift"] != "-100s" {
+		t.Fatalf("expected timeShift tag to be set. got tags %v", got[0].Tags)
+	}
+}
+
+func TestTimeShiftResetEnd(t *testing.T) {
+	f := NewTimeShift().(*FuncTimeShift)
+	f.timeShift = "+10s"
+	f.Context(Context{from: 1000, to: 1020})
+	input := []models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "a",
+			Target:     "a",
+			Datapoints: []schema.Point{{Val: 1, Ts: 1010}, {Val: 2, Ts: 1020}, {Val: 3, Ts: 1030}},
+		},
+	}
+	f.in = NewMock(input)
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []schema.Point{{Val: 1, Ts: 1000}, {Val: 2, Ts: 1010}}
+	if !reflect.DeepEqual(got[0].Datapoints, exp) {
+		t.Fatalf("resetEnd: expected points %v, got %v", exp, got[0].Datapoints)
+	}
+
+	f.resetEnd = false
+	got, err = f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got[0].Datapoints) != 3 {
+		t.Fatalf("no resetEnd: expected 3 points, got %v", got[0].Datapoints)
+	}
+}
+
+// TestTimeStackPlan tests that timeStack requests its input for every shift,
+// that identical requests are only made once, and that every shifted input ends up in the requested timeframe
+func TestTimeStackPlan(t *testing.T) {
+	from := uint32(1000)
+	to := uint32(1020)
+	exprs, err := ParseMany([]string{`timeStack(a, "100s", 0, 3)`, `timeShift(a, "100s")`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := NewPlan(exprs, from, to, 0, true, time.UTC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expReqs := []Req{
+		NewReq("a", 1000, 1020, 0),
+		NewReq("a", 900, 920, 0),
+		NewReq("a", 800, 820, 0),
+	}
+	if !reflect.DeepEqual(plan.Reqs, expReqs) {
+		t.Fatalf("expected reqs %v, got %v", expReqs, plan.Reqs)
+	}
+
+	input := make(map[Req][]models.Series)
+	for i, req := range plan.Reqs {
+		input[req] = []models.Series{
+			{
+				Interval:   10,
+				QueryPatt:  "a",
+				Target:     "a",
+				Datapoints: []schema.Point{{Val: float64(i), Ts: req.From}, {Val: float64(i), Ts: req.From + 10}},
+			},
+		}
+	}
+	out, err := plan.Run(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expTargets := []string{
+		"timeShift(a, 100s, 0)",
+		"timeShift(a, 100s, 1)",
+		"timeShift(a, 100s, 2)",
+		`timeShift(a, "-100s")`,
+	}
+	expVals := []float64{0, 1, 2, 1}
+	if len(out) != len(expTargets) {
+		t.Fatalf("expected %d series, got %d", len(expTargets), len(out))
+	}
+	for i, serie := range out {
+		if serie.Target != expTargets[i] {
+			t.Errorf("series %d: expected target %q, got %q", i, expTargets[i], serie.Target)
+		}
+		if i < 3 && serie.Tags["timeShiftUnit"] != "100s" {
+			t.Errorf("series %d: expected tag timeShiftUnit=100s, got %q", i, serie.Tags["timeShiftUnit"])
+		}
+		exp := []schema.Point{{Val: expVals[i], Ts: 1000}, {Val: expVals[i], Ts: 1010}}
+		if !reflect.DeepEqual(serie.Datapoints, exp) {
+			t.Errorf("series %d: expected points %v, got %v", i, exp, serie.Datapoints)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"github.com/raintank/schema"
+)
+
+type FuncTimeSlice struct {
+	in           GraphiteFunc
+	startSliceAt string
+	endSliceAt   string
+
+	start uint32
+	end   uint32
+}
+
+func NewTimeSlice() GraphiteFunc {
+	return &FuncTimeSlice{endSliceAt: "now"}
+}
+
+func (s *FuncTimeSlice) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "startSliceAt", val: &s.startSliceAt, validator: []Validator{IsDateTimeString}},
+		ArgString{key: "endSliceAt", opt: true, val: &s.endSliceAt, validator: []Validator{IsDateTimeString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+// Context resolves the slice boundaries. absolute times are interpreted in the timezone of the request.
+func (s *FuncTimeSlice) Context(context Context) Context {
+	loc := context.loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	now := time.Now()
+	s.start, _ = dur.ParseDateTime(s.startSliceAt, loc, now, 0)
+	s.end, _ = dur.ParseDateTime(s.endSliceAt, loc, now, 0)
+	return context
+}
+
+func (s *FuncTimeSlice) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		for _, p := range serie.Datapoints {
+			if p.Ts < s.start || p.Ts > s.end {
+				p.Val = math.NaN()
+			}
+			out = append(out, p)
+		}
+		name := fmt.Sprintf("timeSlice(%s, %d, %d)", serie.Target, s.start, s.end)
+		tags := map[string]string{
+			"timeSliceStart": strconv.FormatUint(uint64(s.start), 10),
+			"timeSliceEnd":   strconv.FormatUint(uint64(s.end), 10),
+		}
+		output := newTaggedSeries(serie, name, out, tags)
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,62 @@
+package expr
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/schema"
+)
+
+func TestTimeSlice(t *testing.T) {
+	f := NewTimeSlice().(*FuncTimeSlice)
+	f.startSliceAt = "-1h"
+	f.in = NewMock([]models.Series{
+		{
+			Interval:   10,
+			QueryPatt:  "a",
+			Target:     "a",
+			Datapoints: getCopy(a),
+		},
+	})
+	f.Context(Context{from: 10, to: 70})
+	// set the boundaries directly, graphite time specifications are relative to now or absolute dates
+	f.start, f.end = 20, 40
+
+	out := []models.Series{
+		{
+			Interval:  10,
+			QueryPatt: "timeSlice(a, 20, 40)",
+			Target:    "timeSlice(a, 20, 40)",
+			Datapoints: []schema.Point{
+				{Val: math.NaN(), Ts: 10},
+				{Val: a[1].Val, Ts: 20},
+				{Val: a[2].Val, Ts: 30},
+				{Val: a[3].Val, Ts: 40},
+				{Val: math.NaN(), Ts: 50},
+				{Val: math.NaN(), Ts: 60},
+			},
+		},
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err := equalOutput(out, got, nil, err); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Tags["timeSliceStart"] != "20" || got[0].Tags["timeSliceEnd"] != "40" {
+		t.Fatalf("expected timeSlice tags to be set. got tags %v", got[0].Tags)
+	}
+}
+
+func TestTimeSliceTimezone(t *testing.T) {
+	f := NewTimeSlice().(*FuncTimeSlice)
+	f.startSliceAt = "00:00 20190101"
+	f.endSliceAt = "now"
+
+	f.Context(Context{loc: time.UTC})
+	utc := f.start
+	f.Context(Context{loc: time.FixedZone("UTC+2", 2*3600)})
+	if exp := utc - 2*3600; f.start != exp {
+		t.Fatalf("expected absolute time to be interpreted in the request timezone: expected start %d, got %d", exp, f.start)
+	}
+}
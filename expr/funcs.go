@@ -1,6 +1,8 @@
 package expr
 
 import (
+	"time"
+
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/consolidation"
 )
@@ -10,6 +12,26 @@ type Context struct {
 	from   uint32
 	to     uint32
 	consol consolidation.Consolidator // can be 0 to mean undefined
+
+	// number of points from before from that are needed as well, e.g. for movingAverage(foo, 10).
+	// unlike from, this can only be translated into a time range once we know the interval of the data we'll fetch.
+	lookback uint32
+
+	loc *time.Location // the timezone of the request, to interpret absolute times in function arguments
+}
+
+// lookbackToFrom translates the lookback into a time range for data of the given interval, and moves from back by it.
+// functions that change the interval, such as summarize, call this because the lookback is expressed in points
+// of their output, which cover a different time range than the points of the data they request.
+func (c Context) lookbackToFrom(interval uint32) Context {
+	shift := c.lookback * interval
+	if c.from > shift {
+		c.from -= shift
+	} else {
+		c.from = 0
+	}
+	c.lookback = 0
+	return c
 }
 
 // GraphiteFunc defines a graphite processing function
@@ -36,6 +58,22 @@ type GraphiteFunc interface {
 	Exec(map[Req][]models.Series) ([]models.Series, error)
 }
 
+// multiContextFunc is implemented by functions that need the same input for several different contexts.
+// e.g. timeStack(foo, "1d", 0, 7) needs foo for the requested timeframe, as well as for the 6 days before it.
+// instead of calling Context, the planner sets up the series inputs once for each context returned by Contexts,
+// and calls collectInputs after each round, so the function can save the inputs that were set up for that context.
+type multiContextFunc interface {
+	Contexts(c Context) []Context
+	collectInputs()
+}
+
+// subPlanner is implemented by functions that evaluate additional expressions derived from their input, such as applyByNode.
+// the planner calls planSub once the series inputs of the function are set up, so that it can add the requests
+// for the data it will need.
+type subPlanner interface {
+	planSub(c Context, stable bool, reqs []Req) ([]Req, error)
+}
+
 type funcConstructor func() GraphiteFunc
 
 type funcDef struct {
@@ -48,70 +86,89 @@ var funcs map[string]funcDef
 func init() {
 	// keys must be sorted alphabetically. but functions with aliases can go together, in which case they are sorted by the first of their aliases
 	funcs = map[string]funcDef{
-		"absolute":              {NewAbsolute, true},
-		"alias":                 {NewAlias, true},
-		"aliasByTags":           {NewAliasByNode, true},
-		"aliasByNode":           {NewAliasByNode, true},
-		"aliasSub":              {NewAliasSub, true},
-		"asPercent":             {NewAsPercent, true},
-		"avg":                   {NewAggregateConstructor("average", crossSeriesAvg), true},
-		"averageAbove":          {NewFilterSeriesConstructor("average", ">"), true},
-		"averageBelow":          {NewFilterSeriesConstructor("average", "<="), true},
-		"averageSeries":         {NewAggregateConstructor("average", crossSeriesAvg), true},
-		"consolidateBy":         {NewConsolidateBy, true},
-		"countSeries":           {NewCountSeries, true},
-		"cumulative":            {NewConsolidateByConstructor("sum"), true},
-		"currentAbove":          {NewFilterSeriesConstructor("last", ">"), true},
-		"currentBelow":          {NewFilterSeriesConstructor("last", "<="), true},
-		"derivative":            {NewDerivative, true},
-		"diffSeries":            {NewAggregateConstructor("diff", crossSeriesDiff), true},
-		"divideSeries":          {NewDivideSeries, true},
-		"divideSeriesLists":     {NewDivideSeriesLists, true},
-		"exclude":               {NewExclude, true},
-		"fallbackSeries":        {NewFallbackSeries, true},
-		"filterSeries":          {NewFilterSeries, true},
-		"grep":                  {NewGrep, true},
-		"group":                 {NewGroup, true},
-		"groupByTags":           {NewGroupByTags, true},
-		"highest":               {NewHighestLowestConstructor("", true), true},
-		"highestAverage":        {NewHighestLowestConstructor("average", true), true},
-		"highestCurrent":        {NewHighestLowestConstructor("current", true), true},
-		"highestMax":            {NewHighestLowestConstructor("max", true), true},
-		"integral":              {NewIntegral, true},
-		"isNonNull":             {NewIsNonNull, true},
-		"keepLastValue":         {NewKeepLastValue, true},
-		"lowest":                {NewHighestLowestConstructor("", false), true},
-		"lowestAverage":         {NewHighestLowestConstructor("average", false), true},
-		"lowestCurrent":         {NewHighestLowestConstructor("current", false), true},
-		"max":                   {NewAggregateConstructor("max", crossSeriesMax), true},
-		"maximumAbove":          {NewFilterSeriesConstructor("max", ">"), true},
-		"maximumBelow":          {NewFilterSeriesConstructor("max", "<="), true},
-		"maxSeries":             {NewAggregateConstructor("max", crossSeriesMax), true},
-		"min":                   {NewAggregateConstructor("min", crossSeriesMin), true},
-		"minimumAbove":          {NewFilterSeriesConstructor("min", ">"), true},
-		"minimumBelow":          {NewFilterSeriesConstructor("min", "<="), true},
-		"minSeries":             {NewAggregateConstructor("min", crossSeriesMin), true},
-		"multiplySeries":        {NewAggregateConstructor("multiply", crossSeriesMultiply), true},
-		"movingAverage":         {NewMovingAverage, false},
-		"nonNegativeDerivative": {NewNonNegativeDerivative, true},
-		"perSecond":             {NewPerSecond, true},
-		"rangeOfSeries":         {NewAggregateConstructor("rangeOf", crossSeriesRange), true},
-		"removeAbovePercentile": {NewRemoveAboveBelowPercentileConstructor(true), true},
-		"removeAboveValue":      {NewRemoveAboveBelowValueConstructor(true), true},
-		"removeBelowPercentile": {NewRemoveAboveBelowPercentileConstructor(false), true},
-		"removeBelowValue":      {NewRemoveAboveBelowValueConstructor(false), true},
-		"scale":                 {NewScale, true},
-		"scaleToSeconds":        {NewScaleToSeconds, true},
-		"smartSummarize":        {NewSmartSummarize, false},
-		"sortBy":                {NewSortByConstructor("", false), true},
-		"sortByMaxima":          {NewSortByConstructor("max", true), true},
-		"sortByName":            {NewSortByName, true},
-		"sortByTotal":           {NewSortByConstructor("sum", true), true},
-		"stddevSeries":          {NewAggregateConstructor("stddev", crossSeriesStddev), true},
-		"sum":                   {NewAggregateConstructor("sum", crossSeriesSum), true},
-		"sumSeries":             {NewAggregateConstructor("sum", crossSeriesSum), true},
-		"summarize":             {NewSummarize, true},
-		"transformNull":         {NewTransformNull, true},
+		"absolute":                   {NewAbsolute, true},
+		"alias":                      {NewAlias, true},
+		"aliasByTags":                {NewAliasByNode, true},
+		"aliasByNode":                {NewAliasByNode, true},
+		"aliasQuery":                 {NewAliasQuery, true},
+		"aliasSub":                   {NewAliasSub, true},
+		"applyByNode":                {NewApplyByNode, true},
+		"asPercent":                  {NewAsPercent, true},
+		"avg":                        {NewAggregateConstructor("average", crossSeriesAvg), true},
+		"averageAbove":               {NewFilterSeriesConstructor("average", ">"), true},
+		"averageBelow":               {NewFilterSeriesConstructor("average", "<="), true},
+		"averageSeries":              {NewAggregateConstructor("average", crossSeriesAvg), true},
+		"consolidateBy":              {NewConsolidateBy, true},
+		"countSeries":                {NewCountSeries, true},
+		"cumulative":                 {NewConsolidateByConstructor("sum"), true},
+		"currentAbove":               {NewFilterSeriesConstructor("last", ">"), true},
+		"currentBelow":               {NewFilterSeriesConstructor("last", "<="), true},
+		"derivative":                 {NewDerivative, true},
+		"diffSeries":                 {NewAggregateConstructor("diff", crossSeriesDiff), true},
+		"divideSeries":               {NewDivideSeries, true},
+		"divideSeriesLists":          {NewDivideSeriesLists, true},
+		"exclude":                    {NewExclude, true},
+		"exponentialMovingAverage":   {NewExponentialMovingAverage, true},
+		"fallbackSeries":             {NewFallbackSeries, true},
+		"filterSeries":               {NewFilterSeries, true},
+		"grep":                       {NewGrep, true},
+		"group":                      {NewGroup, true},
+		"groupByNode":                {NewGroupByNodesConstructor(true), true},
+		"groupByNodes":               {NewGroupByNodesConstructor(false), true},
+		"groupByTags":                {NewGroupByTags, true},
+		"highest":                    {NewHighestLowestConstructor("", true), true},
+		"highestAverage":             {NewHighestLowestConstructor("average", true), true},
+		"highestCurrent":             {NewHighestLowestConstructor("current", true), true},
+		"highestMax":                 {NewHighestLowestConstructor("max", true), true},
+		"holtWintersAberration":      {NewHoltWintersAberration, true},
+		"holtWintersConfidenceBands": {NewHoltWintersConfidenceBands, true},
+		"holtWintersForecast":        {NewHoltWintersForecast, true},
+		"integral":                   {NewIntegral, true},
+		"isNonNull":                  {NewIsNonNull, true},
+		"keepLastValue":              {NewKeepLastValue, true},
+		"lowest":                     {NewHighestLowestConstructor("", false), true},
+		"lowestAverage":              {NewHighestLowestConstructor("average", false), true},
+		"lowestCurrent":              {NewHighestLowestConstructor("current", false), true},
+		"max":                        {NewAggregateConstructor("max", crossSeriesMax), true},
+		"maximumAbove":               {NewFilterSeriesConstructor("max", ">"), true},
+		"maximumBelow":               {NewFilterSeriesConstructor("max", "<="), true},
+		"maxSeries":                  {NewAggregateConstructor("max", crossSeriesMax), true},
+		"min":                        {NewAggregateConstructor("min", crossSeriesMin), true},
+		"minimumAbove":               {NewFilterSeriesConstructor("min", ">"), true},
+		"minimumBelow":               {NewFilterSeriesConstructor("min", "<="), true},
+		"minSeries":                  {NewAggregateConstructor("min", crossSeriesMin), true},
+		"multiplySeries":             {NewAggregateConstructor("multiply", crossSeriesMultiply), true},
+		"movingAverage":              {NewMovingWindowConstructor("average"), true},
+		"movingMax":                  {NewMovingWindowConstructor("max"), true},
+		"movingMedian":               {NewMovingWindowConstructor("median"), true},
+		"movingMin":                  {NewMovingWindowConstructor("min"), true},
+		"movingSum":                  {NewMovingWindowConstructor("sum"), true},
+		"movingWindow":               {NewMovingWindowConstructor(""), true},
+		"nonNegativeDerivative":      {NewNonNegativeDerivative, true},
+		"nPercentile":                {NewNPercentile, true},
+		"percentileOfSeries":         {NewPercentileOfSeries, true},
+		"perSecond":                  {NewPerSecond, true},
+		"rangeOfSeries":              {NewAggregateConstructor("rangeOf", crossSeriesRange), true},
+		"removeAbovePercentile":      {NewRemoveAboveBelowPercentileConstructor(true), true},
+		"removeAboveValue":           {NewRemoveAboveBelowValueConstructor(true), true},
+		"removeBelowPercentile":      {NewRemoveAboveBelowPercentileConstructor(false), true},
+		"removeBelowValue":           {NewRemoveAboveBelowValueConstructor(false), true},
+		"removeBetweenPercentile":    {NewRemoveBetweenPercentile, true},
+		"scale":                      {NewScale, true},
+		"scaleToSeconds":             {NewScaleToSeconds, true},
+		"smartSummarize":             {NewSmartSummarize, false},
+		"sortBy":                     {NewSortByConstructor("", false), true},
+		"sortByMaxima":               {NewSortByConstructor("max", true), true},
+		"sortByName":                 {NewSortByName, true},
+		"sortByTotal":                {NewSortByConstructor("sum", true), true},
+		"stddevSeries":               {NewAggregateConstructor("stddev", crossSeriesStddev), true},
+		"sum":                        {NewAggregateConstructor("sum", crossSeriesSum), true},
+		"sumSeries":                  {NewAggregateConstructor("sum", crossSeriesSum), true},
+		"summarize":                  {NewSummarize, true},
+		"timeShift":                  {NewTimeShift, true},
+		"timeSlice":                  {NewTimeSlice, true},
+		"timeStack":                  {NewTimeStack, true},
+		"transformNull":              {NewTransformNull, true},
 	}
 }
 
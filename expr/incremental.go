@@ -0,0 +1,81 @@
+package expr
+
+// incrementalFuncs are the functions whose output points only depend on input points at (or within a bounded window before)
+// the same timestamp, such that evaluating them over a later time range yields the same points for the overlapping range.
+// functions that consider the whole time range, e.g. to select, sort or filter series, or to bootstrap a calculation, are not included.
+var incrementalFuncs = map[string]struct{}{
+	"absolute":              {},
+	"alias":                 {},
+	"aliasByNode":           {},
+	"aliasByTags":           {},
+	"aliasSub":              {},
+	"asPercent":             {},
+	"avg":                   {},
+	"averageSeries":         {},
+	"consolidateBy":         {},
+	"countSeries":           {},
+	"cumulative":            {},
+	"derivative":            {},
+	"diffSeries":            {},
+	"divideSeries":          {},
+	"divideSeriesLists":     {},
+	"exclude":               {},
+	"grep":                  {},
+	"group":                 {},
+	"groupByNode":           {},
+	"groupByNodes":          {},
+	"groupByTags":           {},
+	"isNonNull":             {},
+	"max":                   {},
+	"maxSeries":             {},
+	"min":                   {},
+	"minSeries":             {},
+	"movingAverage":         {},
+	"movingMax":             {},
+	"movingMedian":          {},
+	"movingMin":             {},
+	"movingSum":             {},
+	"movingWindow":          {},
+	"multiplySeries":        {},
+	"nonNegativeDerivative": {},
+	"percentileOfSeries":    {},
+	"perSecond":             {},
+	"rangeOfSeries":         {},
+	"removeAboveValue":      {},
+	"removeBelowValue":      {},
+	"scale":                 {},
+	"scaleToSeconds":        {},
+	"seriesByTag":           {},
+	"sortByName":            {},
+	"stddevSeries":          {},
+	"sum":                   {},
+	"sumSeries":             {},
+	"timeShift":             {},
+	"timeStack":             {},
+	"transformNull":         {},
+}
+
+// Incremental returns whether the expression can be evaluated incrementally:
+// the output for a time range can be extended with the output for a later, overlapping time range.
+// (provided that the later evaluation starts a few points before the overlap, for functions such as derivative)
+func (e expr) Incremental() bool {
+	switch e.etype {
+	case etName:
+		return true
+	case etFunc:
+		if _, ok := incrementalFuncs[e.str]; !ok {
+			return false
+		}
+		for _, a := range e.args {
+			if !a.Incremental() {
+				return false
+			}
+		}
+		for _, a := range e.namedArgs {
+			if !a.Incremental() {
+				return false
+			}
+		}
+	}
+	return true
+}
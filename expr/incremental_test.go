@@ -0,0 +1,59 @@
+package expr
+
+import "testing"
+
+func TestIncremental(t *testing.T) {
+	cases := []struct {
+		target string
+		exp    bool
+	}{
+		{"foo.*", true},
+		{"seriesByTag('name=foo')", true},
+		{"sumSeries(perSecond(foo.*))", true},
+		{"movingAverage(foo, '5min')", true},
+		{"alias(sumSeries(foo.*), 'total')", true},
+		{"highestMax(foo.*, 5)", false},
+		{"sumSeries(foo.*, sortByMaxima(bar.*))", false},
+		{"divideSeries(foo, integral(bar))", false},
+		{"timeSlice(foo, '-10min', '-5min')", false},
+	}
+	for _, c := range cases {
+		e, _, err := Parse(c.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.Incremental(); got != c.exp {
+			t.Fatalf("case %q: expected %t, got %t", c.target, c.exp, got)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	cases := []struct {
+		targets []string
+		exp     string
+	}{
+		{[]string{"foo.bar"}, "foo.bar"},
+		{
+			[]string{
+				"movingWindow(foo.*, '5min', xFilesFactor=0.5, func='sum')",
+				`movingWindow( foo.*,"5min",func="sum",xFilesFactor=0.50)`,
+			},
+			`movingWindow(foo.*,"5min",func="sum",xFilesFactor=0.5)`,
+		},
+		{[]string{"groupByNode(foo.*, 2, 'sum')", "groupByNode(foo.*,2,'sum')"}, `groupByNode(foo.*,2,"sum")`},
+		{[]string{"transformNull(foo, default=0)", "transformNull(foo,default=0)"}, "transformNull(foo,default=0)"},
+		{[]string{"timeShift(foo, '1d', resetEnd=False)"}, `timeShift(foo,"1d",resetEnd=false)`},
+	}
+	for _, c := range cases {
+		for _, target := range c.targets {
+			e, _, err := Parse(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.Canonical(); got != c.exp {
+				t.Fatalf("case %q: expected %q, got %q", target, c.exp, got)
+			}
+		}
+	}
+}
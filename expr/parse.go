@@ -410,21 +410,33 @@ func aggKey(serie models.Series, nodes []expr) string {
 	}
 	// Trim off tags (if they are there) and split on '.'
 	parts := strings.Split(strings.SplitN(metric, ";", 2)[0], ".")
-	var name []string
+	name := make([]string, 0, len(nodes))
 	for _, n := range nodes {
-		if n.etype == etInt {
-			idx := int(n.int)
-			if idx < 0 {
-				idx += len(parts)
-			}
-			if idx >= len(parts) || idx < 0 {
-				continue
-			}
-			name = append(name, parts[idx])
-		} else if n.etype == etString {
-			s := n.str
-			name = append(name, serie.Tags[s])
+		if val, ok := nodeOrTag(serie, parts, n); ok {
+			name = append(name, val)
 		}
 	}
 	return strings.Join(name, ".")
 }
+
+// nodeOrTag returns the node of the metric name (split up in parts) specified by n,
+// if n is an int or a string holding an int. negative nodes count from the end.
+// nodes that don't exist are skipped, in which case ok is false.
+// otherwise, like graphite, it returns the value of the tag named n, or an empty string if there is no such tag.
+func nodeOrTag(serie models.Series, parts []string, n expr) (string, bool) {
+	idx := int(n.int)
+	if n.etype != etInt {
+		i, err := strconv.Atoi(n.str)
+		if err != nil {
+			return serie.Tags[n.str], true
+		}
+		idx = i
+	}
+	if idx < 0 {
+		idx += len(parts)
+	}
+	if idx < 0 || idx >= len(parts) {
+		return "", false
+	}
+	return parts[idx], true
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/grafana/metrictank/api/models"
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
@@ -476,3 +477,29 @@ func TestExtractMetric(t *testing.T) {
 		}
 	}
 }
+
+func TestAggKey(t *testing.T) {
+	serie := models.Series{
+		Target: "foo.bar.baz;dc=us;3=three",
+		Tags:   map[string]string{"name": "foo.bar.baz", "dc": "us", "3": "three"},
+	}
+	var tests = []struct {
+		nodes []expr
+		out   string
+	}{
+		{[]expr{{etype: etInt, int: 0}, {etype: etInt, int: 2}}, "foo.baz"},
+		{[]expr{{etype: etInt, int: -1}}, "baz"},
+		{[]expr{{etype: etString, str: "1"}}, "bar"},
+		{[]expr{{etype: etString, str: "dc"}, {etype: etString, str: "name"}}, "us.foo.bar.baz"},
+		// nodes that don't exist are skipped, rather than looked up as tags
+		{[]expr{{etype: etInt, int: 3}, {etype: etString, str: "dc"}}, "us"},
+		{[]expr{{etype: etInt, int: 0}, {etype: etInt, int: 5}, {etype: etInt, int: -4}, {etype: etInt, int: 1}}, "foo.bar"},
+		{[]expr{{etype: etString, str: "5"}, {etype: etString, str: "dc"}}, "us"},
+		{[]expr{{etype: etInt, int: 0}, {etype: etString, str: "missing"}}, "foo."},
+	}
+	for i, tt := range tests {
+		if out := aggKey(serie, tt.nodes); out != tt.out {
+			t.Errorf("case %d: expected %q, got %q", i, tt.out, out)
+		}
+	}
+}
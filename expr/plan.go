@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/consolidation"
@@ -15,6 +16,9 @@ type Req struct {
 	From  uint32
 	To    uint32
 	Cons  consolidation.Consolidator // can be 0 to mean undefined
+
+	// number of points before From that need to be fetched as well. see Context
+	Lookback uint32
 }
 
 // NewReq creates a new Req. pass cons=0 to leave consolidator undefined,
@@ -33,8 +37,10 @@ type Plan struct {
 	funcs         []GraphiteFunc // top-level funcs to execute, the head of each tree for each target
 	exprs         []*expr
 	MaxDataPoints uint32
+	stable        bool
 	From          uint32                  // global request scoped from
 	To            uint32                  // global request scoped to
+	loc           *time.Location          // timezone of the request
 	data          map[Req][]models.Series // input data to work with. set via Run(), as well as
 	// new data generated by processing funcs. useful for two reasons:
 	// 1) reuse partial calculations e.g. queries like target=movingAvg(sum(foo), 10)&target=sum(foo) (TODO)
@@ -63,7 +69,8 @@ func (p Plan) Dump(w io.Writer) {
 // * validation of arguments
 // * allow functions to modify the Context (change data range or consolidation)
 // * future version: allow functions to mark safe to pre-aggregate using consolidateBy or not
-func NewPlan(exprs []*expr, from, to, mdp uint32, stable bool, reqs []Req) (Plan, error) {
+// loc is the timezone of the request, in which absolute times in function arguments are interpreted
+func NewPlan(exprs []*expr, from, to, mdp uint32, stable bool, loc *time.Location, reqs []Req) (Plan, error) {
 	var err error
 	var funcs []GraphiteFunc
 	for _, e := range exprs {
@@ -71,6 +78,7 @@ func NewPlan(exprs []*expr, from, to, mdp uint32, stable bool, reqs []Req) (Plan
 		context := Context{
 			from: from,
 			to:   to,
+			loc:  loc,
 		}
 		fn, reqs, err = newplan(e, context, stable, reqs)
 		if err != nil {
@@ -83,11 +91,40 @@ func NewPlan(exprs []*expr, from, to, mdp uint32, stable bool, reqs []Req) (Plan
 		exprs:         exprs,
 		funcs:         funcs,
 		MaxDataPoints: mdp,
+		stable:        stable,
 		From:          from,
 		To:            to,
+		loc:           loc,
 	}, nil
 }
 
+// Canonical returns the canonical form of the expression of each target. see expr.Canonical
+func (p Plan) Canonical() []string {
+	out := make([]string, len(p.exprs))
+	for i, e := range p.exprs {
+		out[i] = e.Canonical()
+	}
+	return out
+}
+
+// Incremental returns for each target whether it can be evaluated incrementally. see expr.Incremental
+func (p Plan) Incremental() []bool {
+	out := make([]bool, len(p.exprs))
+	for i, e := range p.exprs {
+		out[i] = e.Incremental()
+	}
+	return out
+}
+
+// Replan creates a new plan for the given targets (indices into the targets of p), with a different timeframe and maxDataPoints
+func (p Plan) Replan(targets []int, from, to, mdp uint32) (Plan, error) {
+	exprs := make([]*expr, 0, len(targets))
+	for _, i := range targets {
+		exprs = append(exprs, p.exprs[i])
+	}
+	return NewPlan(exprs, from, to, mdp, p.stable, p.loc, nil)
+}
+
 // newplan adds requests as needed for the given expr, resolving function calls as needed
 func newplan(e *expr, context Context, stable bool, reqs []Req) (GraphiteFunc, []Req, error) {
 	if e.etype != etFunc && e.etype != etName {
@@ -95,7 +132,8 @@ func newplan(e *expr, context Context, stable bool, reqs []Req) (GraphiteFunc, [
 	}
 	if e.etype == etName {
 		req := NewReq(e.str, context.from, context.to, context.consol)
-		reqs = append(reqs, req)
+		req.Lookback = context.lookback
+		reqs = addReq(reqs, req)
 		return NewGet(req), reqs, nil
 	} else if e.etype == etFunc && e.str == "seriesByTag" {
 		// `seriesByTag` function requires resolving expressions to series
@@ -105,7 +143,8 @@ func newplan(e *expr, context Context, stable bool, reqs []Req) (GraphiteFunc, [
 		// TODO - find a way to prevent this parse/encode/parse/encode loop
 		expressionStr := "seriesByTag(" + e.argsStr + ")"
 		req := NewReq(expressionStr, context.from, context.to, context.consol)
-		reqs = append(reqs, req)
+		req.Lookback = context.lookback
+		reqs = addReq(reqs, req)
 		return NewGet(req), reqs, nil
 	}
 	// here e.type is guaranteed to be etFunc
@@ -193,50 +232,79 @@ func newplanFunc(e *expr, fn GraphiteFunc, context Context, stable bool, reqs []
 
 	// functions now have their non-series input args set,
 	// so they should now be able to specify any context alterations
+	// functions that need their input for several contexts get their series inputs set up once per context
+	if mfn, ok := fn.(multiContextFunc); ok {
+		for _, c := range mfn.Contexts(context) {
+			reqs, err = e.consumeSeriesArgs(argsExp, c, stable, reqs)
+			if err != nil {
+				return nil, err
+			}
+			mfn.collectInputs()
+		}
+		return reqs, nil
+	}
 	context = fn.Context(context)
 	// now that we know the needed context for the data coming into
 	// this function, we can set up the input arguments for the function
 	// that are series
-	pos = 0
-	for _, argExp = range argsExp {
-		if pos >= len(e.args) {
-			break // no more args specified. we're done.
-		}
-		switch argExp.(type) {
-		case ArgSeries, ArgSeriesList, ArgSeriesLists, ArgIn:
-			pos, reqs, err = e.consumeSeriesArg(pos, argExp, context, stable, reqs)
-			if err != nil {
-				return nil, err
-			}
-		default:
-			pos++
+	reqs, err = e.consumeSeriesArgs(argsExp, context, stable, reqs)
+	if err != nil {
+		return nil, err
+	}
+	if sfn, ok := fn.(subPlanner); ok {
+		return sfn.planSub(context, stable, reqs)
+	}
+	return reqs, nil
+}
+
+// addReq adds req to reqs, unless an identical request is already present.
+// e.g. target=timeShift(foo, "1d")&target=timeStack(foo, "1d", 0, 2) only needs foo once for the current, and once for the shifted range.
+func addReq(reqs []Req, req Req) []Req {
+	for _, r := range reqs {
+		if r == req {
+			return reqs
 		}
 	}
-	return reqs, err
+	return append(reqs, req)
 }
 
 // Run invokes all processing as specified in the plan (expressions, from/to) with the input as input
 func (p Plan) Run(input map[Req][]models.Series) ([]models.Series, error) {
+	outs, err := p.RunTargets(input)
+	if err != nil {
+		return nil, err
+	}
 	var out []models.Series
+	for _, o := range outs {
+		out = append(out, o...)
+	}
+	return out, nil
+}
+
+// RunTargets is like Run, but returns the output of each expression separately
+func (p Plan) RunTargets(input map[Req][]models.Series) ([][]models.Series, error) {
+	outs := make([][]models.Series, 0, len(p.funcs))
 	p.data = input
 	for _, fn := range p.funcs {
-		series, err := fn.Exec(p.data)
+		out, err := fn.Exec(p.data)
 		if err != nil {
 			return nil, err
 		}
-		out = append(out, series...)
-	}
-	for i, o := range out {
-		if p.MaxDataPoints != 0 && len(o.Datapoints) > int(p.MaxDataPoints) {
-			// series may have been created by a function that didn't know which consolidation function to default to.
-			// in the future maybe we can do more clever things here. e.g. perSecond maybe consolidate by max.
-			if o.Consolidator == 0 {
-				o.Consolidator = consolidation.Avg
+		outs = append(outs, out)
+	}
+	for _, out := range outs {
+		for i, o := range out {
+			if p.MaxDataPoints != 0 && len(o.Datapoints) > int(p.MaxDataPoints) {
+				// series may have been created by a function that didn't know which consolidation function to default to.
+				// in the future maybe we can do more clever things here. e.g. perSecond maybe consolidate by max.
+				if o.Consolidator == 0 {
+					o.Consolidator = consolidation.Avg
+				}
+				out[i].Datapoints, out[i].Interval = consolidation.ConsolidateStable(o.Datapoints, o.Interval, p.MaxDataPoints, o.Consolidator)
 			}
-			out[i].Datapoints, out[i].Interval = consolidation.ConsolidateStable(o.Datapoints, o.Interval, p.MaxDataPoints, o.Consolidator)
 		}
 	}
-	return out, nil
+	return outs, nil
 }
 
 // Clean returns all buffers (all input data + generated series along the way)
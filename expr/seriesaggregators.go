@@ -4,8 +4,10 @@ package expr
 import (
 	"math"
 	"sort"
+	"strings"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/batch"
 	"github.com/raintank/schema"
 )
 
@@ -16,15 +18,17 @@ type seriesAggregator struct {
 
 type crossSeriesAggFunc func(in []models.Series, out *[]schema.Point)
 
+// getCrossSeriesAggFunc returns the aggregation function for the given name.
+// like graphite, the names of the corresponding series functions (e.g. sumSeries) are accepted too.
 func getCrossSeriesAggFunc(c string) crossSeriesAggFunc {
-	switch c {
+	switch strings.TrimSuffix(c, "Series") {
 	case "avg", "average":
 		return crossSeriesAvg
 	case "min":
 		return crossSeriesMin
 	case "max":
 		return crossSeriesMax
-	case "sum":
+	case "sum", "total":
 		return crossSeriesSum
 	case "multiply":
 		return crossSeriesMultiply
@@ -215,3 +219,24 @@ func crossSeriesRange(in []models.Series, out *[]schema.Point) {
 		(*out)[i].Val -= mins[i].Val
 	}
 }
+
+// crossSeriesPercentile returns a crossSeriesAggFunc that computes the n-th percentile across the series at every timestamp
+func crossSeriesPercentile(n float64, interpolate bool) crossSeriesAggFunc {
+	return func(in []models.Series, out *[]schema.Point) {
+		vals := make([]float64, 0, len(in))
+		for i := 0; i < len(in[0].Datapoints); i++ {
+			vals = vals[:0]
+			for j := 0; j < len(in); j++ {
+				p := in[j].Datapoints[i].Val
+				if !math.IsNaN(p) {
+					vals = append(vals, p)
+				}
+			}
+			sort.Float64s(vals)
+			*out = append(*out, schema.Point{
+				Val: batch.PercentileOfSorted(vals, n, interpolate),
+				Ts:  in[0].Datapoints[i].Ts,
+			})
+		}
+	}
+}
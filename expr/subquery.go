@@ -0,0 +1,145 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+// subQuery supports functions that evaluate targets derived from the names of their input series, such as applyByNode.
+// those targets are only known once the input has been fetched, so instead, a target derived from the input pattern,
+// that matches everything the derived targets may need, is planned up front.
+// when executing, each derived target is evaluated on the subset of that data that matches it.
+// this only works for inputs that are plain metric patterns; other inputs are left to graphite.
+type subQuery struct {
+	context Context
+	stable  bool
+	reqs    []Req // the requests of the target derived from the input pattern
+}
+
+// inputPattern returns the pattern of the given input, if it is a plain metric pattern
+func (q *subQuery) inputPattern(in GraphiteFunc, fn string) (string, error) {
+	get, ok := in.(FuncGet)
+	if !ok || strings.HasPrefix(get.req.Query, "seriesByTag(") {
+		return "", ErrUnknownFunction(fn)
+	}
+	return get.req.Query, nil
+}
+
+// plan adds the requests for the given target, which should match all data the derived targets may need
+func (q *subQuery) plan(fn, target string, context Context, stable bool, reqs []Req) ([]Req, error) {
+	e, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	_, subReqs, err := newplan(e, context, stable, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, req := range subReqs {
+		if strings.HasPrefix(req.Query, "seriesByTag(") {
+			return nil, ErrUnknownFunction(fn)
+		}
+		reqs = addReq(reqs, req)
+	}
+	q.context = context
+	q.stable = stable
+	q.reqs = subReqs
+	return reqs, nil
+}
+
+// exec evaluates the given derived target. any series it generates are added to the cache, so they are reclaimed with the rest.
+func (q *subQuery) exec(cache map[Req][]models.Series, target string) ([]models.Series, error) {
+	e, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	fn, reqs, err := newplan(e, q.context, q.stable, nil)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[Req][]models.Series, len(reqs))
+	for _, req := range reqs {
+		data[req], err = q.matching(cache, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out, err := fn.Exec(data)
+	cache[Req{}] = append(cache[Req{}], data[Req{}]...)
+	return out, err
+}
+
+// matching returns the series fetched for the planned requests, that match the given request of a derived target
+func (q *subQuery) matching(cache map[Req][]models.Series, req Req) ([]models.Series, error) {
+	re, err := globToRegexp(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	var out []models.Series
+	seen := make(map[string]struct{})
+	for _, r := range q.reqs {
+		if r.From != req.From || r.To != req.To || r.Cons != req.Cons {
+			continue
+		}
+		for _, serie := range cache[r] {
+			if _, ok := seen[serie.Target]; ok {
+				continue
+			}
+			if re.MatchString(strings.SplitN(serie.Target, ";", 2)[0]) {
+				seen[serie.Target] = struct{}{}
+				out = append(out, serie)
+			}
+		}
+	}
+	return out, nil
+}
+
+// parseTarget parses a single, complete target
+func parseTarget(target string) (*expr, error) {
+	e, leftover, err := Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if leftover != "" {
+		return nil, fmt.Errorf("failed to parse %q fully. got leftover %q", target, leftover)
+	}
+	return e, nil
+}
+
+// globToRegexp converts a graphite metric pattern into an anchored regular expression
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	buf.WriteString("^")
+	var inBraces, inBrackets bool
+	for _, c := range pattern {
+		switch {
+		case inBrackets:
+			buf.WriteRune(c)
+			if c == ']' {
+				inBrackets = false
+			}
+		case c == '[':
+			inBrackets = true
+			buf.WriteRune(c)
+		case c == '*':
+			buf.WriteString("[^.]*")
+		case c == '?':
+			buf.WriteString("[^.]")
+		case c == '{':
+			inBraces = true
+			buf.WriteString("(?:")
+		case c == '}' && inBraces:
+			inBraces = false
+			buf.WriteString(")")
+		case c == ',' && inBraces:
+			buf.WriteString("|")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
@@ -2,6 +2,7 @@ package expr
 
 import (
 	"errors"
+	"time"
 
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/raintank/dur"
@@ -10,6 +11,7 @@ import (
 var ErrIntPositive = errors.New("integer must be positive")
 var ErrInvalidAggFunc = errors.New("Invalid aggregation func")
 var ErrNonNegativePercent = errors.New("The requested percent is required to be greater than 0")
+var ErrWithinZeroOneInclusiveInterval = errors.New("value must lie within interval [0,1]")
 
 // Validator is a function to validate an input
 type Validator func(e *expr) error
@@ -38,6 +40,18 @@ func IsIntervalString(e *expr) error {
 	return err
 }
 
+// IsSignedIntervalString validates whether a string is an interval, optionally prefixed with + or -
+func IsSignedIntervalString(e *expr) error {
+	_, err := parseTimeShift(e.str)
+	return err
+}
+
+// IsDateTimeString validates whether a string is a graphite time specification, such as "-1h", "now" or "12:00_20190101"
+func IsDateTimeString(e *expr) error {
+	_, err := dur.ParseDateTime(e.str, time.UTC, time.Now(), 0)
+	return err
+}
+
 func IsOperator(e *expr) error {
 	switch e.str {
 	case "=", "!=", ">", ">=", "<", "<=":
@@ -52,3 +66,17 @@ func NonNegativePercent(e *expr) error {
 	}
 	return nil
 }
+
+// WithinZeroOneInclusiveInterval validates whether a number lies within [0,1], such as an xFilesFactor
+func WithinZeroOneInclusiveInterval(e *expr) error {
+	if e.etype == etInt {
+		if e.int < 0 || e.int > 1 {
+			return ErrWithinZeroOneInclusiveInterval
+		}
+		return nil
+	}
+	if e.float < 0 || e.float > 1 {
+		return ErrWithinZeroOneInclusiveInterval
+	}
+	return nil
+}
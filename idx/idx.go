@@ -67,6 +67,10 @@ type MetricIndex interface {
 	// Get returns the archive for the requested id.
 	Get(key schema.MKey) (Archive, bool)
 
+	// ActiveSeries returns the number of series of the given org in the index.
+	// public series are not included.
+	ActiveSeries(orgId uint32) int
+
 	// GetPath returns the archives under the given path.
 	GetPath(orgId uint32, path string) []Archive
 
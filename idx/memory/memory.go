@@ -251,6 +251,9 @@ type UnpartitionedMemoryIdx struct {
 	// and without tags. It also mixes all orgs into one flat map.
 	defById map[schema.MKey]*idx.Archive
 
+	// number of entries in defById, by orgId
+	activeSeries map[uint32]int
+
 	// used by hierarchy index only
 	tree map[uint32]*Tree // by orgId
 
@@ -268,6 +271,7 @@ type UnpartitionedMemoryIdx struct {
 func NewUnpartitionedMemoryIdx() *UnpartitionedMemoryIdx {
 	m := &UnpartitionedMemoryIdx{
 		defById:        make(map[schema.MKey]*idx.Archive),
+		activeSeries:   make(map[uint32]int),
 		defByTagSet:    make(defByTagSet),
 		tree:           make(map[uint32]*Tree),
 		tags:           make(map[uint32]TagIndex),
@@ -637,6 +641,7 @@ func (m *UnpartitionedMemoryIdx) add(archive *idx.Archive) {
 	}
 
 	statMetricsActive.Inc()
+	m.activeSeries[archive.OrgId]++
 
 	def := &archive.MetricDefinition
 	path := def.NameWithTags()
@@ -747,6 +752,13 @@ func (m *UnpartitionedMemoryIdx) Get(id schema.MKey) (idx.Archive, bool) {
 	return idx.Archive{}, ok
 }
 
+// ActiveSeries returns the number of series of the given org in the index.
+func (m *UnpartitionedMemoryIdx) ActiveSeries(orgId uint32) int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.activeSeries[orgId]
+}
+
 // GetPath returns the node under the given org and path.
 // this is an alternative to Find for when you have a path, not a pattern, and want to lookup in a specific org tree only.
 func (m *UnpartitionedMemoryIdx) GetPath(orgId uint32, path string) []idx.Archive {
@@ -1356,6 +1368,7 @@ func (m *UnpartitionedMemoryIdx) deleteTaggedByIdSet(orgId uint32, ids IdSet) []
 		}
 		deletedDefs = append(deletedDefs, CloneArchive(def))
 		delete(m.defById, idStr)
+		m.activeSeries[orgId]--
 	}
 
 	statMetricsActive.DecUint32(uint32(len(deletedDefs)))
@@ -1436,6 +1449,7 @@ func (m *UnpartitionedMemoryIdx) delete(orgId uint32, n *Node, deleteEmptyParent
 		}
 		deletedDefs = append(deletedDefs, CloneArchive(archivePointer))
 		delete(m.defById, id)
+		m.activeSeries[orgId]--
 	}
 
 	n.Defs = nil
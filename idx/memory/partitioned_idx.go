@@ -102,6 +102,15 @@ func (p *PartitionedMemoryIdx) Get(key schema.MKey) (idx.Archive, bool) {
 	return *result, true
 }
 
+// ActiveSeries returns the number of series of the given org in the index.
+func (p *PartitionedMemoryIdx) ActiveSeries(orgId uint32) int {
+	var count int
+	for _, m := range p.Partition {
+		count += m.ActiveSeries(orgId)
+	}
+	return count
+}
+
 // GetPath returns the archives under the given path.
 func (p *PartitionedMemoryIdx) GetPath(orgId uint32, path string) []idx.Archive {
 	g, _ := errgroup.WithContext(context.Background())
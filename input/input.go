@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"time"
 
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/limits"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/stats"
 	"github.com/raintank/schema"
@@ -41,6 +43,8 @@ type DefaultHandler struct {
 	invalidTagMD *stats.CounterRate32
 	invalidMP    *stats.CounterRate32
 	unknownMP    *stats.Counter32
+	limitedMD    *stats.CounterRate32
+	limitedMP    *stats.CounterRate32
 
 	metrics     mdata.Metrics
 	metricIndex idx.MetricIndex
@@ -55,8 +59,12 @@ const (
 	invalidMtype     = "invalid-mtype"
 	invalidTagFormat = "invalid-tag-format"
 	unknownPointId   = "unknown-point-id"
+	limitExceeded    = "limit-exceeded"
 )
 
+// sampleRates tracks the samples per second of each org, across all inputs
+var sampleRates = limits.NewRateLimiters()
+
 func NewDefaultHandler(metrics mdata.Metrics, metricIndex idx.MetricIndex, input string) DefaultHandler {
 	return DefaultHandler{
 		// metric input.%s.metricdata.received is the count of metricdata datapoints received by input plugin
@@ -74,6 +82,12 @@ func NewDefaultHandler(metrics mdata.Metrics, metricIndex idx.MetricIndex, input
 		invalidMP: stats.NewCounterRate32(fmt.Sprintf("input.%s.metricpoint.discarded.invalid", input)),
 		// metric input.%s.metricpoint.discarded.unknown is the count of times the ID of a received metricpoint was not in the index, by input plugin
 		unknownMP: stats.NewCounter32(fmt.Sprintf("input.%s.metricpoint.discarded.unknown", input)),
+		// metric input.%s.metricdata.discarded.limit is the count of times a metricdata was rejected because its org exceeded
+		// the max-active-series or max-samples-per-sec limit, by input plugin
+		limitedMD: stats.NewCounterRate32(fmt.Sprintf("input.%s.metricdata.discarded.limit", input)),
+		// metric input.%s.metricpoint.discarded.limit is the count of times a metricpoint was rejected because its org exceeded
+		// the max-samples-per-sec limit, by input plugin
+		limitedMP: stats.NewCounterRate32(fmt.Sprintf("input.%s.metricpoint.discarded.limit", input)),
 
 		metrics:     metrics,
 		metricIndex: metricIndex,
@@ -97,6 +111,13 @@ func (in DefaultHandler) ProcessMetricPoint(point schema.MetricPoint, format msg
 		return
 	}
 
+	if !sampleRates.Allow(point.MKey.Org, time.Now(), limits.Get(point.MKey.Org).MaxSamplesPerSec, 1) {
+		in.limitedMP.Inc()
+		limits.Rejected(point.MKey.Org, "max-samples-per-sec")
+		mdata.PromDiscardedSamples.WithLabelValues(limitExceeded, strconv.Itoa(int(point.MKey.Org))).Inc()
+		return
+	}
+
 	archive, _, ok := in.metricIndex.Update(point, partition)
 
 	if !ok {
@@ -173,8 +194,31 @@ func (in DefaultHandler) ProcessMetricData(md *schema.MetricData, partition int3
 		return
 	}
 
+	if limit := in.exceededLimit(mkey); limit != "" {
+		in.limitedMD.Inc()
+		limits.Rejected(mkey.Org, limit)
+		mdata.PromDiscardedSamples.WithLabelValues(limitExceeded, strconv.Itoa(md.OrgId)).Inc()
+		return
+	}
+
 	archive, _, _ := in.metricIndex.AddOrUpdate(mkey, md, partition)
 
 	m := in.metrics.GetOrCreate(mkey, archive.SchemaId, archive.AggId, uint32(md.Interval))
 	m.Add(uint32(md.Time), md.Value)
 }
+
+// exceededLimit returns the name of the ingest limit that a point for the given series would exceed, if any.
+// a new series is only accepted if its org has fewer than max-active-series series in the index.
+// note that concurrent points for new series may still take an org slightly over the limit.
+func (in DefaultHandler) exceededLimit(mkey schema.MKey) string {
+	lim := limits.Get(mkey.Org)
+	if lim.MaxActiveSeries > 0 {
+		if _, ok := in.metricIndex.Get(mkey); !ok && in.metricIndex.ActiveSeries(mkey.Org) >= lim.MaxActiveSeries {
+			return "max-active-series"
+		}
+	}
+	if !sampleRates.Allow(mkey.Org, time.Now(), lim.MaxSamplesPerSec, 1) {
+		return "max-samples-per-sec"
+	}
+	return ""
+}
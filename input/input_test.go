@@ -9,6 +9,7 @@ import (
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/idx"
 	"github.com/grafana/metrictank/idx/memory"
+	"github.com/grafana/metrictank/limits"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/mdata/cache"
 	backendStore "github.com/grafana/metrictank/store"
@@ -92,6 +93,43 @@ func TestIngestValidAndInvalidTagsAndValuesWithAndWithoutRejection(t *testing.T)
 	}
 }
 
+func TestIngestLimits(t *testing.T) {
+	handler, index, reset := getDefaultHandler(t)
+	defer reset()
+	tenantLimits := conf.NewTenantLimits()
+	tenantLimits.Orgs[1] = conf.Limits{MaxActiveSeries: 2}
+	tenantLimits.Orgs[2] = conf.Limits{MaxSamplesPerSec: 3}
+	limits.Set(tenantLimits)
+	defer limits.Set(conf.NewTenantLimits())
+
+	// org 1 can't add a 3rd series, but can keep sending data for the first 2
+	for i, name := range []string{"a", "b", "c", "a", "b"} {
+		data := getTestMetricData()
+		data.Name = name
+		data.Time = int64(10 + i)
+		data.SetId()
+		handler.ProcessMetricData(&data, 0)
+	}
+	if got := index.ActiveSeries(1); got != 2 {
+		t.Fatalf("expected 2 series for org 1, got %d", got)
+	}
+	if got := handler.limitedMD.Peek(); got != 1 {
+		t.Fatalf("expected 1 rejected point, got %d", got)
+	}
+
+	// org 2 can send a burst of 3 samples, after which the rest is rejected
+	for i := 0; i < 5; i++ {
+		data := getTestMetricData()
+		data.OrgId = 2
+		data.Time = int64(10 + i)
+		data.SetId()
+		handler.ProcessMetricData(&data, 0)
+	}
+	if got := handler.limitedMD.Peek(); got != 3 {
+		t.Fatalf("expected 3 rejected points, got %d", got)
+	}
+}
+
 func generateInvalidTags(t *testing.T) []string {
 	t.Helper()
 
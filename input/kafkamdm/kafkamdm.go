@@ -12,8 +12,11 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/cluster/partitioner"
+	"github.com/grafana/metrictank/idx"
 	"github.com/grafana/metrictank/input"
 	"github.com/grafana/metrictank/kafka"
+	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/stats"
 	"github.com/raintank/schema"
 	"github.com/raintank/schema/msg"
@@ -33,6 +36,9 @@ type KafkaMdm struct {
 	lagMonitor *LagMonitor
 	wg         sync.WaitGroup
 
+	metrics   mdata.Metrics
+	migration *migration // nil when not migrating
+
 	shutdown chan struct{}
 	// signal to caller that it should shutdown
 	cancel context.CancelFunc
@@ -62,6 +68,17 @@ var netMaxOpenRequests int
 var offsetDuration time.Duration
 var kafkaStats stats.Kafka
 
+var migrationPartitionStr string
+var migrationPartitionList []int32
+var migrationPartitionHash string
+var migrationPartitionScheme string
+var migrationPartitionTagsStr string
+var migrationPartitioner partitioner.Partitioner
+var migrationNumPartitions int32
+
+// how often to check whether the migration is complete
+const migrationCheckInterval = 10 * time.Second
+
 func ConfigSetup() {
 	inKafkaMdm := flag.NewFlagSet("kafka-mdm-in", flag.ExitOnError)
 	inKafkaMdm.BoolVar(&Enabled, "enabled", false, "")
@@ -77,6 +94,10 @@ func ConfigSetup() {
 	inKafkaMdm.DurationVar(&consumerMaxWaitTime, "consumer-max-wait-time", time.Second, "The maximum amount of time the broker will wait for Consumer.Fetch.Min bytes to become available before it returns fewer than that anyway")
 	inKafkaMdm.DurationVar(&consumerMaxProcessingTime, "consumer-max-processing-time", time.Second, "The maximum amount of time the consumer expects a message takes to process")
 	inKafkaMdm.IntVar(&netMaxOpenRequests, "net-max-open-requests", 100, "How many outstanding requests a connection is allowed to have before sending on it blocks")
+	inKafkaMdm.StringVar(&migrationPartitionStr, "migration-partitions", "", "comma separated list of partitions to consume in addition to the partitions, while migrating to a new partitioning. only the metrics that belong to the partitions under the new partitioning are processed.")
+	inKafkaMdm.StringVar(&migrationPartitionHash, "migration-partition-hash", "jump", "hash of the new partitioning, to determine which metrics of the migration partitions belong to the partitions. (kafka|jump)")
+	inKafkaMdm.StringVar(&migrationPartitionScheme, "migration-partition-scheme", "bySeries", "method of the new partitioning, to determine which metrics of the migration partitions belong to the partitions. (byOrg|bySeries|byTag)")
+	inKafkaMdm.StringVar(&migrationPartitionTagsStr, "migration-partition-tags", "", "comma separated list of tags of the new partitioning, when the migration-partition-scheme is byTag")
 	globalconf.Register("kafka-mdm-in", inKafkaMdm, flag.ExitOnError)
 }
 
@@ -152,6 +173,40 @@ func ConfigProcess(instance string) {
 			log.Fatalf("kafkamdm: configured partitions not in list of available partitions. missing %v", missing)
 		}
 	}
+	if migrationPartitionStr != "" {
+		for _, part := range strings.Split(migrationPartitionStr, ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				log.Fatalf("kafkamdm: could not parse migration partition %q. migration-partitions must be a comma separated list of id's", part)
+			}
+			for _, p := range partitions {
+				if p == int32(i) {
+					log.Fatalf("kafkamdm: migration partition %d is also one of the partitions", i)
+				}
+			}
+			migrationPartitionList = append(migrationPartitionList, int32(i))
+		}
+		missing := kafka.DiffPartitions(migrationPartitionList, availParts)
+		if len(missing) > 0 {
+			log.Fatalf("kafkamdm: configured migration partitions not in list of available partitions. missing %v", missing)
+		}
+		var tags []string
+		if migrationPartitionTagsStr != "" {
+			tags = strings.Split(migrationPartitionTagsStr, ",")
+		}
+		migrationPartitioner, err = partitioner.New(migrationPartitionHash, migrationPartitionScheme, tags)
+		if err != nil {
+			log.Fatalf("kafkamdm: invalid migration partitioning: %s", err.Error())
+		}
+		// the new partitioning uses all partitions of the topic
+		for _, p := range availParts {
+			if p >= migrationNumPartitions {
+				migrationNumPartitions = p + 1
+			}
+		}
+		log.Infof("kafkamdm: migrating. will consume partitions %v until the data of the series that moved to our partitions has been saved", migrationPartitionList)
+	}
+
 	// record our partitions so others (MetricIdx) can use the partitioning information.
 	// but only if the manager has been created (e.g. in metrictank), not when this input plugin is used in other contexts
 	if cluster.Manager != nil {
@@ -164,10 +219,12 @@ func ConfigProcess(instance string) {
 	// metric input.kafka-mdm.partition.%d.log_size is the current size of the kafka partition (%d), aka the newest available offset.
 
 	// metric input.kafka-mdm.partition.%d.lag is how many messages (metrics) there are in the kafka partition (%d) that we have not yet consumed.
-	kafkaStats = stats.NewKafka("input.kafka-mdm", partitions)
+	kafkaStats = stats.NewKafka("input.kafka-mdm", append(append([]int32{}, partitions...), migrationPartitionList...))
 }
 
-func New() *KafkaMdm {
+// New returns a kafka-mdm input. the metrics are used to determine when a migration is complete.
+// they may be nil when the input is used outside of metrictank, in which case the migration partitions are not consumed.
+func New(metrics mdata.Metrics) *KafkaMdm {
 	client, err := sarama.NewClient(brokers, config)
 	if err != nil {
 		log.Fatalf("kafkamdm: failed to create client. %s", err)
@@ -182,37 +239,77 @@ func New() *KafkaMdm {
 		client:     client,
 		lagMonitor: NewLagMonitor(10, partitions),
 		shutdown:   make(chan struct{}),
+		metrics:    metrics,
+	}
+	if len(migrationPartitionList) > 0 && metrics != nil {
+		k.migration = newMigration(migrationPartitioner, migrationNumPartitions, partitions, migrationPartitionList, len(topics))
 	}
 
 	return &k
 }
 
+// BindMetricIndex sets the index used to resolve the partition of points on the migration partitions
+// of series of which we haven't consumed a MetricData yet.
+func (k *KafkaMdm) BindMetricIndex(index idx.MetricIndex) {
+	if k.migration != nil {
+		k.migration.index = index
+	}
+}
+
 func (k *KafkaMdm) Start(handler input.Handler, cancel context.CancelFunc) error {
 	k.Handler = handler
 	k.cancel = cancel
-	var err error
 	for _, topic := range topics {
 		for _, partition := range partitions {
-			var offset int64
-			switch offsetStr {
-			case "oldest":
-				offset = sarama.OffsetOldest
-			case "newest":
-				offset = sarama.OffsetNewest
-			default:
-				offset, err = k.client.GetOffset(topic, partition, time.Now().Add(-1*offsetDuration).UnixNano()/int64(time.Millisecond))
-				if err != nil {
-					offset = sarama.OffsetOldest
-					log.Warnf("kafkamdm: failed to get offset %s: %s -> will use oldest instead", offsetDuration, err)
-				}
-			}
 			k.wg.Add(1)
-			go k.consumePartition(topic, partition, offset)
+			go k.consumePartition(topic, partition, k.startOffset(topic, partition), false)
+		}
+		if k.migration == nil {
+			continue
+		}
+		for _, partition := range migrationPartitionList {
+			k.wg.Add(1)
+			go k.consumePartition(topic, partition, k.startOffset(topic, partition), true)
 		}
 	}
+	if k.migration != nil {
+		go k.checkMigration()
+	}
 	return nil
 }
 
+// startOffset returns the offset to start consuming the partition from, as configured
+func (k *KafkaMdm) startOffset(topic string, partition int32) int64 {
+	switch offsetStr {
+	case "oldest":
+		return sarama.OffsetOldest
+	case "newest":
+		return sarama.OffsetNewest
+	}
+	offset, err := k.client.GetOffset(topic, partition, time.Now().Add(-1*offsetDuration).UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		offset = sarama.OffsetOldest
+		log.Warnf("kafkamdm: failed to get offset %s: %s -> will use oldest instead", offsetDuration, err)
+	}
+	return offset
+}
+
+// checkMigration periodically checks whether the migration is complete, until it is
+func (k *KafkaMdm) checkMigration() {
+	ticker := time.NewTicker(migrationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.shutdown:
+			return
+		case <-k.migration.done:
+			return
+		case <-ticker.C:
+			k.migration.check(k.metrics)
+		}
+	}
+}
+
 // tryGetOffset will to query kafka repeatedly for the requested offset and give up after attempts unsuccesfull attempts
 // an error is returned when it had to give up
 func (k *KafkaMdm) tryGetOffset(topic string, partition int32, offset int64, attempts int, sleep time.Duration) (int64, error) {
@@ -249,7 +346,8 @@ func (k *KafkaMdm) tryGetOffset(topic string, partition int32, offset int64, att
 }
 
 // consumePartition consumes from the topic until k.shutdown is triggered.
-func (k *KafkaMdm) consumePartition(topic string, partition int32, currentOffset int64) {
+// migration partitions are consumed until the migration is complete.
+func (k *KafkaMdm) consumePartition(topic string, partition int32, currentOffset int64, migrating bool) {
 	defer k.wg.Done()
 
 	// determine the pos of the topic and the initial offset of our consumer
@@ -274,7 +372,16 @@ func (k *KafkaMdm) consumePartition(topic string, partition int32, currentOffset
 	kafkaStats.Offset.Set(int(currentOffset))
 	kafkaStats.LogSize.Set(int(newest))
 	kafkaStats.Lag.Set(int(newest - currentOffset))
-	go k.trackStats(topic, partition)
+	var migrationDone chan struct{}
+	var caughtUp bool
+	if migrating {
+		migrationDone = k.migration.done
+		if currentOffset >= newest {
+			k.migration.caughtUp(partition)
+			caughtUp = true
+		}
+	}
+	go k.trackStats(topic, partition, migrationDone)
 
 	log.Infof("kafkamdm: consuming from %s:%d from offset %d", topic, partition, currentOffset)
 	pc, err := k.consumer.ConsumePartition(topic, partition, currentOffset)
@@ -296,8 +403,20 @@ func (k *KafkaMdm) consumePartition(topic string, partition int32, currentOffset
 			if log.IsLevelEnabled(log.DebugLevel) {
 				log.Debugf("kafkamdm: received message: Topic %s, Partition: %d, Offset: %d, Key: %x", msg.Topic, msg.Partition, msg.Offset, msg.Key)
 			}
-			k.handleMsg(msg.Value, partition)
+			if migrating {
+				k.handleMigrationMsg(msg.Value)
+				if !caughtUp && msg.Offset >= newest-1 {
+					k.migration.caughtUp(partition)
+					caughtUp = true
+				}
+			} else {
+				k.handleMsg(msg.Value, partition)
+			}
 			kafkaStats.Offset.Set(int(msg.Offset))
+		case <-migrationDone:
+			pc.Close()
+			log.Infof("kafkamdm: consumer for migration partition %s:%d ended.", topic, partition)
+			return
 		case <-k.shutdown:
 			pc.Close()
 			log.Infof("kafkamdm: consumer for %s:%d ended.", topic, partition)
@@ -330,6 +449,40 @@ func (k *KafkaMdm) handleMsg(data []byte, partition int32) {
 	k.Handler.ProcessMetricData(&md, partition)
 }
 
+// handleMigrationMsg handles a message from a migration partition.
+// only metrics that belong to our partitions under the new partitioning are processed, as part of their new partition.
+func (k *KafkaMdm) handleMigrationMsg(data []byte) {
+	format, isPointMsg := msg.IsPointMsg(data)
+	if isPointMsg {
+		_, point, err := msg.ReadPointMsg(data, uint32(orgId))
+		if err != nil {
+			metricsDecodeErr.Inc()
+			log.Errorf("kafkamdm: decode error, skipping message. %s", err)
+			return
+		}
+		partition, ok := k.migration.partitionOfPoint(point)
+		if !ok {
+			return
+		}
+		k.Handler.ProcessMetricPoint(point, format, partition)
+		return
+	}
+
+	md := schema.MetricData{}
+	_, err := md.UnmarshalMsg(data)
+	if err != nil {
+		metricsDecodeErr.Inc()
+		log.Errorf("kafkamdm: decode error, skipping message. %s", err)
+		return
+	}
+	metricsPerMessage.ValueUint32(1)
+	partition, ok := k.migration.partitionOf(&md)
+	if !ok {
+		return
+	}
+	k.Handler.ProcessMetricData(&md, partition)
+}
+
 // Stop will initiate a graceful stop of the Consumer (permanent)
 // and block until it stopped.
 func (k *KafkaMdm) Stop() {
@@ -339,13 +492,18 @@ func (k *KafkaMdm) Stop() {
 	k.client.Close()
 }
 
-func (k *KafkaMdm) trackStats(topic string, partition int32) {
+// trackStats updates the offset stats of the partition every second, until k.shutdown is triggered.
+// for migration partitions, migrationDone is the channel that is closed once the migration is complete.
+// their lag does not affect our priority: we don't serve their data until it has been migrated anyway.
+func (k *KafkaMdm) trackStats(topic string, partition int32, migrationDone chan struct{}) {
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	kafkaStats := kafkaStats[partition]
 	for {
 		select {
 		case <-k.shutdown:
-			ticker.Stop()
+			return
+		case <-migrationDone:
 			return
 		case ts := <-ticker.C:
 			currentOffset := int64(kafkaStats.Offset.Peek())
@@ -357,7 +515,9 @@ func (k *KafkaMdm) trackStats(topic string, partition int32) {
 			kafkaStats.LogSize.Set(int(newest))
 			lag := int(newest - currentOffset)
 			kafkaStats.Lag.Set(lag)
-			k.lagMonitor.StoreOffsets(partition, currentOffset, newest, ts)
+			if migrationDone == nil {
+				k.lagMonitor.StoreOffsets(partition, currentOffset, newest, ts)
+			}
 		}
 	}
 }
@@ -0,0 +1,112 @@
+package kafkamdm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/grafana/metrictank/cluster/partitioner"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/schema"
+	"github.com/raintank/schema/msg"
+)
+
+type mockHandler struct {
+	sync.Mutex
+	partitions []int32
+}
+
+func (m *mockHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	m.Lock()
+	m.partitions = append(m.partitions, partition)
+	m.Unlock()
+}
+
+func (m *mockHandler) ProcessMetricPoint(point schema.MetricPoint, format msg.Format, partition int32) {
+	m.Lock()
+	m.partitions = append(m.partitions, partition)
+	m.Unlock()
+}
+
+func (m *mockHandler) processed() []int32 {
+	m.Lock()
+	defer m.Unlock()
+	return append([]int32{}, m.partitions...)
+}
+
+func TestConsumeMigrationPartition(t *testing.T) {
+	mdata.SetSingleSchema(conf.NewRetentionMT(10, 3600, 600, 2, 0))
+	mdata.SetSingleAgg(conf.Max)
+	p, _ := partitioner.NewJump("bySeries", nil)
+	md := findMetric(t, p, 1, true)
+	data, err := md.MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("mdm", 1, broker.BrokerID()).
+			SetLeader("mdm", 2, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("mdm", 2, sarama.OffsetOldest, 0).
+			SetOffset("mdm", 2, sarama.OffsetNewest, 1),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage("mdm", 2, 0, sarama.ByteEncoder(data)).
+			SetHighWaterMark("mdm", 2, 1),
+	})
+
+	client, err := sarama.NewClient([]string{broker.Addr()}, sarama.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	kafkaStats = stats.NewKafka("input.kafka-mdm", []int32{1, 2})
+	handler := &mockHandler{}
+	k := KafkaMdm{
+		Handler:    handler,
+		consumer:   consumer,
+		client:     client,
+		lagMonitor: NewLagMonitor(10, []int32{1}),
+		shutdown:   make(chan struct{}),
+		metrics:    mdata.NewAggMetrics(nil, nil, false, 3600, 7200, 0),
+		migration:  newMigration(p, 4, []int32{1}, []int32{2}, 1),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	k.cancel = cancel
+
+	k.wg.Add(1)
+	go k.consumePartition("mdm", 2, sarama.OffsetOldest, true)
+
+	// the stats of the partition are tracked every second. make sure they were tracked at least once,
+	// without affecting the lag of our own partitions
+	time.Sleep(1500 * time.Millisecond)
+	close(k.shutdown)
+	k.wg.Wait()
+
+	if ctx.Err() != nil {
+		t.Fatalf("expected the consumer not to fail")
+	}
+	if processed := handler.processed(); len(processed) != 1 || processed[0] != 1 {
+		t.Fatalf("expected the metric to be processed as part of partition 1, got %v", processed)
+	}
+	if logSize := kafkaStats[2].LogSize.Peek(); logSize != 1 {
+		t.Fatalf("expected the log size of the migration partition to be tracked, got %d", logSize)
+	}
+	if _, ok := k.lagMonitor.monitors[2]; ok {
+		t.Fatalf("expected the migration partition not to be monitored for lag")
+	}
+}
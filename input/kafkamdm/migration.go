@@ -0,0 +1,188 @@
+package kafkamdm
+
+import (
+	"sync"
+
+	"github.com/grafana/metrictank/cluster/partitioner"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// metric input.kafka-mdm.migration.partitions is the number of migration partitions that are still being consumed
+	migrationPartitions = stats.NewGauge32("input.kafka-mdm.migration.partitions")
+	// metric input.kafka-mdm.migration.series is the number of series consumed from the migration partitions, of which not all data has been saved yet
+	migrationSeries = stats.NewGauge32("input.kafka-mdm.migration.series")
+	// metric input.kafka-mdm.migration.skipped is the number of metrics from the migration partitions that were skipped, because they don't belong to the partitions of this node
+	migrationSkipped = stats.NewCounter32("input.kafka-mdm.migration.skipped")
+)
+
+// migration tracks the consumption of the migration partitions: the partitions of the old partitioning,
+// from which this node consumes the data of the series that moved to its partitions under the new partitioning.
+// from these partitions, only the metrics that belong to the partitions of this node are processed.
+// the migration is complete once all migration partitions have been consumed up to the offset they were at when we started,
+// and all data consumed from them has been saved.
+type migration struct {
+	sync.Mutex
+	partitioner   partitioner.Partitioner
+	numPartitions int32                           // number of partitions of the new partitioning
+	owned         map[int32]struct{}              // the partitions of this node
+	pending       map[int32]int                   // migration partitions -> number of topics of which they have not yet been consumed up to the offset they were at when we started
+	series        map[schema.MKey]migratingSeries // series consumed from the migration partitions
+	index         idx.MetricIndex                 // to partition the points of series of which we haven't seen a MetricData
+	done          chan struct{}                   // closed when the migration is complete
+}
+
+type migratingSeries struct {
+	partition int32  // partition of the series under the new partitioning
+	lastTs    uint32 // timestamp of the last point consumed from the migration partitions
+}
+
+func newMigration(p partitioner.Partitioner, numPartitions int32, owned, migrating []int32, numTopics int) *migration {
+	m := &migration{
+		partitioner:   p,
+		numPartitions: numPartitions,
+		owned:         make(map[int32]struct{}),
+		pending:       make(map[int32]int),
+		series:        make(map[schema.MKey]migratingSeries),
+		done:          make(chan struct{}),
+	}
+	for _, p := range owned {
+		m.owned[p] = struct{}{}
+	}
+	for _, p := range migrating {
+		m.pending[p] = numTopics
+	}
+	migrationPartitions.Set(len(migrating))
+	return m
+}
+
+// partitionOf returns the partition of the metric under the new partitioning, and whether this node owns it.
+// if it does, the series is tracked until its data has been saved.
+func (m *migration) partitionOf(md *schema.MetricData) (int32, bool) {
+	partition, err := m.partitioner.Partition(md, m.numPartitions)
+	if err != nil {
+		log.Errorf("kafkamdm: failed to get the partition of migrating metric %s: %s", md.Id, err.Error())
+		return 0, false
+	}
+	if _, ok := m.owned[partition]; !ok {
+		migrationSkipped.Inc()
+		return 0, false
+	}
+	mkey, err := schema.MKeyFromString(md.Id)
+	if err != nil {
+		// will be rejected by the input handler
+		return partition, true
+	}
+	m.Lock()
+	s := m.series[mkey]
+	s.partition = partition
+	if uint32(md.Time) > s.lastTs {
+		s.lastTs = uint32(md.Time)
+	}
+	m.series[mkey] = s
+	m.Unlock()
+	return partition, true
+}
+
+// partitionOfPoint is like partitionOf, for points.
+// since a point doesn't contain the information needed to partition it, points of series of which we haven't
+// seen the full MetricData on a migration partition yet (e.g. after a restart) are partitioned based on their
+// definition in the index. only points of series that are not in the index either are skipped.
+func (m *migration) partitionOfPoint(point schema.MetricPoint) (int32, bool) {
+	m.Lock()
+	s, ok := m.series[point.MKey]
+	if ok {
+		if point.Time > s.lastTs {
+			s.lastTs = point.Time
+			m.series[point.MKey] = s
+		}
+		m.Unlock()
+		return s.partition, true
+	}
+	m.Unlock()
+
+	if m.index == nil {
+		migrationSkipped.Inc()
+		return 0, false
+	}
+	def, ok := m.index.Get(point.MKey)
+	if !ok {
+		migrationSkipped.Inc()
+		return 0, false
+	}
+	// migrating series are indexed as part of their partition under the new partitioning
+	partition := def.Partition
+	if _, ok := m.owned[partition]; !ok {
+		migrationSkipped.Inc()
+		return 0, false
+	}
+
+	m.Lock()
+	s = m.series[point.MKey]
+	s.partition = partition
+	if point.Time > s.lastTs {
+		s.lastTs = point.Time
+	}
+	m.series[point.MKey] = s
+	m.Unlock()
+	return partition, true
+}
+
+// caughtUp marks the migration partition of a topic as consumed up to the offset it was at when we started.
+// it must be called once per topic.
+func (m *migration) caughtUp(partition int32) {
+	m.Lock()
+	m.pending[partition]--
+	if m.pending[partition] <= 0 {
+		log.Infof("kafkamdm: migration partition %d has caught up", partition)
+		delete(m.pending, partition)
+	}
+	m.Unlock()
+}
+
+// check completes the migration if all migration partitions have caught up, and all data consumed from them has been saved.
+func (m *migration) check(metrics mdata.Metrics) {
+	m.Lock()
+	if len(m.pending) > 0 {
+		m.Unlock()
+		return
+	}
+	series := make(map[schema.MKey]uint32, len(m.series))
+	for mkey, s := range m.series {
+		series[mkey] = s.lastTs
+	}
+	m.Unlock()
+
+	// get the saved state without holding the lock, so we don't block ingestion
+	var unsaved int
+	for mkey, ts := range series {
+		metric, ok := metrics.Get(mkey)
+		if !ok {
+			// the series is not in memory, so there's no data to save
+			continue
+		}
+		if metric.(*mdata.AggMetric).SavedThrough() <= ts {
+			unsaved++
+		}
+	}
+	migrationSeries.Set(unsaved)
+	if unsaved > 0 {
+		return
+	}
+
+	// new data may have been consumed in the meantime
+	m.Lock()
+	defer m.Unlock()
+	for mkey, s := range m.series {
+		if ts, ok := series[mkey]; !ok || s.lastTs != ts {
+			return
+		}
+	}
+	log.Infof("kafkamdm: migration complete. all data of the %d series consumed from the migration partitions has been saved. the migration partitions are no longer consumed, and can be removed from the config", len(series))
+	migrationPartitions.Set(0)
+	close(m.done)
+}
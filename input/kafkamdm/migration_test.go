@@ -0,0 +1,126 @@
+package kafkamdm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/grafana/metrictank/cluster/partitioner"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/idx/memory"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/test"
+	"github.com/raintank/schema"
+)
+
+// findMetric returns a metric that is in the given partition, or not in it
+func findMetric(t *testing.T, p partitioner.Partitioner, partition int32, in bool) *schema.MetricData {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		md := &schema.MetricData{OrgId: 1, Name: fmt.Sprintf("some.metric.%d", i), Interval: 10, Value: 1, Time: 3700, Mtype: "gauge"}
+		md.SetId()
+		part, err := p.Partition(md, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (part == partition) == in {
+			return md
+		}
+	}
+	t.Fatalf("no metric found")
+	return nil
+}
+
+func isDone(m *migration) bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestMigration(t *testing.T) {
+	mdata.SetSingleSchema(conf.NewRetentionMT(10, 3600, 600, 2, 0), conf.NewRetentionMT(600, 86400, 3600, 2, 0))
+	mdata.SetSingleAgg(conf.Max)
+	metrics := mdata.NewAggMetrics(nil, nil, false, 3600, 7200, 0)
+
+	p, _ := partitioner.NewJump("bySeries", nil)
+	m := newMigration(p, 4, []int32{1}, []int32{0, 2}, 1)
+
+	mine := findMetric(t, p, 1, true)
+	other := findMetric(t, p, 1, false)
+	if part, ok := m.partitionOf(mine); !ok || part != 1 {
+		t.Fatalf("expected metric to be in our partition 1, got %d %t", part, ok)
+	}
+	if _, ok := m.partitionOf(other); ok {
+		t.Fatalf("expected metric of another partition to be skipped")
+	}
+	mkey, _ := schema.MKeyFromString(mine.Id)
+	if part, ok := m.partitionOfPoint(schema.MetricPoint{MKey: mkey, Time: 3710, Value: 1}); !ok || part != 1 {
+		t.Fatalf("expected point of a known series to be in our partition 1, got %d %t", part, ok)
+	}
+	otherKey, _ := schema.MKeyFromString(other.Id)
+	if _, ok := m.partitionOfPoint(schema.MetricPoint{MKey: otherKey, Time: 3710, Value: 1}); ok {
+		t.Fatalf("expected point of an unknown series to be skipped")
+	}
+
+	agg := metrics.GetOrCreate(mkey, 0, 0, 10).(*mdata.AggMetric)
+	agg.Add(3700, 1)
+	agg.Add(3710, 1)
+
+	// not complete until all migration partitions caught up
+	m.caughtUp(0)
+	m.check(metrics)
+	if isDone(m) {
+		t.Fatalf("expected migration not to be complete while a partition has not caught up")
+	}
+	m.caughtUp(2)
+	m.check(metrics)
+	if isDone(m) {
+		t.Fatalf("expected migration not to be complete while data has not been saved")
+	}
+
+	// and all the data, including the rollups, has been saved
+	agg.SyncChunkSaveState(3600, false)()
+	m.check(metrics)
+	if isDone(m) {
+		t.Fatalf("expected migration not to be complete while the rollups have not been saved")
+	}
+	agg.SyncArchiveChunkSaveState(3600, schema.NewArchive(schema.Max, 600))
+	m.check(metrics)
+	if !isDone(m) {
+		t.Fatalf("expected migration to be complete")
+	}
+}
+
+func TestMigrationPointOfIndexedSeries(t *testing.T) {
+	index := memory.New()
+	index.Init()
+	defer index.Stop()
+
+	p, _ := partitioner.NewJump("bySeries", nil)
+	m := newMigration(p, 4, []int32{1}, []int32{0, 2}, 1)
+	m.index = index
+
+	// we haven't seen the MetricData of these series on the migration partitions, e.g. because we restarted
+	mine := findMetric(t, p, 1, true)
+	other := findMetric(t, p, 1, false)
+	mkey, _ := schema.MKeyFromString(mine.Id)
+	otherKey, _ := schema.MKeyFromString(other.Id)
+	index.AddOrUpdate(mkey, mine, 1)
+	index.AddOrUpdate(otherKey, other, 3)
+
+	if part, ok := m.partitionOfPoint(schema.MetricPoint{MKey: mkey, Time: 3710, Value: 1}); !ok || part != 1 {
+		t.Fatalf("expected point of an indexed series to be in our partition 1, got %d %t", part, ok)
+	}
+	if s, ok := m.series[mkey]; !ok || s.lastTs != 3710 {
+		t.Fatalf("expected the indexed series to be tracked, got %v %t", s, ok)
+	}
+	if _, ok := m.partitionOfPoint(schema.MetricPoint{MKey: otherKey, Time: 3710, Value: 1}); ok {
+		t.Fatalf("expected point of an indexed series of another partition to be skipped")
+	}
+	unknown := test.GetMKey(1)
+	if _, ok := m.partitionOfPoint(schema.MetricPoint{MKey: unknown, Time: 3710, Value: 1}); ok {
+		t.Fatalf("expected point of an unknown series to be skipped")
+	}
+}
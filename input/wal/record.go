@@ -0,0 +1,131 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/raintank/schema"
+)
+
+// record types
+const (
+	recordData  byte = 1 // a MetricData, along with its partition
+	recordSaved byte = 2 // a saved chunk of a series archive
+)
+
+// records consist of a header of a checksum, the length of the payload and the type, followed by the payload.
+// the checksum covers the type and the payload.
+const headerSize = 9
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorrupt = errors.New("wal: corrupt or incomplete record")
+)
+
+type record struct {
+	typ byte
+
+	// for recordData
+	partition int32
+	md        schema.MetricData
+
+	// for recordSaved
+	key schema.AMKey
+	t0  uint32
+}
+
+// appendData appends a data record to buf
+func appendData(buf []byte, md *schema.MetricData, partition int32) ([]byte, error) {
+	start := len(buf)
+	buf = append(buf, make([]byte, headerSize+4)...)
+	binary.LittleEndian.PutUint32(buf[start+headerSize:], uint32(partition))
+	buf, err := md.MarshalMsg(buf)
+	if err != nil {
+		return buf[:start], err
+	}
+	return finishRecord(buf, start, recordData), nil
+}
+
+// appendSaved appends a saved chunk record to buf
+func appendSaved(buf []byte, key schema.AMKey, t0 uint32) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, headerSize+4)...)
+	binary.LittleEndian.PutUint32(buf[start+headerSize:], t0)
+	buf = append(buf, key.String()...)
+	return finishRecord(buf, start, recordSaved)
+}
+
+// finishRecord fills in the header of the record that starts at start
+func finishRecord(buf []byte, start int, typ byte) []byte {
+	buf[start+8] = typ
+	binary.LittleEndian.PutUint32(buf[start+4:], uint32(len(buf)-start-headerSize))
+	binary.LittleEndian.PutUint32(buf[start:], crc32.Checksum(buf[start+8:], castagnoli))
+	return buf
+}
+
+// recordReader reads records from a segment
+type recordReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{
+		r: bufio.NewReaderSize(r, 1024*1024),
+	}
+}
+
+// next returns the next record. it returns io.EOF at the end of the segment,
+// and errCorrupt if the record is corrupt or was not completely written, e.g. due to a crash.
+func (rr *recordReader) next() (record, error) {
+	var header [headerSize]byte
+	_, err := io.ReadFull(rr.r, header[:])
+	if err == io.EOF {
+		return record{}, io.EOF
+	}
+	if err != nil {
+		return record{}, errCorrupt
+	}
+	checksum := binary.LittleEndian.Uint32(header[0:])
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size < 4 || size > maxRecordSize {
+		return record{}, errCorrupt
+	}
+	if cap(rr.buf) < int(size)+1 {
+		rr.buf = make([]byte, int(size)+1)
+	}
+	buf := rr.buf[:size+1]
+	buf[0] = header[8]
+	if _, err := io.ReadFull(rr.r, buf[1:]); err != nil {
+		return record{}, errCorrupt
+	}
+	if crc32.Checksum(buf, castagnoli) != checksum {
+		return record{}, errCorrupt
+	}
+
+	rec := record{typ: buf[0]}
+	payload := buf[1:]
+	switch rec.typ {
+	case recordData:
+		rec.partition = int32(binary.LittleEndian.Uint32(payload))
+		if _, err := rec.md.UnmarshalMsg(payload[4:]); err != nil {
+			return record{}, errCorrupt
+		}
+	case recordSaved:
+		rec.t0 = binary.LittleEndian.Uint32(payload)
+		rec.key, err = schema.AMKeyFromString(string(payload[4:]))
+		if err != nil {
+			return record{}, errCorrupt
+		}
+	default:
+		return record{}, errCorrupt
+	}
+	return rec, nil
+}
+
+// maxRecordSize protects against allocating huge buffers for corrupt headers
+const maxRecordSize = 1024 * 1024
@@ -0,0 +1,76 @@
+package wal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func getTestMetricData(name string, ts int64) schema.MetricData {
+	md := schema.MetricData{
+		OrgId:    1,
+		Name:     name,
+		Interval: 10,
+		Value:    float64(ts),
+		Time:     ts,
+		Mtype:    "gauge",
+	}
+	md.SetId()
+	return md
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	md := getTestMetricData("a.b.c", 1234)
+	key, _ := schema.AMKeyFromString(md.Id + "_sum_600")
+
+	var buf []byte
+	buf, err := appendData(buf, &md, 3)
+	if err != nil {
+		t.Fatalf("failed to encode data record: %s", err.Error())
+	}
+	buf = appendSaved(buf, key, 1200)
+
+	reader := newRecordReader(bytes.NewReader(buf))
+	rec, err := reader.next()
+	if err != nil {
+		t.Fatalf("failed to read data record: %s", err.Error())
+	}
+	if rec.typ != recordData || rec.partition != 3 || rec.md.Id != md.Id || rec.md.Time != md.Time || rec.md.Value != md.Value {
+		t.Fatalf("unexpected data record %+v", rec)
+	}
+	rec, err = reader.next()
+	if err != nil {
+		t.Fatalf("failed to read saved record: %s", err.Error())
+	}
+	if rec.typ != recordSaved || rec.key != key || rec.t0 != 1200 {
+		t.Fatalf("unexpected saved record %+v", rec)
+	}
+	if _, err = reader.next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestRecordCorrupt(t *testing.T) {
+	md := getTestMetricData("a.b.c", 1234)
+	record, err := appendData(nil, &md, 0)
+	if err != nil {
+		t.Fatalf("failed to encode data record: %s", err.Error())
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated header", record[:headerSize-1]},
+		{"truncated payload", record[:len(record)-1]},
+		{"flipped bit", append(append([]byte{}, record[:len(record)-1]...), record[len(record)-1]^1)},
+	}
+	for _, c := range cases {
+		reader := newRecordReader(bytes.NewReader(c.data))
+		if _, err := reader.next(); err != errCorrupt {
+			t.Fatalf("%s: expected errCorrupt, got %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+// Package wal provides an on-disk write-ahead log for the inputs that can't replay data themselves, such as carbon and prometheus.
+// received data is appended to segment files before it is processed, and replayed at startup,
+// so that data that was not yet saved to the store survives a restart.
+//
+// besides the data, the log holds records of saved chunks, so that replaying the data does not make us save incomplete chunks
+// over complete ones. whenever a series first appears in a segment, the last saved chunks of the series are written to that segment first,
+// so that each segment holds the saved state of the series in it.
+//
+// segments are deleted, oldest first, once all of the data in them has been saved, including the rollups.
+// this is driven by the chunk save notifications of mdata.AggMetric.SyncChunkSaveState.
+package wal
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/input"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	Enabled       bool
+	dir           string
+	segmentSize   int
+	segmentMaxAge time.Duration
+	syncInterval  time.Duration
+
+	// metric input.wal.records_written is the number of records written to the write-ahead log
+	recordsWritten = stats.NewCounter32("input.wal.records_written")
+	// metric input.wal.bytes_written is the number of bytes written to the write-ahead log
+	bytesWritten = stats.NewCounter64("input.wal.bytes_written")
+	// metric input.wal.write_errors is the number of records that could not be written to the write-ahead log
+	writeErrors = stats.NewCounter32("input.wal.write_errors")
+	// metric input.wal.segments is the number of segment files of the write-ahead log
+	segmentsGauge = stats.NewGauge32("input.wal.segments")
+	// metric input.wal.replay.records is the number of records replayed from the write-ahead log at startup
+	replayedRecords = stats.NewCounter32("input.wal.replay.records")
+	// metric input.wal.replay.corrupt is the number of segments of which the tail could not be replayed because it was corrupt or incomplete
+	replayCorrupt = stats.NewCounter32("input.wal.replay.corrupt")
+)
+
+func ConfigSetup() {
+	walCfg := flag.NewFlagSet("wal", flag.ExitOnError)
+	walCfg.BoolVar(&Enabled, "enabled", false, "log data received by the carbon and prometheus inputs to disk, and replay it at startup, so that data not yet saved survives a restart")
+	walCfg.StringVar(&dir, "dir", "/var/lib/metrictank/wal", "directory to store the write-ahead log in")
+	walCfg.IntVar(&segmentSize, "segment-size", 64*1024*1024, "size in bytes after which a new segment file is started")
+	walCfg.DurationVar(&segmentMaxAge, "segment-max-age", 10*time.Minute, "maximum age of a segment file before a new one is started. only segments that are no longer written to can be deleted")
+	walCfg.DurationVar(&syncInterval, "sync-interval", time.Second, "how often to sync the write-ahead log to disk. data received in between may be lost in case of a crash. (0 syncs after every write)")
+	globalconf.Register("wal", walCfg, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !Enabled {
+		return
+	}
+	if segmentSize <= 0 {
+		log.Fatal("wal: segment-size must be > 0")
+	}
+	if segmentMaxAge <= 0 {
+		log.Fatal("wal: segment-max-age must be > 0")
+	}
+}
+
+type segment struct {
+	seq  uint64
+	path string
+
+	// maxTs of each series in the segment.
+	// for closed segments, series of which all data in the segment has been saved are removed.
+	series map[schema.MKey]uint32
+}
+
+func segmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d.wal", seq))
+}
+
+// WAL is the write-ahead log
+type WAL struct {
+	sync.Mutex
+	dir           string
+	segmentSize   int
+	segmentMaxAge time.Duration
+	syncInterval  time.Duration
+	metrics       mdata.Metrics
+
+	segments []*segment // oldest first. the last one is the segment being written to
+	file     *os.File
+	writer   *bufio.Writer
+	size     int                      // size of the current segment
+	created  time.Time                // creation time of the current segment
+	toReplay []*segment               // the segments that existed when we opened the log
+	buf      []byte                   // buffer to encode records into
+	dirty    map[schema.MKey]struct{} // series of which chunks have been saved since the last truncation
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New opens the write-ahead log in the configured directory
+func New(metrics mdata.Metrics) (*WAL, error) {
+	return Open(dir, segmentSize, segmentMaxAge, syncInterval, metrics)
+}
+
+// Open opens the write-ahead log in the given directory, and starts a new segment to write to.
+// the existing segments should be replayed using Replay before any data is written.
+func Open(dir string, segmentSize int, segmentMaxAge, syncInterval time.Duration, metrics mdata.Metrics) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		dir:           dir,
+		segmentSize:   segmentSize,
+		segmentMaxAge: segmentMaxAge,
+		syncInterval:  syncInterval,
+		metrics:       metrics,
+		dirty:         make(map[schema.MKey]struct{}),
+		shutdown:      make(chan struct{}),
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".wal") {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), ".wal"), 10, 64)
+		if err != nil {
+			log.Warnf("wal: ignoring unexpected file %q", f.Name())
+			continue
+		}
+		w.toReplay = append(w.toReplay, &segment{
+			seq:    seq,
+			path:   filepath.Join(dir, f.Name()),
+			series: make(map[schema.MKey]uint32),
+		})
+	}
+	sort.Slice(w.toReplay, func(i, j int) bool { return w.toReplay[i].seq < w.toReplay[j].seq })
+	w.segments = append(w.segments, w.toReplay...)
+
+	var seq uint64
+	if len(w.segments) > 0 {
+		seq = w.segments[len(w.segments)-1].seq + 1
+	}
+	if err := w.newSegment(seq); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Start starts syncing the log to disk and deleting segments that are no longer needed
+func (w *WAL) Start() {
+	w.wg.Add(1)
+	go w.run()
+}
+
+// Close syncs the log to disk and closes it
+func (w *WAL) Close() {
+	close(w.shutdown)
+	w.wg.Wait()
+	w.Lock()
+	w.sync()
+	w.file.Close()
+	w.Unlock()
+}
+
+func (w *WAL) run() {
+	defer w.wg.Done()
+	interval := w.syncInterval
+	if interval == 0 || interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastSync := time.Now()
+	for {
+		select {
+		case <-w.shutdown:
+			return
+		case now := <-ticker.C:
+			w.Lock()
+			if now.Sub(lastSync) >= w.syncInterval {
+				w.sync()
+				lastSync = now
+			}
+			if w.size > 0 && now.Sub(w.created) >= w.segmentMaxAge {
+				w.rotate()
+			}
+			w.Unlock()
+			w.truncate()
+		}
+	}
+}
+
+// newSegment starts a new segment with the given sequence number.
+// caller must hold lock, or be the only user of the log
+func (w *WAL) newSegment(seq uint64) error {
+	path := segmentPath(w.dir, seq)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.writer = bufio.NewWriterSize(file, 256*1024)
+	w.size = 0
+	w.created = time.Now()
+	w.segments = append(w.segments, &segment{
+		seq:    seq,
+		path:   path,
+		series: make(map[schema.MKey]uint32),
+	})
+	segmentsGauge.Set(len(w.segments))
+	return nil
+}
+
+// rotate closes the current segment and starts a new one.
+// caller must hold lock
+func (w *WAL) rotate() {
+	w.sync()
+	if err := w.file.Close(); err != nil {
+		log.Errorf("wal: failed to close segment %q: %s", w.file.Name(), err.Error())
+	}
+	cur := w.segments[len(w.segments)-1]
+	// series that were never saved, e.g. because they were invalid, must also be checked,
+	// so check all series of the segment on the next truncation.
+	for mkey := range cur.series {
+		w.dirty[mkey] = struct{}{}
+	}
+	if err := w.newSegment(cur.seq + 1); err != nil {
+		// without a segment to write to, there is nothing sensible we can do
+		log.Fatalf("wal: failed to create new segment: %s", err.Error())
+	}
+}
+
+// sync flushes the buffered records and syncs the current segment to disk.
+// caller must hold lock
+func (w *WAL) sync() {
+	if err := w.writer.Flush(); err != nil {
+		log.Errorf("wal: failed to write to segment %q: %s", w.file.Name(), err.Error())
+		return
+	}
+	if err := w.file.Sync(); err != nil {
+		log.Errorf("wal: failed to sync segment %q: %s", w.file.Name(), err.Error())
+	}
+}
+
+// write writes the records in w.buf to the current segment.
+// caller must hold lock
+func (w *WAL) write(records int) {
+	if len(w.buf) == 0 {
+		return
+	}
+	if _, err := w.writer.Write(w.buf); err != nil {
+		writeErrors.Add(records)
+		log.Errorf("wal: failed to write to segment %q: %s", w.file.Name(), err.Error())
+		return
+	}
+	recordsWritten.Add(records)
+	bytesWritten.AddUint64(uint64(len(w.buf)))
+	w.size += len(w.buf)
+	if w.syncInterval == 0 {
+		w.sync()
+	}
+	if w.size >= w.segmentSize {
+		w.rotate()
+	}
+}
+
+// Log writes the given MetricData to the log
+func (w *WAL) Log(md *schema.MetricData, partition int32) {
+	mkey, err := schema.MKeyFromString(md.Id)
+	if err != nil {
+		// will be rejected by the input handler
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	w.buf = w.buf[:0]
+	records := 1
+	cur := w.segments[len(w.segments)-1]
+	maxTs, ok := cur.series[mkey]
+	if !ok {
+		// first data of this series in this segment: it must be preceded by the saved state of the series
+		if m, ok := w.metrics.Get(mkey); ok {
+			for archive, t0 := range m.(*mdata.AggMetric).LastSaved() {
+				w.buf = appendSaved(w.buf, schema.AMKey{MKey: mkey, Archive: archive}, t0)
+				records++
+			}
+		}
+	}
+	w.buf, err = appendData(w.buf, md, partition)
+	if err != nil {
+		writeErrors.Inc()
+		log.Errorf("wal: failed to encode metric %q: %s", md.Id, err.Error())
+		return
+	}
+	if uint32(md.Time) > maxTs || !ok {
+		cur.series[mkey] = uint32(md.Time)
+	}
+	w.write(records)
+}
+
+// ChunkSaved records the saved chunk in the log, and marks the series to be checked for segments that are no longer needed.
+// it implements mdata.ChunkSaveListener
+func (w *WAL) ChunkSaved(key schema.AMKey, t0 uint32) {
+	w.Lock()
+	defer w.Unlock()
+	w.buf = appendSaved(w.buf[:0], key, t0)
+	w.write(1)
+	w.dirty[key.MKey] = struct{}{}
+}
+
+// truncate removes the series of which all data has been saved from the closed segments,
+// and deletes the oldest segments that no longer have any series left.
+func (w *WAL) truncate() {
+	w.Lock()
+	dirty := w.dirty
+	w.dirty = make(map[schema.MKey]struct{})
+	w.Unlock()
+
+	if len(dirty) == 0 {
+		return
+	}
+
+	// get the saved state without holding the lock, so we don't block ingestion
+	savedThrough := make(map[schema.MKey]uint32, len(dirty))
+	for mkey := range dirty {
+		m, ok := w.metrics.Get(mkey)
+		if !ok {
+			// the series is not in memory, so there's no data to save: it was either invalid, or has been saved and cleaned up.
+			savedThrough[mkey] = ^uint32(0)
+			continue
+		}
+		savedThrough[mkey] = m.(*mdata.AggMetric).SavedThrough()
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	for _, seg := range w.segments[:len(w.segments)-1] {
+		for mkey, through := range savedThrough {
+			if maxTs, ok := seg.series[mkey]; ok && maxTs < through {
+				delete(seg.series, mkey)
+			}
+		}
+	}
+	for len(w.segments) > 1 && len(w.segments[0].series) == 0 && !w.replaying(w.segments[0]) {
+		if err := os.Remove(w.segments[0].path); err != nil {
+			log.Errorf("wal: failed to delete segment %q: %s", w.segments[0].path, err.Error())
+			break
+		}
+		log.Debugf("wal: deleted segment %q", w.segments[0].path)
+		w.segments = w.segments[1:]
+	}
+	segmentsGauge.Set(len(w.segments))
+}
+
+// replaying returns whether the given segment still needs to be replayed.
+// caller must hold lock
+func (w *WAL) replaying(seg *segment) bool {
+	for _, s := range w.toReplay {
+		if s == seg {
+			return true
+		}
+	}
+	return false
+}
+
+// Replay feeds the data of the segments that existed when the log was opened to the given handler.
+// the saved state of the series is restored before their data is processed,
+// so that chunks which were already saved are not saved again with incomplete data.
+func (w *WAL) Replay(handler input.Handler) error {
+	pre := time.Now()
+
+	// first pass: collect the last saved chunk of every series archive
+	saved := make(map[schema.MKey]map[schema.Archive]uint32)
+	err := w.readSegments(func(seg *segment, rec record) {
+		if rec.typ != recordSaved {
+			return
+		}
+		archives, ok := saved[rec.key.MKey]
+		if !ok {
+			archives = make(map[schema.Archive]uint32)
+			saved[rec.key.MKey] = archives
+		}
+		if rec.t0 > archives[rec.key.Archive] {
+			archives[rec.key.Archive] = rec.t0
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// second pass: replay the data
+	var points int
+	err = w.readSegments(func(seg *segment, rec record) {
+		if rec.typ != recordData {
+			return
+		}
+		mkey, err := schema.MKeyFromString(rec.md.Id)
+		if err != nil {
+			return
+		}
+		handler.ProcessMetricData(&rec.md, rec.partition)
+		points++
+		// the series is created by its first point, which can't trigger a save, so it's safe to sync the saved state afterwards.
+		if archives, ok := saved[mkey]; ok {
+			if m, ok := w.metrics.Get(mkey); ok {
+				for archive, t0 := range archives {
+					m.(*mdata.AggMetric).SyncArchiveChunkSaveState(t0, archive)
+				}
+			}
+			delete(saved, mkey)
+		}
+		w.Lock()
+		if maxTs, ok := seg.series[mkey]; !ok || uint32(rec.md.Time) > maxTs {
+			seg.series[mkey] = uint32(rec.md.Time)
+		}
+		w.dirty[mkey] = struct{}{}
+		w.Unlock()
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Lock()
+	w.toReplay = nil
+	w.Unlock()
+	log.Infof("wal: replayed %d points in %s", points, time.Since(pre))
+	return nil
+}
+
+// readSegments calls fn for each record in the segments to replay
+func (w *WAL) readSegments(fn func(seg *segment, rec record)) error {
+	for _, seg := range w.toReplay {
+		file, err := os.Open(seg.path)
+		if err != nil {
+			return err
+		}
+		reader := newRecordReader(file)
+		for {
+			rec, err := reader.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// the tail of a segment may be incomplete if we crashed while writing it
+				replayCorrupt.Inc()
+				log.Warnf("wal: segment %q has a corrupt or incomplete record. skipping the rest of it", seg.path)
+				break
+			}
+			replayedRecords.Inc()
+			fn(seg, rec)
+		}
+		file.Close()
+	}
+	return nil
+}
+
+// Handler returns a handler that logs the MetricData it receives, and then passes it on to the given handler
+func (w *WAL) Handler(handler input.Handler) input.Handler {
+	return &walHandler{
+		Handler: handler,
+		wal:     w,
+	}
+}
+
+type walHandler struct {
+	input.Handler
+	wal *WAL
+}
+
+// ProcessMetricData logs the data before processing it.
+// note that ProcessMetricPoint is not logged: it can only be used by inputs that can replay data themselves.
+func (h *walHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	h.wal.Log(md, partition)
+	h.Handler.ProcessMetricData(md, partition)
+}
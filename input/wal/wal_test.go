@@ -0,0 +1,159 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/raintank/schema"
+	"github.com/raintank/schema/msg"
+)
+
+type mockHandler struct {
+	data []schema.MetricData
+}
+
+func (m *mockHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	m.data = append(m.data, *md)
+}
+
+func (m *mockHandler) ProcessMetricPoint(point schema.MetricPoint, format msg.Format, partition int32) {
+}
+
+func getWAL(t *testing.T, dir string, metrics mdata.Metrics) *WAL {
+	t.Helper()
+	w, err := Open(dir, 1024*1024, time.Hour, time.Hour, metrics)
+	if err != nil {
+		t.Fatalf("failed to open wal: %s", err.Error())
+	}
+	return w
+}
+
+func getMetrics() mdata.Metrics {
+	mdata.SetSingleSchema(conf.NewRetentionMT(10, 3600, 600, 2, 0), conf.NewRetentionMT(600, 86400, 3600, 2, 0))
+	mdata.SetSingleAgg(conf.Max)
+	return mdata.NewAggMetrics(nil, nil, false, 3600, 7200, 0)
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	metrics := getMetrics()
+	w := getWAL(t, dir, metrics)
+	if err := w.Replay(&mockHandler{}); err != nil {
+		t.Fatalf("failed to replay empty wal: %s", err.Error())
+	}
+	handler := &mockHandler{}
+	walHandler := w.Handler(handler)
+	for ts := int64(10); ts <= 50; ts += 10 {
+		md := getTestMetricData("a.b.c", ts)
+		walHandler.ProcessMetricData(&md, 0)
+	}
+	mkey, _ := schema.MKeyFromString(handler.data[0].Id)
+	w.ChunkSaved(schema.AMKey{MKey: mkey}, 0)
+	w.Close()
+
+	if len(handler.data) != 5 {
+		t.Fatalf("expected 5 points to be passed on, got %d", len(handler.data))
+	}
+
+	// "restart": the points must be replayed into a fresh set of metrics, with the saved state restored
+	metrics = getMetrics()
+	w = getWAL(t, dir, metrics)
+	defer w.Close()
+	replayHandler := &replayingHandler{metrics: metrics}
+	if err := w.Replay(replayHandler); err != nil {
+		t.Fatalf("failed to replay wal: %s", err.Error())
+	}
+	if replayHandler.count != 5 {
+		t.Fatalf("expected 5 points to be replayed, got %d", replayHandler.count)
+	}
+	if len(w.segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(w.segments))
+	}
+	if maxTs := w.segments[0].series[mkey]; maxTs != 50 {
+		t.Fatalf("expected maxTs 50 for the replayed series, got %d", maxTs)
+	}
+}
+
+// replayingHandler creates the series in metrics, like the default input handler would
+type replayingHandler struct {
+	metrics mdata.Metrics
+	count   int
+}
+
+func (r *replayingHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	mkey, _ := schema.MKeyFromString(md.Id)
+	m := r.metrics.GetOrCreate(mkey, 0, 0, uint32(md.Interval))
+	m.Add(uint32(md.Time), md.Value)
+	r.count++
+}
+
+func (r *replayingHandler) ProcessMetricPoint(point schema.MetricPoint, format msg.Format, partition int32) {
+}
+
+func TestTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	metrics := getMetrics()
+	w := getWAL(t, dir, metrics)
+	defer w.Close()
+	if err := w.Replay(&mockHandler{}); err != nil {
+		t.Fatalf("failed to replay empty wal: %s", err.Error())
+	}
+
+	saved := getTestMetricData("saved", 7210)
+	unsaved := getTestMetricData("unsaved", 7210)
+	savedKey, _ := schema.MKeyFromString(saved.Id)
+	unsavedKey, _ := schema.MKeyFromString(unsaved.Id)
+	metrics.GetOrCreate(unsavedKey, 0, 0, 10).Add(7210, 1)
+
+	w.Log(&saved, 0)
+	w.Log(&unsaved, 0)
+	w.Lock()
+	w.rotate()
+	w.Unlock()
+	w.truncate()
+
+	// the series not in memory has nothing left to save, but the other one does
+	if len(w.segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(w.segments))
+	}
+	if _, ok := w.segments[0].series[savedKey]; ok {
+		t.Fatalf("expected series without data in memory to be truncated")
+	}
+	if _, ok := w.segments[0].series[unsavedKey]; !ok {
+		t.Fatalf("expected series with unsaved data not to be truncated")
+	}
+
+	// once the chunk and its rollups have been saved, the segment can be deleted.
+	// the wal is not registered as a chunk save listener here, so notify it ourselves
+	m, _ := metrics.Get(unsavedKey)
+	m.(*mdata.AggMetric).SyncChunkSaveState(7200, false)()
+	w.ChunkSaved(schema.AMKey{MKey: unsavedKey}, 7200)
+	w.truncate()
+	if len(w.segments) != 2 {
+		t.Fatalf("expected 2 segments while the rollup is not saved, got %d", len(w.segments))
+	}
+	m.(*mdata.AggMetric).SyncArchiveChunkSaveState(7200, schema.NewArchive(schema.Max, 600))
+	w.ChunkSaved(schema.AMKey{MKey: unsavedKey, Archive: schema.NewArchive(schema.Max, 600)}, 7200)
+	path := w.segments[0].path
+	w.truncate()
+	if len(w.segments) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(w.segments))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected segment %q to be deleted, got %v", path, err)
+	}
+}
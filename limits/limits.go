@@ -0,0 +1,106 @@
+// Package limits holds the per-org limits, which are loaded from the tenant-limits.conf file
+// and reloaded whenever the file changes.
+package limits
+
+import (
+	"flag"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	Enabled        bool
+	limitsFile     string
+	reloadInterval time.Duration
+
+	// current holds the conf.TenantLimits in effect
+	current atomic.Value
+	// modTime of the file the current limits were read from
+	modTime time.Time
+
+	// metric limits.reload.success is the number of times the tenant limits file was successfully (re)loaded
+	reloadSuccess = stats.NewCounter32("limits.reload.success")
+	// metric limits.reload.fail is the number of times the tenant limits file could not be reloaded. the previous limits remain in effect
+	reloadFail = stats.NewCounter32("limits.reload.fail")
+)
+
+func init() {
+	current.Store(conf.NewTenantLimits())
+}
+
+func ConfigSetup() {
+	limitsCfg := flag.NewFlagSet("limits", flag.ExitOnError)
+	limitsCfg.BoolVar(&Enabled, "enabled", false, "enable per-org limits")
+	limitsCfg.StringVar(&limitsFile, "limits-file", "/etc/metrictank/tenant-limits.conf", "path to tenant-limits.conf file")
+	limitsCfg.DurationVar(&reloadInterval, "reload-interval", 30*time.Second, "how often to check the limits file for changes. (0 disables reloading)")
+	globalconf.Register("limits", limitsCfg, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !Enabled {
+		return
+	}
+	if err := load(); err != nil {
+		log.Fatalf("limits: can't read tenant-limits file %q: %s", limitsFile, err.Error())
+	}
+}
+
+// Start periodically reloads the limits file if it has changed
+func Start() {
+	if !Enabled || reloadInterval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(reloadInterval)
+		for range ticker.C {
+			info, err := os.Stat(limitsFile)
+			if err != nil {
+				reloadFail.Inc()
+				log.Errorf("limits: can't stat tenant-limits file %q, keeping current limits: %s", limitsFile, err.Error())
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			if err := load(); err != nil {
+				log.Errorf("limits: can't reload tenant-limits file %q, keeping current limits: %s", limitsFile, err.Error())
+				continue
+			}
+			log.Infof("limits: reloaded tenant-limits file %q", limitsFile)
+		}
+	}()
+}
+
+// load reads the limits file and puts the limits in effect
+func load() error {
+	info, err := os.Stat(limitsFile)
+	if err != nil {
+		reloadFail.Inc()
+		return err
+	}
+	tenantLimits, err := conf.ReadTenantLimits(limitsFile)
+	if err != nil {
+		reloadFail.Inc()
+		return err
+	}
+	Set(tenantLimits)
+	modTime = info.ModTime()
+	reloadSuccess.Inc()
+	return nil
+}
+
+// Set puts the given limits in effect
+func Set(tenantLimits conf.TenantLimits) {
+	current.Store(tenantLimits)
+}
+
+// Get returns the limits in effect for the given org
+func Get(orgId uint32) conf.Limits {
+	return current.Load().(conf.TenantLimits).Get(orgId)
+}
@@ -0,0 +1,67 @@
+package limits
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that allows bursts of up to 1 second worth of the rate.
+// the rate is passed in on every call, so that it follows reloads of the limits.
+type RateLimiter struct {
+	sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Allow returns whether n events are allowed at the given time, at the given rate per second.
+// if so, they are taken from the bucket. a rate of 0 allows everything.
+func (r *RateLimiter) Allow(now time.Time, rate, n int) bool {
+	if rate <= 0 {
+		return true
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.last.IsZero() {
+		r.tokens = float64(rate)
+	} else if now.After(r.last) {
+		r.tokens += now.Sub(r.last).Seconds() * float64(rate)
+	}
+	if r.tokens > float64(rate) {
+		r.tokens = float64(rate)
+	}
+	if !now.Before(r.last) {
+		r.last = now
+	}
+	if r.tokens < float64(n) {
+		return false
+	}
+	r.tokens -= float64(n)
+	return true
+}
+
+// RateLimiters holds a RateLimiter per org
+type RateLimiters struct {
+	sync.Mutex
+	orgs map[uint32]*RateLimiter
+}
+
+func NewRateLimiters() *RateLimiters {
+	return &RateLimiters{
+		orgs: make(map[uint32]*RateLimiter),
+	}
+}
+
+// Allow returns whether n events of the given org are allowed at the given time, at the given rate per second.
+func (r *RateLimiters) Allow(orgId uint32, now time.Time, rate, n int) bool {
+	if rate <= 0 {
+		return true
+	}
+	r.Lock()
+	l, ok := r.orgs[orgId]
+	if !ok {
+		l = &RateLimiter{}
+		r.orgs[orgId] = l
+	}
+	r.Unlock()
+	return l.Allow(now, rate, n)
+}
@@ -0,0 +1,31 @@
+package limits
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var r RateLimiter
+	now := time.Unix(1000, 0)
+	cases := []struct {
+		at   time.Duration // time since now
+		rate int
+		n    int
+		exp  bool
+	}{
+		{0, 10, 5, true},
+		{0, 10, 5, true},
+		{0, 10, 1, false}, // the burst of 10 is used up
+		{100 * time.Millisecond, 10, 1, true},
+		{100 * time.Millisecond, 10, 1, false},
+		{10 * time.Second, 10, 11, false}, // the bucket never holds more than 1 second worth
+		{10 * time.Second, 10, 10, true},
+		{10 * time.Second, 0, 1000, true}, // a rate of 0 disables the limit
+	}
+	for i, c := range cases {
+		if got := r.Allow(now.Add(c.at), c.rate, c.n); got != c.exp {
+			t.Fatalf("case %d: expected %t, got %t", i, c.exp, got)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package limits
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/grafana/metrictank/stats"
+)
+
+type rejectedKey struct {
+	orgId uint32
+	limit string
+}
+
+var (
+	rejectedLock sync.Mutex
+	rejected     = make(map[rejectedKey]*stats.Counter32)
+)
+
+// Rejected records that a request or data of the given org was rejected because it hit the given limit
+func Rejected(orgId uint32, limit string) {
+	RejectedN(orgId, limit, 1)
+}
+
+// RejectedN records that n requests or points of the given org were rejected because they hit the given limit
+func RejectedN(orgId uint32, limit string, n uint32) {
+	key := rejectedKey{orgId, limit}
+	rejectedLock.Lock()
+	c, ok := rejected[key]
+	if !ok {
+		// metric limits.org.%d.rejected.%s is the count of requests or points of an org that were rejected, per limit that was hit.
+		// e.g. `limits.org.12.rejected.max-reqs-per-sec`
+		c = stats.NewCounter32(fmt.Sprintf("limits.org.%d.rejected.%s", orgId, limit))
+		rejected[key] = c
+	}
+	rejectedLock.Unlock()
+	c.AddUint32(n)
+}
@@ -40,10 +40,17 @@ type AggMetric struct {
 	aggregators     []*Aggregator
 	dropFirstChunk  bool
 	ttl             uint32
-	lastSaveStart   uint32 // last chunk T0 that was added to the write Queue.
-	lastSaveFinish  uint32 // last chunk T0 successfully written to Cassandra.
-	lastWrite       uint32 // wall clock time of when last point was successfully added (possibly to the ROB)
-	firstTs         uint32 // timestamp of first point seen
+	lastSaveStart   uint32         // last chunk T0 that was added to the write Queue.
+	lastSaveFinish  uint32         // last chunk T0 successfully written to Cassandra.
+	lastWrite       uint32         // wall clock time of when last point was successfully added (possibly to the ROB)
+	firstTs         uint32         // timestamp of first point seen
+	late            []schema.Point // points too old for the chunk they belong to, to be merged into their chunks. see addLate
+
+	// chunks of which the write to the store hasn't completed yet, by t0. merges into chunks in the store build on them.
+	// see addToStore and mergeStore
+	pendingLock sync.Mutex
+	pending     map[uint32][]byte
+	mergeLock   sync.Mutex // serializes merges into the store
 }
 
 // NewAggMetric creates a metric with given key, it retains the given number of chunks each chunkSpan seconds long
@@ -94,7 +101,67 @@ func (a *AggMetric) SyncChunkSaveState(ts uint32, sendPersist bool) ChunkSaveCal
 		if sendPersist {
 			SendPersistMessage(a.key.String(), ts)
 		}
+		for _, l := range chunkSaveListeners {
+			l.ChunkSaved(a.key, ts)
+		}
+	}
+}
+
+// SyncArchiveChunkSaveState syncs the saved state of a chunk by its T0, for the given archive of this metric.
+func (a *AggMetric) SyncArchiveChunkSaveState(ts uint32, archive schema.Archive) {
+	if archive == 0 {
+		a.SyncChunkSaveState(ts, false)()
+		return
+	}
+	a.SyncAggregatedChunkSaveState(ts, consolidation.FromArchive(archive.Method()), archive.Span())
+}
+
+// LastSaved returns the T0 of the last saved chunk of each archive of this metric, for the archives that have any saved chunks.
+func (a *AggMetric) LastSaved() map[schema.Archive]uint32 {
+	saved := make(map[schema.Archive]uint32)
+	a.RLock()
+	if a.lastSaveFinish > 0 {
+		saved[a.key.Archive] = a.lastSaveFinish
 	}
+	a.RUnlock()
+
+	// no lock needed cause aggregators don't change at runtime
+	for _, agg := range a.aggregators {
+		for _, m := range agg.archives() {
+			for archive, t0 := range m.LastSaved() {
+				saved[archive] = t0
+			}
+		}
+	}
+	return saved
+}
+
+// SavedThrough returns the timestamp before which all data of this metric, including its rollups, has been saved.
+// 0 means no data may have been saved yet.
+func (a *AggMetric) SavedThrough() uint32 {
+	a.RLock()
+	var through uint32
+	if a.lastSaveFinish > 0 {
+		through = a.lastSaveFinish + a.chunkSpan
+	}
+	a.RUnlock()
+
+	// no lock needed cause aggregators don't change at runtime
+	for _, agg := range a.aggregators {
+		for _, m := range agg.archives() {
+			// an aggregated point reflects the data in the timeframe preceding it
+			aggThrough := m.SavedThrough()
+			if aggThrough > agg.span {
+				aggThrough -= agg.span
+			} else {
+				aggThrough = 0
+			}
+			if aggThrough < through {
+				through = aggThrough
+			}
+		}
+	}
+	return through
 }
 
 // Sync the saved state of a chunk by its T0.
@@ -413,7 +480,7 @@ func (a *AggMetric) persist(pos int) {
 	// before newer data.
 	for pendingChunk >= 0 {
 		log.Debugf("AM: persist(): sealing chunk %d/%d (%s:%d) and adding to write queue.", pendingChunk, len(pending), a.key, chunk.Series.T0)
-		a.store.Add(pending[pendingChunk])
+		a.addToStore(pending[pendingChunk])
 		pendingChunk--
 	}
 	persistDuration.Value(time.Now().Sub(pre))
@@ -440,7 +507,7 @@ func (a *AggMetric) Add(ts uint32, val float64) {
 					a.add(p.Ts, p.Val)
 				}
 			}
-		} else {
+		} else if err != mdataerrors.ErrMetricTooOld || !a.addLate(ts, val) {
 			log.Debugf("AM: failed to add metric to reorder buffer for %s. %s", a.key, err)
 			a.discardedMetricsInc(err)
 		}
@@ -480,6 +547,9 @@ func (a *AggMetric) add(ts uint32, val float64) {
 	if t0 == currentChunk.Series.T0 {
 		// last prior data was in same chunk as new point
 		if currentChunk.Series.Finished {
+			if a.addLate(ts, val) {
+				return
+			}
 			// if we've already 'finished' the chunk, it means it has the end-of-stream marker and any new points behind it wouldn't be read by an iterator
 			// you should monitor this metric closely, it indicates that maybe your GC settings don't match how you actually send data (too late)
 			discardedReceivedTooLate.Inc()
@@ -488,6 +558,9 @@ func (a *AggMetric) add(ts uint32, val float64) {
 		}
 
 		if err := currentChunk.Push(ts, val); err != nil {
+			if err == mdataerrors.ErrMetricTooOld && a.addLate(ts, val) {
+				return
+			}
 			log.Debugf("AM: failed to add metric to chunk for %s. %s", a.key, err)
 			a.discardedMetricsInc(err)
 			return
@@ -499,6 +572,9 @@ func (a *AggMetric) add(ts uint32, val float64) {
 			log.Debugf("AM: %s Add(): pushed new value to last chunk: %v", a.key, a.chunks[0])
 		}
 	} else if t0 < currentChunk.Series.T0 {
+		if a.addLate(ts, val) {
+			return
+		}
 		log.Debugf("AM: Point at %d has t0 %d, goes back into previous chunk. CurrentChunk t0: %d, LastTs: %d", ts, t0, currentChunk.Series.T0, currentChunk.Series.T)
 		discardedSampleOutOfOrder.Inc()
 		PromDiscardedSamples.WithLabelValues(sampleOutOfOrder, strconv.Itoa(int(a.key.MKey.Org))).Inc()
@@ -579,7 +655,8 @@ func (a *AggMetric) GC(now, chunkMinTs, metricMinTs uint32) (uint32, bool) {
 	defer a.Unlock()
 
 	// unless it looks like the AggMetric is collectable, abort and mark as not stale
-	if !a.collectable(now, chunkMinTs) {
+	// late points must be merged into their chunks first
+	if !a.collectable(now, chunkMinTs) || len(a.late) > 0 {
 		return 0, false
 	}
 
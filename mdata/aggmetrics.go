@@ -41,9 +41,35 @@ func NewAggMetrics(store Store, cachePusher cache.CachePusher, dropFirstChunk bo
 	if gcInterval > 0 {
 		go ms.GC()
 	}
+	if backfill && backfillInterval > 0 {
+		go ms.backfill()
+	}
 	return &ms
 }
 
+// periodically merge the late points of the metrics into their chunks
+func (ms *AggMetrics) backfill() {
+	ticker := time.NewTicker(backfillInterval)
+	for range ticker.C {
+		ms.flushLate()
+	}
+}
+
+// flushLate merges the late points of all metrics into their chunks
+func (ms *AggMetrics) flushLate() {
+	ms.RLock()
+	metrics := make([]*AggMetric, 0, len(ms.Metrics))
+	for _, org := range ms.Metrics {
+		for _, m := range org {
+			metrics = append(metrics, m)
+		}
+	}
+	ms.RUnlock()
+	for _, m := range metrics {
+		m.flushLate()
+	}
+}
+
 // periodically scan chunks and close any that have not received data in a while
 func (ms *AggMetrics) GC() {
 	for {
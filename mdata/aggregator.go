@@ -74,6 +74,17 @@ func NewAggregator(store Store, cachePusher cache.CachePusher, key schema.AMKey,
 	return aggregator
 }
 
+// archives returns the metrics of the rollup archives of this aggregator
+func (agg *Aggregator) archives() []*AggMetric {
+	var archives []*AggMetric
+	for _, m := range []*AggMetric{agg.minMetric, agg.maxMetric, agg.sumMetric, agg.cntMetric, agg.lstMetric} {
+		if m != nil {
+			archives = append(archives, m)
+		}
+	}
+	return archives
+}
+
 // flush adds points to the aggregation-series and resets aggregation state
 func (agg *Aggregator) flush() {
 	if agg.minMetric != nil {
@@ -116,6 +127,57 @@ func (agg *Aggregator) Add(ts uint32, val float64) {
 	}
 }
 
+// addLate adds a point that is older than the last point added, if it belongs to the bucket that is being worked on,
+// and returns whether it did. the rollup points of older buckets need to be recomputed instead.
+func (agg *Aggregator) addLate(ts uint32, val float64) bool {
+	if agg.agg.Cnt == 0 || AggBoundary(ts, agg.span) != agg.currentBoundary {
+		return false
+	}
+	// the last value is that of the newest point, which this is not
+	lst := agg.agg.Lst
+	agg.agg.Add(val)
+	agg.agg.Lst = lst
+	return true
+}
+
+// recompute computes the rollup points of the buckets with the given sorted boundaries from the sorted raw points,
+// and replaces the rollup points in the archives with them.
+func (agg *Aggregator) recompute(boundaries []uint32, raw []schema.Point) {
+	var min, max, sum, cnt, lst []schema.Point
+	var i int
+	for _, boundary := range boundaries {
+		a := NewAggregation()
+		for ; i < len(raw) && raw[i].Ts <= boundary; i++ {
+			if raw[i].Ts > boundary-agg.span {
+				a.Add(raw[i].Val)
+			}
+		}
+		if a.Cnt == 0 {
+			continue
+		}
+		min = append(min, schema.Point{Val: a.Min, Ts: boundary})
+		max = append(max, schema.Point{Val: a.Max, Ts: boundary})
+		sum = append(sum, schema.Point{Val: a.Sum, Ts: boundary})
+		cnt = append(cnt, schema.Point{Val: a.Cnt, Ts: boundary})
+		lst = append(lst, schema.Point{Val: a.Lst, Ts: boundary})
+	}
+	if agg.minMetric != nil {
+		agg.minMetric.backfill(min, true)
+	}
+	if agg.maxMetric != nil {
+		agg.maxMetric.backfill(max, true)
+	}
+	if agg.sumMetric != nil {
+		agg.sumMetric.backfill(sum, true)
+	}
+	if agg.cntMetric != nil {
+		agg.cntMetric.backfill(cnt, true)
+	}
+	if agg.lstMetric != nil {
+		agg.lstMetric.backfill(lst, true)
+	}
+}
+
 // GC returns whether all of the associated series are stale and can be removed, and their combined pointcount if so
 func (agg *Aggregator) GC(now, chunkMinTs, metricMinTs, lastWriteTime uint32) (uint32, bool) {
 	var points uint32
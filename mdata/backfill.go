@@ -0,0 +1,485 @@
+package mdata
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/mdata/chunk/tsz"
+	"github.com/grafana/metrictank/stats"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// set via ConfigProcess or from the unit tests
+	backfill          bool
+	backfillInterval  time.Duration
+	backfillMaxPoints int
+
+	// metric tank.backfill.points is the number of points that were too old for the reorder window and the chunk they belong to,
+	// and are merged into their chunks instead of being discarded
+	backfillPoints = stats.NewCounterRate32("tank.backfill.points")
+
+	// metric tank.backfill.chunks_rewritten is the number of chunks, in memory and in the store, that were rewritten to merge late points into them
+	backfillChunksRewritten = stats.NewCounter32("tank.backfill.chunks_rewritten")
+
+	// metric tank.backfill.discarded is the number of late points that were discarded when merging them into their chunks,
+	// because their timestamp already had a value, or their chunk could not be read from the store
+	backfillDiscarded = stats.NewCounterRate32("tank.backfill.discarded")
+)
+
+// addLate accepts a point that is too old for the chunk it belongs to, so it can be merged into its chunk later, and returns whether it did.
+// only raw series accept late points, their rollups are recomputed from them.
+// caller must hold lock
+func (a *AggMetric) addLate(ts uint32, val float64) bool {
+	if !backfill || a.key.Archive != 0 {
+		return false
+	}
+	if uint64(ts)+uint64(a.ttl) < uint64(time.Now().Unix()) {
+		return false
+	}
+	if backfillMaxPoints > 0 && len(a.late) >= backfillMaxPoints {
+		return false
+	}
+	a.late = append(a.late, schema.Point{Val: val, Ts: ts})
+	backfillPoints.Inc()
+	return true
+}
+
+// flushLate merges the late points into their chunks, in memory or in the store, and recomputes the rollup points of their buckets.
+// late points don't replace existing points with the same timestamp.
+func (a *AggMetric) flushLate() {
+	a.Lock()
+	if len(a.late) == 0 {
+		a.Unlock()
+		return
+	}
+	late := a.late
+	a.late = nil
+	sortPoints(late)
+	merged, older := a.merge(late, false)
+	rollups := a.lateRollups(older, merged)
+	a.Unlock()
+
+	a.mergeStore(older, false)
+	if len(rollups) == 0 {
+		return
+	}
+	ctx := opentracing.ContextWithSpan(context.Background(), opentracing.NoopTracer{}.StartSpan("backfill"))
+	for i, boundaries := range rollups {
+		if len(boundaries) == 0 {
+			continue
+		}
+		agg := a.aggregators[i]
+		raw, err := a.rawPoints(ctx, boundaries[0]-agg.span+1, boundaries[len(boundaries)-1]+1)
+		if err != nil {
+			log.Errorf("AM: %s failed to read raw data to recompute %d rollup points of aggregator %d: %s", a.key, len(boundaries), agg.span, err.Error())
+			continue
+		}
+		// the late points merged into chunks in the store may not have been saved yet
+		raw, _ = mergePoints(raw, older, false)
+		agg.recompute(boundaries, raw)
+	}
+}
+
+// backfill merges the points into their chunks, in memory or in the store, replacing existing points with the same timestamp if replace is set.
+func (a *AggMetric) backfill(points []schema.Point, replace bool) {
+	if len(points) == 0 {
+		return
+	}
+	a.Lock()
+	_, older := a.merge(points, replace)
+	a.Unlock()
+	a.mergeStore(older, replace)
+}
+
+// lateRollups adds the merged late points to the buckets the aggregators are still working on.
+// for the other buckets, which have been flushed already, it returns the boundaries per aggregator, so their rollup points can be recomputed.
+// caller must hold lock
+func (a *AggMetric) lateRollups(older, merged []schema.Point) [][]uint32 {
+	if len(older)+len(merged) == 0 || len(a.aggregators) == 0 {
+		return nil
+	}
+	rollups := make([][]uint32, len(a.aggregators))
+	for i, agg := range a.aggregators {
+		for _, points := range [][]schema.Point{older, merged} {
+			for _, p := range points {
+				if agg.addLate(p.Ts, p.Val) {
+					continue
+				}
+				boundary := AggBoundary(p.Ts, agg.span)
+				if boundary > agg.currentBoundary {
+					// the aggregator hasn't seen data this new, it will add the point when it gets there
+					continue
+				}
+				if n := len(rollups[i]); n == 0 || rollups[i][n-1] != boundary {
+					rollups[i] = append(rollups[i], boundary)
+				}
+			}
+		}
+	}
+	return rollups
+}
+
+// merge merges the sorted points into their chunks in memory, and returns which points were merged into chunks,
+// and which points are too old for the chunks in memory, and need to be merged into the chunks in the store.
+// points that are newer than the data in memory are added as usual.
+// chunks that have been saved already, are saved again.
+// caller must hold lock
+func (a *AggMetric) merge(points []schema.Point, replace bool) ([]schema.Point, []schema.Point) {
+	var merged, older []schema.Point
+	var rewrite []schema.Point
+	for _, p := range points {
+		if len(a.chunks) == 0 {
+			a.add(p.Ts, p.Val)
+			continue
+		}
+		current := a.chunks[a.currentChunkPos]
+		t0 := p.Ts - (p.Ts % a.chunkSpan)
+		if t0 > current.Series.T0 || (t0 == current.Series.T0 && !current.Series.Finished && p.Ts > current.Series.T) {
+			a.add(p.Ts, p.Val)
+			continue
+		}
+		rewrite = append(rewrite, p)
+	}
+	if len(rewrite) == 0 {
+		return nil, nil
+	}
+
+	chunks := a.orderedChunks()
+	oldest := chunks[0]
+	var inserted, rewritten bool
+	for len(rewrite) > 0 {
+		t0 := rewrite[0].Ts - (rewrite[0].Ts % a.chunkSpan)
+		n := 1
+		for n < len(rewrite) && rewrite[n].Ts-(rewrite[n].Ts%a.chunkSpan) == t0 {
+			n++
+		}
+		group := rewrite[:n]
+		rewrite = rewrite[n:]
+
+		if t0 < oldest.Series.T0 {
+			older = append(older, group...)
+			continue
+		}
+		if t0 == oldest.Series.T0 && oldest.First {
+			// the first chunk only has the data from when we started, earlier data is in the store
+			i := sort.Search(len(group), func(i int) bool { return group[i].Ts >= a.firstTs })
+			older = append(older, group[:i]...)
+			group = group[i:]
+			if len(group) == 0 {
+				continue
+			}
+		}
+
+		i := sort.Search(len(chunks), func(i int) bool { return chunks[i].Series.T0 >= t0 })
+		var existing []schema.Point
+		var old *chunk.Chunk
+		if i < len(chunks) && chunks[i].Series.T0 == t0 {
+			old = chunks[i]
+			existing = iterPoints(old.Series.Iter(), 0, 0)
+		}
+		points, kept := mergePoints(existing, group, replace)
+		backfillDiscarded.Add(len(group) - len(kept))
+		if len(kept) == 0 {
+			continue
+		}
+		merged = append(merged, kept...)
+
+		var c *chunk.Chunk
+		if old != nil && old.First {
+			c = chunk.NewFirst(t0)
+		} else {
+			c = chunk.New(t0)
+		}
+		for _, p := range points {
+			c.Push(p.Ts, p.Val)
+		}
+		if old != nil {
+			totalPoints.DecUint64(uint64(old.NumPoints))
+			chunks[i] = c
+		} else {
+			// there was no data for this chunk yet
+			chunkCreate.Inc()
+			chunks = append(chunks, nil)
+			copy(chunks[i+1:], chunks[i:])
+			chunks[i] = c
+			inserted = true
+		}
+		totalPoints.Add(int(c.NumPoints))
+		backfillChunksRewritten.Inc()
+
+		if old == nil || old.Series.Finished {
+			c.Finish()
+			rewritten = true
+			if a.store != nil && cluster.Manager.IsPrimary() && a.lastSaveStart >= t0 {
+				cwr := NewChunkWriteRequest(nil, a.key, a.ttl, t0, c.Encode(a.chunkSpan), time.Now())
+				a.addToStore(&cwr)
+			}
+		}
+	}
+
+	if inserted && len(chunks) > int(a.numChunks) {
+		// the oldest chunk has been saved already, if we are primary
+		chunkClear.Inc()
+		totalPoints.DecUint64(uint64(chunks[0].NumPoints))
+		chunks = chunks[1:]
+	}
+	if inserted {
+		a.chunks = chunks
+		a.currentChunkPos = len(chunks) - 1
+	} else {
+		for i, c := range chunks {
+			a.chunks[(a.currentChunkPos+1+i)%len(a.chunks)] = c
+		}
+	}
+	if rewritten && a.cachePusher != nil {
+		// the chunk cache may hold the old versions of the rewritten chunks
+		a.cachePusher.DelMetric(a.key.MKey)
+	}
+	return merged, older
+}
+
+// orderedChunks returns the chunks in memory, from oldest to newest
+// caller must hold lock
+func (a *AggMetric) orderedChunks() []*chunk.Chunk {
+	chunks := make([]*chunk.Chunk, 0, len(a.chunks)+1)
+	for i := range a.chunks {
+		chunks = append(chunks, a.chunks[(a.currentChunkPos+1+i)%len(a.chunks)])
+	}
+	return chunks
+}
+
+// mergeStore merges the sorted points into their chunks in the store.
+// chunks that are still in the write queue are merged into instead of the versions in the store,
+// and merges are serialized, so that they don't overwrite each other.
+// only the primary writes to the store.
+func (a *AggMetric) mergeStore(points []schema.Point, replace bool) {
+	if len(points) == 0 || a.store == nil || !cluster.Manager.IsPrimary() {
+		return
+	}
+	a.mergeLock.Lock()
+	defer a.mergeLock.Unlock()
+	ctx := opentracing.ContextWithSpan(context.Background(), opentracing.NoopTracer{}.StartSpan("backfill"))
+	for len(points) > 0 {
+		t0 := points[0].Ts - (points[0].Ts % a.chunkSpan)
+		n := 1
+		for n < len(points) && points[n].Ts-(points[n].Ts%a.chunkSpan) == t0 {
+			n++
+		}
+		group := points[:n]
+		points = points[n:]
+
+		existing, err := a.storedPoints(ctx, t0, t0+a.chunkSpan)
+		if err != nil {
+			log.Errorf("AM: %s failed to read chunk %d from the store, to merge %d late points into it: %s", a.key, t0, len(group), err.Error())
+			backfillDiscarded.Add(len(group))
+			continue
+		}
+		merged, kept := mergePoints(existing, group, replace)
+		backfillDiscarded.Add(len(group) - len(kept))
+		if len(kept) == 0 {
+			continue
+		}
+		c := chunk.New(t0)
+		for _, p := range merged {
+			c.Push(p.Ts, p.Val)
+		}
+		c.Finish()
+		cwr := NewChunkWriteRequest(nil, a.key, a.ttl, t0, c.Encode(a.chunkSpan), time.Now())
+		a.addToStore(&cwr)
+		backfillChunksRewritten.Inc()
+	}
+	if a.cachePusher != nil {
+		a.cachePusher.DelMetric(a.key.MKey)
+	}
+}
+
+// addToStore adds the chunk to the store, and tracks it until it has been saved, so that merges build on it
+func (a *AggMetric) addToStore(cwr *ChunkWriteRequest) {
+	data := cwr.Data
+	a.pendingLock.Lock()
+	if a.pending == nil {
+		a.pending = make(map[uint32][]byte)
+	}
+	a.pending[cwr.T0] = data
+	a.pendingLock.Unlock()
+
+	callback := cwr.Callback
+	cwr.Callback = func() {
+		a.pendingLock.Lock()
+		// unless the chunk has been written again since
+		if d, ok := a.pending[cwr.T0]; ok && &d[0] == &data[0] {
+			delete(a.pending, cwr.T0)
+		}
+		a.pendingLock.Unlock()
+		if callback != nil {
+			callback()
+		}
+	}
+	a.store.Add(cwr)
+}
+
+// pendingPoints returns the points from (inclusive) to (exclusive) of the chunks of which the write to the store hasn't completed yet, by t0
+func (a *AggMetric) pendingPoints(from, to uint32) (map[uint32][]schema.Point, error) {
+	a.pendingLock.Lock()
+	defer a.pendingLock.Unlock()
+	var res map[uint32][]schema.Point
+	for t0, data := range a.pending {
+		if t0 >= to || t0+a.chunkSpan <= from {
+			continue
+		}
+		itgen, err := chunk.NewIterGen(t0, a.key.Archive.Span(), data)
+		if err != nil {
+			return nil, err
+		}
+		it, err := itgen.Get()
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			res = make(map[uint32][]schema.Point)
+		}
+		res[t0] = iterPoints(it, from, to)
+	}
+	return res, nil
+}
+
+// storedPoints returns the points in the store from (inclusive) to (exclusive).
+// chunks that are still in the write queue are used instead of the versions in the store.
+func (a *AggMetric) storedPoints(ctx context.Context, from, to uint32) ([]schema.Point, error) {
+	if a.store == nil {
+		return nil, nil
+	}
+	points, err := StoredPoints(ctx, a.store, a.key, a.ttl, from, to)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := a.pendingPoints(from, to)
+	if err != nil || len(pending) == 0 {
+		return points, err
+	}
+	out := make([]schema.Point, 0, len(points))
+	for _, p := range points {
+		if _, ok := pending[p.Ts-(p.Ts%a.chunkSpan)]; !ok {
+			out = append(out, p)
+		}
+	}
+	for _, p := range pending {
+		out = append(out, p...)
+	}
+	sortPoints(out)
+	return out, nil
+}
+
+// StoredPoints returns the points of the series in the store from (inclusive) to (exclusive).
+// if the store has several versions of a chunk, the last one is used.
+func StoredPoints(ctx context.Context, store Store, key schema.AMKey, ttl, from, to uint32) ([]schema.Point, error) {
+	itgens, err := store.Search(ctx, key, ttl, from, to)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[uint32]int)
+	var t0s []uint32
+	for i, itgen := range itgens {
+		if _, ok := latest[itgen.T0]; !ok {
+			t0s = append(t0s, itgen.T0)
+		}
+		latest[itgen.T0] = i
+	}
+	sort.Slice(t0s, func(i, j int) bool { return t0s[i] < t0s[j] })
+	var points []schema.Point
+	for _, t0 := range t0s {
+		it, err := itgens[latest[t0]].Get()
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, iterPoints(it, from, to)...)
+	}
+	return points, nil
+}
+
+// rawPoints returns the points of the series from (inclusive) to (exclusive), from memory and the store
+func (a *AggMetric) rawPoints(ctx context.Context, from, to uint32) ([]schema.Point, error) {
+	res, err := a.Get(from, to)
+	if err != nil {
+		return nil, err
+	}
+	var points []schema.Point
+	if res.Oldest > from {
+		until := to
+		if res.Oldest < until {
+			until = res.Oldest
+		}
+		points, err = a.storedPoints(ctx, from, until)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, it := range res.Iters {
+		points = append(points, iterPoints(it, from, to)...)
+	}
+	for _, p := range res.Points {
+		if p.Ts >= from && p.Ts < to {
+			points = append(points, p)
+		}
+	}
+	sortPoints(points)
+	points, _ = mergePoints(nil, points, false)
+	return points, nil
+}
+
+// iterPoints returns the points of the iterator from (inclusive) to (exclusive). to 0 means no limit.
+func iterPoints(it tsz.Iter, from, to uint32) []schema.Point {
+	var points []schema.Point
+	for it.Next() {
+		ts, val := it.Values()
+		if ts < from || (to > 0 && ts >= to) {
+			continue
+		}
+		points = append(points, schema.Point{Val: val, Ts: ts})
+	}
+	return points
+}
+
+// mergePoints merges the sorted new points into the sorted existing points, and returns the result
+// along with the new points that made it into the result.
+// new points replace existing points with the same timestamp if replace is set, otherwise they are discarded.
+func mergePoints(existing, new []schema.Point, replace bool) ([]schema.Point, []schema.Point) {
+	out := make([]schema.Point, 0, len(existing)+len(new))
+	kept := make([]schema.Point, 0, len(new))
+	var i, j int
+	for i < len(existing) || j < len(new) {
+		if j == len(new) || (i < len(existing) && existing[i].Ts <= new[j].Ts) {
+			out = append(out, existing[i])
+			i++
+			continue
+		}
+		p := new[j]
+		j++
+		n := len(out)
+		if n > 0 && out[n-1].Ts == p.Ts {
+			if !replace {
+				continue
+			}
+			out[n-1] = p
+			if m := len(kept); m > 0 && kept[m-1].Ts == p.Ts {
+				kept[m-1] = p
+				continue
+			}
+		} else {
+			out = append(out, p)
+		}
+		kept = append(kept, p)
+	}
+	return out, kept
+}
+
+// sortPoints sorts the points by timestamp, keeping the order of points with the same timestamp
+func sortPoints(points []schema.Point) {
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Ts < points[j].Ts })
+}
@@ -0,0 +1,271 @@
+package mdata
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/test"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/schema"
+)
+
+func enableBackfill(t *testing.T) func() {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	mockstore.Reset()
+	backfill, backfillMaxPoints = true, 0
+	return func() {
+		backfill, backfillMaxPoints = false, 0
+		mockstore.Reset()
+	}
+}
+
+// getPoints returns the points of the metric from (inclusive) to (exclusive), from memory and the store
+func getPoints(t *testing.T, a *AggMetric, from, to uint32) []schema.Point {
+	ctx := opentracing.ContextWithSpan(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+	points, err := a.rawPoints(ctx, from, to)
+	if err != nil {
+		t.Fatalf("failed to get points: %s", err)
+	}
+	return points
+}
+
+// series returns points every 10s from (inclusive) to (exclusive), skipping the given timestamps
+func series(from, to uint32, skip ...uint32) []schema.Point {
+	var points []schema.Point
+	for ts := from; ts < to; ts += 10 {
+		skipped := false
+		for _, s := range skip {
+			skipped = skipped || s == ts
+		}
+		if !skipped {
+			points = append(points, schema.Point{Val: float64(ts), Ts: ts})
+		}
+	}
+	return points
+}
+
+func TestBackfillMemory(t *testing.T) {
+	defer enableBackfill(t)()
+
+	now := uint32(time.Now().Unix())
+	base := now - now%60 - 600
+	mockCache := cache.NewMockCache()
+	ret := []conf.Retention{conf.NewRetentionMT(10, 86400, 60, 10, 0)}
+	agg := NewAggMetric(mockstore, mockCache, test.GetAMKey(42), ret, 0, 10, nil, false)
+
+	for _, p := range series(base+10, base+260, base+70, base+80, base+200, base+240) {
+		agg.Add(p.Ts, p.Val)
+	}
+	// two chunks that were saved already, and the current chunk
+	for _, ts := range []uint32{base + 70, base + 80, base + 200, base + 240} {
+		agg.Add(ts, float64(ts))
+	}
+	// points that already have a value are discarded
+	agg.Add(base+90, 0)
+	items := mockstore.Items()
+
+	agg.flushLate()
+
+	exp := series(base+10, base+260)
+	if got := getPoints(t, agg, base, base+300); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	// only the chunks that were saved already, are saved again
+	if mockstore.Items() != items+2 {
+		t.Fatalf("expected 2 chunks to be saved again, got %d", mockstore.Items()-items)
+	}
+	if len(mockCache.DelMetricKeys) == 0 {
+		t.Fatalf("expected the rewritten chunks to be deleted from the cache")
+	}
+	if len(agg.late) != 0 {
+		t.Fatalf("expected late points to be merged, got %d pending", len(agg.late))
+	}
+}
+
+func TestBackfillStore(t *testing.T) {
+	defer enableBackfill(t)()
+
+	now := uint32(time.Now().Unix())
+	base := now - now%60 - 600
+	ret := []conf.Retention{conf.NewRetentionMT(10, 86400, 60, 2, 0)}
+	agg := NewAggMetric(mockstore, cache.NewMockCache(), test.GetAMKey(42), ret, 0, 10, nil, false)
+
+	for _, p := range series(base+10, base+310, base+70, base+200) {
+		agg.Add(p.Ts, p.Val)
+	}
+	// the chunk of base+70 is only in the store, the chunk of base+200 is still in memory
+	agg.Add(base+70, float64(base+70))
+	agg.Add(base+200, float64(base+200))
+	agg.flushLate()
+
+	exp := series(base+60, base+120)
+	if got := getPoints(t, agg, base+60, base+120); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	exp = series(base+180, base+240)
+	if got := getPoints(t, agg, base+180, base+240); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+// queuedStore is a MockStore that only saves the chunks added to it when flushed, like a store with a write queue
+type queuedStore struct {
+	*MockStore
+	queue []*ChunkWriteRequest
+}
+
+func (s *queuedStore) Add(cwr *ChunkWriteRequest) {
+	s.queue = append(s.queue, cwr)
+}
+
+func (s *queuedStore) flush() {
+	for _, cwr := range s.queue {
+		s.MockStore.Add(cwr)
+		if cwr.Callback != nil {
+			cwr.Callback()
+		}
+	}
+	s.queue = nil
+}
+
+func TestBackfillStorePending(t *testing.T) {
+	defer enableBackfill(t)()
+
+	now := uint32(time.Now().Unix())
+	base := now - now%60 - 600
+	store := &queuedStore{MockStore: mockstore}
+	ret := []conf.Retention{conf.NewRetentionMT(10, 86400, 60, 2, 0)}
+	agg := NewAggMetric(store, cache.NewMockCache(), test.GetAMKey(42), ret, 0, 10, nil, false)
+
+	for _, p := range series(base+10, base+310, base+70, base+80) {
+		agg.Add(p.Ts, p.Val)
+	}
+	// the chunk of base+70 and base+80 is only in the write queue, and is merged into twice before it is saved
+	agg.Add(base+70, float64(base+70))
+	agg.flushLate()
+	agg.Add(base+80, float64(base+80))
+	agg.flushLate()
+
+	exp := series(base+60, base+120)
+	if got := getPoints(t, agg, base+60, base+120); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected the pending chunk to be used before it is saved: expected %v, got %v", exp, got)
+	}
+	store.flush()
+	if len(agg.pending) != 0 {
+		t.Fatalf("expected no pending chunks after they were saved, got %d", len(agg.pending))
+	}
+	if got := getPoints(t, agg, base+60, base+120); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected the last write to contain all merged points: expected %v, got %v", exp, got)
+	}
+}
+
+func TestBackfillSecondary(t *testing.T) {
+	defer enableBackfill(t)()
+	cluster.Manager.SetPrimary(false)
+
+	now := uint32(time.Now().Unix())
+	base := now - now%60 - 600
+	ret := []conf.Retention{conf.NewRetentionMT(10, 86400, 60, 10, 0)}
+	agg := NewAggMetric(mockstore, cache.NewMockCache(), test.GetAMKey(42), ret, 0, 10, nil, false)
+
+	for _, p := range series(base+10, base+250, base+70) {
+		agg.Add(p.Ts, p.Val)
+	}
+	agg.Add(base+70, float64(base+70))
+	agg.flushLate()
+
+	// nothing was saved, so only read what is in memory
+	exp := series(base+10, base+250)
+	if got := getPoints(t, agg, base+10, base+300); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if mockstore.Items() != 0 {
+		t.Fatalf("expected secondary not to save chunks, got %d", mockstore.Items())
+	}
+}
+
+func TestBackfillRollups(t *testing.T) {
+	defer enableBackfill(t)()
+
+	now := uint32(time.Now().Unix())
+	base := now - now%600 - 1200
+	ret := conf.Retentions{
+		conf.NewRetentionMT(10, 86400, 60, 10, 0),
+		conf.NewRetentionMT(60, 86400, 600, 2, 0),
+	}
+	aggs := &conf.Aggregation{AggregationMethod: []conf.Method{conf.Avg}}
+	agg := NewAggMetric(mockstore, cache.NewMockCache(), test.GetAMKey(42), ret, 0, 10, aggs, false)
+
+	for _, p := range series(base+10, base+225, base+70, base+210) {
+		agg.Add(p.Ts, p.Val)
+	}
+	// a bucket that was flushed already, and the bucket that is being aggregated
+	agg.Add(base+70, float64(base+70))
+	agg.Add(base+210, float64(base+210))
+	agg.flushLate()
+	// complete the current bucket
+	for _, p := range series(base+230, base+250) {
+		agg.Add(p.Ts, p.Val)
+	}
+
+	// the rollup chunks were not saved yet, so only read what is in memory
+	rollup := agg.aggregators[0]
+	var expSum, expCnt []schema.Point
+	for boundary := base + 60; boundary <= base+240; boundary += 60 {
+		var sum float64
+		for _, p := range series(boundary-50, boundary+10) {
+			sum += p.Val
+		}
+		expSum = append(expSum, schema.Point{Val: sum, Ts: boundary})
+		expCnt = append(expCnt, schema.Point{Val: 6, Ts: boundary})
+	}
+	if got := getPoints(t, rollup.sumMetric, base+60, base+300); !reflect.DeepEqual(got, expSum) {
+		t.Fatalf("expected sum %v, got %v", expSum, got)
+	}
+	if got := getPoints(t, rollup.cntMetric, base+60, base+300); !reflect.DeepEqual(got, expCnt) {
+		t.Fatalf("expected cnt %v, got %v", expCnt, got)
+	}
+}
+
+func TestBackfillDisabled(t *testing.T) {
+	defer enableBackfill(t)()
+	backfill = false
+
+	now := uint32(time.Now().Unix())
+	base := now - now%60 - 600
+	ret := []conf.Retention{conf.NewRetentionMT(10, 86400, 60, 10, 0)}
+	agg := NewAggMetric(mockstore, cache.NewMockCache(), test.GetAMKey(42), ret, 0, 10, nil, false)
+
+	for _, p := range series(base+10, base+250, base+70) {
+		agg.Add(p.Ts, p.Val)
+	}
+	agg.Add(base+70, float64(base+70))
+	if len(agg.late) != 0 {
+		t.Fatalf("expected late point to be discarded")
+	}
+}
+
+func TestMergePoints(t *testing.T) {
+	existing := []schema.Point{{Val: 1, Ts: 10}, {Val: 2, Ts: 20}, {Val: 4, Ts: 40}}
+	new := []schema.Point{{Val: 0, Ts: 5}, {Val: 20, Ts: 20}, {Val: 3, Ts: 30}, {Val: 5, Ts: 50}, {Val: 50, Ts: 50}}
+
+	out, kept := mergePoints(existing, new, false)
+	expOut := []schema.Point{{Val: 0, Ts: 5}, {Val: 1, Ts: 10}, {Val: 2, Ts: 20}, {Val: 3, Ts: 30}, {Val: 4, Ts: 40}, {Val: 5, Ts: 50}}
+	expKept := []schema.Point{{Val: 0, Ts: 5}, {Val: 3, Ts: 30}, {Val: 5, Ts: 50}}
+	if !reflect.DeepEqual(out, expOut) || !reflect.DeepEqual(kept, expKept) {
+		t.Fatalf("expected %v and %v, got %v and %v", expOut, expKept, out, kept)
+	}
+
+	out, kept = mergePoints(existing, new, true)
+	expOut = []schema.Point{{Val: 0, Ts: 5}, {Val: 1, Ts: 10}, {Val: 20, Ts: 20}, {Val: 3, Ts: 30}, {Val: 4, Ts: 40}, {Val: 50, Ts: 50}}
+	expKept = []schema.Point{{Val: 0, Ts: 5}, {Val: 20, Ts: 20}, {Val: 3, Ts: 30}, {Val: 50, Ts: 50}}
+	if !reflect.DeepEqual(out, expOut) || !reflect.DeepEqual(kept, expKept) {
+		t.Fatalf("expected %v and %v, got %v and %v", expOut, expKept, out, kept)
+	}
+}
@@ -0,0 +1,127 @@
+package accnt
+
+import (
+	"container/list"
+)
+
+const (
+	arcT1 = iota // keys that have been used once
+	arcT2        // keys that have been used more than once
+	arcB1        // ghost keys, recently evicted from t1
+	arcB2        // ghost keys, recently evicted from t2
+)
+
+// ARC implements the adaptive replacement cache policy.
+// It keeps the keys that have been used once (t1) apart from the ones that have been used more often (t2),
+// and remembers the keys that have recently been evicted from either of them (the ghost lists b1 and b2).
+// When an evicted key gets added again, the target size of t1 is adapted in favor of the list it was
+// evicted from. This way it balances between recency and frequency, depending on the workload.
+// As the cache is limited by its size in bytes rather than by its number of chunks, the capacity
+// is the number of keys in t1 and t2.
+type ARC struct {
+	lists [4]*list.List
+	items map[interface{}]*arcItem
+	p     int // the target size of t1
+}
+
+type arcItem struct {
+	key  interface{}
+	list int
+	elem *list.Element
+}
+
+func NewARC() *ARC {
+	a := &ARC{}
+	a.reset()
+	return a
+}
+
+// size is the number of keys that are in the cache
+func (a *ARC) size() int {
+	return a.lists[arcT1].Len() + a.lists[arcT2].Len()
+}
+
+func (a *ARC) move(item *arcItem, l int) {
+	a.lists[item.list].Remove(item.elem)
+	item.list = l
+	item.elem = a.lists[l].PushFront(item)
+}
+
+func (a *ARC) touch(key interface{}) {
+	item, ok := a.items[key]
+	if !ok {
+		item = &arcItem{key: key, list: arcT1}
+		item.elem = a.lists[arcT1].PushFront(item)
+		a.items[key] = item
+		return
+	}
+	b1, b2 := a.lists[arcB1].Len(), a.lists[arcB2].Len()
+	switch item.list {
+	case arcB1:
+		// evicted from t1 too early: give t1 more room
+		a.p += ratio(b2, b1)
+		if a.p > a.size()+1 {
+			a.p = a.size() + 1
+		}
+	case arcB2:
+		// evicted from t2 too early: give t2 more room
+		a.p -= ratio(b1, b2)
+		if a.p < 0 {
+			a.p = 0
+		}
+	}
+	a.move(item, arcT2)
+}
+
+func (a *ARC) del(key interface{}) {
+	if item, ok := a.items[key]; ok {
+		a.lists[item.list].Remove(item.elem)
+		delete(a.items, key)
+	}
+}
+
+func (a *ARC) pop() interface{} {
+	t1, t2 := a.lists[arcT1], a.lists[arcT2]
+	var from, to int
+	switch {
+	case t1.Len() > 0 && (t1.Len() > a.p || t2.Len() == 0):
+		from, to = arcT1, arcB1
+	case t2.Len() > 0:
+		from, to = arcT2, arcB2
+	default:
+		return nil
+	}
+	item := a.lists[from].Back().Value.(*arcItem)
+	a.move(item, to)
+	a.trimGhosts()
+	return item.key
+}
+
+// trimGhosts makes sure we don't remember more evicted keys than there are keys in the cache
+func (a *ARC) trimGhosts() {
+	t1, b1, b2 := a.lists[arcT1], a.lists[arcB1], a.lists[arcB2]
+	for b1.Len()+b2.Len() > a.size() {
+		l := b2
+		if b1.Len() > 0 && (t1.Len()+b1.Len() > a.size() || b2.Len() == 0) {
+			l = b1
+		}
+		item := l.Remove(l.Back()).(*arcItem)
+		delete(a.items, item.key)
+	}
+}
+
+func (a *ARC) reset() {
+	for i := range a.lists {
+		a.lists[i] = list.New()
+	}
+	a.items = make(map[interface{}]*arcItem)
+	a.p = 0
+}
+
+// ratio returns a divided by b, but at least 1
+func ratio(a, b int) int {
+	if a/b < 1 {
+		return 1
+	}
+	return a / b
+}
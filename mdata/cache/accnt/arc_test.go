@@ -0,0 +1,89 @@
+package accnt
+
+import (
+	"testing"
+)
+
+func TestARC(t *testing.T) {
+	arc := NewARC()
+	arc.touch(1)
+	arc.touch(2)
+	arc.touch(3)
+	// 1 is used twice, so it moves to t2
+	arc.touch(1)
+
+	// the target size of t1 is 0, so the least recently used key of t1 goes first
+	if val := arc.pop(); val != 2 {
+		t.Fatalf("expected 2, got %v", val)
+	}
+	if val := arc.pop(); val != 3 {
+		t.Fatalf("expected 3, got %v", val)
+	}
+	if val := arc.pop(); val != 1 {
+		t.Fatalf("expected 1, got %v", val)
+	}
+	if val := arc.pop(); val != nil {
+		t.Fatalf("expected nil, got %v", val)
+	}
+}
+
+func TestARCAdapts(t *testing.T) {
+	arc := NewARC()
+	arc.touch(1)
+	arc.touch(1)
+	arc.touch(2)
+	arc.touch(3)
+	arc.touch(3)
+	arc.touch(4)
+
+	// t1: 4 2, t2: 3 1
+	if val := arc.pop(); val != 2 {
+		t.Fatalf("expected 2, got %v", val)
+	}
+	// 2 was evicted from t1 too early, which makes t1 grow
+	arc.touch(2)
+	if arc.p != 1 {
+		t.Fatalf("expected the target size of t1 to be 1, got %d", arc.p)
+	}
+	// t1: 4, t2: 2 3 1. t1 is not bigger than its target size, so t2 goes first
+	if val := arc.pop(); val != 1 {
+		t.Fatalf("expected 1, got %v", val)
+	}
+}
+
+func TestARCDelete(t *testing.T) {
+	arc := NewARC()
+	arc.touch(1)
+	arc.touch(2)
+	arc.touch(3)
+	arc.del(1)
+	arc.pop()
+	arc.del(2)
+
+	if len(arc.items) != 1 {
+		t.Fatalf("expected 1 remaining key, got %d", len(arc.items))
+	}
+	if val := arc.pop(); val != 3 {
+		t.Fatalf("expected 3, got %v", val)
+	}
+	arc.reset()
+	if val := arc.pop(); val != nil {
+		t.Fatalf("expected nil, got %v", val)
+	}
+}
+
+func TestARCGhostsBounded(t *testing.T) {
+	arc := NewARC()
+	for i := 0; i < 100; i++ {
+		arc.touch(i)
+		if i >= 10 {
+			arc.pop()
+		}
+	}
+	if size := arc.size(); size != 10 {
+		t.Fatalf("expected 10 keys in the cache, got %d", size)
+	}
+	if ghosts := arc.lists[arcB1].Len() + arc.lists[arcB2].Len(); ghosts > 10 {
+		t.Fatalf("expected at most 10 ghost keys, got %d", ghosts)
+	}
+}
@@ -35,11 +35,11 @@ const (
 var EventQSize = 100000
 
 // FlatAccnt implements Flat accounting.
-// Keeps track of the chunk cache size and in which order the contained
-// chunks have been used to last time. If it detects that the total cache
-// size is above the given limit, it feeds the least recently used
-// cache chunks into the evict queue, which will get consumed by the
-// evict loop.
+// Keeps track of the chunk cache size and how the contained chunks
+// have been used. If it detects that the total cache size is above the
+// given limit, it feeds the chunks chosen by its eviction policy (by
+// default, the least recently used ones) into the evict queue, which
+// will get consumed by the evict loop.
 type FlatAccnt struct {
 	// metric accounting per metric key
 	metrics map[schema.AMKey]*FlatAccntMet
@@ -47,10 +47,10 @@ type FlatAccnt struct {
 	// the size limit, once this is reached we'll start evicting data
 	maxSize uint64
 
-	// a last-recently-used implementation that keeps track of all chunks
-	// and which hasn't been used for the longest time. the eviction
-	// function relies on this to know what to evict.
-	lru *LRU
+	// the eviction policy, e.g. a last-recently-used implementation that
+	// keeps track of all chunks and which hasn't been used for the longest time.
+	// the eviction function relies on this to know what to evict.
+	policy Policy
 
 	// whenever a chunk gets evicted a job gets added to this queue. it is
 	// consumed by the chunk cache, which will evict whatever the jobs in
@@ -121,11 +121,17 @@ type GetTotalPayload struct {
 	res_chan chan uint64
 }
 
+// NewFlatAccnt creates a FlatAccnt that evicts the least recently used chunks
 func NewFlatAccnt(maxSize uint64) *FlatAccnt {
+	return NewFlatAccntWithPolicy(maxSize, NewLRU())
+}
+
+// NewFlatAccntWithPolicy creates a FlatAccnt that evicts the chunks chosen by the given policy
+func NewFlatAccntWithPolicy(maxSize uint64, policy Policy) *FlatAccnt {
 	accnt := FlatAccnt{
 		metrics: make(map[schema.AMKey]*FlatAccntMet),
 		maxSize: maxSize,
-		lru:     NewLRU(),
+		policy:  policy,
 		evictQ:  make(chan *EvictTarget, evictQSize),
 		eventQ:  make(chan FlatAccntEvent, EventQSize),
 	}
@@ -193,7 +199,7 @@ func (a *FlatAccnt) eventLoop() {
 				payload := event.pl.(*AddPayload)
 				a.add(payload.metric, payload.ts, payload.size)
 				cacheChunkAdd.Inc()
-				a.lru.touch(
+				a.policy.touch(
 					EvictTarget{
 						Metric: payload.metric,
 						Ts:     payload.ts,
@@ -204,7 +210,7 @@ func (a *FlatAccnt) eventLoop() {
 				a.addRange(payload.metric, payload.chunks)
 				cacheChunkAdd.Add(len(payload.chunks))
 				for _, chunk := range payload.chunks {
-					a.lru.touch(
+					a.policy.touch(
 						EvictTarget{
 							Metric: payload.metric,
 							Ts:     chunk.T0,
@@ -213,7 +219,7 @@ func (a *FlatAccnt) eventLoop() {
 				}
 			case evnt_hit_chnk:
 				payload := event.pl.(*HitPayload)
-				a.lru.touch(
+				a.policy.touch(
 					EvictTarget{
 						Metric: payload.metric,
 						Ts:     payload.ts,
@@ -222,7 +228,7 @@ func (a *FlatAccnt) eventLoop() {
 			case evnt_hit_chnks:
 				payload := event.pl.(*HitsPayload)
 				for _, chunk := range payload.chunks {
-					a.lru.touch(
+					a.policy.touch(
 						EvictTarget{
 							Metric: payload.metric,
 							Ts:     chunk.T0,
@@ -239,7 +245,7 @@ func (a *FlatAccnt) eventLoop() {
 				return
 			case evnt_reset:
 				a.metrics = make(map[schema.AMKey]*FlatAccntMet)
-				a.lru.reset()
+				a.policy.reset()
 				cacheSizeUsed.SetUint64(0)
 				cacheOverheadChunk.SetUint64(0)
 				cacheOverheadFlat.SetUint64(0)
@@ -271,7 +277,7 @@ func (a *FlatAccnt) delMet(metric schema.AMKey) {
 	cacheOverheadChunk.DecUint64(uint64(lenChunks*ccmChunkSize + ccmSize))
 
 	for ts := range met.chunks {
-		a.lru.del(
+		a.policy.del(
 			EvictTarget{
 				Metric: metric,
 				Ts:     ts,
@@ -307,7 +313,7 @@ func (a *FlatAccnt) add(metric schema.AMKey, ts uint32, size uint64) {
 
 	totalFlat += famChunkSize
 	totalChunk += ccmChunkSize
-	// this func is called from the event loop so lru will be touched with new EvictTarget
+	// this func is called from the event loop so the policy will be touched with new EvictTarget
 	totalLru += lruItemSize
 	met.total = met.total + size
 	cacheSizeUsed.AddUint64(size)
@@ -344,7 +350,7 @@ func (a *FlatAccnt) addRange(metric schema.AMKey, chunks []chunk.IterGen) {
 		met.chunks[chunk.T0] = size
 		totalFlat += famChunkSize
 		totalChunk += ccmChunkSize
-		// this func is called from the event loop so lru will be touched with new EvictTarget
+		// this func is called from the event loop so the policy will be touched with new EvictTarget
 		totalLru += lruItemSize
 	}
 
@@ -365,7 +371,7 @@ func (a *FlatAccnt) evict() {
 	var target EvictTarget
 	var totalFlat, totalChunk uint64
 
-	e = a.lru.pop()
+	e = a.policy.pop()
 
 	// got nothing to evict
 	if e == nil {
@@ -374,7 +380,7 @@ func (a *FlatAccnt) evict() {
 
 	// convert to EvictTarget otherwise
 	target = e.(EvictTarget)
-	// the item is already removed from the LRU and will not be re-added in this call path
+	// the item is already removed from the policy and will not be re-added in this call path
 	// so it is safe to decrement the stat
 	cacheOverheadLru.DecUint64(lruItemSize)
 
@@ -1,13 +1,16 @@
 package accnt
 
 import (
+	"fmt"
+
 	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/raintank/schema"
 )
 
 // Accnt represents an instance of cache accounting.
 // Currently there is only one implementation called `FlatAccnt`,
-// but it could be replaced with alternative eviction algorithms
+// which supports different eviction policies (see NewAccnt),
+// but it could be replaced with alternative accounting
 // in the future if they just implement this interface.
 type Accnt interface {
 	GetEvictQ() chan *EvictTarget
@@ -25,3 +28,45 @@ type EvictTarget struct {
 	Metric schema.AMKey
 	Ts     uint32
 }
+
+// Policy decides which chunks to evict, based on how they have been used.
+// Keys are EvictTargets.
+type Policy interface {
+	// touch records that the key has been added or hit
+	touch(key interface{})
+	// del removes the key, because it was removed from the cache
+	del(key interface{})
+	// pop removes and returns the key that should be evicted next, or nil if there is none
+	pop() interface{}
+	// reset removes all keys
+	reset()
+}
+
+// Policies are the names of the supported eviction policies
+var Policies = []string{"lru", "arc", "tinylfu"}
+
+// NewPolicy returns the eviction policy with the given name:
+// lru evicts the least recently used chunks.
+// arc (adaptive replacement cache) balances between recently and frequently used chunks.
+// tinylfu evicts the least recently used chunks, but doesn't admit new chunks that are used less frequently
+// than the chunk they would replace, which protects hot chunks from large scans.
+func NewPolicy(name string) (Policy, error) {
+	switch name {
+	case "lru":
+		return NewLRU(), nil
+	case "arc":
+		return NewARC(), nil
+	case "tinylfu":
+		return NewTinyLFU(), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q. must be one of %v", name, Policies)
+}
+
+// NewAccnt returns the accounting for a cache of the given size, with the given eviction policy
+func NewAccnt(policy string, maxSize uint64) (Accnt, error) {
+	p, err := NewPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	return NewFlatAccntWithPolicy(maxSize, p), nil
+}
@@ -22,6 +22,15 @@ var (
 	// metric cache.ops.chunk.hit is how many chunks were hit
 	CacheChunkHit = stats.NewCounter32("cache.ops.chunk.hit")
 
+	// metric cache.ops.peer.hit is how many metrics peers asked for had some of the requested chunks in cache. these don't count as hits of the cache.ops.metric stats
+	CachePeerHit = stats.NewCounterRate32("cache.ops.peer.hit")
+
+	// metric cache.ops.peer.miss is how many metrics peers asked for had none of the requested chunks in cache. these don't count as misses of the cache.ops.metric stats
+	CachePeerMiss = stats.NewCounterRate32("cache.ops.peer.miss")
+
+	// metric cache.ops.peer.chunk-hit is how many chunks were served to peers. these don't count as hits of cache.ops.chunk.hit
+	CachePeerChunkHit = stats.NewCounter32("cache.ops.peer.chunk-hit")
+
 	// metric cache.ops.chunk.push-hot is how many chunks have been pushed into the cache because their metric is hot
 	CacheChunkPushHot = stats.NewCounter32("cache.ops.chunk.push-hot")
 
@@ -31,6 +40,9 @@ var (
 	// metric cache.ops.chunk.evict is how many chunks were evicted from the cache
 	cacheChunkEvict = stats.NewCounter32("cache.ops.chunk.evict")
 
+	// metric cache.ops.chunk.reject is how many chunks were evicted right after they were added, because the eviction policy did not admit them
+	cacheChunkReject = stats.NewCounter32("cache.ops.chunk.reject")
+
 	// metric cache.size.max is the maximum size of the cache (overhead does not count towards this limit)
 	cacheSizeMax = stats.NewGauge64("cache.size.max")
 
@@ -43,7 +55,7 @@ var (
 	// metric cache.overhead.flat is an approximation of the overhead used by flat accounting
 	cacheOverheadFlat = stats.NewGauge64("cache.overhead.flat")
 
-	// metric cache.overhead.lru is an approximation of the overhead used by the LRU
+	// metric cache.overhead.lru is an approximation of the overhead used by the LRU (or other eviction policy)
 	cacheOverheadLru = stats.NewGauge64("cache.overhead.lru")
 
 	accntEventAddDuration = stats.NewLatencyHistogram15s32("cache.accounting.queue.add")
@@ -0,0 +1,176 @@
+package accnt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+const (
+	// how many added keys we consider for admission, at most. older ones are admitted without question
+	maxCandidates = 1024
+	// the minimum width of the frequency sketch
+	minSketchWidth = 1024
+	// the maximum value of the frequency counters
+	maxFrequency = 15
+)
+
+// TinyLFU is an LRU with the TinyLFU admission policy.
+// It estimates how often each series has been used recently, using a count-min sketch.
+// Consecutive touches of chunks of the same series, as done for all chunks of a request, count as one use.
+// When a key has to be evicted, the most recently added key is only admitted into the cache if its series
+// is used at least as often as the one of the least recently used key, otherwise it is evicted itself.
+// This keeps large scans over rarely used series from flushing frequently used series out of the cache.
+type TinyLFU struct {
+	lru        *LRU
+	sketch     *cmSketch
+	candidates []interface{} // recently added keys that have not been admitted yet, most recent last
+	last       uint64        // the hash of the last touched series
+}
+
+func NewTinyLFU() *TinyLFU {
+	return &TinyLFU{
+		lru:    NewLRU(),
+		sketch: newCMSketch(minSketchWidth),
+	}
+}
+
+func (t *TinyLFU) touch(key interface{}) {
+	if _, ok := t.lru.items[key]; !ok {
+		if len(t.candidates) == maxCandidates {
+			t.candidates = append(t.candidates[:0], t.candidates[maxCandidates/2:]...)
+		}
+		t.candidates = append(t.candidates, key)
+	}
+	t.lru.touch(key)
+
+	// keep the sketch wide enough to tell the keys apart. this resets all estimates
+	if len(t.lru.items) > t.sketch.width() {
+		t.sketch = newCMSketch(2 * len(t.lru.items))
+	}
+	h := hashKey(key)
+	if h != t.last {
+		t.sketch.increment(h)
+		t.last = h
+	}
+}
+
+func (t *TinyLFU) del(key interface{}) {
+	t.lru.del(key)
+}
+
+func (t *TinyLFU) pop() interface{} {
+	back := t.lru.list.Back()
+	if back == nil {
+		return nil
+	}
+	victim := back.Value
+
+	// find the most recent candidate that is still in the cache
+	for len(t.candidates) > 0 {
+		candidate := t.candidates[len(t.candidates)-1]
+		t.candidates = t.candidates[:len(t.candidates)-1]
+		if _, ok := t.lru.items[candidate]; !ok || candidate == victim {
+			continue
+		}
+		if t.sketch.estimate(hashKey(candidate)) < t.sketch.estimate(hashKey(victim)) {
+			cacheChunkReject.Inc()
+			t.lru.del(candidate)
+			return candidate
+		}
+		break
+	}
+	return t.lru.pop()
+}
+
+func (t *TinyLFU) reset() {
+	t.lru.reset()
+	t.sketch = newCMSketch(minSketchWidth)
+	t.candidates = nil
+	t.last = 0
+}
+
+// cmSketch is a count-min sketch with 4 rows of counters, that are halved periodically
+// so that the estimates reflect recent usage
+type cmSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+}
+
+func newCMSketch(width int) *cmSketch {
+	w := minSketchWidth
+	for w < width {
+		w *= 2
+	}
+	s := &cmSketch{
+		mask: uint64(w - 1),
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+func (s *cmSketch) width() int {
+	return len(s.rows[0])
+}
+
+func (s *cmSketch) index(h uint64, row int) uint64 {
+	return (h + uint64(row)*(h>>32|1)) & s.mask
+}
+
+func (s *cmSketch) increment(h uint64) {
+	for i := range s.rows {
+		idx := s.index(h, i)
+		if s.rows[i][idx] < maxFrequency {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= 10*s.width() {
+		s.age()
+	}
+}
+
+func (s *cmSketch) estimate(h uint64) uint8 {
+	min := uint8(maxFrequency)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(h, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// age halves all counters
+func (s *cmSketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] /= 2
+		}
+	}
+	s.additions /= 2
+}
+
+// hashKey hashes the series of the given key, which is typically an EvictTarget
+func hashKey(key interface{}) uint64 {
+	var h uint64
+	switch k := key.(type) {
+	case EvictTarget:
+		// the key is an md5 sum, so its bits are evenly distributed already
+		h = binary.LittleEndian.Uint64(k.Metric.MKey.Key[:8]) ^ binary.LittleEndian.Uint64(k.Metric.MKey.Key[8:])
+		h ^= (uint64(k.Metric.MKey.Org)<<16 | uint64(k.Metric.Archive)) * 0x9e3779b97f4a7c15
+	default:
+		f := fnv.New64a()
+		fmt.Fprint(f, key)
+		h = f.Sum64()
+	}
+	// the splitmix64 finalizer, so that all bits depend on all the input bits
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
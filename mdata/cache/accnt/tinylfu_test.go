@@ -0,0 +1,65 @@
+package accnt
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/test"
+	"github.com/raintank/schema"
+)
+
+func TestTinyLFURejectsInfrequent(t *testing.T) {
+	hot := schema.GetAMKey(test.GetMKey(1), schema.Cnt, 600)
+	cold := schema.GetAMKey(test.GetMKey(2), schema.Cnt, 600)
+
+	// the series are used twice, the new one once, so the new chunk is rejected
+	tlfu := NewTinyLFU()
+	tlfu.touch(EvictTarget{Metric: hot, Ts: 1})
+	tlfu.touch(EvictTarget{Metric: cold, Ts: 1})
+	tlfu.touch(EvictTarget{Metric: hot, Ts: 1})
+	tlfu.touch(EvictTarget{Metric: cold, Ts: 2})
+	newKey := EvictTarget{Metric: schema.GetAMKey(test.GetMKey(3), schema.Cnt, 600), Ts: 1}
+	tlfu.touch(newKey)
+
+	if val := tlfu.pop(); val != newKey {
+		t.Fatalf("expected the new key %v to be rejected, got %v", newKey, val)
+	}
+	// without a candidate, it is just an lru
+	if val := tlfu.pop(); val != (EvictTarget{Metric: cold, Ts: 1}) {
+		t.Fatalf("expected the least recently used key, got %v", val)
+	}
+}
+
+func TestTinyLFUAdmitsFrequent(t *testing.T) {
+	hot := schema.GetAMKey(test.GetMKey(1), schema.Cnt, 600)
+	cold := schema.GetAMKey(test.GetMKey(2), schema.Cnt, 600)
+
+	tlfu := NewTinyLFU()
+	tlfu.touch(EvictTarget{Metric: cold, Ts: 1})
+	tlfu.touch(EvictTarget{Metric: hot, Ts: 1})
+	tlfu.touch(EvictTarget{Metric: cold, Ts: 2})
+	// a new chunk of the hot series, which is used as often as the victim, gets admitted
+	tlfu.touch(EvictTarget{Metric: hot, Ts: 2})
+
+	if val := tlfu.pop(); val != (EvictTarget{Metric: cold, Ts: 1}) {
+		t.Fatalf("expected the least recently used key, got %v", val)
+	}
+}
+
+func TestCMSketch(t *testing.T) {
+	s := newCMSketch(minSketchWidth)
+	h1, h2 := hashKey(1), hashKey(2)
+	for i := 0; i < 20; i++ {
+		s.increment(h1)
+	}
+	s.increment(h2)
+	if est := s.estimate(h1); est != maxFrequency {
+		t.Fatalf("expected estimate %d, got %d", maxFrequency, est)
+	}
+	if est := s.estimate(h2); est != 1 {
+		t.Fatalf("expected estimate 1, got %d", est)
+	}
+	s.age()
+	if est := s.estimate(h1); est != maxFrequency/2 {
+		t.Fatalf("expected estimate %d after aging, got %d", maxFrequency/2, est)
+	}
+}
@@ -59,6 +59,10 @@ func (mc *MockCache) Search(ctx context.Context, metric schema.AMKey, from uint3
 	return nil, nil
 }
 
+func (mc *MockCache) SearchPeer(ctx context.Context, metric schema.AMKey, from uint32, until uint32) (*CCSearchResult, error) {
+	return mc.Search(ctx, metric, from, until)
+}
+
 func (mc *MockCache) DelMetric(rawMetric schema.MKey) (int, int) {
 	mc.DelMetricKeys = append(mc.DelMetricKeys, rawMetric)
 	return mc.DelMetricSeries, mc.DelMetricArchives
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/mdata/cache/accnt"
@@ -18,15 +19,23 @@ import (
 )
 
 var (
-	maxSize         uint64
-	searchFwdBug    = stats.NewCounter32("recovered_errors.cache.metric.searchForwardBug")
-	ErrInvalidRange = errors.New("CCache: invalid range: from must be less than to")
+	maxSize          uint64
+	evictionPolicy   string
+	snapshotFile     string
+	snapshotInterval time.Duration
+	snapshotMaxAge   time.Duration
+	searchFwdBug     = stats.NewCounter32("recovered_errors.cache.metric.searchForwardBug")
+	ErrInvalidRange  = errors.New("CCache: invalid range: from must be less than to")
 )
 
 func init() {
 	flags := flag.NewFlagSet("chunk-cache", flag.ExitOnError)
 	// (1024 ^ 3) * 4 = 4294967296 = 4G
 	flags.Uint64Var(&maxSize, "max-size", 4294967296, "Maximum size of chunk cache in bytes. 0 disables cache")
+	flags.StringVar(&evictionPolicy, "eviction-policy", "lru", "which chunks to evict when the cache is full: lru (least recently used), arc (adaptive replacement cache, balances between recently and frequently used) or tinylfu (least recently used, but don't admit chunks of series that are used less frequently, which protects hot series from large scans)")
+	flags.StringVar(&snapshotFile, "snapshot-file", "", "file to save the contents of the cache to at shutdown, and to restore them from at startup, so that restarted nodes don't have to fetch all their hot data from the store. empty disables snapshots")
+	flags.DurationVar(&snapshotInterval, "snapshot-interval", 0, "also save a snapshot at this interval, so that the cache can be restored after a crash. 0 only saves a snapshot at shutdown")
+	flags.DurationVar(&snapshotMaxAge, "snapshot-max-age", time.Hour, "don't restore snapshots older than this, as chunks may have been rewritten in the store in the meantime. 0 restores snapshots of any age")
 	globalconf.Register("chunk-cache", flags, flag.ExitOnError)
 }
 
@@ -56,15 +65,22 @@ func NewCCache() *CCache {
 	if maxSize == 0 {
 		return nil
 	}
+	accounting, err := accnt.NewAccnt(evictionPolicy, maxSize)
+	if err != nil {
+		log.Fatalf("CCache: %s", err)
+	}
 
 	cc := &CCache{
 		metricCache:   make(map[schema.AMKey]*CCacheMetric),
 		metricRawKeys: make(map[schema.MKey]map[schema.Archive]struct{}),
-		accnt:         accnt.NewFlatAccnt(maxSize),
+		accnt:         accounting,
 		stop:          make(chan interface{}),
 		tracer:        opentracing.NoopTracer{},
 	}
 	go cc.evictLoop()
+	if snapshotFile != "" && snapshotInterval > 0 {
+		go cc.snapshotLoop()
+	}
 	return cc
 }
 
@@ -224,7 +240,7 @@ func (c *CCache) Stop() {
 		return
 	}
 	c.accnt.Stop()
-	c.stop <- nil
+	close(c.stop)
 }
 
 func (c *CCache) evict(target *accnt.EvictTarget) {
@@ -256,6 +272,47 @@ func (c *CCache) evict(target *accnt.EvictTarget) {
 // Search looks for the requested metric and returns a complete-as-possible CCSearchResult
 // from is inclusive, until is exclusive
 func (c *CCache) Search(ctx context.Context, metric schema.AMKey, from, until uint32) (*CCSearchResult, error) {
+	res, err := c.search(ctx, "CCache.Search", metric, from, until)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Start) == 0 && len(res.End) == 0 {
+		accnt.CacheMetricMiss.Inc()
+		return res, nil
+	}
+
+	accnt.CacheChunkHit.Add(len(res.Start) + len(res.End))
+	go func() {
+		c.accnt.HitChunks(metric, res.Start)
+		c.accnt.HitChunks(metric, res.End)
+	}()
+
+	if res.Complete {
+		accnt.CacheMetricHitFull.Inc()
+	} else {
+		accnt.CacheMetricHitPartial.Inc()
+	}
+	return res, nil
+}
+
+// SearchPeer is like Search, for requests of peers that look for chunks they don't have cached themselves.
+// those are not our own cache use, so they are counted separately and don't affect the eviction policy
+func (c *CCache) SearchPeer(ctx context.Context, metric schema.AMKey, from, until uint32) (*CCSearchResult, error) {
+	res, err := c.search(ctx, "CCache.SearchPeer", metric, from, until)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Start) == 0 && len(res.End) == 0 {
+		accnt.CachePeerMiss.Inc()
+		return res, nil
+	}
+	accnt.CachePeerHit.Inc()
+	accnt.CachePeerChunkHit.Add(len(res.Start) + len(res.End))
+	return res, nil
+}
+
+// search looks for the requested metric without updating any stats or the eviction policy
+func (c *CCache) search(ctx context.Context, op string, metric schema.AMKey, from, until uint32) (*CCSearchResult, error) {
 	if from >= until {
 		return nil, ErrInvalidRange
 	}
@@ -266,11 +323,10 @@ func (c *CCache) Search(ctx context.Context, metric schema.AMKey, from, until ui
 	}
 
 	if c == nil {
-		accnt.CacheMetricMiss.Inc()
 		return res, nil
 	}
 
-	ctx, span := tracing.NewSpan(ctx, c.tracer, "CCache.Search")
+	ctx, span := tracing.NewSpan(ctx, c.tracer, op)
 	defer span.Finish()
 
 	c.RLock()
@@ -279,30 +335,17 @@ func (c *CCache) Search(ctx context.Context, metric schema.AMKey, from, until ui
 	cm, ok := c.metricCache[metric]
 	if !ok {
 		span.SetTag("cache", "miss")
-		accnt.CacheMetricMiss.Inc()
 		return res, nil
 	}
 
 	cm.Search(ctx, metric, res, from, until)
-	if len(res.Start) == 0 && len(res.End) == 0 {
+	switch {
+	case len(res.Start) == 0 && len(res.End) == 0:
 		span.SetTag("cache", "miss")
-		accnt.CacheMetricMiss.Inc()
-	} else {
-
-		accnt.CacheChunkHit.Add(len(res.Start) + len(res.End))
-		go func() {
-			c.accnt.HitChunks(metric, res.Start)
-			c.accnt.HitChunks(metric, res.End)
-		}()
-
-		if res.Complete {
-			span.SetTag("cache", "hit-full")
-			accnt.CacheMetricHitFull.Inc()
-		} else {
-			span.SetTag("cache", "hit-partial")
-			accnt.CacheMetricHitPartial.Inc()
-		}
+	case res.Complete:
+		span.SetTag("cache", "hit-full")
+	default:
+		span.SetTag("cache", "hit-partial")
 	}
-
 	return res, nil
 }
@@ -3,8 +3,10 @@ package cache
 import (
 	"bytes"
 	"encoding/binary"
+	"runtime"
 	"testing"
 
+	"github.com/grafana/metrictank/mdata/cache/accnt"
 	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/test"
 	"github.com/raintank/schema"
@@ -710,3 +712,69 @@ func testMetricDelete(t *testing.T, cc *CCache) {
 	}
 
 }
+
+// BenchmarkEvictionPolicies replays a workload of repeated queries for a set of hot series,
+// interleaved with a scan over series that are only queried once, against the cache with each of the
+// eviction policies. The cache can hold 1.5 times the hot series, which is not enough to also hold
+// the scanned series that are queried before a hot series is queried again.
+// It reports the ratio of the requested chunks that were served from the cache.
+func BenchmarkEvictionPolicies(b *testing.B) {
+	for _, policy := range accnt.Policies {
+		b.Run(policy, func(b *testing.B) {
+			benchmarkEvictionPolicy(b, policy)
+		})
+	}
+}
+
+func benchmarkEvictionPolicy(b *testing.B, policy string) {
+	hotSeries := 50
+	chunksPerSeries := 4
+	values := []uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	var itgens []chunk.IterGen
+	for i := 0; i < chunksPerSeries; i++ {
+		itgens = append(itgens, getItgen(b, values, uint32(1000+i*len(values)), false))
+	}
+	until := uint32(1000 + chunksPerSeries*len(values))
+
+	origMaxSize, origPolicy := maxSize, evictionPolicy
+	defer func() {
+		maxSize, evictionPolicy = origMaxSize, origPolicy
+	}()
+	maxSize = uint64(hotSeries*chunksPerSeries*3/2) * itgens[0].Size()
+	evictionPolicy = policy
+	cc := NewCCache()
+	defer cc.Stop()
+	// the size stats are global, make sure previous runs don't count against our max size
+	cc.Reset()
+	defer cc.Reset()
+
+	// accounting and eviction happen asynchronously. to get comparable results, we wait for them
+	// to catch up after every request, otherwise the cache may grow far beyond its max size
+	flatAccnt := cc.accnt.(*accnt.FlatAccnt)
+	evictQ := flatAccnt.GetEvictQ()
+
+	ctx := test.NewContext()
+	var hits, total int
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		metric := test.GetAMKey(hotSeries + i)
+		if i%2 == 0 {
+			metric = test.GetAMKey(i / 2 % hotSeries)
+		}
+		res, err := cc.Search(ctx, metric, 1000, until)
+		if err != nil {
+			b.Fatalf("expected err nil, got %v", err)
+		}
+		hits += len(res.Start) + len(res.End)
+		total += chunksPerSeries
+		if !res.Complete {
+			// what we would have fetched from the store
+			cc.AddRange(metric, 0, itgens)
+		}
+		flatAccnt.GetTotal()
+		for len(evictQ) > 0 {
+			runtime.Gosched()
+		}
+	}
+	b.Logf("hit-ratio %.4f", float64(hits)/float64(total))
+}
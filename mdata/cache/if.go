@@ -13,12 +13,16 @@ type Cache interface {
 	AddRange(metric schema.AMKey, prev uint32, itergens []chunk.IterGen)
 	Stop()
 	Search(ctx context.Context, metric schema.AMKey, from, until uint32) (*CCSearchResult, error)
+	// SearchPeer is like Search, for requests of peers. they don't count as cache use of this node
+	SearchPeer(ctx context.Context, metric schema.AMKey, from, until uint32) (*CCSearchResult, error)
 	DelMetric(rawMetric schema.MKey) (int, int)
 	Reset() (int, int)
 }
 
 type CachePusher interface {
 	AddIfHot(metric schema.AMKey, prev uint32, itergen chunk.IterGen)
+	// DelMetric deletes the chunks of all archives of the metric, e.g. because they were rewritten
+	DelMetric(rawMetric schema.MKey) (int, int)
 }
 
 type CCSearchResult struct {
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+	"github.com/tinylib/msgp/msgp"
+)
+
+const (
+	snapshotMagic   = "metrictank-chunk-cache"
+	snapshotVersion = 1
+)
+
+var (
+	errSnapshotMagic   = errors.New("not a chunk cache snapshot")
+	errSnapshotVersion = errors.New("unsupported chunk cache snapshot version")
+
+	// makes sure periodic snapshots and the one at shutdown don't write the file concurrently
+	snapshotLock sync.Mutex
+
+	// metric cache.snapshot.saved_chunks is the number of chunks written to the last snapshot of the chunk cache
+	snapshotSavedChunks = stats.NewGauge32("cache.snapshot.saved_chunks")
+	// metric cache.snapshot.save_duration is the time it took to write the last snapshot of the chunk cache
+	snapshotSaveDuration = stats.NewGauge32("cache.snapshot.save_duration")
+	// metric cache.snapshot.restored_chunks is the number of chunks restored from the snapshot of the chunk cache at startup
+	snapshotRestoredChunks = stats.NewGauge32("cache.snapshot.restored_chunks")
+)
+
+// snapshotRun is a sequence of chunks of a metric that are linked to each other in the cache
+type snapshotRun struct {
+	metric   schema.AMKey
+	itergens []chunk.IterGen
+}
+
+// snapshot returns all cached chunks, as runs of linked chunks
+func (c *CCache) snapshot() []snapshotRun {
+	c.RLock()
+	defer c.RUnlock()
+
+	var runs []snapshotRun
+	for metric, ccm := range c.metricCache {
+		ccm.RLock()
+		var run *snapshotRun
+		for i, ts := range ccm.keys {
+			cc := ccm.chunks[ts]
+			if run == nil || ccm.chunks[ccm.keys[i-1]].Next != ts {
+				runs = append(runs, snapshotRun{metric: metric})
+				run = &runs[len(runs)-1]
+			}
+			run.itergens = append(run.itergens, cc.Itgen)
+		}
+		ccm.RUnlock()
+	}
+	return runs
+}
+
+// WriteSnapshot writes all cached chunks to w.
+// It returns the number of chunks written
+func (c *CCache) WriteSnapshot(w io.Writer, now time.Time) (int, error) {
+	if c == nil {
+		return 0, nil
+	}
+	mw := msgp.NewWriter(w)
+	mw.WriteString(snapshotMagic)
+	mw.WriteUint8(snapshotVersion)
+	mw.WriteInt64(now.Unix())
+
+	var chunks int
+	for _, run := range c.snapshot() {
+		// every run is preceded by true, the last one is followed by false
+		mw.WriteBool(true)
+		if err := run.metric.MKey.EncodeMsg(mw); err != nil {
+			return chunks, err
+		}
+		mw.WriteUint16(uint16(run.metric.Archive))
+		mw.WriteArrayHeader(uint32(len(run.itergens)))
+		for _, itergen := range run.itergens {
+			if err := itergen.EncodeMsg(mw); err != nil {
+				return chunks, err
+			}
+		}
+		chunks += len(run.itergens)
+	}
+	mw.WriteBool(false)
+	return chunks, mw.Flush()
+}
+
+// ReadSnapshot adds the chunks of a snapshot, read from r, to the cache.
+// Snapshots that were taken longer than maxAge ago (if > 0) are ignored.
+// Reading stops once the restored chunks fill the cache.
+// It returns the number of chunks added
+func (c *CCache) ReadSnapshot(r io.Reader, maxAge time.Duration) (int, error) {
+	if c == nil {
+		return 0, nil
+	}
+	mr := msgp.NewReader(r)
+	magic, err := mr.ReadString()
+	if err != nil || magic != snapshotMagic {
+		return 0, errSnapshotMagic
+	}
+	version, err := mr.ReadUint8()
+	if err != nil {
+		return 0, err
+	}
+	if version != snapshotVersion {
+		return 0, errSnapshotVersion
+	}
+	created, err := mr.ReadInt64()
+	if err != nil {
+		return 0, err
+	}
+	if age := time.Since(time.Unix(created, 0)); maxAge > 0 && age > maxAge {
+		log.Infof("CCache: ignoring snapshot that is %s old", age)
+		return 0, nil
+	}
+
+	var chunks int
+	var size uint64
+	for {
+		more, err := mr.ReadBool()
+		if err != nil {
+			return chunks, err
+		}
+		if !more {
+			return chunks, nil
+		}
+		var metric schema.AMKey
+		if err := metric.MKey.DecodeMsg(mr); err != nil {
+			return chunks, err
+		}
+		archive, err := mr.ReadUint16()
+		if err != nil {
+			return chunks, err
+		}
+		metric.Archive = schema.Archive(archive)
+		n, err := mr.ReadArrayHeader()
+		if err != nil {
+			return chunks, err
+		}
+		itergens := make([]chunk.IterGen, n)
+		for i := range itergens {
+			if err := itergens[i].DecodeMsg(mr); err != nil {
+				return chunks, err
+			}
+			size += itergens[i].Size()
+		}
+		if size > maxSize {
+			// adding any more would just evict what we restored already
+			return chunks, nil
+		}
+		c.AddRange(metric, 0, itergens)
+		chunks += len(itergens)
+	}
+}
+
+// SaveSnapshot writes all cached chunks to the configured snapshot file, if any.
+// The snapshot is written to a temporary file first, which replaces the previous snapshot once it is complete
+func (c *CCache) SaveSnapshot() error {
+	if c == nil || snapshotFile == "" {
+		return nil
+	}
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+
+	pre := time.Now()
+	tmp, err := os.Create(snapshotFile + ".tmp")
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(tmp)
+	chunks, err := c.WriteSnapshot(buf, pre)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), snapshotFile); err != nil {
+		return err
+	}
+	snapshotSavedChunks.Set(chunks)
+	snapshotSaveDuration.Set(int(time.Since(pre).Nanoseconds()))
+	log.Infof("CCache: saved snapshot of %d chunks to %s in %s", chunks, snapshotFile, time.Since(pre))
+	return nil
+}
+
+// LoadSnapshot adds the chunks of the configured snapshot file, if any, to the cache
+func (c *CCache) LoadSnapshot() error {
+	if c == nil || snapshotFile == "" {
+		return nil
+	}
+	f, err := os.Open(snapshotFile)
+	if os.IsNotExist(err) {
+		log.Infof("CCache: no snapshot found at %s, starting with an empty cache", snapshotFile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pre := time.Now()
+	chunks, err := c.ReadSnapshot(bufio.NewReader(f), snapshotMaxAge)
+	snapshotRestoredChunks.Set(chunks)
+	if err != nil {
+		return fmt.Errorf("restored %d chunks from %s before failing: %s", chunks, snapshotFile, err)
+	}
+	log.Infof("CCache: restored %d chunks from %s in %s", chunks, snapshotFile, time.Since(pre))
+	return nil
+}
+
+// snapshotLoop saves a snapshot every snapshot-interval, until the cache is stopped
+func (c *CCache) snapshotLoop() {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.SaveSnapshot(); err != nil {
+				log.Errorf("CCache: failed to save snapshot to %s: %s", snapshotFile, err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/test"
+)
+
+func getSnapshotItgens(t *testing.T, t0s ...uint32) []chunk.IterGen {
+	values := []uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	var itgens []chunk.IterGen
+	for _, t0 := range t0s {
+		itgens = append(itgens, getItgen(t, values, t0, false))
+	}
+	return itgens
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	metric1, metric2 := test.GetAMKey(1), test.GetAMKey(2)
+	metric2.Archive = 3
+
+	cc := NewCCache()
+	defer cc.Stop()
+	cc.Reset()
+	// two runs for metric1, with a gap between them
+	cc.AddRange(metric1, 0, getSnapshotItgens(t, 1000, 1010, 1020))
+	cc.AddRange(metric1, 0, getSnapshotItgens(t, 1050, 1060))
+	cc.AddRange(metric2, 0, getSnapshotItgens(t, 2000, 2010))
+
+	var buf bytes.Buffer
+	written, err := cc.WriteSnapshot(&buf, time.Now())
+	if err != nil {
+		t.Fatalf("expected err nil, got %s", err)
+	}
+	if written != 7 {
+		t.Fatalf("expected 7 chunks to be written, got %d", written)
+	}
+
+	restored := NewCCache()
+	defer restored.Stop()
+	read, err := restored.ReadSnapshot(&buf, time.Hour)
+	if err != nil {
+		t.Fatalf("expected err nil, got %s", err)
+	}
+	if read != written {
+		t.Fatalf("expected %d chunks to be restored, got %d", written, read)
+	}
+
+	ctx := test.NewContext()
+	res, _ := restored.Search(ctx, metric1, 1000, 1030)
+	if !res.Complete || len(res.Start) != 3 {
+		t.Fatalf("expected the first run of metric1 to be restored completely, got %d chunks, complete %t", len(res.Start), res.Complete)
+	}
+	res, _ = restored.Search(ctx, metric1, 1000, 1070)
+	if res.Complete || len(res.Start) != 3 || len(res.End) != 2 {
+		t.Fatalf("expected the gap between the runs of metric1 to be restored, got %d start and %d end chunks, complete %t", len(res.Start), len(res.End), res.Complete)
+	}
+	res, _ = restored.Search(ctx, metric2, 2000, 2020)
+	if !res.Complete || len(res.Start) != 2 {
+		t.Fatalf("expected metric2 to be restored, got %d chunks, complete %t", len(res.Start), res.Complete)
+	}
+	if !bytes.Equal(res.Start[0].B, getSnapshotItgens(t, 2000)[0].B) {
+		t.Fatalf("expected the restored chunk data to match")
+	}
+}
+
+func TestSnapshotMaxAge(t *testing.T) {
+	cc := NewCCache()
+	defer cc.Stop()
+	cc.Reset()
+	cc.AddRange(test.GetAMKey(1), 0, getSnapshotItgens(t, 1000, 1010))
+
+	var buf bytes.Buffer
+	if _, err := cc.WriteSnapshot(&buf, time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatalf("expected err nil, got %s", err)
+	}
+	restored := NewCCache()
+	defer restored.Stop()
+	read, err := restored.ReadSnapshot(&buf, time.Hour)
+	if err != nil {
+		t.Fatalf("expected err nil, got %s", err)
+	}
+	if read != 0 {
+		t.Fatalf("expected an outdated snapshot not to be restored, got %d chunks", read)
+	}
+}
+
+func TestSnapshotMaxSize(t *testing.T) {
+	itgens := getSnapshotItgens(t, 1000, 1010)
+	origMaxSize := maxSize
+	defer func() {
+		maxSize = origMaxSize
+	}()
+
+	cc := NewCCache()
+	defer cc.Stop()
+	cc.Reset()
+	for i := 0; i < 10; i++ {
+		cc.AddRange(test.GetAMKey(i), 0, itgens)
+	}
+	var buf bytes.Buffer
+	if _, err := cc.WriteSnapshot(&buf, time.Now()); err != nil {
+		t.Fatalf("expected err nil, got %s", err)
+	}
+
+	// only room for 3 of the series
+	maxSize = 7 * itgens[0].Size()
+	restored := NewCCache()
+	defer restored.Stop()
+	defer restored.Reset()
+	read, err := restored.ReadSnapshot(&buf, time.Hour)
+	if err != nil {
+		t.Fatalf("expected err nil, got %s", err)
+	}
+	if read != 6 {
+		t.Fatalf("expected 6 chunks to be restored, got %d", read)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccache-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origSnapshotFile := snapshotFile
+	defer func() {
+		snapshotFile = origSnapshotFile
+	}()
+	snapshotFile = filepath.Join(dir, "snapshot")
+
+	restored := NewCCache()
+	defer restored.Stop()
+	if err := restored.LoadSnapshot(); err != nil {
+		t.Fatalf("expected a missing snapshot to be ignored, got %s", err)
+	}
+
+	cc := NewCCache()
+	defer cc.Stop()
+	cc.Reset()
+	cc.AddRange(test.GetAMKey(1), 0, getSnapshotItgens(t, 1000, 1010))
+	if err := cc.SaveSnapshot(); err != nil {
+		t.Fatalf("expected err nil, got %s", err)
+	}
+	if _, err := os.Stat(snapshotFile + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary file to be gone, got %v", err)
+	}
+	if err := restored.LoadSnapshot(); err != nil {
+		t.Fatalf("expected err nil, got %s", err)
+	}
+	res, _ := restored.Search(test.NewContext(), test.GetAMKey(1), 1000, 1020)
+	if !res.Complete || len(res.Start) != 2 {
+		t.Fatalf("expected the snapshot to be restored, got %d chunks, complete %t", len(res.Start), res.Complete)
+	}
+
+	if err := ioutil.WriteFile(snapshotFile, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.LoadSnapshot(); err == nil {
+		t.Fatalf("expected an error for a corrupt snapshot")
+	}
+}
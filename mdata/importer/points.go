@@ -0,0 +1,243 @@
+package importer
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/raintank/schema"
+)
+
+// NewArchiveRequestFromPoints returns a request to write the points of a series, along with the rollups
+// generated from them, according to the storage-schemas and storage-aggregation rules matching the series.
+// the points don't need to be sorted. of points with the same timestamp, the last one is used.
+// the points are merged into the chunks of the series in the store, if any, replacing stored points with the same timestamp.
+// the rollup points of the buckets the points fall in are recomputed from the merged raw data, so for time ranges
+// of which the raw data has expired from the store, they only reflect the given points.
+// all chunks are written, including the last one, even if it isn't complete.
+func NewArchiveRequestFromPoints(ctx context.Context, md schema.MetricData, points []schema.Point, schemas conf.Schemas, aggs conf.Aggregations, store mdata.Store) (*ArchiveRequest, error) {
+	res := &ArchiveRequest{
+		MetricData: md,
+	}
+	sorted := sortSchemaPoints(points)
+	if len(sorted) == 0 {
+		return res, nil
+	}
+	res.MetricData.Time = int64(sorted[len(sorted)-1].Timestamp)
+	mkey, err := schema.MKeyFromString(md.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	path := schema.MetricDefinitionFromMetricData(&md).NameWithTags()
+	_, selectedSchema := schemas.Match(path, md.Interval)
+	_, selectedAgg := aggs.Match(path)
+
+	// stored returns the points of the given archive in the store from (inclusive) to (exclusive)
+	stored := func(archive schema.Archive, retention conf.Retention) func(from, to uint32) ([]whisper.Point, error) {
+		return func(from, to uint32) ([]whisper.Point, error) {
+			if store == nil {
+				return nil, nil
+			}
+			points, err := mdata.StoredPoints(ctx, store, schema.AMKey{MKey: mkey, Archive: archive}, uint32(retention.MaxRetention()), from, to)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]whisper.Point, len(points))
+			for i, p := range points {
+				out[i] = whisper.Point{Timestamp: p.Ts, Value: p.Val}
+			}
+			return out, nil
+		}
+	}
+
+	raw := selectedSchema.Retentions[0]
+	merged, err := mergeStored(sorted, raw.ChunkSpan, stored(0, raw))
+	if err != nil {
+		return nil, err
+	}
+	res.addChunks(schema.Archive(0), raw, merged)
+
+	for _, retention := range selectedSchema.Retentions[1:] {
+		span := uint32(retention.SecondsPerPoint)
+		rollups := make(map[schema.Method][]whisper.Point)
+		// recompute the buckets the points fall in, a run of consecutive buckets at a time
+		for _, run := range bucketRuns(sorted, span) {
+			from, to := run[0]-span+1, run[1]+1
+			rawPoints, err := stored(0, raw)(from, to)
+			if err != nil {
+				return nil, err
+			}
+			rawPoints = mergePoints(rawPoints, pointsBetween(sorted, from, to))
+			for m, p := range rollup(rawPoints, span, selectedAgg.AggregationMethod) {
+				rollups[m] = append(rollups[m], p...)
+			}
+		}
+		for m, p := range rollups {
+			archive := schema.NewArchive(m, span)
+			merged, err := mergeStored(p, retention.ChunkSpan, stored(archive, retention))
+			if err != nil {
+				return nil, err
+			}
+			res.addChunks(archive, retention, merged)
+		}
+	}
+	return res, nil
+}
+
+func (a *ArchiveRequest) addChunks(archive schema.Archive, retention conf.Retention, points []whisper.Point) {
+	if len(points) == 0 {
+		return
+	}
+	for _, c := range encodeChunksFromPoints(points, uint32(retention.SecondsPerPoint), retention.ChunkSpan, true) {
+		a.ChunkWriteRequests = append(a.ChunkWriteRequests, NewChunkWriteRequest(
+			archive,
+			uint32(retention.MaxRetention()),
+			c.Series.T0,
+			c.Encode(retention.ChunkSpan),
+			time.Now(),
+		))
+	}
+}
+
+// sortSchemaPoints returns the points sorted by timestamp, keeping the last point of the ones with the same timestamp
+func sortSchemaPoints(in []schema.Point) []whisper.Point {
+	sorted := make([]schema.Point, len(in))
+	copy(sorted, in)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Ts < sorted[j].Ts })
+
+	points := make([]whisper.Point, 0, len(sorted))
+	for _, p := range sorted {
+		if p.Ts == 0 {
+			continue
+		}
+		if n := len(points); n > 0 && points[n-1].Timestamp == p.Ts {
+			points[n-1].Value = p.Val
+			continue
+		}
+		points = append(points, whisper.Point{Timestamp: p.Ts, Value: p.Val})
+	}
+	return points
+}
+
+// rollup aggregates the sorted raw points into points of the given span, for each of the series
+// the aggregation methods need. like the rollups of the live data, aggregated points reflect the
+// data in the timeframe preceding them.
+func rollup(points []whisper.Point, span uint32, methods []conf.Method) map[schema.Method][]whisper.Point {
+	res := make(map[schema.Method][]whisper.Point)
+	for _, method := range methods {
+		switch method {
+		case conf.Avg:
+			res[schema.Sum] = nil
+			res[schema.Cnt] = nil
+		case conf.Sum:
+			res[schema.Sum] = nil
+		case conf.Lst:
+			res[schema.Lst] = nil
+		case conf.Max:
+			res[schema.Max] = nil
+		case conf.Min:
+			res[schema.Min] = nil
+		}
+	}
+	add := func(agg *mdata.Aggregation, boundary uint32) {
+		for m := range res {
+			var val float64
+			switch m {
+			case schema.Sum:
+				val = agg.Sum
+			case schema.Cnt:
+				val = agg.Cnt
+			case schema.Lst:
+				val = agg.Lst
+			case schema.Max:
+				val = agg.Max
+			case schema.Min:
+				val = agg.Min
+			}
+			res[m] = append(res[m], whisper.Point{Timestamp: boundary, Value: val})
+		}
+	}
+
+	agg := mdata.NewAggregation()
+	var boundary uint32
+	for _, p := range points {
+		b := mdata.AggBoundary(p.Timestamp, span)
+		if b != boundary && agg.Cnt != 0 {
+			add(agg, boundary)
+			agg.Reset()
+		}
+		boundary = b
+		agg.Add(p.Value)
+	}
+	if agg.Cnt != 0 {
+		add(agg, boundary)
+	}
+	return res
+}
+
+// bucketRuns returns the first and last boundary of each run of consecutive buckets of the given span
+// that the sorted points fall in
+func bucketRuns(points []whisper.Point, span uint32) [][2]uint32 {
+	var runs [][2]uint32
+	for _, p := range points {
+		boundary := mdata.AggBoundary(p.Timestamp, span)
+		n := len(runs)
+		if n > 0 && boundary <= runs[n-1][1]+span {
+			runs[n-1][1] = boundary
+			continue
+		}
+		runs = append(runs, [2]uint32{boundary, boundary})
+	}
+	return runs
+}
+
+// pointsBetween returns the sorted points from (inclusive) to (exclusive)
+func pointsBetween(points []whisper.Point, from, to uint32) []whisper.Point {
+	i := sort.Search(len(points), func(i int) bool { return points[i].Timestamp >= from })
+	j := sort.Search(len(points), func(i int) bool { return points[i].Timestamp >= to })
+	return points[i:j]
+}
+
+// mergeStored merges the sorted points into the stored points of the chunks they belong to,
+// and returns all points of those chunks
+func mergeStored(points []whisper.Point, chunkSpan uint32, stored func(from, to uint32) ([]whisper.Point, error)) ([]whisper.Point, error) {
+	var res []whisper.Point
+	for len(points) > 0 {
+		t0 := points[0].Timestamp - (points[0].Timestamp % chunkSpan)
+		n := 1
+		for n < len(points) && points[n].Timestamp-(points[n].Timestamp%chunkSpan) == t0 {
+			n++
+		}
+		existing, err := stored(t0, t0+chunkSpan)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, mergePoints(existing, points[:n])...)
+		points = points[n:]
+	}
+	return res, nil
+}
+
+// mergePoints merges the sorted new points into the sorted existing points,
+// replacing existing points with the same timestamp
+func mergePoints(existing, new []whisper.Point) []whisper.Point {
+	out := make([]whisper.Point, 0, len(existing)+len(new))
+	var i, j int
+	for i < len(existing) || j < len(new) {
+		if j == len(new) || (i < len(existing) && existing[i].Timestamp < new[j].Timestamp) {
+			out = append(out, existing[i])
+			i++
+			continue
+		}
+		if i < len(existing) && existing[i].Timestamp == new[j].Timestamp {
+			i++
+		}
+		out = append(out, new[j])
+		j++
+	}
+	return out
+}
@@ -0,0 +1,320 @@
+package importer
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/raintank/schema"
+)
+
+func TestSortSchemaPoints(t *testing.T) {
+	cases := []struct {
+		name string
+		in   []schema.Point
+		exp  []whisper.Point
+	}{
+		{
+			name: "empty",
+			in:   nil,
+			exp:  []whisper.Point{},
+		},
+		{
+			name: "unsorted",
+			in:   []schema.Point{{Val: 3, Ts: 30}, {Val: 1, Ts: 10}, {Val: 2, Ts: 20}},
+			exp:  []whisper.Point{{Timestamp: 10, Value: 1}, {Timestamp: 20, Value: 2}, {Timestamp: 30, Value: 3}},
+		},
+		{
+			name: "zero timestamp",
+			in:   []schema.Point{{Val: 0, Ts: 0}, {Val: 1, Ts: 10}},
+			exp:  []whisper.Point{{Timestamp: 10, Value: 1}},
+		},
+		{
+			name: "duplicate timestamps keep the last point",
+			in:   []schema.Point{{Val: 3, Ts: 30}, {Val: 1, Ts: 10}, {Val: 2, Ts: 20}, {Val: 4, Ts: 10}, {Val: 5, Ts: 10}},
+			exp:  []whisper.Point{{Timestamp: 10, Value: 5}, {Timestamp: 20, Value: 2}, {Timestamp: 30, Value: 3}},
+		},
+	}
+	for _, c := range cases {
+		if got := sortSchemaPoints(c.in); !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("case %q: expected %v, got %v", c.name, c.exp, got)
+		}
+	}
+}
+
+func TestRollup(t *testing.T) {
+	cases := []struct {
+		name    string
+		points  []whisper.Point
+		span    uint32
+		methods []conf.Method
+		exp     map[schema.Method][]whisper.Point
+	}{
+		{
+			name:    "avg is rolled up as sum and cnt",
+			points:  generatePoints(10, 10, 1, 0, 14, func(i float64) float64 { return i + 1 }),
+			span:    60,
+			methods: []conf.Method{conf.Avg, conf.Sum, conf.Max},
+			// 2 full buckets and one partial one
+			exp: map[schema.Method][]whisper.Point{
+				schema.Sum: {{Timestamp: 60, Value: 21}, {Timestamp: 120, Value: 57}, {Timestamp: 180, Value: 27}},
+				schema.Cnt: {{Timestamp: 60, Value: 6}, {Timestamp: 120, Value: 6}, {Timestamp: 180, Value: 2}},
+				schema.Max: {{Timestamp: 60, Value: 6}, {Timestamp: 120, Value: 12}, {Timestamp: 180, Value: 14}},
+			},
+		},
+		{
+			name:    "points on a boundary belong to the bucket ending there",
+			points:  []whisper.Point{{Timestamp: 60, Value: 1}, {Timestamp: 61, Value: 2}, {Timestamp: 120, Value: 3}, {Timestamp: 121, Value: 4}},
+			span:    60,
+			methods: []conf.Method{conf.Lst, conf.Min},
+			exp: map[schema.Method][]whisper.Point{
+				schema.Lst: {{Timestamp: 60, Value: 1}, {Timestamp: 120, Value: 3}, {Timestamp: 180, Value: 4}},
+				schema.Min: {{Timestamp: 60, Value: 1}, {Timestamp: 120, Value: 2}, {Timestamp: 180, Value: 4}},
+			},
+		},
+		{
+			name:    "buckets without points are skipped",
+			points:  []whisper.Point{{Timestamp: 10, Value: 1}, {Timestamp: 250, Value: 2}},
+			span:    60,
+			methods: []conf.Method{conf.Sum},
+			exp: map[schema.Method][]whisper.Point{
+				schema.Sum: {{Timestamp: 60, Value: 1}, {Timestamp: 300, Value: 2}},
+			},
+		},
+		{
+			name:    "duplicate methods",
+			points:  []whisper.Point{{Timestamp: 10, Value: 1}, {Timestamp: 20, Value: 3}},
+			span:    3600,
+			methods: []conf.Method{conf.Avg, conf.Sum},
+			exp: map[schema.Method][]whisper.Point{
+				schema.Sum: {{Timestamp: 3600, Value: 4}},
+				schema.Cnt: {{Timestamp: 3600, Value: 2}},
+			},
+		},
+		{
+			name:    "no points",
+			points:  nil,
+			span:    60,
+			methods: []conf.Method{conf.Max},
+			exp: map[schema.Method][]whisper.Point{
+				schema.Max: nil,
+			},
+		},
+	}
+	for _, c := range cases {
+		if got := rollup(c.points, c.span, c.methods); !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("case %q: expected %v, got %v", c.name, c.exp, got)
+		}
+	}
+}
+
+func TestBucketRuns(t *testing.T) {
+	cases := []struct {
+		name   string
+		points []whisper.Point
+		exp    [][2]uint32
+	}{
+		{
+			name:   "one bucket",
+			points: []whisper.Point{{Timestamp: 10}, {Timestamp: 60}},
+			exp:    [][2]uint32{{60, 60}},
+		},
+		{
+			name:   "consecutive buckets",
+			points: []whisper.Point{{Timestamp: 10}, {Timestamp: 61}, {Timestamp: 130}},
+			exp:    [][2]uint32{{60, 180}},
+		},
+		{
+			name:   "gap between buckets",
+			points: []whisper.Point{{Timestamp: 10}, {Timestamp: 61}, {Timestamp: 250}},
+			exp:    [][2]uint32{{60, 120}, {300, 300}},
+		},
+	}
+	for _, c := range cases {
+		if got := bucketRuns(c.points, 60); !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("case %q: expected %v, got %v", c.name, c.exp, got)
+		}
+	}
+}
+
+func TestMergePoints(t *testing.T) {
+	cases := []struct {
+		name     string
+		existing []whisper.Point
+		new      []whisper.Point
+		exp      []whisper.Point
+	}{
+		{
+			name: "nothing stored",
+			new:  []whisper.Point{{Timestamp: 10, Value: 1}},
+			exp:  []whisper.Point{{Timestamp: 10, Value: 1}},
+		},
+		{
+			name:     "interleaved",
+			existing: []whisper.Point{{Timestamp: 10, Value: 1}, {Timestamp: 30, Value: 3}},
+			new:      []whisper.Point{{Timestamp: 20, Value: 2}, {Timestamp: 40, Value: 4}},
+			exp:      []whisper.Point{{Timestamp: 10, Value: 1}, {Timestamp: 20, Value: 2}, {Timestamp: 30, Value: 3}, {Timestamp: 40, Value: 4}},
+		},
+		{
+			name:     "new points replace stored points with the same timestamp",
+			existing: []whisper.Point{{Timestamp: 10, Value: 1}, {Timestamp: 20, Value: 2}, {Timestamp: 30, Value: 3}},
+			new:      []whisper.Point{{Timestamp: 10, Value: 5}, {Timestamp: 30, Value: 6}},
+			exp:      []whisper.Point{{Timestamp: 10, Value: 5}, {Timestamp: 20, Value: 2}, {Timestamp: 30, Value: 6}},
+		},
+	}
+	for _, c := range cases {
+		if got := mergePoints(c.existing, c.new); !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("case %q: expected %v, got %v", c.name, c.exp, got)
+		}
+	}
+}
+
+func testSchemasAggs() (conf.Schemas, conf.Aggregations) {
+	schemas := conf.NewSchemas([]conf.Schema{{
+		Name:    "test",
+		Pattern: regexp.MustCompile("^test"),
+		Retentions: conf.Retentions{
+			conf.NewRetentionMT(10, 86400, 600, 2, 0),
+			conf.NewRetentionMT(60, 86400*7, 3600, 2, 0),
+		},
+	}})
+	aggs := conf.NewAggregations()
+	aggs.Data = append(aggs.Data, conf.Aggregation{
+		Name:              "test",
+		Pattern:           regexp.MustCompile("^test"),
+		AggregationMethod: []conf.Method{conf.Lst},
+	})
+	return schemas, aggs
+}
+
+func TestNewArchiveRequestFromPoints(t *testing.T) {
+	schemas, aggs := testSchemasAggs()
+	md := schema.MetricData{Name: "test.metric", OrgId: 1, Interval: 10, Mtype: "gauge", Tags: []string{"a=b"}}
+	md.SetId()
+	var points []schema.Point
+	for ts := uint32(37210); ts >= 36010; ts -= 10 {
+		points = append(points, schema.Point{Val: float64(ts), Ts: ts})
+	}
+	ar, err := NewArchiveRequestFromPoints(context.Background(), md, points, schemas, aggs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ar.MetricData.Time != 37210 {
+		t.Fatalf("expected time of the last point 37210, got %d", ar.MetricData.Time)
+	}
+	// raw: 3 chunks of 600s. rollup: 1 chunk of 3600s
+	got := make(map[schema.Archive][]uint32)
+	for _, cwr := range ar.ChunkWriteRequests {
+		got[cwr.Archive] = append(got[cwr.Archive], cwr.T0)
+	}
+	exp := map[schema.Archive][]uint32{
+		0:                                 {36000, 36600, 37200},
+		schema.NewArchive(schema.Lst, 60): {36000},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected chunks %v, got %v", exp, got)
+	}
+
+	for _, cwr := range ar.ChunkWriteRequests {
+		if cwr.Archive == 0 {
+			if cwr.TTL != 86400 {
+				t.Fatalf("expected raw ttl 86400, got %d", cwr.TTL)
+			}
+			continue
+		}
+		if cwr.TTL != 86400*7 {
+			t.Fatalf("expected rollup ttl %d, got %d", 86400*7, cwr.TTL)
+		}
+		itgen, err := chunk.NewIterGen(cwr.T0, 60, cwr.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := itgen.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		for it.Next() {
+			ts, val := it.Values()
+			n++
+			// the last bucket is partial
+			last := ts
+			if last > 37210 {
+				last = 37210
+			}
+			if float64(last) != val || ts%60 != 0 {
+				t.Fatalf("expected the last point of the bucket ending at %d, got %f", ts, val)
+			}
+		}
+		if n != 21 {
+			t.Fatalf("expected 21 rollup points, got %d", n)
+		}
+	}
+}
+
+func TestNewArchiveRequestFromPointsMergesStored(t *testing.T) {
+	schemas, aggs := testSchemasAggs()
+	md := schema.MetricData{Name: "test.metric", OrgId: 1, Interval: 10, Mtype: "gauge"}
+	md.SetId()
+	mkey, _ := schema.MKeyFromString(md.Id)
+
+	store := mdata.NewMockStore()
+	c := chunk.New(36000)
+	for ts := uint32(36010); ts < 36600; ts += 10 {
+		c.Push(ts, 1)
+	}
+	c.Finish()
+	cwr := mdata.NewChunkWriteRequest(nil, schema.AMKey{MKey: mkey}, 86400, 36000, c.Encode(600), time.Now())
+	store.Add(&cwr)
+
+	points := []schema.Point{{Val: 5, Ts: 36300}, {Val: 6, Ts: 36610}}
+	ar, err := NewArchiveRequestFromPoints(context.Background(), md, points, schemas, aggs, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[schema.Archive][]schema.Point)
+	for _, cwr := range ar.ChunkWriteRequests {
+		itgen, err := chunk.NewIterGen(cwr.T0, cwr.Archive.Span(), cwr.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := itgen.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for it.Next() {
+			ts, val := it.Values()
+			got[cwr.Archive] = append(got[cwr.Archive], schema.Point{Val: val, Ts: ts})
+		}
+	}
+
+	raw := got[0]
+	if len(raw) != 60 {
+		t.Fatalf("expected the 59 stored points and 1 new one in the raw chunks, got %d points", len(raw))
+	}
+	for _, p := range raw {
+		exp := 1.0
+		switch p.Ts {
+		case 36300:
+			exp = 5
+		case 36610:
+			exp = 6
+		}
+		if p.Val != exp {
+			t.Fatalf("expected value %f at %d, got %f", exp, p.Ts, p.Val)
+		}
+	}
+	// only the buckets of the new points are recomputed, including the stored raw points in them
+	exp := []schema.Point{{Val: 5, Ts: 36300}, {Val: 6, Ts: 36660}}
+	if rollup := got[schema.NewArchive(schema.Lst, 60)]; !reflect.DeepEqual(rollup, exp) {
+		t.Fatalf("expected rollup points %v, got %v", exp, rollup)
+	}
+}
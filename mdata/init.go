@@ -6,6 +6,7 @@ package mdata
 import (
 	"flag"
 	"io/ioutil"
+	"time"
 
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/conf"
@@ -104,12 +105,19 @@ func ConfigSetup() {
 	retentionConf := flag.NewFlagSet("retention", flag.ExitOnError)
 	retentionConf.StringVar(&schemasFile, "schemas-file", "/etc/metrictank/storage-schemas.conf", "path to storage-schemas.conf file")
 	retentionConf.StringVar(&aggFile, "aggregations-file", "/etc/metrictank/storage-aggregation.conf", "path to storage-aggregation.conf file")
+	retentionConf.BoolVar(&backfill, "backfill", false, "accept points that are too old for the reorder window and the chunk they belong to, and merge them into their chunks in memory or in the store, recomputing the affected rollup points. points with a timestamp that already has a value are still discarded.")
+	retentionConf.DurationVar(&backfillInterval, "backfill-interval", time.Minute, "how often to merge the accepted late points into their chunks")
+	retentionConf.IntVar(&backfillMaxPoints, "backfill-max-points", 10000, "maximum number of late points per series to accept per backfill-interval. further late points are discarded. (0 disables limit)")
 	globalconf.Register("retention", retentionConf, flag.ExitOnError)
 }
 
 func ConfigProcess() {
 	var err error
 
+	if backfill && backfillInterval <= 0 {
+		log.Fatalf("retention.backfill-interval must be positive when backfill is enabled")
+	}
+
 	// === read storage-schemas.conf ===
 
 	// graphite behavior: abort on any config reading errors, but skip any rules that have problems.
@@ -5,14 +5,14 @@ import (
 
 	"github.com/raintank/schema"
 
-	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/idx"
 	"github.com/grafana/metrictank/stats"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	notifiers []Notifier
+	notifiers          []Notifier
+	chunkSaveListeners []ChunkSaveListener
 
 	// metric cluster.notifier.all.messages-received is a counter of messages received from cluster notifiers
 	messagesReceived = stats.NewCounter32("cluster.notifier.all.messages-received")
@@ -48,6 +48,16 @@ func InitPersistNotifier(not ...Notifier) {
 	notifiers = not
 }
 
+// ChunkSaveListener is notified of every chunk that has been saved, whether by this node or by a peer.
+type ChunkSaveListener interface {
+	ChunkSaved(key schema.AMKey, t0 uint32)
+}
+
+// AddChunkSaveListener registers a listener for chunk saves. it must be called before any data is ingested.
+func AddChunkSaveListener(l ChunkSaveListener) {
+	chunkSaveListeners = append(chunkSaveListeners, l)
+}
+
 type NotifierHandler interface {
 	// Handle handles an incoming message
 	Handle([]byte)
@@ -96,13 +106,7 @@ func (dn DefaultNotifierHandler) Handle(data []byte) {
 				continue
 			}
 			agg := dn.metrics.GetOrCreate(amkey.MKey, def.SchemaId, def.AggId, uint32(def.Interval))
-			if amkey.Archive != 0 {
-				consolidator := consolidation.FromArchive(amkey.Archive.Method())
-				aggSpan := amkey.Archive.Span()
-				agg.(*AggMetric).SyncAggregatedChunkSaveState(c.T0, consolidator, aggSpan)
-			} else {
-				agg.(*AggMetric).SyncChunkSaveState(c.T0, false)()
-			}
+			agg.(*AggMetric).SyncArchiveChunkSaveState(c.T0, amkey.Archive)
 		}
 	} else {
 		log.Errorf("notifier: unknown version %d", version)
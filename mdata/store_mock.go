@@ -2,7 +2,6 @@ package mdata
 
 import (
 	"context"
-	"errors"
 
 	"github.com/raintank/schema"
 
@@ -51,14 +50,8 @@ func (c *MockStore) Add(cwr *ChunkWriteRequest) {
 
 // searches through the mock results and returns the right ones according to start / end
 func (c *MockStore) Search(ctx context.Context, metric schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
-	var itgens, res []chunk.IterGen
-	var ok bool
-
-	if itgens, ok = c.results[metric]; !ok {
-		return res, errors.New("metric not found")
-	}
-
-	for _, itgen := range itgens {
+	var res []chunk.IterGen
+	for _, itgen := range c.results[metric] {
 		// start is inclusive, end is exclusive
 		if itgen.T0 < end && itgen.EndTs() > start && start < end {
 			res = append(res, itgen)
@@ -61,6 +61,7 @@ Metrictank comes with an [example main config file](https://github.com/grafana/m
 a [storage-schemas.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/storage-schemas.conf) and
 a [storage-aggregation.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/storage-aggregation.conf)
 an [index-rules.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/index-rules.conf)
+a [tenant-limits.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/tenant-limits.conf)
 
 The files themselves are well documented, but for your convenience, they are replicated below.  
 
@@ -118,6 +119,16 @@ cat scripts/config/storage-schemas.conf
 cat << EOF
 \`\`\`
 
+# tenant-limits.conf
+
+\`\`\`
+EOF
+
+cat scripts/config/tenant-limits.conf
+
+cat << EOF
+\`\`\`
+
 This file is generated by [config-to-doc](https://github.com/grafana/metrictank/blob/master/scripts/dev/config-to-doc.sh)
 
 EOF
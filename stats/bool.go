@@ -36,8 +36,11 @@ func (b *Bool) Peek() bool {
 	return true
 }
 
-func (b *Bool) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (b *Bool) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	val := atomic.LoadUint32(&b.val)
-	buf = WriteUint32(buf, prefix, []byte("gauge1"), val, now)
-	return buf
+	w.WriteUint32(prefix, []byte("gauge1"), val, now)
+}
+
+func (b *Bool) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	s.Gauge(name, "", float64(atomic.LoadUint32(&b.val)))
 }
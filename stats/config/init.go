@@ -11,20 +11,28 @@ import (
 )
 
 var enabled bool
+var output string
 var prefix string
 var addr string
 var interval int
 var bufferSize int
 var timeout time.Duration
+var selfOrgId int
+var selfBufferSize int
+
+var self *stats.Self
 
 func ConfigSetup() {
 	inStats := flag.NewFlagSet("stats", flag.ExitOnError)
 	inStats.BoolVar(&enabled, "enabled", true, "enable sending graphite messages for instrumentation")
+	inStats.StringVar(&output, "output", "graphite", "where to report the instrumentation to: graphite (send to addr), prometheus (expose at the /prometheus/metrics endpoint of the http api) or self (ingest into this metrictank, requires an input. only while the node is primary, and not replicated to other nodes of its partition)")
 	inStats.StringVar(&prefix, "prefix", "metrictank.stats.default.$instance", "stats prefix (will add trailing dot automatically if needed)")
 	inStats.StringVar(&addr, "addr", "localhost:2003", "graphite address")
 	inStats.IntVar(&interval, "interval", 1, "interval at which to send statistics")
 	inStats.DurationVar(&timeout, "timeout", time.Second*10, "timeout after which a write is considered not successful")
 	inStats.IntVar(&bufferSize, "buffer-size", 20000, "how many messages (holding all measurements from one interval. rule of thumb: a message is ~25kB) to buffer up in case graphite endpoint is unavailable. With the default of 20k you will use max about 500MB and bridge 5 hours of downtime when needed")
+	inStats.IntVar(&selfOrgId, "self-org-id", 1, "org to ingest the instrumentation into, for the self output")
+	inStats.IntVar(&selfBufferSize, "self-buffer-size", 300, "how many reports (holding all measurements from one interval) to buffer up while the node is not ready, for the self output")
 	globalconf.Register("stats", inStats, flag.ExitOnError)
 }
 
@@ -32,6 +40,12 @@ func ConfigProcess(instance string) {
 	if !enabled {
 		return
 	}
+	if output != "graphite" && output != "prometheus" && output != "self" {
+		log.Fatalf("stats: invalid output %q. must be graphite, prometheus or self", output)
+	}
+	if output == "self" && selfOrgId < 1 {
+		log.Fatalf("stats: invalid self-org-id %d. must be >= 1", selfOrgId)
+	}
 	// TODO validate tcp addr
 	prefix = strings.Replace(prefix, "$instance", instance, -1)
 }
@@ -44,9 +58,29 @@ func Start() {
 		if err != nil {
 			log.Fatalf("stats: could not initialize process reporter: %v", err)
 		}
-		stats.NewGraphite(prefix, addr, interval, bufferSize, timeout)
+		switch output {
+		case "prometheus":
+			stats.NewPrometheus("metrictank", interval)
+		case "self":
+			self = stats.NewSelf(prefix, selfOrgId, interval, selfBufferSize)
+		default:
+			stats.NewGraphite(prefix, addr, interval, bufferSize, timeout)
+		}
 	} else {
 		stats.NewDevnull()
 		log.Warn("running metrictank without instrumentation.")
 	}
 }
+
+// SelfEnabled returns whether the instrumentation is ingested into this metrictank
+func SelfEnabled() bool {
+	return enabled && output == "self"
+}
+
+// StartSelf starts ingesting the instrumentation via the given handler, whenever ready returns true.
+// It is a no-op unless the self output is enabled.
+func StartSelf(handler stats.MetricDataHandler, partition int32, ready func() bool) {
+	if self != nil {
+		self.Start(handler, partition, ready)
+	}
+}
@@ -33,8 +33,11 @@ func (c *Counter32) Peek() uint32 {
 	return atomic.LoadUint32(&c.val)
 }
 
-func (c *Counter32) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (c *Counter32) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	val := atomic.LoadUint32(&c.val)
-	buf = WriteUint32(buf, prefix, []byte("counter32"), val, now)
-	return buf
+	w.WriteUint32(prefix, []byte("counter32"), val, now)
+}
+
+func (c *Counter32) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	s.Counter(name, "", float64(atomic.LoadUint32(&c.val)))
 }
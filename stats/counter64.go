@@ -25,8 +25,11 @@ func (c *Counter64) AddUint64(val uint64) {
 	atomic.AddUint64(&c.val, val)
 }
 
-func (c *Counter64) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (c *Counter64) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	val := atomic.LoadUint64(&c.val)
-	buf = WriteUint64(buf, prefix, []byte("counter64"), val, now)
-	return buf
+	w.WriteUint64(prefix, []byte("counter64"), val, now)
+}
+
+func (c *Counter64) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	s.Counter(name, "", float64(atomic.LoadUint64(&c.val)))
 }
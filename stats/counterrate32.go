@@ -39,12 +39,16 @@ func (c *CounterRate32) Peek() uint32 {
 	return atomic.LoadUint32(&c.val)
 }
 
-func (c *CounterRate32) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (c *CounterRate32) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	val := atomic.LoadUint32(&c.val)
-	buf = WriteUint32(buf, prefix, []byte("counter32"), val, now)
-	buf = WriteFloat64(buf, prefix, []byte("rate32"), float64(val-c.prev)/now.Sub(c.since).Seconds(), now)
+	w.WriteUint32(prefix, []byte("counter32"), val, now)
+	w.WriteFloat64(prefix, []byte("rate32"), float64(val-c.prev)/now.Sub(c.since).Seconds(), now)
 
 	c.prev = val
 	c.since = now
-	return buf
+}
+
+// ReportPrometheus only reports the counter, prometheus derives the rate from it
+func (c *CounterRate32) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	s.Counter(name, "", float64(atomic.LoadUint32(&c.val)))
 }
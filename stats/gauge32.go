@@ -49,8 +49,11 @@ func (g *Gauge32) SetUint32(val uint32) {
 	atomic.StoreUint32(&g.val, val)
 }
 
-func (g *Gauge32) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (g *Gauge32) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	val := atomic.LoadUint32(&g.val)
-	buf = WriteUint32(buf, prefix, []byte("gauge32"), val, now)
-	return buf
+	w.WriteUint32(prefix, []byte("gauge32"), val, now)
+}
+
+func (g *Gauge32) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	s.Gauge(name, "", float64(atomic.LoadUint32(&g.val)))
 }
@@ -48,10 +48,13 @@ func (g *Gauge64) SetUint64(val uint64) {
 	atomic.StoreUint64((*uint64)(g), val)
 }
 
-func (g *Gauge64) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (g *Gauge64) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	val := atomic.LoadUint64((*uint64)(g))
-	buf = WriteUint64(buf, prefix, []byte("gauge64"), val, now)
-	return buf
+	w.WriteUint64(prefix, []byte("gauge64"), val, now)
+}
+
+func (g *Gauge64) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	s.Gauge(name, "", float64(atomic.LoadUint64((*uint64)(g))))
 }
 
 func (g *Gauge64) Peek() uint64 {
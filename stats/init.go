@@ -6,7 +6,7 @@
 // (e.g. histograms and meters) resulting in unreasonable memory usage.
 // (though you can ignore this for shortlived processes, unit tests, etc)
 // If you use >1 outputs, then each will only see a partial view of the stats.
-// Currently supported outputs are DevNull and Graphite
+// Currently supported outputs are DevNull, Graphite, Prometheus and Self
 package stats
 
 var registry *Registry
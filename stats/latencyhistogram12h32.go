@@ -10,6 +10,10 @@ import (
 type LatencyHistogram12h32 struct {
 	hist  hist12h.Hist12h
 	since time.Time
+
+	// cumulative count and approximate sum of all values, only used for prometheus summaries
+	totalCount uint64
+	totalSum   uint64 // in millis
 }
 
 func NewLatencyHistogram12h32(name string) *LatencyHistogram12h32 {
@@ -24,21 +28,40 @@ func (l *LatencyHistogram12h32) Value(t time.Duration) {
 	l.hist.AddDuration(t)
 }
 
-func (l *LatencyHistogram12h32) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (l *LatencyHistogram12h32) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	snap := l.hist.Snapshot()
 	// TODO: once we can actually do cool stuff (e.g. visualize) histogram bucket data, report it
 	// for now, only report the summaries :(
 	r, ok := l.hist.Report(snap)
 	if ok {
-		buf = WriteUint32(buf, prefix, []byte("latency.min.gauge32"), r.Min/1000, now)
-		buf = WriteUint32(buf, prefix, []byte("latency.mean.gauge32"), r.Mean/1000, now)
-		buf = WriteUint32(buf, prefix, []byte("latency.median.gauge32"), r.Median/1000, now)
-		buf = WriteUint32(buf, prefix, []byte("latency.p75.gauge32"), r.P75/1000, now)
-		buf = WriteUint32(buf, prefix, []byte("latency.p90.gauge32"), r.P90/1000, now)
-		buf = WriteUint32(buf, prefix, []byte("latency.max.gauge32"), r.Max/1000, now)
+		w.WriteUint32(prefix, []byte("latency.min.gauge32"), r.Min/1000, now)
+		w.WriteUint32(prefix, []byte("latency.mean.gauge32"), r.Mean/1000, now)
+		w.WriteUint32(prefix, []byte("latency.median.gauge32"), r.Median/1000, now)
+		w.WriteUint32(prefix, []byte("latency.p75.gauge32"), r.P75/1000, now)
+		w.WriteUint32(prefix, []byte("latency.p90.gauge32"), r.P90/1000, now)
+		w.WriteUint32(prefix, []byte("latency.max.gauge32"), r.Max/1000, now)
+	}
+	w.WriteUint32(prefix, []byte("values.count32"), r.Count, now)
+	w.WriteFloat64(prefix, []byte("values.rate32"), float64(r.Count)/now.Sub(l.since).Seconds(), now)
+	l.since = now
+}
+
+// ReportPrometheus reports a summary, in seconds, with the quantiles of the latencies of the last interval.
+// min and max are reported as the 0 and 1 quantiles.
+func (l *LatencyHistogram12h32) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	snap := l.hist.Snapshot()
+	quantiles := make(map[float64]float64)
+	r, ok := l.hist.Report(snap)
+	if ok {
+		quantiles[0] = float64(r.Min) / 1e3
+		quantiles[0.5] = float64(r.Median) / 1e3
+		quantiles[0.75] = float64(r.P75) / 1e3
+		quantiles[0.9] = float64(r.P90) / 1e3
+		quantiles[1] = float64(r.Max) / 1e3
+		l.totalCount += uint64(r.Count)
+		// we don't track the sum, so derive it from the mean
+		l.totalSum += uint64(r.Mean) * uint64(r.Count)
 	}
-	buf = WriteUint32(buf, prefix, []byte("values.count32"), r.Count, now)
-	buf = WriteFloat64(buf, prefix, []byte("values.rate32"), float64(r.Count)/now.Sub(l.since).Seconds(), now)
+	s.Summary(name, "latency_seconds", l.totalCount, float64(l.totalSum)/1e3, quantiles)
 	l.since = now
-	return buf
 }
@@ -12,6 +12,10 @@ type LatencyHistogram15s32 struct {
 	hist  hist15s.Hist15s
 	since time.Time
 	sum   uint64 // in micros. to generate more accurate mean
+
+	// cumulative count and sum of all values, only used for prometheus summaries
+	totalCount uint64
+	totalSum   uint64 // in micros
 }
 
 func NewLatencyHistogram15s32(name string) *LatencyHistogram15s32 {
@@ -27,23 +31,41 @@ func (l *LatencyHistogram15s32) Value(t time.Duration) {
 	l.hist.AddDuration(t)
 }
 
-func (l *LatencyHistogram15s32) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (l *LatencyHistogram15s32) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	snap := l.hist.Snapshot()
 	// TODO: once we can actually do cool stuff (e.g. visualize) histogram bucket data, report it
 	// for now, only report the summaries :(
 	r, ok := l.hist.Report(snap)
 	if ok {
 		sum := atomic.SwapUint64(&l.sum, 0)
-		buf = WriteUint32(buf, prefix, []byte("latency.min.gauge32"), r.Min/1000, now)
-		buf = WriteUint32(buf, prefix, []byte("latency.mean.gauge32"), uint32((sum / uint64(r.Count) / 1000)), now)
-		buf = WriteUint32(buf, prefix, []byte("latency.median.gauge32"), r.Median/1000, now)
-		buf = WriteUint32(buf, prefix, []byte("latency.p75.gauge32"), r.P75/1000, now)
-		buf = WriteUint32(buf, prefix, []byte("latency.p90.gauge32"), r.P90/1000, now)
-		buf = WriteUint32(buf, prefix, []byte("latency.max.gauge32"), r.Max/1000, now)
+		w.WriteUint32(prefix, []byte("latency.min.gauge32"), r.Min/1000, now)
+		w.WriteUint32(prefix, []byte("latency.mean.gauge32"), uint32((sum / uint64(r.Count) / 1000)), now)
+		w.WriteUint32(prefix, []byte("latency.median.gauge32"), r.Median/1000, now)
+		w.WriteUint32(prefix, []byte("latency.p75.gauge32"), r.P75/1000, now)
+		w.WriteUint32(prefix, []byte("latency.p90.gauge32"), r.P90/1000, now)
+		w.WriteUint32(prefix, []byte("latency.max.gauge32"), r.Max/1000, now)
 	}
-	buf = WriteUint32(buf, prefix, []byte("values.count32"), r.Count, now)
-	buf = WriteFloat64(buf, prefix, []byte("values.rate32"), float64(r.Count)/now.Sub(l.since).Seconds(), now)
+	w.WriteUint32(prefix, []byte("values.count32"), r.Count, now)
+	w.WriteFloat64(prefix, []byte("values.rate32"), float64(r.Count)/now.Sub(l.since).Seconds(), now)
+
+	l.since = now
+}
 
+// ReportPrometheus reports a summary, in seconds, with the quantiles of the latencies of the last interval.
+// min and max are reported as the 0 and 1 quantiles.
+func (l *LatencyHistogram15s32) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	snap := l.hist.Snapshot()
+	quantiles := make(map[float64]float64)
+	r, ok := l.hist.Report(snap)
+	if ok {
+		quantiles[0] = float64(r.Min) / 1e6
+		quantiles[0.5] = float64(r.Median) / 1e6
+		quantiles[0.75] = float64(r.P75) / 1e6
+		quantiles[0.9] = float64(r.P90) / 1e6
+		quantiles[1] = float64(r.Max) / 1e6
+		l.totalCount += uint64(r.Count)
+		l.totalSum += atomic.SwapUint64(&l.sum, 0)
+	}
+	s.Summary(name, "latency_seconds", l.totalCount, float64(l.totalSum)/1e6, quantiles)
 	l.since = now
-	return buf
 }
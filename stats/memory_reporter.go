@@ -36,37 +36,52 @@ func getGcPercent() int {
 	return val
 }
 
-func (m *MemoryReporter) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (m *MemoryReporter) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	runtime.ReadMemStats(&m.mem)
 	gcPercent := getGcPercent()
 
 	// metric memory.total_bytes_allocated is a counter of total number of bytes allocated during process lifetime
-	buf = WriteUint64(buf, prefix, []byte("total_bytes_allocated.counter64"), m.mem.TotalAlloc, now)
+	w.WriteUint64(prefix, []byte("total_bytes_allocated.counter64"), m.mem.TotalAlloc, now)
 
 	// metric memory.bytes_allocated_on_heap is a gauge of currently allocated (within the runtime) memory.
-	buf = WriteUint64(buf, prefix, []byte("bytes.allocated_in_heap.gauge64"), m.mem.Alloc, now)
+	w.WriteUint64(prefix, []byte("bytes.allocated_in_heap.gauge64"), m.mem.Alloc, now)
 
 	// metric memory.bytes.obtained_from_sys is the number of bytes currently obtained from the system by the process.  This is what the profiletrigger looks at.
-	buf = WriteUint64(buf, prefix, []byte("bytes.obtained_from_sys.gauge64"), m.mem.Sys, now)
+	w.WriteUint64(prefix, []byte("bytes.obtained_from_sys.gauge64"), m.mem.Sys, now)
 
 	// metric memory.total_gc_cycles is a counter of the number of GC cycles since process start
-	buf = WriteUint32(buf, prefix, []byte("total_gc_cycles.counter64"), m.mem.NumGC, now)
+	w.WriteUint32(prefix, []byte("total_gc_cycles.counter64"), m.mem.NumGC, now)
 
 	// metric memory.gc.cpu_fraction is how much cpu is consumed by the GC across process lifetime, in pro-mille
-	buf = WriteUint32(buf, prefix, []byte("gc.cpu_fraction.gauge32"), uint32(1000*m.mem.GCCPUFraction), now)
+	w.WriteUint32(prefix, []byte("gc.cpu_fraction.gauge32"), uint32(1000*m.mem.GCCPUFraction), now)
 
 	// metric memory.gc.heap_objects is how many objects are allocated on the heap, it's a key indicator for GC workload
-	buf = WriteUint64(buf, prefix, []byte("gc.heap_objects.gauge64"), m.mem.HeapObjects, now)
+	w.WriteUint64(prefix, []byte("gc.heap_objects.gauge64"), m.mem.HeapObjects, now)
 
 	// there was no new GC run, we should only report points to represent actual runs
 	if m.gcCyclesTotal != m.mem.NumGC {
 		// metric memory.gc.last_duration is the duration of the last GC STW pause in nanoseconds
-		buf = WriteUint64(buf, prefix, []byte("gc.last_duration.gauge64"), m.mem.PauseNs[(m.mem.NumGC+255)%256], now)
+		w.WriteUint64(prefix, []byte("gc.last_duration.gauge64"), m.mem.PauseNs[(m.mem.NumGC+255)%256], now)
 		m.gcCyclesTotal = m.mem.NumGC
 	}
 
 	// metric memory.gc.gogc is the current GOGC value (derived from the GOGC environment variable)
-	buf = WriteInt32(buf, prefix, []byte("gc.gogc.sgauge32"), int32(gcPercent), now)
+	w.WriteInt32(prefix, []byte("gc.gogc.sgauge32"), int32(gcPercent), now)
+}
+
+func (m *MemoryReporter) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	runtime.ReadMemStats(&m.mem)
 
-	return buf
+	s.Counter(name, "total_bytes_allocated", float64(m.mem.TotalAlloc))
+	s.Gauge(name, "bytes_allocated_in_heap", float64(m.mem.Alloc))
+	s.Gauge(name, "bytes_obtained_from_sys", float64(m.mem.Sys))
+	s.Counter(name, "total_gc_cycles", float64(m.mem.NumGC))
+	// as a fraction, rather than in pro-mille
+	s.Gauge(name, "gc_cpu_fraction", m.mem.GCCPUFraction)
+	s.Gauge(name, "gc_heap_objects", float64(m.mem.HeapObjects))
+	if m.mem.NumGC != 0 {
+		s.Gauge(name, "gc_last_duration_seconds", float64(m.mem.PauseNs[(m.mem.NumGC+255)%256])/1e9)
+	}
+	m.gcCyclesTotal = m.mem.NumGC
+	s.Gauge(name, "gc_gogc", float64(getGcPercent()))
 }
@@ -23,6 +23,10 @@ type Meter32 struct {
 	max   uint32
 	count uint32
 	since time.Time
+
+	// cumulative count and sum of all values, only used for prometheus summaries
+	totalCount uint64
+	totalSum   uint64
 }
 
 func NewMeter32(name string, approx bool) *Meter32 {
@@ -92,11 +96,11 @@ func (m *Meter32) ValuesUint32(val, num uint32) {
 	m.Unlock()
 }
 
-func (m *Meter32) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (m *Meter32) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	m.Lock()
 	if m.count == 0 {
 		m.Unlock()
-		return buf
+		return
 	}
 	keys := make([]int, 0, len(m.hist))
 	for k := range m.hist {
@@ -123,20 +127,56 @@ func (m *Meter32) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
 		runningsum += uint64(m.hist[key]) * uint64(key)
 		p := float64(runningcount) / float64(m.count)
 		for pidx < len(quantiles) && quantiles[pidx].p <= p {
-			buf = WriteUint32(buf, prefix, []byte(quantiles[pidx].str), key, now)
+			w.WriteUint32(prefix, []byte(quantiles[pidx].str), key, now)
 			pidx++
 		}
 	}
 
-	buf = WriteUint32(buf, prefix, []byte("min.gauge32"), m.min, now)
-	buf = WriteUint32(buf, prefix, []byte("mean.gauge32"), uint32(runningsum/uint64(m.count)), now)
-	buf = WriteUint32(buf, prefix, []byte("max.gauge32"), m.max, now)
-	buf = WriteUint32(buf, prefix, []byte("values.count32"), m.count, now)
-	buf = WriteFloat64(buf, prefix, []byte("values.rate32"), float64(m.count)/now.Sub(m.since).Seconds(), now)
+	w.WriteUint32(prefix, []byte("min.gauge32"), m.min, now)
+	w.WriteUint32(prefix, []byte("mean.gauge32"), uint32(runningsum/uint64(m.count)), now)
+	w.WriteUint32(prefix, []byte("max.gauge32"), m.max, now)
+	w.WriteUint32(prefix, []byte("values.count32"), m.count, now)
+	w.WriteFloat64(prefix, []byte("values.rate32"), float64(m.count)/now.Sub(m.since).Seconds(), now)
 	m.since = now
 
 	m.clear()
 	m.Unlock()
+}
+
+// ReportPrometheus reports a summary with the quantiles of the values of the last interval.
+// min and max are reported as the 0 and 1 quantiles.
+func (m *Meter32) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	m.Lock()
+	quantiles := make(map[float64]float64)
+	if m.count != 0 {
+		keys := make([]int, 0, len(m.hist))
+		for k := range m.hist {
+			keys = append(keys, int(k))
+		}
+		sort.Ints(keys)
+
+		qs := []float64{0.50, 0.75, 0.90}
+		pidx := 0
+		runningcount := uint32(0)
+		runningsum := uint64(0)
+		for _, k := range keys {
+			key := uint32(k)
+			runningcount += m.hist[key]
+			runningsum += uint64(m.hist[key]) * uint64(key)
+			p := float64(runningcount) / float64(m.count)
+			for pidx < len(qs) && qs[pidx] <= p {
+				quantiles[qs[pidx]] = float64(key)
+				pidx++
+			}
+		}
+		quantiles[0] = float64(m.min)
+		quantiles[1] = float64(m.max)
+		m.totalCount += uint64(m.count)
+		m.totalSum += runningsum
+	}
+	s.Summary(name, "", m.totalCount, float64(m.totalSum), quantiles)
+	m.since = now
 
-	return buf
+	m.clear()
+	m.Unlock()
 }
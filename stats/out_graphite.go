@@ -19,8 +19,8 @@ var (
 )
 
 type GraphiteMetric interface {
-	// Report the measurements in graphite format and reset measurements for the next interval if needed
-	ReportGraphite(prefix []byte, buf []byte, now time.Time) []byte
+	// Report the measurements to the writer and reset measurements for the next interval if needed
+	ReportGraphite(w Writer, prefix []byte, now time.Time)
 }
 
 type Graphite struct {
@@ -65,7 +65,7 @@ func (g *Graphite) reporter(interval int) {
 
 		pre := time.Now()
 
-		buf := make([]byte, 0)
+		w := &GraphiteWriter{Buf: make([]byte, 0)}
 
 		var fullPrefix bytes.Buffer
 		for name, metric := range registry.list() {
@@ -73,8 +73,9 @@ func (g *Graphite) reporter(interval int) {
 			fullPrefix.Write(g.prefix)
 			fullPrefix.WriteString(name)
 			fullPrefix.WriteRune('.')
-			buf = metric.ReportGraphite(fullPrefix.Bytes(), buf, now)
+			metric.ReportGraphite(w, fullPrefix.Bytes(), now)
 		}
+		buf := w.Buf
 
 		genDataDuration.Set(int(time.Since(pre).Nanoseconds()))
 		messageSize.Set(len(buf))
@@ -0,0 +1,290 @@
+package stats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+type PrometheusMetric interface {
+	// Report the measurements to the prometheus snapshot and reset measurements for the next interval if needed
+	ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time)
+}
+
+// promTemplates map dotted metric names that have variable parts, to prometheus metric names with labels.
+// a segment {label} matches any segment and turns it into a label value, a segment * matches any segment
+// and keeps it in the metric name. the remaining segments must match literally.
+// names that don't match any template get a label for every numeric segment, named after the segment
+// preceding it. e.g. input.kafka-mdm.partition.3.lag becomes input_kafka_mdm_partition_lag{partition="3"}
+var promTemplates = [][]string{
+	strings.Split("api.request.{path}", "."),
+	strings.Split("api.request.{path}.size", "."),
+	strings.Split("api.request.{path}.status.{status}", "."),
+	strings.Split("api.request.render.proxy-due-to.{reason}", "."),
+	strings.Split("cluster.peer.{peer}.*", "."),
+	strings.Split("input.{input}.*.received", "."),
+	strings.Split("input.{input}.*.discarded.{reason}", "."),
+	strings.Split("limits.org.{org}.rejected.{limit}", "."),
+	strings.Split("store.tiered.tier.{tier}.chunks", "."),
+	strings.Split("version.{version}", "."),
+}
+
+// Prometheus reports all metrics as prometheus metrics at a given interval, and exposes the latest report
+// via the default prometheus registry, and therefore at the /prometheus/metrics endpoint.
+// Reporting at a fixed interval (rather than upon every scrape) assures that meters and histograms are reset
+// regularly, and that multiple scrapers all see the same, complete view.
+type Prometheus struct {
+	namespace string
+
+	sync.RWMutex
+	metrics []prometheus.Metric
+}
+
+func NewPrometheus(namespace string, interval int) {
+	genDataDuration = NewGauge32("stats.generate_message.duration")
+
+	p := &Prometheus{
+		namespace: namespace,
+	}
+	prometheus.MustRegister(p)
+	go p.reporter(interval)
+}
+
+// Describe implements prometheus.Collector. It describes nothing, because the set of metrics
+// changes over time, which makes it an unchecked collector.
+func (p *Prometheus) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// Collect implements prometheus.Collector
+func (p *Prometheus) Collect(ch chan<- prometheus.Metric) {
+	p.RLock()
+	for _, m := range p.metrics {
+		ch <- m
+	}
+	p.RUnlock()
+}
+
+func (p *Prometheus) reporter(interval int) {
+	ticker := tick(time.Duration(interval) * time.Second)
+	for now := range ticker {
+		log.Debugf("stats flushing for %s to prometheus", now)
+		pre := time.Now()
+		s := p.report(now)
+		p.Lock()
+		p.metrics = s.metrics
+		p.Unlock()
+		genDataDuration.Set(int(time.Since(pre).Nanoseconds()))
+	}
+}
+
+func (p *Prometheus) report(now time.Time) *PrometheusSnapshot {
+	metrics := registry.list()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	// report in a fixed order, so that naming conflicts are always resolved in the same way
+	sort.Strings(names)
+
+	s := NewPrometheusSnapshot(p.namespace)
+	for _, name := range names {
+		metric := metrics[name]
+		if pm, ok := metric.(PrometheusMetric); ok {
+			pm.ReportPrometheus(name, s, now)
+			continue
+		}
+		// we can't represent this metric, but it must still be reset
+		metric.ReportGraphite(discardWriter{}, nil, now)
+	}
+	return s
+}
+
+type promKind uint8
+
+const (
+	promCounter promKind = iota
+	promGauge
+	promSummary
+)
+
+// promFamily is a prometheus metric name, along with the type, help and label names of all its series
+type promFamily struct {
+	kind   promKind
+	help   string
+	labels []string
+}
+
+// PrometheusSnapshot collects the measurements of all metrics as prometheus metrics
+type PrometheusSnapshot struct {
+	namespace string
+	families  map[string]promFamily
+	seen      map[string]struct{}
+	metrics   []prometheus.Metric
+}
+
+func NewPrometheusSnapshot(namespace string) *PrometheusSnapshot {
+	return &PrometheusSnapshot{
+		namespace: namespace,
+		families:  make(map[string]promFamily),
+		seen:      make(map[string]struct{}),
+	}
+}
+
+// Counter adds a counter for the metric with the given name.
+// sub, if not empty, is appended to the metric name and is used by metrics that report multiple measurements.
+// the name of the counter gets the _total suffix.
+func (s *PrometheusSnapshot) Counter(name, sub string, val float64) {
+	s.add(promCounter, name, sub, func(desc *prometheus.Desc, labelValues []string) (prometheus.Metric, error) {
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, val, labelValues...)
+	})
+}
+
+// Gauge adds a gauge for the metric with the given name. see Counter for the meaning of sub.
+func (s *PrometheusSnapshot) Gauge(name, sub string, val float64) {
+	s.add(promGauge, name, sub, func(desc *prometheus.Desc, labelValues []string) (prometheus.Metric, error) {
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, val, labelValues...)
+	})
+}
+
+// Summary adds a summary for the metric with the given name. see Counter for the meaning of sub.
+// count and sum are cumulative over the lifetime of the metric, the quantiles may cover just the last interval.
+func (s *PrometheusSnapshot) Summary(name, sub string, count uint64, sum float64, quantiles map[float64]float64) {
+	s.add(promSummary, name, sub, func(desc *prometheus.Desc, labelValues []string) (prometheus.Metric, error) {
+		return prometheus.NewConstSummary(desc, count, sum, quantiles, labelValues...)
+	})
+}
+
+func (s *PrometheusSnapshot) add(kind promKind, name, sub string, newMetric func(*prometheus.Desc, []string) (prometheus.Metric, error)) {
+	metricName, help, labels, values := promName(name)
+	fqName, ok := s.family(kind, metricName, sub, help, labels)
+	if !ok {
+		// another metric already uses this name with a different type or labels,
+		// fall back to the full name, without labels
+		metricName, help, labels, values = promSanitize(name), name, nil, nil
+		fqName, ok = s.family(kind, metricName, sub, help, labels)
+		if !ok {
+			log.Debugf("stats: can't report metric %q to prometheus: conflicting name %q", name, fqName)
+			return
+		}
+	}
+
+	key := fqName + "\xff" + strings.Join(values, "\xff")
+	if _, ok := s.seen[key]; ok {
+		log.Debugf("stats: can't report metric %q to prometheus: duplicate series %q %v", name, fqName, values)
+		return
+	}
+	m, err := newMetric(prometheus.NewDesc(fqName, help, labels, nil), values)
+	if err != nil {
+		log.Debugf("stats: can't report metric %q to prometheus: %s", name, err)
+		return
+	}
+	s.seen[key] = struct{}{}
+	s.metrics = append(s.metrics, m)
+}
+
+// family returns the fully qualified name for the given metric name, and whether it is compatible
+// with the metrics that were already added under that name.
+func (s *PrometheusSnapshot) family(kind promKind, metricName, sub, help string, labels []string) (string, bool) {
+	fqName := s.namespace + "_" + metricName
+	if sub != "" {
+		fqName += "_" + sub
+	}
+	if kind == promCounter && !strings.HasSuffix(fqName, "_total") {
+		fqName += "_total"
+	}
+	f, ok := s.families[fqName]
+	if !ok {
+		s.families[fqName] = promFamily{kind: kind, help: help, labels: labels}
+		return fqName, true
+	}
+	if f.kind != kind || f.help != help || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+		return fqName, false
+	}
+	return fqName, true
+}
+
+// promName maps the dotted name of a metric to a prometheus metric name, help text, label names and label values
+func promName(name string) (string, string, []string, []string) {
+	segments := strings.Split(name, ".")
+	for _, tpl := range promTemplates {
+		if len(tpl) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, seg := range tpl {
+			if seg != "*" && !isPromLabel(seg) && seg != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		var parts, labels, values []string
+		for i, seg := range tpl {
+			if isPromLabel(seg) {
+				labels = append(labels, promSanitize(seg[1:len(seg)-1]))
+				values = append(values, segments[i])
+				continue
+			}
+			parts = append(parts, segments[i])
+		}
+		return promSanitize(strings.Join(parts, "_")), strings.Join(tpl, "."), labels, values
+	}
+
+	var parts, labels, values []string
+	help := make([]string, len(segments))
+	copy(help, segments)
+	for i, seg := range segments {
+		if i > 0 && isNumeric(seg) && !isNumeric(segments[i-1]) {
+			label := promSanitize(segments[i-1])
+			if !contains(labels, label) {
+				labels = append(labels, label)
+				values = append(values, seg)
+				help[i] = "{" + segments[i-1] + "}"
+				continue
+			}
+		}
+		parts = append(parts, seg)
+	}
+	return promSanitize(strings.Join(parts, "_")), strings.Join(help, "."), labels, values
+}
+
+func isPromLabel(seg string) bool {
+	return len(seg) > 2 && seg[0] == '{' && seg[len(seg)-1] == '}'
+}
+
+func isNumeric(seg string) bool {
+	if seg == "" {
+		return false
+	}
+	for _, c := range seg {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// promSanitize replaces all characters that are not valid in prometheus metric and label names by underscores
+func promSanitize(name string) string {
+	return strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			return c
+		}
+		return '_'
+	}, name)
+}
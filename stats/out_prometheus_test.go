@@ -0,0 +1,104 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPromName(t *testing.T) {
+	cases := []struct {
+		in     string
+		name   string
+		help   string
+		labels []string
+		values []string
+	}{
+		{"tank.chunk_operations.create", "tank_chunk_operations_create", "tank.chunk_operations.create", nil, nil},
+		{"api.request.render", "api_request", "api.request.{path}", []string{"path"}, []string{"render"}},
+		{"api.request.render.status.200", "api_request_status", "api.request.{path}.status.{status}", []string{"path", "status"}, []string{"render", "200"}},
+		{"api.request.render.series", "api_request_render_series", "api.request.render.series", nil, nil},
+		{"cluster.peer.mt-1.latency", "cluster_peer_latency", "cluster.peer.{peer}.*", []string{"peer"}, []string{"mt-1"}},
+		{"input.kafka-mdm.metricdata.discarded.invalid", "input_metricdata_discarded", "input.{input}.*.discarded.{reason}", []string{"input", "reason"}, []string{"kafka-mdm", "invalid"}},
+		{"input.kafka-mdm.partition.3.lag", "input_kafka_mdm_partition_lag", "input.kafka-mdm.partition.{partition}.lag", []string{"partition"}, []string{"3"}},
+		{"store.cassandra.write_queue.1.items", "store_cassandra_write_queue_items", "store.cassandra.write_queue.{write_queue}.items", []string{"write_queue"}, []string{"1"}},
+	}
+	for _, c := range cases {
+		name, help, labels, values := promName(c.in)
+		if name != c.name || help != c.help || !reflect.DeepEqual(labels, c.labels) || !reflect.DeepEqual(values, c.values) {
+			t.Errorf("%q: expected %q %q %v %v, got %q %q %v %v", c.in, c.name, c.help, c.labels, c.values, name, help, labels, values)
+		}
+	}
+}
+
+func TestPrometheusReport(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	NewCounter32("test.requests").Add(3)
+	NewCounter32("test.partition.1.messages").Add(1)
+	NewCounter32("test.partition.2.messages").Add(2)
+	NewGauge64("test.queue").Set(5)
+	// version.{version} conflicts with the gauge without labels, so it falls back to its full name
+	NewGauge32("version")
+	NewBool("version.1_0").SetTrue()
+	m := NewMeter32("test.size", false)
+	m.Value(10)
+	m.Value(20)
+	l := NewLatencyHistogram15s32("test.flush")
+	l.Value(time.Millisecond)
+
+	p := &Prometheus{namespace: "metrictank"}
+	p.metrics = p.report(time.Now()).metrics
+	m.Value(30)
+	p.metrics = p.report(time.Now()).metrics
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(p)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather: %s", err)
+	}
+	families := make(map[string]*dto.MetricFamily)
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+
+	exp := map[string]dto.MetricType{
+		"metrictank_test_requests_total":           dto.MetricType_COUNTER,
+		"metrictank_test_partition_messages_total": dto.MetricType_COUNTER,
+		"metrictank_test_queue":                    dto.MetricType_GAUGE,
+		"metrictank_version":                       dto.MetricType_GAUGE,
+		"metrictank_version_1_0":                   dto.MetricType_GAUGE,
+		"metrictank_test_size":                     dto.MetricType_SUMMARY,
+		"metrictank_test_flush_latency_seconds":    dto.MetricType_SUMMARY,
+	}
+	if len(families) != len(exp) {
+		t.Fatalf("expected %d metric families, got %d: %v", len(exp), len(families), mfs)
+	}
+	for name, typ := range exp {
+		mf, ok := families[name]
+		if !ok {
+			t.Fatalf("expected metric family %q, got %v", name, mfs)
+		}
+		if mf.GetType() != typ {
+			t.Fatalf("expected %q to be a %s, got %s", name, typ, mf.GetType())
+		}
+	}
+
+	if n := len(families["metrictank_test_partition_messages_total"].Metric); n != 2 {
+		t.Fatalf("expected 2 partition counters, got %d", n)
+	}
+	size := families["metrictank_test_size"].Metric[0].GetSummary()
+	if size.GetSampleCount() != 3 || size.GetSampleSum() != 60 {
+		t.Fatalf("expected cumulative count 3 and sum 60, got %d and %f", size.GetSampleCount(), size.GetSampleSum())
+	}
+	for _, q := range size.Quantile {
+		if q.GetValue() != 30 {
+			t.Fatalf("expected quantiles of the last interval to be 30, got %f for %f", q.GetValue(), q.GetQuantile())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	selfQueueItems *Range32
+	selfPoints     *Gauge32
+)
+
+// MetricDataHandler processes MetricData. It is implemented by input.Handler
+type MetricDataHandler interface {
+	ProcessMetricData(md *schema.MetricData, partition int32)
+}
+
+// Self reports all metrics into the local metrictank, by passing them to a handler
+// as if they were ingested by an input.
+// Reports are buffered while the handler is not set or the node is not ready.
+type Self struct {
+	prefix   []byte
+	orgId    int
+	interval int
+
+	toHandler chan []*schema.MetricData
+}
+
+func NewSelf(prefix string, orgId, interval, bufferSize int) *Self {
+	if len(prefix) != 0 && prefix[len(prefix)-1] != '.' {
+		prefix = prefix + "."
+	}
+	NewGauge32("stats.self.write_queue.size").Set(bufferSize)
+	selfQueueItems = NewRange32("stats.self.write_queue.items")
+	genDataDuration = NewGauge32("stats.generate_message.duration")
+	// metric stats.self.points is the number of points in the last report of the self stats output
+	selfPoints = NewGauge32("stats.self.points")
+
+	s := &Self{
+		prefix:    []byte(prefix),
+		orgId:     orgId,
+		interval:  interval,
+		toHandler: make(chan []*schema.MetricData, bufferSize),
+	}
+	go s.reporter()
+	return s
+}
+
+// Start starts passing the reports to the handler, with the given partition,
+// whenever ready returns true.
+func (s *Self) Start(handler MetricDataHandler, partition int32, ready func() bool) {
+	go s.writer(handler, partition, ready)
+}
+
+func (s *Self) reporter() {
+	ticker := tick(time.Duration(s.interval) * time.Second)
+	for now := range ticker {
+		log.Debugf("stats flushing for %s to self", now)
+		selfQueueItems.Value(len(s.toHandler))
+		if cap(s.toHandler) != 0 && len(s.toHandler) == cap(s.toHandler) {
+			// no space in buffer, no use in doing any work
+			continue
+		}
+
+		pre := time.Now()
+
+		w := &selfWriter{orgId: s.orgId, interval: s.interval}
+
+		var fullPrefix bytes.Buffer
+		for name, metric := range registry.list() {
+			fullPrefix.Reset()
+			fullPrefix.Write(s.prefix)
+			fullPrefix.WriteString(name)
+			fullPrefix.WriteRune('.')
+			metric.ReportGraphite(w, fullPrefix.Bytes(), now)
+		}
+		mds := w.mds
+
+		genDataDuration.Set(int(time.Since(pre).Nanoseconds()))
+		selfPoints.Set(len(mds))
+		s.toHandler <- mds
+		selfQueueItems.Value(len(s.toHandler))
+	}
+}
+
+// selfWriter converts the values of a report to MetricData
+type selfWriter struct {
+	orgId    int
+	interval int
+	mds      []*schema.MetricData
+}
+
+func (w *selfWriter) add(prefix, key []byte, val float64, now time.Time) {
+	md := &schema.MetricData{
+		Name:     string(prefix) + string(key),
+		OrgId:    w.orgId,
+		Interval: w.interval,
+		Value:    val,
+		Unit:     "unknown",
+		Time:     now.Unix(),
+		Mtype:    "gauge",
+	}
+	md.SetId()
+	w.mds = append(w.mds, md)
+}
+
+func (w *selfWriter) WriteFloat64(prefix, key []byte, val float64, now time.Time) {
+	w.add(prefix, key, val, now)
+}
+
+func (w *selfWriter) WriteUint32(prefix, key []byte, val uint32, now time.Time) {
+	w.add(prefix, key, float64(val), now)
+}
+
+func (w *selfWriter) WriteUint64(prefix, key []byte, val uint64, now time.Time) {
+	w.add(prefix, key, float64(val), now)
+}
+
+func (w *selfWriter) WriteInt32(prefix, key []byte, val int32, now time.Time) {
+	w.add(prefix, key, float64(val), now)
+}
+
+// writer passes the buffered reports to the handler, once the node is ready
+func (s *Self) writer(handler MetricDataHandler, partition int32, ready func() bool) {
+	for mds := range s.toHandler {
+		for !ready() {
+			time.Sleep(time.Second)
+		}
+		for _, md := range mds {
+			handler.ProcessMetricData(md, partition)
+		}
+		selfQueueItems.Value(len(s.toHandler))
+	}
+}
@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/raintank/schema"
+)
+
+type mockHandler struct {
+	sync.Mutex
+	mds        []*schema.MetricData
+	partitions []int32
+}
+
+func (h *mockHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	h.Lock()
+	h.mds = append(h.mds, md)
+	h.partitions = append(h.partitions, partition)
+	h.Unlock()
+}
+
+func (h *mockHandler) len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.mds)
+}
+
+var initSelfStats sync.Once
+
+func newTestSelf(bufferSize int) *Self {
+	// writers of previous tests may still be running, so only set these once
+	initSelfStats.Do(func() {
+		selfQueueItems = NewRange32("stats.self.write_queue.items")
+	})
+	return &Self{
+		prefix:    []byte("metrictank.stats.test."),
+		orgId:     2,
+		interval:  10,
+		toHandler: make(chan []*schema.MetricData, bufferSize),
+	}
+}
+
+func TestSelfWriter(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	c := NewCounter32("test.requests")
+	c.Add(3)
+	g := NewGauge64("test.bytes")
+	g.Set(1 << 40)
+	now := time.Unix(1000, 0)
+	w := &selfWriter{orgId: 2, interval: 10}
+	c.ReportGraphite(w, []byte("metrictank.stats.test.test.requests."), now)
+	g.ReportGraphite(w, []byte("metrictank.stats.test.test.bytes."), now)
+
+	if len(w.mds) != 2 {
+		t.Fatalf("expected 2 points, got %d", len(w.mds))
+	}
+	cases := []struct {
+		name  string
+		value float64
+	}{
+		{"metrictank.stats.test.test.requests.counter32", 3},
+		{"metrictank.stats.test.test.bytes.gauge64", 1 << 40},
+	}
+	for i, c := range cases {
+		md := w.mds[i]
+		if md.Name != c.name || md.Value != c.value || md.Time != 1000 || md.OrgId != 2 || md.Interval != 10 {
+			t.Fatalf("case %d: unexpected point %v", i, md)
+		}
+		if md.Id == "" || md.Validate() != nil {
+			t.Fatalf("case %d: expected a valid point with an id, got %v", i, md)
+		}
+	}
+}
+
+func TestSelfBuffersUntilReady(t *testing.T) {
+	Clear()
+	defer Clear()
+	s := newTestSelf(10)
+
+	var ready uint32
+	handler := &mockHandler{}
+	s.toHandler <- []*schema.MetricData{{Name: "a"}, {Name: "b"}}
+	s.toHandler <- []*schema.MetricData{{Name: "c"}}
+	s.Start(handler, 3, func() bool { return atomic.LoadUint32(&ready) == 1 })
+
+	time.Sleep(20 * time.Millisecond)
+	if handler.len() != 0 {
+		t.Fatalf("expected no points to be processed before the node is ready, got %d", handler.len())
+	}
+
+	atomic.StoreUint32(&ready, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for handler.len() != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if handler.len() != 3 {
+		t.Fatalf("expected 3 points to be processed once the node is ready, got %d", handler.len())
+	}
+	for _, p := range handler.partitions {
+		if p != 3 {
+			t.Fatalf("expected partition 3, got %d", p)
+		}
+	}
+}
@@ -23,7 +23,7 @@ func NewProcessReporter() (*ProcessReporter, error) {
 	return registry.getOrAdd("process", &p).(*ProcessReporter), nil
 }
 
-func (m *ProcessReporter) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (m *ProcessReporter) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	stat, err := m.proc.NewStat()
 
 	if err == nil {
@@ -31,19 +31,29 @@ func (m *ProcessReporter) ReportGraphite(prefix, buf []byte, now time.Time) []by
 		rss := uint64(stat.ResidentMemory())
 
 		// metric process.virtual_memory_bytes.gauge64 is a gauge of the process VSZ from /proc/pid/stat
-		buf = WriteUint64(buf, prefix, []byte("virtual_memory_bytes.gauge64"), vsz, now)
+		w.WriteUint64(prefix, []byte("virtual_memory_bytes.gauge64"), vsz, now)
 
 		// metric process.resident_memory_bytes.gauge64 is a gauge of the process RSS from /proc/pid/stat
-		buf = WriteUint64(buf, prefix, []byte("resident_memory_bytes.gauge64"), rss, now)
+		w.WriteUint64(prefix, []byte("resident_memory_bytes.gauge64"), rss, now)
 		// metric process.minor_page_faults.counter64 is the number of minor faults the process has made which have not required loading a memory page from disk
-		buf = WriteUint64(buf, prefix, []byte("minor_page_faults.counter64"), uint64(stat.MinFlt), now)
+		w.WriteUint64(prefix, []byte("minor_page_faults.counter64"), uint64(stat.MinFlt), now)
 
 		// metric process.major_page_faults.counter64 is the number of major faults the process has made which have required loading a memory page from disk
-		buf = WriteUint64(buf, prefix, []byte("major_page_faults.counter64"), uint64(stat.MajFlt), now)
+		w.WriteUint64(prefix, []byte("major_page_faults.counter64"), uint64(stat.MajFlt), now)
 
 		// metric is Total user and system CPU time spent in seconds
-		buf = WriteFloat64(buf, prefix, []byte("cpu_seconds_total.counter64"), stat.CPUTime(), now)
+		w.WriteFloat64(prefix, []byte("cpu_seconds_total.counter64"), stat.CPUTime(), now)
 	}
+}
 
-	return buf
+func (m *ProcessReporter) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	stat, err := m.proc.NewStat()
+	if err != nil {
+		return
+	}
+	s.Gauge(name, "virtual_memory_bytes", float64(stat.VirtualMemory()))
+	s.Gauge(name, "resident_memory_bytes", float64(stat.ResidentMemory()))
+	s.Counter(name, "minor_page_faults", float64(stat.MinFlt))
+	s.Counter(name, "major_page_faults", float64(stat.MajFlt))
+	s.Counter(name, "cpu_seconds", stat.CPUTime())
 }
@@ -41,16 +41,28 @@ func (r *Range32) ValueUint32(val uint32) {
 	r.Unlock()
 }
 
-func (r *Range32) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (r *Range32) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	r.Lock()
 	// if no values were seen, don't report anything to graphite
 	if r.valid {
-		buf = WriteUint32(buf, prefix, []byte("min.gauge32"), r.min, now)
-		buf = WriteUint32(buf, prefix, []byte("max.gauge32"), r.max, now)
+		w.WriteUint32(prefix, []byte("min.gauge32"), r.min, now)
+		w.WriteUint32(prefix, []byte("max.gauge32"), r.max, now)
+		r.min = math.MaxUint32
+		r.max = 0
+		r.valid = false
+	}
+	r.Unlock()
+}
+
+func (r *Range32) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	r.Lock()
+	// if no values were seen, don't report anything
+	if r.valid {
+		s.Gauge(name, "min", float64(r.min))
+		s.Gauge(name, "max", float64(r.max))
 		r.min = math.MaxUint32
 		r.max = 0
 		r.valid = false
 	}
 	r.Unlock()
-	return buf
 }
@@ -22,13 +22,22 @@ func (g *TimeDiffReporter32) Set(target uint32) {
 	atomic.StoreUint32(&g.target, target)
 }
 
-func (g *TimeDiffReporter32) ReportGraphite(prefix, buf []byte, now time.Time) []byte {
+func (g *TimeDiffReporter32) ReportGraphite(w Writer, prefix []byte, now time.Time) {
 	target := atomic.LoadUint32(&g.target)
 	now32 := uint32(now.Unix())
 	report := uint32(0)
 	if now32 < target {
 		report = target - now32
 	}
-	buf = WriteUint32(buf, prefix, []byte("gauge32"), report, now)
-	return buf
+	w.WriteUint32(prefix, []byte("gauge32"), report, now)
+}
+
+func (g *TimeDiffReporter32) ReportPrometheus(name string, s *PrometheusSnapshot, now time.Time) {
+	target := atomic.LoadUint32(&g.target)
+	now32 := uint32(now.Unix())
+	report := uint32(0)
+	if now32 < target {
+		report = target - now32
+	}
+	s.Gauge(name, "", float64(report))
 }
@@ -5,6 +5,43 @@ import (
 	"time"
 )
 
+// Writer receives the values of a metric report
+type Writer interface {
+	WriteFloat64(prefix, key []byte, val float64, now time.Time)
+	WriteUint32(prefix, key []byte, val uint32, now time.Time)
+	WriteUint64(prefix, key []byte, val uint64, now time.Time)
+	WriteInt32(prefix, key []byte, val int32, now time.Time)
+}
+
+// GraphiteWriter appends the values as graphite plaintext lines to Buf
+type GraphiteWriter struct {
+	Buf []byte
+}
+
+func (g *GraphiteWriter) WriteFloat64(prefix, key []byte, val float64, now time.Time) {
+	g.Buf = WriteFloat64(g.Buf, prefix, key, val, now)
+}
+
+func (g *GraphiteWriter) WriteUint32(prefix, key []byte, val uint32, now time.Time) {
+	g.Buf = WriteUint32(g.Buf, prefix, key, val, now)
+}
+
+func (g *GraphiteWriter) WriteUint64(prefix, key []byte, val uint64, now time.Time) {
+	g.Buf = WriteUint64(g.Buf, prefix, key, val, now)
+}
+
+func (g *GraphiteWriter) WriteInt32(prefix, key []byte, val int32, now time.Time) {
+	g.Buf = WriteInt32(g.Buf, prefix, key, val, now)
+}
+
+// discardWriter drops all values
+type discardWriter struct{}
+
+func (discardWriter) WriteFloat64(prefix, key []byte, val float64, now time.Time) {}
+func (discardWriter) WriteUint32(prefix, key []byte, val uint32, now time.Time)   {}
+func (discardWriter) WriteUint64(prefix, key []byte, val uint64, now time.Time)   {}
+func (discardWriter) WriteInt32(prefix, key []byte, val int32, now time.Time)     {}
+
 func WriteFloat64(buf, prefix, key []byte, val float64, now time.Time) []byte {
 	buf = append(buf, prefix...)
 	buf = append(buf, key...)
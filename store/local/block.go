@@ -0,0 +1,445 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/raintank/schema"
+)
+
+// chunks are stored in block files, each of which holds the chunks of one table with a t0 in the time window of the block.
+// a block consists of up to 2 files:
+// * <start>.log: chunks are appended to it as they are written, in no particular order.
+// * <start>.blk: the compacted block. chunks sorted by key and t0, followed by an index of the chunks and a footer.
+//
+// both use the same chunk records:
+// crc32c (4) | length of the rest of the record (4) | t0 (4) | ttl (4) | length of the key (2) | key | chunk data
+// the checksum covers everything after the length.
+//
+// the index of a .blk file holds, for each key:
+// length of the key (2) | key | number of chunks (4) | per chunk: t0 (4) | ttl (4) | offset of the data (8) | size of the data (4)
+// and the footer is:
+// offset of the index (8) | length of the index (4) | crc32c of the index (4) | magic (8)
+
+const (
+	recordHeaderSize = 8
+	footerSize       = 24
+	magic            = "MTBLK001"
+	maxRecordSize    = 16 * 1024 * 1024
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorrupt = errors.New("corrupt or incomplete record")
+)
+
+// chunkRef refers to the data of a chunk in a block file
+type chunkRef struct {
+	t0     uint32
+	ttl    uint32
+	file   *os.File
+	offset int64
+	size   uint32
+}
+
+// block holds the chunks of a table within a time window
+type block struct {
+	sync.RWMutex
+	dir   string
+	start uint32
+
+	blk     *os.File // compacted file. may be nil
+	log     *os.File // file being appended to. may be nil
+	logSize int64
+
+	// chunk references by key, sorted by t0 and unique per t0.
+	chunks map[schema.AMKey][]chunkRef
+	maxTTL uint32
+}
+
+func newBlock(dir string, start uint32) *block {
+	return &block{
+		dir:    dir,
+		start:  start,
+		chunks: make(map[schema.AMKey][]chunkRef),
+	}
+}
+
+func (b *block) path(ext string) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%d.%s", b.start, ext))
+}
+
+// add adds a chunk reference, replacing any previous chunk with the same t0.
+// caller must hold write lock
+func (b *block) add(key schema.AMKey, ref chunkRef) {
+	refs := b.chunks[key]
+	i := sort.Search(len(refs), func(i int) bool { return refs[i].t0 >= ref.t0 })
+	if i < len(refs) && refs[i].t0 == ref.t0 {
+		refs[i] = ref
+	} else {
+		refs = append(refs, chunkRef{})
+		copy(refs[i+1:], refs[i:])
+		refs[i] = ref
+	}
+	b.chunks[key] = refs
+	if ref.ttl > b.maxTTL {
+		b.maxTTL = ref.ttl
+	}
+}
+
+// appendRecord appends a chunk record to buf, returning the extended buffer and the offset of the data within the record
+func appendRecord(buf []byte, key string, t0, ttl uint32, data []byte) ([]byte, int) {
+	start := len(buf)
+	buf = append(buf, make([]byte, recordHeaderSize+10)...)
+	binary.LittleEndian.PutUint32(buf[start+8:], t0)
+	binary.LittleEndian.PutUint32(buf[start+12:], ttl)
+	binary.LittleEndian.PutUint16(buf[start+16:], uint16(len(key)))
+	buf = append(buf, key...)
+	dataOffset := len(buf) - start
+	buf = append(buf, data...)
+	binary.LittleEndian.PutUint32(buf[start+4:], uint32(len(buf)-start-recordHeaderSize))
+	binary.LittleEndian.PutUint32(buf[start:], crc32.Checksum(buf[start+recordHeaderSize:], castagnoli))
+	return buf, dataOffset
+}
+
+// writeLog appends a chunk to the log file of the block, creating it if needed.
+// caller must hold write lock
+func (b *block) writeLog(key schema.AMKey, t0, ttl uint32, data []byte, buf []byte) ([]byte, error) {
+	if b.log == nil {
+		f, err := os.OpenFile(b.path("log"), os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return buf, err
+		}
+		b.log = f
+		b.logSize = 0
+	}
+	buf, dataOffset := appendRecord(buf[:0], key.String(), t0, ttl, data)
+	n, err := b.log.WriteAt(buf, b.logSize)
+	if err != nil {
+		// make sure we don't leave a partial record behind that later records would be appended to
+		b.log.Truncate(b.logSize)
+		return buf, err
+	}
+	b.add(key, chunkRef{
+		t0:     t0,
+		ttl:    ttl,
+		file:   b.log,
+		offset: b.logSize + int64(dataOffset),
+		size:   uint32(len(data)),
+	})
+	b.logSize += int64(n)
+	return buf, nil
+}
+
+// loadLog loads the chunk references of the log file.
+// an incomplete or corrupt tail, e.g. due to a crash while writing, is truncated, unless we are read-only,
+// in which case it may be a chunk that is being written.
+// caller must hold write lock
+func (b *block) loadLog(readOnly bool) error {
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(b.path("log"), flag, 0644)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReaderSize(f, 1024*1024)
+	var offset int64
+	var header [recordHeaderSize]byte
+	var rec []byte
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			rec, err = readRecord(r, header, rec)
+		}
+		if err == nil {
+			var key schema.AMKey
+			var t0, ttl uint32
+			var dataOffset int
+			key, t0, ttl, dataOffset, err = parseRecord(rec)
+			if err == nil {
+				b.add(key, chunkRef{
+					t0:     t0,
+					ttl:    ttl,
+					file:   f,
+					offset: offset + recordHeaderSize + int64(dataOffset),
+					size:   uint32(len(rec) - dataOffset),
+				})
+				offset += recordHeaderSize + int64(len(rec))
+				continue
+			}
+		}
+		if readOnly {
+			break
+		}
+		if err := f.Truncate(offset); err != nil {
+			f.Close()
+			return err
+		}
+		break
+	}
+	b.log = f
+	b.logSize = offset
+	return nil
+}
+
+// readRecord reads the body of the record with the given header into buf
+func readRecord(r io.Reader, header [recordHeaderSize]byte, buf []byte) ([]byte, error) {
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size < 10 || size > maxRecordSize {
+		return buf, errCorrupt
+	}
+	if cap(buf) < int(size) {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return buf, errCorrupt
+	}
+	if crc32.Checksum(buf, castagnoli) != binary.LittleEndian.Uint32(header[0:]) {
+		return buf, errCorrupt
+	}
+	return buf, nil
+}
+
+// parseRecord parses the body of a record, returning the offset of the data within the body
+func parseRecord(rec []byte) (schema.AMKey, uint32, uint32, int, error) {
+	t0 := binary.LittleEndian.Uint32(rec[0:])
+	ttl := binary.LittleEndian.Uint32(rec[4:])
+	keyLen := int(binary.LittleEndian.Uint16(rec[8:]))
+	if 10+keyLen > len(rec) {
+		return schema.AMKey{}, 0, 0, 0, errCorrupt
+	}
+	key, err := schema.AMKeyFromString(string(rec[10 : 10+keyLen]))
+	if err != nil {
+		return schema.AMKey{}, 0, 0, 0, errCorrupt
+	}
+	return key, t0, ttl, 10 + keyLen, nil
+}
+
+// loadBlk loads the index of the compacted file
+// caller must hold write lock
+func (b *block) loadBlk() error {
+	f, err := os.Open(b.path("blk"))
+	if err != nil {
+		return err
+	}
+	err = b.readIndex(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %s", f.Name(), err.Error())
+	}
+	b.blk = f
+	return nil
+}
+
+func (b *block) readIndex(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < footerSize {
+		return errCorrupt
+	}
+	var footer [footerSize]byte
+	if _, err := f.ReadAt(footer[:], fi.Size()-footerSize); err != nil {
+		return err
+	}
+	if string(footer[16:]) != magic {
+		return errCorrupt
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(footer[0:]))
+	indexLen := int64(binary.LittleEndian.Uint32(footer[8:]))
+	if indexOffset+indexLen != fi.Size()-footerSize {
+		return errCorrupt
+	}
+	index := make([]byte, indexLen)
+	if _, err := f.ReadAt(index, indexOffset); err != nil {
+		return err
+	}
+	if crc32.Checksum(index, castagnoli) != binary.LittleEndian.Uint32(footer[12:]) {
+		return errCorrupt
+	}
+	for len(index) > 0 {
+		if len(index) < 2 {
+			return errCorrupt
+		}
+		keyLen := int(binary.LittleEndian.Uint16(index))
+		if len(index) < 2+keyLen+4 {
+			return errCorrupt
+		}
+		key, err := schema.AMKeyFromString(string(index[2 : 2+keyLen]))
+		if err != nil {
+			return errCorrupt
+		}
+		num := int(binary.LittleEndian.Uint32(index[2+keyLen:]))
+		index = index[2+keyLen+4:]
+		if len(index) < num*20 {
+			return errCorrupt
+		}
+		for i := 0; i < num; i++ {
+			b.add(key, chunkRef{
+				t0:     binary.LittleEndian.Uint32(index[0:]),
+				ttl:    binary.LittleEndian.Uint32(index[4:]),
+				file:   f,
+				offset: int64(binary.LittleEndian.Uint64(index[8:])),
+				size:   binary.LittleEndian.Uint32(index[16:]),
+			})
+			index = index[20:]
+		}
+	}
+	return nil
+}
+
+// compact writes all chunks of the block into a new compacted file, and removes the log file.
+// caller must hold write lock
+func (b *block) compact() error {
+	keys := make([]schema.AMKey, 0, len(b.chunks))
+	for key := range b.chunks {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	tmpPath := b.path("blk.tmp")
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	w := bufio.NewWriterSize(f, 1024*1024)
+	var offset int64
+	var buf, data []byte
+	var index bytes.Buffer
+	var scratch [20]byte
+	newChunks := make(map[schema.AMKey][]chunkRef, len(b.chunks))
+	for _, key := range keys {
+		refs := b.chunks[key]
+		keyStr := key.String()
+		binary.LittleEndian.PutUint16(scratch[:], uint16(len(keyStr)))
+		index.Write(scratch[:2])
+		index.WriteString(keyStr)
+		binary.LittleEndian.PutUint32(scratch[:], uint32(len(refs)))
+		index.Write(scratch[:4])
+		newRefs := make([]chunkRef, len(refs))
+		for i, ref := range refs {
+			if cap(data) < int(ref.size) {
+				data = make([]byte, ref.size)
+			}
+			data = data[:ref.size]
+			if _, err := ref.file.ReadAt(data, ref.offset); err != nil {
+				return fail(err)
+			}
+			var dataOffset int
+			buf, dataOffset = appendRecord(buf[:0], keyStr, ref.t0, ref.ttl, data)
+			if _, err := w.Write(buf); err != nil {
+				return fail(err)
+			}
+			newRefs[i] = chunkRef{
+				t0:     ref.t0,
+				ttl:    ref.ttl,
+				file:   f,
+				offset: offset + int64(dataOffset),
+				size:   ref.size,
+			}
+			binary.LittleEndian.PutUint32(scratch[0:], ref.t0)
+			binary.LittleEndian.PutUint32(scratch[4:], ref.ttl)
+			binary.LittleEndian.PutUint64(scratch[8:], uint64(newRefs[i].offset))
+			binary.LittleEndian.PutUint32(scratch[16:], ref.size)
+			index.Write(scratch[:20])
+			offset += int64(len(buf))
+		}
+		newChunks[key] = newRefs
+	}
+	var footer [footerSize]byte
+	binary.LittleEndian.PutUint64(footer[0:], uint64(offset))
+	binary.LittleEndian.PutUint32(footer[8:], uint32(index.Len()))
+	binary.LittleEndian.PutUint32(footer[12:], crc32.Checksum(index.Bytes(), castagnoli))
+	copy(footer[16:], magic)
+	if _, err := w.Write(index.Bytes()); err != nil {
+		return fail(err)
+	}
+	if _, err := w.Write(footer[:]); err != nil {
+		return fail(err)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, b.path("blk")); err != nil {
+		return fail(err)
+	}
+	syncDir(b.dir)
+
+	// if we crash before the log file is removed, we load both on startup, which results in the same chunks.
+	if b.log != nil {
+		b.log.Close()
+		os.Remove(b.path("log"))
+		b.log = nil
+		b.logSize = 0
+	}
+	if b.blk != nil {
+		b.blk.Close()
+	}
+	b.blk = f
+	b.chunks = newChunks
+	return nil
+}
+
+// close closes the files of the block
+// caller must hold write lock
+func (b *block) close() {
+	if b.log != nil {
+		b.log.Close()
+	}
+	if b.blk != nil {
+		b.blk.Close()
+	}
+}
+
+// remove closes and removes the files of the block
+// caller must hold write lock
+func (b *block) remove() error {
+	b.close()
+	if b.log != nil {
+		if err := os.Remove(b.path("log")); err != nil {
+			return err
+		}
+	}
+	if b.blk != nil {
+		if err := os.Remove(b.path("blk")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir syncs a directory, so that renames and new files in it are persisted
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,69 @@
+package local
+
+import (
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/grafana/globalconf"
+	log "github.com/sirupsen/logrus"
+)
+
+type StoreConfig struct {
+	Enabled        bool
+	Dir            string
+	WindowFactor   int
+	WriteQueueSize int
+	FlushInterval  time.Duration
+	CompactAfter   time.Duration
+}
+
+func (cfg *StoreConfig) Validate() error {
+	if cfg.Dir == "" {
+		return errors.New("dir must be set")
+	}
+	if cfg.WindowFactor < 1 {
+		return errors.New("window-factor must be at least 1")
+	}
+	if cfg.WriteQueueSize < 1 {
+		return errors.New("write-queue-size must be at least 1")
+	}
+	if cfg.FlushInterval <= 0 {
+		return errors.New("flush-interval must be > 0")
+	}
+	return nil
+}
+
+// return StoreConfig with default values set.
+func NewStoreConfig() *StoreConfig {
+	return &StoreConfig{
+		Enabled:        false,
+		Dir:            "/var/lib/metrictank/store",
+		WindowFactor:   20,
+		WriteQueueSize: 100000,
+		FlushInterval:  time.Second,
+		CompactAfter:   time.Hour,
+	}
+}
+
+var CliConfig = NewStoreConfig()
+
+func ConfigSetup() {
+	localStore := flag.NewFlagSet("local-store", flag.ExitOnError)
+	localStore.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "enable the local backend store plugin, which stores chunks in files on local disk")
+	localStore.StringVar(&CliConfig.Dir, "dir", CliConfig.Dir, "directory to store the chunks in. it gets a sub directory per TTL bucket, named like the cassandra tables")
+	localStore.IntVar(&CliConfig.WindowFactor, "window-factor", CliConfig.WindowFactor, "size of the time window of block files relative to TTL. like the cassandra compaction window")
+	localStore.IntVar(&CliConfig.WriteQueueSize, "write-queue-size", CliConfig.WriteQueueSize, "max number of chunks allowed to be unwritten to disk")
+	localStore.DurationVar(&CliConfig.FlushInterval, "flush-interval", CliConfig.FlushInterval, "how often to sync written chunks to disk. chunks are only marked as saved once they have been synced")
+	localStore.DurationVar(&CliConfig.CompactAfter, "compact-after", CliConfig.CompactAfter, "how long after the last write to a block, and the end of its time window, it gets compacted into a sorted block file with an index")
+	globalconf.Register("local-store", localStore, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !CliConfig.Enabled {
+		return
+	}
+	if err := CliConfig.Validate(); err != nil {
+		log.Fatalf("local-store: Config validation error. %s", err)
+	}
+}
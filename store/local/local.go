@@ -0,0 +1,588 @@
+// Package local implements a backend store that keeps chunks in files on local disk,
+// so that metrictank can run without cassandra or bigtable.
+//
+// chunks are grouped in tables by TTL, using the same buckets as the cassandra store, and each table is a directory.
+// within a table, chunks are stored in blocks by the time window their t0 falls in, with the size of the windows relative to the TTL.
+// new chunks are appended to the log file of their block. once a block has not been written to for a while after the end of its window,
+// it is compacted into a sorted file with an index. blocks are removed once all of their chunks have expired.
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/store/cassandra"
+	"github.com/grafana/metrictank/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errChunkTooSmall = errors.New("impossibly small chunk in local store")
+	errInvalidRange  = errors.New("LocalStore: invalid range: from must be less than to")
+	errTableNotFound = errors.New("table not found")
+
+	// metric store.local.get.exec is the duration of getting from the local store
+	getExecDuration = stats.NewLatencyHistogram15s32("store.local.get.exec")
+	// metric store.local.get.error is the count of reads that failed
+	getError = stats.NewCounter32("store.local.get.error")
+	// metric store.local.put.wait is the duration of a put in the wait queue
+	putWaitDuration = stats.NewLatencyHistogram12h32("store.local.put.wait")
+	// metric store.local.write_queue.items is the number of chunks in the write queue
+	writeQueueItems = stats.NewRange32("store.local.write_queue.items")
+	// metric store.local.chunk_operations.save_ok is counter of successful saves
+	chunkSaveOk = stats.NewCounter32("store.local.chunk_operations.save_ok")
+	// metric store.local.chunk_operations.save_fail is counter of failed saves
+	chunkSaveFail = stats.NewCounter32("store.local.chunk_operations.save_fail")
+	// metric store.local.chunk_size.at_save is the sizes of chunks seen when saving them
+	chunkSizeAtSave = stats.NewMeter32("store.local.chunk_size.at_save", true)
+	// metric store.local.chunk_size.at_load is the sizes of chunks seen when loading them
+	chunkSizeAtLoad = stats.NewMeter32("store.local.chunk_size.at_load", true)
+	// metric store.local.blocks is the number of blocks in the local store
+	blocksGauge = stats.NewGauge32("store.local.blocks")
+	// metric store.local.compactions is the number of blocks that have been compacted
+	compactions = stats.NewCounter32("store.local.compactions")
+	// metric store.local.expired_blocks is the number of blocks that have been removed because all of their chunks expired
+	expiredBlocks = stats.NewCounter32("store.local.expired_blocks")
+)
+
+// Table is a directory of blocks holding the chunks of a TTL bucket
+type Table struct {
+	Name string
+	// TTL is the lower limit of the TTL bucket in hours, like the TTL of cassandra.Table as returned by FindExistingTables.
+	TTL uint32
+
+	dir        string
+	windowSize uint32 // in seconds
+
+	sync.RWMutex
+	blocks    map[uint32]*block
+	lastWrite map[uint32]time.Time
+}
+
+type Store struct {
+	cfg        *StoreConfig
+	tables     map[string]*Table
+	tablesLock sync.RWMutex
+	writeQueue chan *mdata.ChunkWriteRequest
+	shutdown   chan struct{}
+	wg         sync.WaitGroup
+	tracer     opentracing.Tracer
+	readOnly   bool
+}
+
+func NewStore(cfg *StoreConfig) (*Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("localStore: failed to create directory %q. %s", cfg.Dir, err)
+	}
+	s := &Store{
+		cfg:        cfg,
+		tables:     make(map[string]*Table),
+		writeQueue: make(chan *mdata.ChunkWriteRequest, cfg.WriteQueueSize),
+		shutdown:   make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.wg.Add(2)
+	go s.processWriteQueue()
+	go s.maintain()
+	return s, nil
+}
+
+// NewReadOnlyStore opens the store for reading only, e.g. while metrictank is running and writing to it.
+// chunks can't be added to it, and it doesn't compact or remove blocks.
+func NewReadOnlyStore(cfg *StoreConfig) (*Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		cfg:      cfg,
+		tables:   make(map[string]*Table),
+		shutdown: make(chan struct{}),
+		readOnly: true,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load loads the existing tables and their blocks
+func (s *Store) load() error {
+	pre := time.Now()
+	dirs, err := ioutil.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("localStore: failed to read directory %q. %s", s.cfg.Dir, err)
+	}
+	var numBlocks int
+	for _, d := range dirs {
+		if !d.IsDir() || !cassandra.IsStoreTable(d.Name()) {
+			continue
+		}
+		// the windowSize is a function of the TTL, so any ttl in the bucket will do
+		ttlHours, _ := strconv.Atoi(strings.TrimPrefix(d.Name(), "metric_"))
+		tbl := s.getTable(uint32(ttlHours) * 3600)
+		files, err := ioutil.ReadDir(tbl.dir)
+		if err != nil {
+			return fmt.Errorf("localStore: failed to read directory %q. %s", tbl.dir, err)
+		}
+		for _, f := range files {
+			name := f.Name()
+			ext := filepath.Ext(name)
+			if ext == ".tmp" {
+				// leftover of an interrupted compaction
+				if !s.readOnly {
+					os.Remove(filepath.Join(tbl.dir, name))
+				}
+				continue
+			}
+			if ext != ".log" && ext != ".blk" {
+				continue
+			}
+			start, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 32)
+			if err != nil {
+				log.Warnf("localStore: ignoring unexpected file %q", filepath.Join(tbl.dir, name))
+				continue
+			}
+			b, ok := tbl.blocks[uint32(start)]
+			if !ok {
+				b = newBlock(tbl.dir, uint32(start))
+				tbl.blocks[uint32(start)] = b
+				tbl.lastWrite[uint32(start)] = f.ModTime()
+				numBlocks++
+			}
+			if f.ModTime().After(tbl.lastWrite[uint32(start)]) {
+				tbl.lastWrite[uint32(start)] = f.ModTime()
+			}
+		}
+		// the compacted file must be loaded first, so that chunks in the log file take precedence
+		for _, b := range tbl.blocks {
+			if _, err := os.Stat(b.path("blk")); err == nil {
+				if err := b.loadBlk(); err != nil {
+					return fmt.Errorf("localStore: failed to load block. %s", err)
+				}
+			}
+			if _, err := os.Stat(b.path("log")); err == nil {
+				if err := b.loadLog(s.readOnly); err != nil {
+					return fmt.Errorf("localStore: failed to load block %q. %s", b.path("log"), err)
+				}
+			}
+		}
+	}
+	blocksGauge.Set(numBlocks)
+	log.Infof("localStore: loaded %d blocks in %s", numBlocks, time.Since(pre))
+	return nil
+}
+
+// getTable returns the table for the given TTL, creating it if needed
+func (s *Store) getTable(ttl uint32) *Table {
+	def := cassandra.GetTable(ttl, s.cfg.WindowFactor, cassandra.Table_name_format)
+	s.tablesLock.RLock()
+	tbl, ok := s.tables[def.Name]
+	s.tablesLock.RUnlock()
+	if ok {
+		return tbl
+	}
+	s.tablesLock.Lock()
+	defer s.tablesLock.Unlock()
+	tbl, ok = s.tables[def.Name]
+	if ok {
+		return tbl
+	}
+	ttlHours, _ := strconv.Atoi(strings.TrimPrefix(def.Name, "metric_"))
+	tbl = &Table{
+		Name:       def.Name,
+		TTL:        uint32(ttlHours),
+		dir:        filepath.Join(s.cfg.Dir, def.Name),
+		windowSize: def.WindowSize * 3600,
+		blocks:     make(map[uint32]*block),
+		lastWrite:  make(map[uint32]time.Time),
+	}
+	s.tables[def.Name] = tbl
+	return tbl
+}
+
+// Tables returns the tables of the store in TTL asc order
+func (s *Store) Tables() []*Table {
+	s.tablesLock.RLock()
+	tables := make([]*Table, 0, len(s.tables))
+	for _, tbl := range s.tables {
+		tables = append(tables, tbl)
+	}
+	s.tablesLock.RUnlock()
+	sort.Slice(tables, func(i, j int) bool { return tables[i].TTL < tables[j].TTL })
+	return tables
+}
+
+// GetTable returns the table with the given name
+func (s *Store) GetTable(name string) (*Table, error) {
+	s.tablesLock.RLock()
+	defer s.tablesLock.RUnlock()
+	tbl, ok := s.tables[name]
+	if !ok {
+		return nil, errTableNotFound
+	}
+	return tbl, nil
+}
+
+func (s *Store) SetTracer(t opentracing.Tracer) {
+	s.tracer = t
+}
+
+func (s *Store) Add(cwr *mdata.ChunkWriteRequest) {
+	if s.readOnly {
+		panic("localStore: can't add chunks to a read-only store")
+	}
+	writeQueueItems.Value(len(s.writeQueue))
+	s.writeQueue <- cwr
+}
+
+// processWriteQueue writes the chunks in the queue to the log files of their blocks,
+// and periodically syncs the written files to disk, after which the chunks are marked as saved.
+func (s *Store) processWriteQueue() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var buf []byte
+	var written []*mdata.ChunkWriteRequest
+	dirty := make(map[*block]struct{})
+
+	flush := func() {
+		for b := range dirty {
+			b.RLock()
+			if b.log != nil {
+				if err := b.log.Sync(); err != nil {
+					// the chunks are in the page cache and readable, but may not survive a crash.
+					log.Errorf("localStore: failed to sync %q. %s", b.log.Name(), err)
+				}
+			}
+			b.RUnlock()
+			delete(dirty, b)
+		}
+		for _, cwr := range written {
+			if cwr.Callback != nil {
+				cwr.Callback()
+			}
+		}
+		chunkSaveOk.Add(len(written))
+		written = written[:0]
+	}
+
+	// add writes the chunk, retrying until it succeeds. it returns false if we're shutting down.
+	add := func(cwr *mdata.ChunkWriteRequest) bool {
+		putWaitDuration.Value(time.Since(cwr.Timestamp))
+		chunkSizeAtSave.Value(len(cwr.Data))
+		attempts := 0
+		for {
+			var b *block
+			var err error
+			b, buf, err = s.write(cwr, buf)
+			if err == nil {
+				dirty[b] = struct{}{}
+				written = append(written, cwr)
+				return true
+			}
+			chunkSaveFail.Inc()
+			if (attempts % 20) == 0 {
+				log.Warnf("localStore: failed to write chunk %s:%d. it will be retried. %s", cwr.Key, cwr.T0, err)
+			}
+			sleepTime := 100 * attempts
+			if sleepTime > 2000 {
+				sleepTime = 2000
+			}
+			select {
+			case <-time.After(time.Duration(sleepTime) * time.Millisecond):
+			case <-s.shutdown:
+				return false
+			}
+			attempts++
+		}
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case cwr := <-s.writeQueue:
+			if !add(cwr) {
+				flush()
+				return
+			}
+			if len(written) >= s.cfg.WriteQueueSize {
+				flush()
+			}
+		case <-s.shutdown:
+			// write what is still queued, but don't wait for more
+			for {
+				select {
+				case cwr := <-s.writeQueue:
+					if !add(cwr) {
+						flush()
+						return
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write appends the chunk to the log file of its block
+func (s *Store) write(cwr *mdata.ChunkWriteRequest, buf []byte) (*block, []byte, error) {
+	tbl := s.getTable(cwr.TTL)
+	start := cwr.T0 - cwr.T0%tbl.windowSize
+	tbl.Lock()
+	b, ok := tbl.blocks[start]
+	if !ok {
+		if err := os.MkdirAll(tbl.dir, 0755); err != nil {
+			tbl.Unlock()
+			return nil, buf, err
+		}
+		b = newBlock(tbl.dir, start)
+		tbl.blocks[start] = b
+		blocksGauge.Inc()
+	}
+	tbl.lastWrite[start] = time.Now()
+	b.Lock()
+	tbl.Unlock()
+	buf, err := b.writeLog(cwr.Key, cwr.T0, cwr.TTL, cwr.Data, buf)
+	b.Unlock()
+	return b, buf, err
+}
+
+// maintain compacts blocks that are no longer written to, and removes blocks of which all chunks expired
+func (s *Store) maintain() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case now := <-ticker.C:
+			for _, tbl := range s.Tables() {
+				s.maintainTable(tbl, now)
+			}
+		}
+	}
+}
+
+func (s *Store) maintainTable(tbl *Table, now time.Time) {
+	unix := uint32(now.Unix())
+	tbl.RLock()
+	var expired, compact []*block
+	for start, b := range tbl.blocks {
+		b.RLock()
+		end := start + tbl.windowSize
+		if end+b.maxTTL <= unix {
+			expired = append(expired, b)
+		} else if b.log != nil && end <= unix && now.Sub(tbl.lastWrite[start]) >= s.cfg.CompactAfter {
+			compact = append(compact, b)
+		}
+		b.RUnlock()
+	}
+	tbl.RUnlock()
+
+	for _, b := range compact {
+		b.Lock()
+		pre := time.Now()
+		err := b.compact()
+		b.Unlock()
+		if err != nil {
+			log.Errorf("localStore: failed to compact block %s/%d. %s", tbl.Name, b.start, err)
+			continue
+		}
+		compactions.Inc()
+		log.Infof("localStore: compacted block %s/%d in %s", tbl.Name, b.start, time.Since(pre))
+	}
+
+	for _, b := range expired {
+		tbl.Lock()
+		b.Lock()
+		// a late chunk may have been written to the block in the meantime
+		if b.start+tbl.windowSize+b.maxTTL <= unix {
+			if err := b.remove(); err != nil {
+				log.Errorf("localStore: failed to remove block %s/%d. %s", tbl.Name, b.start, err)
+			}
+			delete(tbl.blocks, b.start)
+			delete(tbl.lastWrite, b.start)
+			blocksGauge.Dec()
+			expiredBlocks.Inc()
+		}
+		b.Unlock()
+		tbl.Unlock()
+	}
+}
+
+// Search returns the chunks of the given key in the table for the given ttl
+// start inclusive, end exclusive
+func (s *Store) Search(ctx context.Context, key schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	def := cassandra.GetTable(ttl, s.cfg.WindowFactor, cassandra.Table_name_format)
+	tbl, err := s.GetTable(def.Name)
+	if err == errTableNotFound {
+		// nothing has been written for this ttl yet
+		return nil, nil
+	}
+	return s.SearchTable(ctx, key, tbl, start, end)
+}
+
+// SearchTable returns the chunks of the given key in the given table:
+// all chunks with a t0 in the range, as well as the last chunk with a t0 <= start.
+// start inclusive, end exclusive
+func (s *Store) SearchTable(ctx context.Context, key schema.AMKey, tbl *Table, start, end uint32) ([]chunk.IterGen, error) {
+	_, span := tracing.NewSpan(ctx, s.tracer, "LocalStore.SearchTable")
+	defer span.Finish()
+
+	if start >= end {
+		tracing.Failure(span)
+		tracing.Error(span, errInvalidRange)
+		return nil, errInvalidRange
+	}
+
+	pre := time.Now()
+	var intervalHint uint32
+	if key.Archive > 0 {
+		intervalHint = key.Archive.Span()
+	}
+
+	// we don't know the chunkspan, so to find the last chunk with a t0 <= start we go back
+	// one block at a time, until we find a block with a chunk at or before start.
+	tbl.RLock()
+	var blocks []*block
+	for blockStart, b := range tbl.blocks {
+		if blockStart < end {
+			blocks = append(blocks, b)
+		}
+	}
+	tbl.RUnlock()
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].start > blocks[j].start })
+
+	var itgens []chunk.IterGen
+	var data []byte
+	var err error
+	foundStart := false
+	for _, b := range blocks {
+		if foundStart {
+			break
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+		b.RLock()
+		refs := b.chunks[key]
+		for i := len(refs) - 1; i >= 0 && !foundStart; i-- {
+			ref := refs[i]
+			if ref.t0 >= end {
+				continue
+			}
+			if ref.t0 <= start {
+				foundStart = true
+			}
+			if ref.size < 2 {
+				err = errChunkTooSmall
+				break
+			}
+			// the data of the itergen must not be modified, so we can't reuse the buffer
+			data = make([]byte, ref.size)
+			if _, err = ref.file.ReadAt(data, ref.offset); err != nil {
+				break
+			}
+			chunkSizeAtLoad.Value(len(data))
+			var itgen chunk.IterGen
+			itgen, err = chunk.NewIterGen(ref.t0, intervalHint, data)
+			if err != nil {
+				break
+			}
+			itgens = append(itgens, itgen)
+		}
+		b.RUnlock()
+		if err != nil {
+			break
+		}
+	}
+	getExecDuration.Value(time.Since(pre))
+
+	if err != nil {
+		log.Errorf("localStore: failed to read chunks of %s. %s", key, err)
+		tracing.Failure(span)
+		tracing.Error(span, err)
+		getError.Inc()
+		return nil, err
+	}
+	sort.Sort(chunk.IterGensAsc(itgens))
+	return itgens, nil
+}
+
+// ChunkInfo describes a chunk in the store
+type ChunkInfo struct {
+	Key schema.AMKey
+	T0  uint32
+	TTL uint32
+}
+
+// Chunks calls fn for each chunk in the table, or only for those of the given keys if any are given.
+// chunks are visited in no particular order.
+func (s *Store) Chunks(tbl *Table, keys []schema.AMKey, fn func(ChunkInfo)) {
+	tbl.RLock()
+	blocks := make([]*block, 0, len(tbl.blocks))
+	for _, b := range tbl.blocks {
+		blocks = append(blocks, b)
+	}
+	tbl.RUnlock()
+
+	for _, b := range blocks {
+		b.RLock()
+		visit := func(key schema.AMKey, refs []chunkRef) {
+			for _, ref := range refs {
+				fn(ChunkInfo{Key: key, T0: ref.t0, TTL: ref.ttl})
+			}
+		}
+		if len(keys) == 0 {
+			for key, refs := range b.chunks {
+				visit(key, refs)
+			}
+		} else {
+			for _, key := range keys {
+				visit(key, b.chunks[key])
+			}
+		}
+		b.RUnlock()
+	}
+}
+
+func (s *Store) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+	s.tablesLock.Lock()
+	for _, tbl := range s.tables {
+		tbl.Lock()
+		for _, b := range tbl.blocks {
+			b.Lock()
+			b.close()
+			b.Unlock()
+		}
+		tbl.Unlock()
+	}
+	s.tablesLock.Unlock()
+}
@@ -0,0 +1,187 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/schema"
+)
+
+const testTTL = 3600 * 24 * 7
+
+func getTestStore(t *testing.T, dir string) *Store {
+	t.Helper()
+	cfg := NewStoreConfig()
+	cfg.Dir = dir
+	cfg.FlushInterval = 10 * time.Millisecond
+	cfg.CompactAfter = 0
+	s, err := NewStore(cfg)
+	if err != nil {
+		t.Fatalf("failed to create store: %s", err.Error())
+	}
+	return s
+}
+
+// addChunks adds chunks of 600s for the given t0's, with a point every 60s of which the value is the timestamp,
+// and waits until they have been saved
+func addChunks(t *testing.T, s *Store, key schema.AMKey, t0s ...uint32) {
+	t.Helper()
+	saved := make(chan struct{}, len(t0s))
+	for _, t0 := range t0s {
+		c := chunk.New(t0)
+		for ts := t0; ts < t0+600; ts += 60 {
+			c.Push(ts, float64(ts))
+		}
+		c.Finish()
+		cwr := mdata.NewChunkWriteRequest(func() { saved <- struct{}{} }, key, testTTL, t0, c.Encode(600), time.Now())
+		s.Add(&cwr)
+	}
+	for range t0s {
+		select {
+		case <-saved:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for chunks to be saved")
+		}
+	}
+}
+
+func assertChunks(t *testing.T, s *Store, key schema.AMKey, start, end uint32, expT0s ...uint32) {
+	t.Helper()
+	tracer := opentracing.NoopTracer{}
+	s.SetTracer(tracer)
+	ctx := opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("test"))
+	itgens, err := s.Search(ctx, key, testTTL, start, end)
+	if err != nil {
+		t.Fatalf("search failed: %s", err.Error())
+	}
+	if len(itgens) != len(expT0s) {
+		t.Fatalf("expected %d chunks, got %d", len(expT0s), len(itgens))
+	}
+	for i, itgen := range itgens {
+		if itgen.T0 != expT0s[i] {
+			t.Fatalf("chunk %d: expected t0 %d, got %d", i, expT0s[i], itgen.T0)
+		}
+		iter, err := itgen.Get()
+		if err != nil {
+			t.Fatalf("chunk %d: failed to decode: %s", i, err.Error())
+		}
+		var points int
+		for iter.Next() {
+			ts, val := iter.Values()
+			if val != float64(ts) {
+				t.Fatalf("chunk %d: expected value %d at ts %d, got %f", i, ts, ts, val)
+			}
+			points++
+		}
+		if points != 10 {
+			t.Fatalf("chunk %d: expected 10 points, got %d", i, points)
+		}
+	}
+}
+
+func TestAddSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, _ := schema.AMKeyFromString("1.01234567890123456789012345678901")
+	other, _ := schema.AMKeyFromString("1.01234567890123456789012345678901_sum_600")
+
+	s := getTestStore(t, dir)
+	// the window size for this ttl is 9 hours, so these span multiple blocks
+	t0s := []uint32{3600 * 8, 3600*8 + 600, 3600*8 + 1200, 3600 * 9, 3600*9 + 600, 3600 * 20}
+	addChunks(t, s, key, t0s...)
+	addChunks(t, s, other, 3600*8)
+
+	assertChunks(t, s, key, 3600*8, 3600*8+1, 3600*8)
+	// the last chunk before start must be included, even if it is in a previous block
+	assertChunks(t, s, key, 3600*9-1, 3600*9+1, 3600*8+1200, 3600*9)
+	assertChunks(t, s, key, 3600*10, 3600*21, 3600*9+600, 3600*20)
+	assertChunks(t, s, key, 1, 3600*8)
+	assertChunks(t, s, other, 3600*8, 3600*9, 3600*8)
+
+	// overwriting a chunk must not result in duplicates
+	addChunks(t, s, key, 3600*9)
+	assertChunks(t, s, key, 3600*9, 3600*9+1, 3600*9)
+
+	s.Stop()
+
+	// all data must survive a restart
+	s = getTestStore(t, dir)
+	assertChunks(t, s, key, 0, 3600*21, t0s...)
+
+	// and compaction. the data is from 1970, so pretend that's when it was written, so it doesn't expire.
+	for _, tbl := range s.Tables() {
+		for start := range tbl.lastWrite {
+			tbl.lastWrite[start] = time.Unix(int64(start), 0)
+		}
+		s.maintainTable(tbl, time.Unix(3600*100, 0))
+	}
+	assertChunks(t, s, key, 0, 3600*21, t0s...)
+	matches, _ := filepath.Glob(filepath.Join(dir, "*", "*.log"))
+	if len(matches) != 0 {
+		t.Fatalf("expected all log files to be compacted, got %v", matches)
+	}
+	s.Stop()
+
+	s = getTestStore(t, dir)
+	assertChunks(t, s, key, 0, 3600*21, t0s...)
+
+	// chunks written to a compacted block are merged with it
+	addChunks(t, s, key, 3600*8+1800)
+	assertChunks(t, s, key, 3600*8+1200, 3600*8+1801, 3600*8+1200, 3600*8+1800)
+	s.Stop()
+	s = getTestStore(t, dir)
+	assertChunks(t, s, key, 3600*8+1200, 3600*8+1801, 3600*8+1200, 3600*8+1800)
+
+	// once all chunks of a block expired, it is removed
+	for _, tbl := range s.Tables() {
+		s.maintainTable(tbl, time.Unix(3600*18+testTTL, 0))
+	}
+	assertChunks(t, s, key, 0, 3600*21, 3600*20)
+	s.Stop()
+}
+
+func TestLoadTruncatedLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, _ := schema.AMKeyFromString("1.01234567890123456789012345678901")
+	s := getTestStore(t, dir)
+	addChunks(t, s, key, 600, 1200)
+	s.Stop()
+
+	// simulate a crash while writing the last chunk
+	matches, _ := filepath.Glob(filepath.Join(dir, "*", "*.log"))
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 log file, got %v", matches)
+	}
+	fi, err := os.Stat(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(matches[0], fi.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	s = getTestStore(t, dir)
+	assertChunks(t, s, key, 0, 1800, 600)
+	// new chunks must be appended after the last valid one
+	addChunks(t, s, key, 1200)
+	s.Stop()
+	s = getTestStore(t, dir)
+	assertChunks(t, s, key, 0, 1800, 600, 1200)
+	s.Stop()
+}
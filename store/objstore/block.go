@@ -0,0 +1,253 @@
+package objstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raintank/schema"
+)
+
+// every block is stored as 2 objects:
+// * <prefix><table>/<window start>/<id>.chunks: the data of the chunks, sorted by key and t0.
+// * <prefix><table>/<window start>/<id>.index: the index of the chunks in the chunks object.
+// the index is uploaded after the chunks, so a block exists once its index exists.
+//
+// the index is:
+// magic (8) | seq (8) | level (4) | number of keys (4) | per key:
+// length of the key (2) | key | number of chunks (4) | per chunk: t0 (4) | ttl (4) | offset of the data (8) | size of the data (4) | crc32c of the data (4)
+// followed by the crc32c of everything before it (4).
+//
+// the seq orders the blocks: when multiple blocks have a chunk with the same key and t0, the one in the block with the highest seq wins.
+// it is the creation time of the block, or for compacted blocks, the highest seq of the blocks they replace.
+
+const (
+	indexMagic      = "MTOBJ001"
+	indexHeaderSize = 24
+	indexEntrySize  = 24
+	chunksExt       = ".chunks"
+	indexExt        = ".index"
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorruptIndex = errors.New("corrupt block index")
+	errCorruptChunk = errors.New("corrupt chunk: checksum mismatch")
+)
+
+// chunkRef refers to the data of a chunk in the chunks object of a block
+type chunkRef struct {
+	t0     uint32
+	ttl    uint32
+	offset int64
+	size   uint32
+	crc    uint32
+}
+
+// block is an immutable set of chunks of a table, with t0's in one time window
+type block struct {
+	id    string
+	table string
+	start uint32 // start of the time window
+	seq   uint64
+	level uint32
+	size  int64 // size of the chunks object
+
+	chunks map[schema.AMKey][]chunkRef // sorted by t0, unique per t0
+	maxTTL uint32
+
+	// when the block was added to the store. used to tell blocks that were not yet there when listing the bucket.
+	added time.Time
+}
+
+// newBlockID returns a unique id for a new block, which sorts by creation time
+func newBlockID(now time.Time) string {
+	var rnd [4]byte
+	rand.Read(rnd[:])
+	return fmt.Sprintf("%016x-%s", now.UnixNano(), hex.EncodeToString(rnd[:]))
+}
+
+func blockName(prefix, table string, start uint32, id string) string {
+	return fmt.Sprintf("%s%s/%d/%s", prefix, table, start, id)
+}
+
+func (b *block) name(prefix string) string {
+	return blockName(prefix, b.table, b.start, b.id)
+}
+
+// parseIndexName parses the name of an index object into the table, window start and id of its block
+func parseIndexName(prefix, name string) (string, uint32, string, bool) {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, indexExt) {
+		return "", 0, "", false
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, prefix), indexExt), "/")
+	if len(parts) != 3 {
+		return "", 0, "", false
+	}
+	start, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return "", 0, "", false
+	}
+	return parts[0], uint32(start), parts[2], true
+}
+
+// pendingChunk is a chunk to be written to a block
+type pendingChunk struct {
+	key  schema.AMKey
+	t0   uint32
+	ttl  uint32
+	data []byte
+}
+
+// encodeBlock encodes the given chunks, which must be unique per key and t0, into the data of the chunks and index objects.
+// it returns the block, without its id and table set.
+func encodeBlock(start uint32, seq uint64, level uint32, chunks []pendingChunk) (*block, []byte, []byte) {
+	keyStrs := make(map[schema.AMKey]string)
+	for _, c := range chunks {
+		if _, ok := keyStrs[c.key]; !ok {
+			keyStrs[c.key] = c.key.String()
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].key != chunks[j].key {
+			return keyStrs[chunks[i].key] < keyStrs[chunks[j].key]
+		}
+		return chunks[i].t0 < chunks[j].t0
+	})
+
+	b := &block{
+		start:  start,
+		seq:    seq,
+		level:  level,
+		chunks: make(map[schema.AMKey][]chunkRef),
+	}
+	var data bytes.Buffer
+	for _, c := range chunks {
+		ref := chunkRef{
+			t0:     c.t0,
+			ttl:    c.ttl,
+			offset: int64(data.Len()),
+			size:   uint32(len(c.data)),
+			crc:    crc32.Checksum(c.data, castagnoli),
+		}
+		data.Write(c.data)
+		b.chunks[c.key] = append(b.chunks[c.key], ref)
+		if c.ttl > b.maxTTL {
+			b.maxTTL = c.ttl
+		}
+	}
+	b.size = int64(data.Len())
+
+	// the chunks are sorted by key, so the keys are too
+	keys := make([]schema.AMKey, 0, len(b.chunks))
+	for i, c := range chunks {
+		if i == 0 || c.key != chunks[i-1].key {
+			keys = append(keys, c.key)
+		}
+	}
+
+	index := make([]byte, indexHeaderSize, indexHeaderSize+len(chunks)*(indexEntrySize+8))
+	copy(index, indexMagic)
+	binary.LittleEndian.PutUint64(index[8:], seq)
+	binary.LittleEndian.PutUint32(index[16:], level)
+	binary.LittleEndian.PutUint32(index[20:], uint32(len(keys)))
+	var scratch [indexEntrySize]byte
+	for _, key := range keys {
+		refs := b.chunks[key]
+		keyStr := keyStrs[key]
+		binary.LittleEndian.PutUint16(scratch[:], uint16(len(keyStr)))
+		index = append(index, scratch[:2]...)
+		index = append(index, keyStr...)
+		binary.LittleEndian.PutUint32(scratch[:], uint32(len(refs)))
+		index = append(index, scratch[:4]...)
+		for _, ref := range refs {
+			binary.LittleEndian.PutUint32(scratch[0:], ref.t0)
+			binary.LittleEndian.PutUint32(scratch[4:], ref.ttl)
+			binary.LittleEndian.PutUint64(scratch[8:], uint64(ref.offset))
+			binary.LittleEndian.PutUint32(scratch[16:], ref.size)
+			binary.LittleEndian.PutUint32(scratch[20:], ref.crc)
+			index = append(index, scratch[:]...)
+		}
+	}
+	binary.LittleEndian.PutUint32(scratch[:], crc32.Checksum(index, castagnoli))
+	index = append(index, scratch[:4]...)
+	return b, data.Bytes(), index
+}
+
+// decodeIndex decodes the index of a block
+func decodeIndex(table string, start uint32, id string, index []byte) (*block, error) {
+	if len(index) < indexHeaderSize+4 || string(index[:8]) != indexMagic {
+		return nil, errCorruptIndex
+	}
+	body := index[:len(index)-4]
+	if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(index[len(index)-4:]) {
+		return nil, errCorruptIndex
+	}
+	b := &block{
+		id:     id,
+		table:  table,
+		start:  start,
+		seq:    binary.LittleEndian.Uint64(body[8:]),
+		level:  binary.LittleEndian.Uint32(body[16:]),
+		chunks: make(map[schema.AMKey][]chunkRef),
+	}
+	numKeys := int(binary.LittleEndian.Uint32(body[20:]))
+	body = body[indexHeaderSize:]
+	for k := 0; k < numKeys; k++ {
+		if len(body) < 2 {
+			return nil, errCorruptIndex
+		}
+		keyLen := int(binary.LittleEndian.Uint16(body))
+		if len(body) < 2+keyLen+4 {
+			return nil, errCorruptIndex
+		}
+		key, err := schema.AMKeyFromString(string(body[2 : 2+keyLen]))
+		if err != nil {
+			return nil, errCorruptIndex
+		}
+		num := int(binary.LittleEndian.Uint32(body[2+keyLen:]))
+		body = body[2+keyLen+4:]
+		if len(body) < num*indexEntrySize {
+			return nil, errCorruptIndex
+		}
+		refs := make([]chunkRef, num)
+		for i := range refs {
+			refs[i] = chunkRef{
+				t0:     binary.LittleEndian.Uint32(body[0:]),
+				ttl:    binary.LittleEndian.Uint32(body[4:]),
+				offset: int64(binary.LittleEndian.Uint64(body[8:])),
+				size:   binary.LittleEndian.Uint32(body[16:]),
+				crc:    binary.LittleEndian.Uint32(body[20:]),
+			}
+			if end := refs[i].offset + int64(refs[i].size); end > b.size {
+				b.size = end
+			}
+			if refs[i].ttl > b.maxTTL {
+				b.maxTTL = refs[i].ttl
+			}
+			body = body[indexEntrySize:]
+		}
+		b.chunks[key] = refs
+	}
+	if len(body) != 0 {
+		return nil, errCorruptIndex
+	}
+	return b, nil
+}
+
+// verifyChunk checks the data of a chunk against the checksum in the index
+func verifyChunk(ref chunkRef, data []byte) error {
+	if crc32.Checksum(data, castagnoli) != ref.crc {
+		return errCorruptChunk
+	}
+	return nil
+}
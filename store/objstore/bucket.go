@@ -0,0 +1,148 @@
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var errNotExist = errors.New("object does not exist")
+
+// Bucket is a flat namespace of immutable objects, with names that use / as separator
+type Bucket interface {
+	// Put creates or replaces the object
+	Put(ctx context.Context, name string, data []byte) error
+	// Get returns the data of the object, or errNotExist
+	Get(ctx context.Context, name string) ([]byte, error)
+	// GetRange returns length bytes of the object, starting at offset, or errNotExist
+	GetRange(ctx context.Context, name string, offset, length int64) ([]byte, error)
+	// List returns the names of all objects with the given prefix, in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes the object. deleting an object that doesn't exist is not an error
+	Delete(ctx context.Context, name string) error
+}
+
+func NewBucket(cfg *StoreConfig) (Bucket, error) {
+	switch cfg.Backend {
+	case "filesystem":
+		return NewFSBucket(cfg.Dir)
+	case "s3":
+		return NewS3Bucket(cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Region, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3PathStyle, cfg.Timeout)
+	}
+	return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+}
+
+// FSBucket is a bucket in a directory on a filesystem, e.g. for testing or a network filesystem.
+// objects are files, with the / in their names mapped to directories.
+type FSBucket struct {
+	dir string
+}
+
+func NewFSBucket(dir string) (*FSBucket, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FSBucket{dir: dir}, nil
+}
+
+func (b *FSBucket) path(name string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(name))
+}
+
+// Put writes the object to a temporary file first, so that readers never see partial objects
+func (b *FSBucket) Put(ctx context.Context, name string, data []byte) error {
+	path := b.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (b *FSBucket) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(b.path(name))
+	if os.IsNotExist(err) {
+		return nil, errNotExist
+	}
+	return data, err
+}
+
+func (b *FSBucket) GetRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	f, err := os.Open(b.path(name))
+	if os.IsNotExist(err) {
+		return nil, errNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (b *FSBucket) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(b.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the file or directory may have been deleted since we listed its parent
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// Delete removes the object, as well as any directories that become empty
+func (b *FSBucket) Delete(ctx context.Context, name string) error {
+	path := b.path(name)
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != b.dir && strings.HasPrefix(dir, b.dir); dir = filepath.Dir(dir) {
+		// fails if the directory is not empty
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
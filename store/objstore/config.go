@@ -0,0 +1,145 @@
+package objstore
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/grafana/globalconf"
+	log "github.com/sirupsen/logrus"
+)
+
+type StoreConfig struct {
+	Enabled            bool
+	Backend            string
+	Dir                string
+	S3Endpoint         string
+	S3Bucket           string
+	S3Region           string
+	S3AccessKey        string
+	S3SecretKey        string
+	S3PathStyle        bool
+	Prefix             string
+	Timeout            time.Duration
+	WindowFactor       int
+	WriteQueueSize     int
+	FlushInterval      time.Duration
+	MaxBlockSize       int
+	ReadConcurrency    int
+	SyncInterval       time.Duration
+	CompactionEnabled  bool
+	CompactionInterval time.Duration
+	CompactionMin      int
+	CompactAfter       time.Duration
+	DeletionDelay      time.Duration
+}
+
+func (cfg *StoreConfig) Validate() error {
+	switch cfg.Backend {
+	case "filesystem":
+		if cfg.Dir == "" {
+			return errors.New("dir must be set for the filesystem backend")
+		}
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" || cfg.S3Region == "" {
+			return errors.New("s3-endpoint, s3-bucket and s3-region must be set for the s3 backend")
+		}
+	default:
+		return errors.New("backend must be one of filesystem or s3")
+	}
+	if cfg.WindowFactor < 1 {
+		return errors.New("window-factor must be at least 1")
+	}
+	if cfg.WriteQueueSize < 1 {
+		return errors.New("write-queue-size must be at least 1")
+	}
+	if cfg.FlushInterval <= 0 {
+		return errors.New("flush-interval must be > 0")
+	}
+	if cfg.ReadConcurrency < 1 {
+		return errors.New("read-concurrency must be at least 1")
+	}
+	if cfg.SyncInterval <= 0 {
+		return errors.New("sync-interval must be > 0")
+	}
+	if cfg.CompactionEnabled {
+		if cfg.CompactionInterval <= 0 {
+			return errors.New("compaction-interval must be > 0")
+		}
+		if cfg.CompactionMin < 2 {
+			return errors.New("compaction-min-blocks must be at least 2")
+		}
+	}
+	return nil
+}
+
+// return StoreConfig with default values set.
+func NewStoreConfig() *StoreConfig {
+	return &StoreConfig{
+		Enabled:            false,
+		Backend:            "filesystem",
+		Dir:                "/var/lib/metrictank/objstore",
+		S3Endpoint:         "https://s3.amazonaws.com",
+		S3Bucket:           "metrictank",
+		S3Region:           "us-east-1",
+		S3PathStyle:        false,
+		Prefix:             "",
+		Timeout:            30 * time.Second,
+		WindowFactor:       20,
+		WriteQueueSize:     100000,
+		FlushInterval:      time.Minute,
+		MaxBlockSize:       64 * 1024 * 1024,
+		ReadConcurrency:    20,
+		SyncInterval:       5 * time.Minute,
+		CompactionEnabled:  false,
+		CompactionInterval: 10 * time.Minute,
+		CompactionMin:      10,
+		CompactAfter:       time.Hour,
+		DeletionDelay:      15 * time.Minute,
+	}
+}
+
+var CliConfig = NewStoreConfig()
+
+func ConfigSetup() {
+	objStore := flag.NewFlagSet("object-store", flag.ExitOnError)
+	objStore.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "enable the object storage backend store plugin, which stores chunks in blocks in an S3-compatible bucket or a directory")
+	objStore.StringVar(&CliConfig.Backend, "backend", CliConfig.Backend, "where to store the blocks: filesystem or s3")
+	objStore.StringVar(&CliConfig.Dir, "dir", CliConfig.Dir, "directory to store the blocks in, for the filesystem backend")
+	objStore.StringVar(&CliConfig.S3Endpoint, "s3-endpoint", CliConfig.S3Endpoint, "url of the S3-compatible endpoint")
+	objStore.StringVar(&CliConfig.S3Bucket, "s3-bucket", CliConfig.S3Bucket, "name of the bucket")
+	objStore.StringVar(&CliConfig.S3Region, "s3-region", CliConfig.S3Region, "region of the bucket")
+	objStore.StringVar(&CliConfig.S3AccessKey, "s3-access-key", CliConfig.S3AccessKey, "access key. defaults to the AWS_ACCESS_KEY_ID environment variable")
+	objStore.StringVar(&CliConfig.S3SecretKey, "s3-secret-key", CliConfig.S3SecretKey, "secret key. defaults to the AWS_SECRET_ACCESS_KEY environment variable")
+	objStore.BoolVar(&CliConfig.S3PathStyle, "s3-path-style", CliConfig.S3PathStyle, "use path-style requests (<endpoint>/<bucket>/<object>) rather than virtual-hosted-style requests. most S3-compatible stores need this")
+	objStore.StringVar(&CliConfig.Prefix, "prefix", CliConfig.Prefix, "prefix of the names of all objects, e.g. to share a bucket")
+	objStore.DurationVar(&CliConfig.Timeout, "timeout", CliConfig.Timeout, "timeout of requests to the bucket")
+	objStore.IntVar(&CliConfig.WindowFactor, "window-factor", CliConfig.WindowFactor, "size of the time window of blocks relative to TTL. like the cassandra compaction window")
+	objStore.IntVar(&CliConfig.WriteQueueSize, "write-queue-size", CliConfig.WriteQueueSize, "max number of chunks allowed to be waiting to be added to a block")
+	objStore.DurationVar(&CliConfig.FlushInterval, "flush-interval", CliConfig.FlushInterval, "how often to upload the pending chunks as new blocks. chunks are only marked as saved once they have been uploaded")
+	objStore.IntVar(&CliConfig.MaxBlockSize, "max-block-size", CliConfig.MaxBlockSize, "size in bytes of the pending chunks at which they are uploaded before the flush-interval passed")
+	objStore.IntVar(&CliConfig.ReadConcurrency, "read-concurrency", CliConfig.ReadConcurrency, "max number of concurrent reads from the bucket")
+	objStore.DurationVar(&CliConfig.SyncInterval, "sync-interval", CliConfig.SyncInterval, "how often to list the bucket, to find blocks written, compacted or removed by other nodes")
+	objStore.BoolVar(&CliConfig.CompactionEnabled, "compaction-enabled", CliConfig.CompactionEnabled, "enable compaction and removal of expired blocks. enable it on exactly one node per bucket, since nodes compacting the same bucket concurrently conflict")
+	objStore.DurationVar(&CliConfig.CompactionInterval, "compaction-interval", CliConfig.CompactionInterval, "how often to look for blocks to compact or remove")
+	objStore.IntVar(&CliConfig.CompactionMin, "compaction-min-blocks", CliConfig.CompactionMin, "number of blocks of the same time window and compaction level, at which they get compacted into 1 block of the next level")
+	objStore.DurationVar(&CliConfig.CompactAfter, "compact-after", CliConfig.CompactAfter, "how long after the end of its time window all blocks of a window get compacted into 1 block")
+	objStore.DurationVar(&CliConfig.DeletionDelay, "deletion-delay", CliConfig.DeletionDelay, "how long to wait before deleting compacted blocks, so that other nodes reading them see the new block first. should be more than the sync-interval")
+	globalconf.Register("object-store", objStore, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !CliConfig.Enabled {
+		return
+	}
+	if CliConfig.S3AccessKey == "" {
+		CliConfig.S3AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if CliConfig.S3SecretKey == "" {
+		CliConfig.S3SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if err := CliConfig.Validate(); err != nil {
+		log.Fatalf("object-store: Config validation error. %s", err)
+	}
+}
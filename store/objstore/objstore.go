@@ -0,0 +1,793 @@
+// Package objstore implements a backend store that keeps chunks in immutable blocks in object storage:
+// an S3-compatible bucket, or a directory on a (network) filesystem.
+//
+// chunks are grouped in tables by TTL, using the same buckets as the cassandra store.
+// within a table, chunks are grouped by the time window their t0 falls in, with the size of the windows relative to the TTL.
+// new chunks are held in memory, and periodically uploaded as a new block per table and window.
+// the index of every block is kept in memory, so that reads only need to fetch the data of the chunks they need.
+//
+// to keep the number of blocks down, blocks of the same window and compaction level are compacted into a block of the next level,
+// and once a window has ended, all of its blocks are compacted into one. blocks are removed once all of their chunks have expired.
+// other nodes that use the same bucket find new and removed blocks by periodically listing the bucket.
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/store/cassandra"
+	"github.com/grafana/metrictank/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errChunkTooSmall = errors.New("impossibly small chunk in object store")
+	errInvalidRange  = errors.New("ObjStore: invalid range: from must be less than to")
+
+	// metric store.objstore.get.exec is the duration of getting from the object store
+	getExecDuration = stats.NewLatencyHistogram15s32("store.objstore.get.exec")
+	// metric store.objstore.get.error is the count of reads that failed
+	getError = stats.NewCounter32("store.objstore.get.error")
+	// metric store.objstore.put.wait is the duration of a put in the wait queue, until it is uploaded
+	putWaitDuration = stats.NewLatencyHistogram12h32("store.objstore.put.wait")
+	// metric store.objstore.write_queue.items is the number of chunks in the write queue
+	writeQueueItems = stats.NewRange32("store.objstore.write_queue.items")
+	// metric store.objstore.pending_chunks is the number of chunks waiting to be uploaded
+	pendingChunks = stats.NewGauge32("store.objstore.pending_chunks")
+	// metric store.objstore.chunk_operations.save_ok is counter of successful saves
+	chunkSaveOk = stats.NewCounter32("store.objstore.chunk_operations.save_ok")
+	// metric store.objstore.chunk_operations.save_fail is counter of failed saves
+	chunkSaveFail = stats.NewCounter32("store.objstore.chunk_operations.save_fail")
+	// metric store.objstore.chunk_size.at_save is the sizes of chunks seen when saving them
+	chunkSizeAtSave = stats.NewMeter32("store.objstore.chunk_size.at_save", true)
+	// metric store.objstore.chunk_size.at_load is the sizes of chunks seen when loading them
+	chunkSizeAtLoad = stats.NewMeter32("store.objstore.chunk_size.at_load", true)
+	// metric store.objstore.upload.exec is the duration of uploading a block
+	uploadDuration = stats.NewLatencyHistogram12h32("store.objstore.upload.exec")
+	// metric store.objstore.blocks is the number of blocks in the object store
+	blocksGauge = stats.NewGauge32("store.objstore.blocks")
+	// metric store.objstore.compactions is the number of compactions of blocks into a new block
+	compactions = stats.NewCounter32("store.objstore.compactions")
+	// metric store.objstore.compaction.error is the number of compactions that failed
+	compactionError = stats.NewCounter32("store.objstore.compaction.error")
+	// metric store.objstore.expired_blocks is the number of blocks that have been removed because all of their chunks expired
+	expiredBlocks = stats.NewCounter32("store.objstore.expired_blocks")
+	// metric store.objstore.sync.error is the number of times listing the bucket, or loading a block found in it, failed
+	syncError = stats.NewCounter32("store.objstore.sync.error")
+)
+
+// Table holds the blocks of a TTL bucket
+type Table struct {
+	Name string
+	// TTL is the lower limit of the TTL bucket in hours, like the TTL of cassandra.Table as returned by FindExistingTables.
+	TTL uint32
+
+	windowSize uint32 // in seconds
+
+	sync.RWMutex
+	blocks  map[string]*block         // by id
+	pending map[uint32]*pendingWindow // chunks waiting to be uploaded, by window start
+}
+
+// pendingWindow holds the chunks of a window that are waiting to be uploaded
+type pendingWindow struct {
+	chunks map[schema.AMKey]map[uint32]*mdata.ChunkWriteRequest
+	// all requests, including those of which the chunk was replaced by a newer version, so all of them get their callback.
+	cwrs []*mdata.ChunkWriteRequest
+	size int
+}
+
+type Store struct {
+	cfg         *StoreConfig
+	bucket      Bucket
+	tables      map[string]*Table
+	tablesLock  sync.RWMutex
+	writeQueue  chan *mdata.ChunkWriteRequest
+	readLimiter chan struct{}
+	shutdown    chan struct{}
+	wg          sync.WaitGroup
+	tracer      opentracing.Tracer
+
+	// only accessed by the write queue processor
+	pendingSize int
+	flushFailed bool
+
+	// protects the fields below, and serializes syncs and compactions
+	maintainLock sync.Mutex
+	// names of compacted or expired blocks that are to be deleted from the bucket, and when.
+	deletions map[string]time.Time
+}
+
+func NewStore(cfg *StoreConfig) (*Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	bucket, err := NewBucket(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newStore(cfg, bucket)
+}
+
+func newStore(cfg *StoreConfig, bucket Bucket) (*Store, error) {
+	s := &Store{
+		cfg:         cfg,
+		bucket:      bucket,
+		tables:      make(map[string]*Table),
+		writeQueue:  make(chan *mdata.ChunkWriteRequest, cfg.WriteQueueSize),
+		readLimiter: make(chan struct{}, cfg.ReadConcurrency),
+		shutdown:    make(chan struct{}),
+		deletions:   make(map[string]time.Time),
+	}
+	pre := time.Now()
+	if err := s.sync(time.Now()); err != nil {
+		return nil, fmt.Errorf("objStore: failed to load blocks. %s", err)
+	}
+	log.Infof("objStore: loaded %d blocks in %s", s.numBlocks(), time.Since(pre))
+	s.wg.Add(2)
+	go s.processWriteQueue()
+	go s.maintain()
+	return s, nil
+}
+
+// getTable returns the table for the given TTL, creating it if needed
+func (s *Store) getTable(ttl uint32) *Table {
+	def := cassandra.GetTable(ttl, s.cfg.WindowFactor, cassandra.Table_name_format)
+	s.tablesLock.RLock()
+	tbl, ok := s.tables[def.Name]
+	s.tablesLock.RUnlock()
+	if ok {
+		return tbl
+	}
+	s.tablesLock.Lock()
+	defer s.tablesLock.Unlock()
+	tbl, ok = s.tables[def.Name]
+	if ok {
+		return tbl
+	}
+	ttlHours, _ := strconv.Atoi(strings.TrimPrefix(def.Name, "metric_"))
+	tbl = &Table{
+		Name:       def.Name,
+		TTL:        uint32(ttlHours),
+		windowSize: def.WindowSize * 3600,
+		blocks:     make(map[string]*block),
+		pending:    make(map[uint32]*pendingWindow),
+	}
+	s.tables[def.Name] = tbl
+	return tbl
+}
+
+// getTableByName returns the table with the given name, creating it if needed, or nil if the name is not a valid table name
+func (s *Store) getTableByName(name string) *Table {
+	if !cassandra.IsStoreTable(name) {
+		return nil
+	}
+	// the windowSize is a function of the TTL, so any ttl in the bucket will do
+	ttlHours, _ := strconv.Atoi(strings.TrimPrefix(name, "metric_"))
+	tbl := s.getTable(uint32(ttlHours) * 3600)
+	if tbl.Name != name {
+		return nil
+	}
+	return tbl
+}
+
+// Tables returns the tables of the store in TTL asc order
+func (s *Store) Tables() []*Table {
+	s.tablesLock.RLock()
+	tables := make([]*Table, 0, len(s.tables))
+	for _, tbl := range s.tables {
+		tables = append(tables, tbl)
+	}
+	s.tablesLock.RUnlock()
+	sort.Slice(tables, func(i, j int) bool { return tables[i].TTL < tables[j].TTL })
+	return tables
+}
+
+func (s *Store) numBlocks() int {
+	var num int
+	for _, tbl := range s.Tables() {
+		tbl.RLock()
+		num += len(tbl.blocks)
+		tbl.RUnlock()
+	}
+	return num
+}
+
+func (s *Store) SetTracer(t opentracing.Tracer) {
+	s.tracer = t
+}
+
+func (s *Store) Add(cwr *mdata.ChunkWriteRequest) {
+	writeQueueItems.Value(len(s.writeQueue))
+	s.writeQueue <- cwr
+}
+
+// processWriteQueue adds the chunks in the queue to the pending chunks,
+// and uploads them as new blocks every flush interval, or once they reach the max block size.
+func (s *Store) processWriteQueue() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case cwr := <-s.writeQueue:
+			s.addPending(cwr)
+			// after a failed flush, we don't retry until the next tick
+			if s.pendingSize >= s.cfg.MaxBlockSize && !s.flushFailed {
+				s.flush()
+			}
+		case <-s.shutdown:
+			// upload what is still queued, but don't wait for more
+			for {
+				select {
+				case cwr := <-s.writeQueue:
+					s.addPending(cwr)
+				default:
+					s.flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Store) addPending(cwr *mdata.ChunkWriteRequest) {
+	chunkSizeAtSave.Value(len(cwr.Data))
+	tbl := s.getTable(cwr.TTL)
+	start := cwr.T0 - cwr.T0%tbl.windowSize
+	tbl.Lock()
+	w, ok := tbl.pending[start]
+	if !ok {
+		w = &pendingWindow{
+			chunks: make(map[schema.AMKey]map[uint32]*mdata.ChunkWriteRequest),
+		}
+		tbl.pending[start] = w
+	}
+	byT0, ok := w.chunks[cwr.Key]
+	if !ok {
+		byT0 = make(map[uint32]*mdata.ChunkWriteRequest)
+		w.chunks[cwr.Key] = byT0
+	}
+	byT0[cwr.T0] = cwr
+	w.cwrs = append(w.cwrs, cwr)
+	w.size += len(cwr.Data)
+	tbl.Unlock()
+	s.pendingSize += len(cwr.Data)
+	pendingChunks.Inc()
+}
+
+// flush uploads the pending chunks as a new block per table and window.
+// the chunks of windows that fail to upload remain pending, and are retried on the next flush.
+func (s *Store) flush() {
+	s.flushFailed = false
+	for _, tbl := range s.Tables() {
+		tbl.RLock()
+		starts := make([]uint32, 0, len(tbl.pending))
+		for start := range tbl.pending {
+			starts = append(starts, start)
+		}
+		tbl.RUnlock()
+
+		for _, start := range starts {
+			// only this goroutine modifies the pending windows, so we don't need to hold the lock while uploading
+			tbl.RLock()
+			w := tbl.pending[start]
+			tbl.RUnlock()
+			chunks := make([]pendingChunk, 0, len(w.cwrs))
+			for key, byT0 := range w.chunks {
+				for t0, cwr := range byT0 {
+					chunks = append(chunks, pendingChunk{key: key, t0: t0, ttl: cwr.TTL, data: cwr.Data})
+				}
+			}
+			now := time.Now()
+			b, data, index := encodeBlock(start, uint64(now.UnixNano()), 0, chunks)
+			b.id = newBlockID(now)
+			b.table = tbl.Name
+			if err := s.upload(b, data, index); err != nil {
+				log.Errorf("objStore: failed to upload block %s with %d chunks. it will be retried. %s", b.name(s.cfg.Prefix), len(chunks), err)
+				chunkSaveFail.Add(len(w.cwrs))
+				s.flushFailed = true
+				continue
+			}
+			b.added = time.Now()
+			tbl.Lock()
+			tbl.blocks[b.id] = b
+			delete(tbl.pending, start)
+			tbl.Unlock()
+			blocksGauge.Inc()
+
+			s.pendingSize -= w.size
+			pendingChunks.Add(-len(w.cwrs))
+			for _, cwr := range w.cwrs {
+				putWaitDuration.Value(time.Since(cwr.Timestamp))
+				if cwr.Callback != nil {
+					cwr.Callback()
+				}
+			}
+			chunkSaveOk.Add(len(w.cwrs))
+		}
+	}
+}
+
+// upload uploads the chunks and index objects of a block
+func (s *Store) upload(b *block, data, index []byte) error {
+	pre := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	name := b.name(s.cfg.Prefix)
+	if err := s.bucket.Put(ctx, name+chunksExt, data); err != nil {
+		return err
+	}
+	if err := s.bucket.Put(ctx, name+indexExt, index); err != nil {
+		return err
+	}
+	uploadDuration.Value(time.Since(pre))
+	return nil
+}
+
+// maintain periodically syncs the blocks with the bucket, and compacts and removes blocks if enabled
+func (s *Store) maintain() {
+	defer s.wg.Done()
+	syncTicker := time.NewTicker(s.cfg.SyncInterval)
+	defer syncTicker.Stop()
+	var compactC <-chan time.Time
+	if s.cfg.CompactionEnabled {
+		compactTicker := time.NewTicker(s.cfg.CompactionInterval)
+		defer compactTicker.Stop()
+		compactC = compactTicker.C
+	}
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case now := <-syncTicker.C:
+			if err := s.sync(now); err != nil {
+				log.Errorf("objStore: failed to sync blocks. %s", err)
+			}
+		case now := <-compactC:
+			s.compact(now)
+			s.deleteBlocks(now)
+		}
+	}
+}
+
+// sync lists the bucket, to load the blocks added by other nodes, and to drop the blocks removed by other nodes.
+// blocks added after the given time are kept, since they may have been added after the bucket was listed.
+func (s *Store) sync(now time.Time) error {
+	s.maintainLock.Lock()
+	defer s.maintainLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	names, err := s.bucket.List(ctx, s.cfg.Prefix)
+	cancel()
+	if err != nil {
+		syncError.Inc()
+		return err
+	}
+
+	seen := make(map[string]struct{}, len(names))
+	var failed int
+	for _, name := range names {
+		tableName, start, id, ok := parseIndexName(s.cfg.Prefix, name)
+		if !ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		if _, ok := s.deletions[strings.TrimSuffix(name, indexExt)]; ok {
+			continue
+		}
+		tbl := s.getTableByName(tableName)
+		if tbl == nil {
+			log.Warnf("objStore: ignoring block %q of unknown table", name)
+			continue
+		}
+		tbl.RLock()
+		_, ok = tbl.blocks[id]
+		tbl.RUnlock()
+		if ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+		data, err := s.bucket.Get(ctx, name)
+		cancel()
+		if err == errNotExist {
+			// removed since we listed the bucket
+			continue
+		}
+		if err == nil {
+			var b *block
+			b, err = decodeIndex(tableName, start, id, data)
+			if err == nil {
+				b.added = time.Now()
+				tbl.Lock()
+				tbl.blocks[id] = b
+				tbl.Unlock()
+				blocksGauge.Inc()
+				continue
+			}
+		}
+		log.Errorf("objStore: failed to load block index %q. %s", name, err)
+		syncError.Inc()
+		failed++
+	}
+
+	for _, tbl := range s.Tables() {
+		tbl.Lock()
+		for id, b := range tbl.blocks {
+			if _, ok := seen[id]; !ok && b.added.Before(now) {
+				delete(tbl.blocks, id)
+				blocksGauge.Dec()
+			}
+		}
+		tbl.Unlock()
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to load %d blocks", failed)
+	}
+	return nil
+}
+
+// compact compacts the blocks of each table that are due for compaction, and removes the expired ones
+func (s *Store) compact(now time.Time) {
+	s.maintainLock.Lock()
+	defer s.maintainLock.Unlock()
+
+	unix := uint32(now.Unix())
+	for _, tbl := range s.Tables() {
+		tbl.RLock()
+		windows := make(map[uint32][]*block)
+		for _, b := range tbl.blocks {
+			windows[b.start] = append(windows[b.start], b)
+		}
+		tbl.RUnlock()
+
+		for start, blocks := range windows {
+			end := start + tbl.windowSize
+			var maxTTL, maxLevel uint32
+			for _, b := range blocks {
+				if b.maxTTL > maxTTL {
+					maxTTL = b.maxTTL
+				}
+				if b.level > maxLevel {
+					maxLevel = b.level
+				}
+			}
+			if end+maxTTL <= unix {
+				s.removeBlocks(tbl, blocks, now)
+				expiredBlocks.Add(len(blocks))
+				continue
+			}
+			if uint32(now.Add(-s.cfg.CompactAfter).Unix()) >= end {
+				if len(blocks) > 1 {
+					s.compactBlocks(tbl, blocks, maxLevel+1, now)
+				}
+				continue
+			}
+			byLevel := make(map[uint32][]*block)
+			for _, b := range blocks {
+				byLevel[b.level] = append(byLevel[b.level], b)
+			}
+			for level, blocks := range byLevel {
+				if len(blocks) >= s.cfg.CompactionMin {
+					s.compactBlocks(tbl, blocks, level+1, now)
+				}
+			}
+		}
+	}
+}
+
+// compactBlocks merges the given blocks of a window into a new block of the given level
+// caller must hold maintainLock
+func (s *Store) compactBlocks(tbl *Table, blocks []*block, level uint32, now time.Time) {
+	pre := time.Now()
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].seq < blocks[j].seq })
+	merged := make(map[schema.AMKey]map[uint32]pendingChunk)
+	for _, b := range blocks {
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+		data, err := s.bucket.Get(ctx, b.name(s.cfg.Prefix)+chunksExt)
+		cancel()
+		if err == nil && int64(len(data)) < b.size {
+			err = errCorruptChunk
+		}
+		if err != nil {
+			log.Errorf("objStore: failed to compact blocks of %s/%d: failed to get block %s. %s", tbl.Name, blocks[0].start, b.id, err)
+			compactionError.Inc()
+			return
+		}
+		for key, refs := range b.chunks {
+			byT0, ok := merged[key]
+			if !ok {
+				byT0 = make(map[uint32]pendingChunk)
+				merged[key] = byT0
+			}
+			for _, ref := range refs {
+				chunkData := data[ref.offset : ref.offset+int64(ref.size)]
+				if err := verifyChunk(ref, chunkData); err != nil {
+					log.Errorf("objStore: failed to compact blocks of %s/%d: chunk %s:%d of block %s. %s", tbl.Name, b.start, key, ref.t0, b.id, err)
+					compactionError.Inc()
+					return
+				}
+				// blocks are sorted by seq, so newer versions of a chunk replace older ones
+				byT0[ref.t0] = pendingChunk{key: key, t0: ref.t0, ttl: ref.ttl, data: chunkData}
+			}
+		}
+	}
+	var chunks []pendingChunk
+	for _, byT0 := range merged {
+		for _, c := range byT0 {
+			chunks = append(chunks, c)
+		}
+	}
+
+	// the compacted block takes the place of the blocks it replaces in the order of blocks
+	newBlock, data, index := encodeBlock(blocks[0].start, blocks[len(blocks)-1].seq, level, chunks)
+	newBlock.id = newBlockID(pre)
+	newBlock.table = tbl.Name
+	if err := s.upload(newBlock, data, index); err != nil {
+		log.Errorf("objStore: failed to upload compacted block %s. %s", newBlock.name(s.cfg.Prefix), err)
+		compactionError.Inc()
+		return
+	}
+	newBlock.added = time.Now()
+	tbl.Lock()
+	tbl.blocks[newBlock.id] = newBlock
+	tbl.Unlock()
+	blocksGauge.Inc()
+	s.removeBlocks(tbl, blocks, now.Add(s.cfg.DeletionDelay))
+	compactions.Inc()
+	log.Infof("objStore: compacted %d blocks of %s/%d into level %d block %s with %d chunks in %s", len(blocks), tbl.Name, newBlock.start, level, newBlock.id, len(chunks), time.Since(pre))
+}
+
+// removeBlocks removes the blocks from the table, and schedules them to be deleted from the bucket at the given time.
+// caller must hold maintainLock
+func (s *Store) removeBlocks(tbl *Table, blocks []*block, at time.Time) {
+	tbl.Lock()
+	for _, b := range blocks {
+		if _, ok := tbl.blocks[b.id]; ok {
+			delete(tbl.blocks, b.id)
+			blocksGauge.Dec()
+		}
+		s.deletions[b.name(s.cfg.Prefix)] = at
+	}
+	tbl.Unlock()
+}
+
+// deleteBlocks deletes the blocks from the bucket that are due for deletion
+func (s *Store) deleteBlocks(now time.Time) {
+	s.maintainLock.Lock()
+	defer s.maintainLock.Unlock()
+	for name, at := range s.deletions {
+		if now.Before(at) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+		// delete the index first, so that no one can find a block without chunks
+		err := s.bucket.Delete(ctx, name+indexExt)
+		if err == nil {
+			err = s.bucket.Delete(ctx, name+chunksExt)
+		}
+		cancel()
+		if err != nil {
+			log.Errorf("objStore: failed to delete block %s. it will be retried. %s", name, err)
+			continue
+		}
+		delete(s.deletions, name)
+	}
+}
+
+// chunkRead is a chunk to be read from a block, or from the pending chunks if cwr is set
+type chunkRead struct {
+	block *block
+	ref   chunkRef
+	cwr   *mdata.ChunkWriteRequest
+	data  []byte
+}
+
+// Search returns the chunks of the given key in the table for the given ttl:
+// all chunks with a t0 in the range, as well as the last chunk with a t0 <= start.
+// start inclusive, end exclusive
+func (s *Store) Search(ctx context.Context, key schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	_, span := tracing.NewSpan(ctx, s.tracer, "ObjStore.Search")
+	defer span.Finish()
+
+	if start >= end {
+		tracing.Failure(span)
+		tracing.Error(span, errInvalidRange)
+		return nil, errInvalidRange
+	}
+
+	def := cassandra.GetTable(ttl, s.cfg.WindowFactor, cassandra.Table_name_format)
+	s.tablesLock.RLock()
+	tbl, ok := s.tables[def.Name]
+	s.tablesLock.RUnlock()
+	if !ok {
+		// nothing has been written for this ttl yet
+		return nil, nil
+	}
+
+	pre := time.Now()
+	reads := s.findChunks(tbl, key, start, end)
+	err := s.readChunks(ctx, key, reads)
+	var itgens []chunk.IterGen
+	if err == nil {
+		var intervalHint uint32
+		if key.Archive > 0 {
+			intervalHint = key.Archive.Span()
+		}
+		for _, r := range reads {
+			var itgen chunk.IterGen
+			itgen, err = chunk.NewIterGen(r.ref.t0, intervalHint, r.data)
+			if err != nil {
+				break
+			}
+			itgens = append(itgens, itgen)
+		}
+	}
+	getExecDuration.Value(time.Since(pre))
+
+	if err != nil {
+		log.Errorf("objStore: failed to read chunks of %s. %s", key, err)
+		tracing.Failure(span)
+		tracing.Error(span, err)
+		getError.Inc()
+		return nil, err
+	}
+	sort.Sort(chunk.IterGensAsc(itgens))
+	return itgens, nil
+}
+
+// findChunks returns the chunks of the key to read: those with a t0 < end, down to and including the last one with a t0 <= start.
+// we don't know the chunkspan, so to find the last chunk with a t0 <= start we go back one window at a time,
+// until we find a window with a chunk at or before start.
+func (s *Store) findChunks(tbl *Table, key schema.AMKey, start, end uint32) []chunkRead {
+	tbl.RLock()
+	defer tbl.RUnlock()
+
+	windows := make(map[uint32][]*block)
+	for _, b := range tbl.blocks {
+		if b.start < end {
+			windows[b.start] = append(windows[b.start], b)
+		}
+	}
+	for windowStart := range tbl.pending {
+		if _, ok := windows[windowStart]; !ok && windowStart < end {
+			windows[windowStart] = nil
+		}
+	}
+	starts := make([]uint32, 0, len(windows))
+	for windowStart := range windows {
+		starts = append(starts, windowStart)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] > starts[j] })
+
+	var reads []chunkRead
+	for _, windowStart := range starts {
+		// collect the latest version of every chunk in the window: blocks in seq order, followed by the pending chunks
+		blocks := windows[windowStart]
+		sort.Slice(blocks, func(i, j int) bool { return blocks[i].seq < blocks[j].seq })
+		byT0 := make(map[uint32]chunkRead)
+		for _, b := range blocks {
+			for _, ref := range b.chunks[key] {
+				byT0[ref.t0] = chunkRead{block: b, ref: ref}
+			}
+		}
+		if w, ok := tbl.pending[windowStart]; ok {
+			for t0, cwr := range w.chunks[key] {
+				byT0[t0] = chunkRead{ref: chunkRef{t0: t0, ttl: cwr.TTL}, cwr: cwr}
+			}
+		}
+		t0s := make([]uint32, 0, len(byT0))
+		for t0 := range byT0 {
+			if t0 < end {
+				t0s = append(t0s, t0)
+			}
+		}
+		sort.Slice(t0s, func(i, j int) bool { return t0s[i] > t0s[j] })
+		for _, t0 := range t0s {
+			reads = append(reads, byT0[t0])
+			if t0 <= start {
+				return reads
+			}
+		}
+	}
+	return reads
+}
+
+// readChunks reads the data of the chunks. adjacent chunks of the same block are read with a single request,
+// and the requests are executed concurrently, limited by the read concurrency.
+func (s *Store) readChunks(ctx context.Context, key schema.AMKey, reads []chunkRead) error {
+	type rangeRead struct {
+		block   *block
+		offset  int64
+		length  int64
+		indexes []int
+	}
+	var ranges []*rangeRead
+	byBlock := make(map[*block][]int)
+	for i := range reads {
+		r := &reads[i]
+		if r.cwr != nil {
+			r.data = r.cwr.Data
+			continue
+		}
+		byBlock[r.block] = append(byBlock[r.block], i)
+	}
+	for b, indexes := range byBlock {
+		sort.Slice(indexes, func(i, j int) bool { return reads[indexes[i]].ref.offset < reads[indexes[j]].ref.offset })
+		var cur *rangeRead
+		for _, i := range indexes {
+			ref := reads[i].ref
+			if cur != nil && cur.offset+cur.length == ref.offset {
+				cur.length += int64(ref.size)
+				cur.indexes = append(cur.indexes, i)
+				continue
+			}
+			cur = &rangeRead{block: b, offset: ref.offset, length: int64(ref.size), indexes: []int{i}}
+			ranges = append(ranges, cur)
+		}
+	}
+
+	errs := make(chan error, len(ranges))
+	for _, rr := range ranges {
+		go func(rr *rangeRead) {
+			select {
+			case s.readLimiter <- struct{}{}:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+			ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+			data, err := s.bucket.GetRange(ctx, rr.block.name(s.cfg.Prefix)+chunksExt, rr.offset, rr.length)
+			cancel()
+			<-s.readLimiter
+			if err != nil {
+				errs <- fmt.Errorf("failed to read block %s: %s", rr.block.id, err)
+				return
+			}
+			for _, i := range rr.indexes {
+				ref := reads[i].ref
+				chunkData := data[ref.offset-rr.offset : ref.offset-rr.offset+int64(ref.size)]
+				if err := verifyChunk(ref, chunkData); err != nil {
+					errs <- fmt.Errorf("chunk %s:%d of block %s: %s", key, ref.t0, rr.block.id, err)
+					return
+				}
+				reads[i].data = chunkData
+			}
+			errs <- nil
+		}(rr)
+	}
+	var err error
+	for range ranges {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for _, r := range reads {
+		if len(r.data) < 2 {
+			return errChunkTooSmall
+		}
+		chunkSizeAtLoad.Value(len(r.data))
+	}
+	return nil
+}
+
+func (s *Store) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
@@ -0,0 +1,289 @@
+package objstore
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/schema"
+)
+
+const testTTL = 3600 * 24 * 7
+
+func getTestStore(t *testing.T, bucket Bucket, flushInterval time.Duration) *Store {
+	t.Helper()
+	cfg := NewStoreConfig()
+	cfg.FlushInterval = flushInterval
+	// syncs and compactions are triggered by the tests
+	cfg.SyncInterval = time.Hour
+	cfg.CompactionInterval = time.Hour
+	cfg.CompactionMin = 2
+	cfg.CompactAfter = 0
+	cfg.DeletionDelay = time.Minute
+	s, err := newStore(cfg, bucket)
+	if err != nil {
+		t.Fatalf("failed to create store: %s", err.Error())
+	}
+	tracer := opentracing.NoopTracer{}
+	s.SetTracer(tracer)
+	return s
+}
+
+func newTestChunk(key schema.AMKey, t0 uint32, done func()) *mdata.ChunkWriteRequest {
+	c := chunk.New(t0)
+	for ts := t0; ts < t0+600; ts += 60 {
+		c.Push(ts, float64(ts))
+	}
+	c.Finish()
+	cwr := mdata.NewChunkWriteRequest(done, key, testTTL, t0, c.Encode(600), time.Now())
+	return &cwr
+}
+
+// addChunks adds chunks of 600s for the given t0's, with a point every 60s of which the value is the timestamp,
+// and waits until they have been uploaded
+func addChunks(t *testing.T, s *Store, key schema.AMKey, t0s ...uint32) {
+	t.Helper()
+	saved := make(chan struct{}, len(t0s))
+	for _, t0 := range t0s {
+		s.Add(newTestChunk(key, t0, func() { saved <- struct{}{} }))
+	}
+	for range t0s {
+		select {
+		case <-saved:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for chunks to be saved")
+		}
+	}
+}
+
+func search(t *testing.T, s *Store, key schema.AMKey, start, end uint32) []chunk.IterGen {
+	t.Helper()
+	ctx := opentracing.ContextWithSpan(context.Background(), s.tracer.StartSpan("test"))
+	itgens, err := s.Search(ctx, key, testTTL, start, end)
+	if err != nil {
+		t.Fatalf("search failed: %s", err.Error())
+	}
+	return itgens
+}
+
+func assertChunks(t *testing.T, s *Store, key schema.AMKey, start, end uint32, expT0s ...uint32) {
+	t.Helper()
+	itgens := search(t, s, key, start, end)
+	if len(itgens) != len(expT0s) {
+		t.Fatalf("expected %d chunks, got %d", len(expT0s), len(itgens))
+	}
+	for i, itgen := range itgens {
+		if itgen.T0 != expT0s[i] {
+			t.Fatalf("chunk %d: expected t0 %d, got %d", i, expT0s[i], itgen.T0)
+		}
+		iter, err := itgen.Get()
+		if err != nil {
+			t.Fatalf("chunk %d: failed to decode: %s", i, err.Error())
+		}
+		var points int
+		for iter.Next() {
+			ts, val := iter.Values()
+			if val != float64(ts) {
+				t.Fatalf("chunk %d: expected value %d at ts %d, got %f", i, ts, ts, val)
+			}
+			points++
+		}
+		if points != 10 {
+			t.Fatalf("chunk %d: expected 10 points, got %d", i, points)
+		}
+	}
+}
+
+func countIndexes(t *testing.T, bucket Bucket) int {
+	t.Helper()
+	names, err := bucket.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("failed to list bucket: %s", err)
+	}
+	var num int
+	for _, name := range names {
+		if strings.HasSuffix(name, indexExt) {
+			num++
+		}
+	}
+	return num
+}
+
+func testStore(t *testing.T, bucket Bucket) {
+	key, _ := schema.AMKeyFromString("1.01234567890123456789012345678901")
+	other, _ := schema.AMKeyFromString("1.01234567890123456789012345678901_sum_600")
+
+	s := getTestStore(t, bucket, 10*time.Millisecond)
+	// the window size for this ttl is 7 hours, so these span multiple windows
+	t0s := []uint32{3600 * 8, 3600*8 + 600, 3600*8 + 1200, 3600 * 9, 3600*9 + 600, 3600 * 20}
+	addChunks(t, s, key, t0s...)
+	addChunks(t, s, other, 3600*8)
+
+	assertChunks(t, s, key, 3600*8, 3600*8+1, 3600*8)
+	// the last chunk before start must be included, even if it is in a previous window
+	assertChunks(t, s, key, 3600*9-1, 3600*9+1, 3600*8+1200, 3600*9)
+	assertChunks(t, s, key, 3600*10, 3600*21, 3600*9+600, 3600*20)
+	assertChunks(t, s, key, 1, 3600*8)
+	assertChunks(t, s, other, 3600*8, 3600*9, 3600*8)
+
+	// overwriting a chunk must not result in duplicates
+	addChunks(t, s, key, 3600*9)
+	assertChunks(t, s, key, 3600*9, 3600*9+1, 3600*9)
+
+	// another node using the same bucket sees the blocks, and new ones after a sync
+	reader := getTestStore(t, bucket, time.Hour)
+	assertChunks(t, reader, key, 0, 3600*21, t0s...)
+	addChunks(t, s, key, 3600*21)
+	assertChunks(t, reader, key, 3600*21, 3600*22, 3600*20)
+	if err := reader.sync(time.Now()); err != nil {
+		t.Fatalf("sync failed: %s", err)
+	}
+	assertChunks(t, reader, key, 3600*21, 3600*22, 3600*21)
+	t0s = append(t0s, 3600*21)
+
+	s.Stop()
+
+	// all data must survive a restart
+	s = getTestStore(t, bucket, 10*time.Millisecond)
+	assertChunks(t, s, key, 0, 3600*22, t0s...)
+
+	// once their windows ended, all blocks of a window are compacted into one.
+	// blocks are only deleted from the bucket after the deletion delay.
+	tbl := s.Tables()[0]
+	tbl.RLock()
+	numBlocks := len(tbl.blocks)
+	windows := make(map[uint32]int)
+	for _, b := range tbl.blocks {
+		windows[b.start]++
+	}
+	tbl.RUnlock()
+	var compacted int
+	for _, num := range windows {
+		if num > 1 {
+			compacted++
+		}
+	}
+	if compacted == 0 {
+		t.Fatalf("expected windows with multiple blocks, got %v", windows)
+	}
+	now := time.Unix(3600*100, 0)
+	s.compact(now)
+	if s.numBlocks() != len(windows) {
+		t.Fatalf("expected 1 block for each of the %d windows after compaction, got %d", len(windows), s.numBlocks())
+	}
+	assertChunks(t, s, key, 0, 3600*22, t0s...)
+	s.deleteBlocks(now)
+	if num := countIndexes(t, bucket); num != numBlocks+compacted {
+		t.Fatalf("expected the compacted blocks to not be deleted yet, got %d blocks in the bucket, expected %d", num, numBlocks+compacted)
+	}
+	s.deleteBlocks(now.Add(time.Minute))
+	if num := countIndexes(t, bucket); num != len(windows) {
+		t.Fatalf("expected the compacted blocks to be deleted, got %d blocks in the bucket", num)
+	}
+	if err := reader.sync(time.Now()); err != nil {
+		t.Fatalf("sync failed: %s", err)
+	}
+	if reader.numBlocks() != len(windows) {
+		t.Fatalf("expected the reader to see the %d compacted blocks, got %d", len(windows), reader.numBlocks())
+	}
+	assertChunks(t, reader, key, 0, 3600*22, t0s...)
+	reader.Stop()
+
+	// blocks of an open window are compacted once there are enough of them of the same level
+	addChunks(t, s, key, 3600*30)
+	addChunks(t, s, key, 3600*30+600)
+	s.compact(time.Unix(3600*31, 0))
+	tbl.RLock()
+	var levels []uint32
+	for _, b := range tbl.blocks {
+		if b.start == 3600*30-3600*30%tbl.windowSize {
+			levels = append(levels, b.level)
+		}
+	}
+	tbl.RUnlock()
+	if len(levels) != 1 || levels[0] != 1 {
+		t.Fatalf("expected 1 block of level 1 in the open window, got blocks of levels %v", levels)
+	}
+	assertChunks(t, s, key, 3600*30, 3600*31, 3600*30, 3600*30+600)
+
+	// once all chunks of a window expired, its blocks are removed
+	s.compact(time.Unix(3600*18+testTTL, 0))
+	assertChunks(t, s, key, 0, 3600*22, 3600*20, 3600*21)
+	s.Stop()
+}
+
+func TestStoreFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "objstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bucket, err := NewFSBucket(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, bucket)
+}
+
+func TestStoreS3(t *testing.T) {
+	srv := httptest.NewServer(newFakeS3("test"))
+	defer srv.Close()
+	bucket, err := NewS3Bucket(srv.URL, "test", "us-east-1", "key", "secret", true, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, bucket)
+}
+
+// TestSearchPending tests that chunks can be read before they are uploaded
+func TestSearchPending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "objstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bucket, err := NewFSBucket(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := schema.AMKeyFromString("1.01234567890123456789012345678901")
+	s := getTestStore(t, bucket, time.Hour)
+	saved := make(chan struct{}, 1)
+	s.Add(newTestChunk(key, 3600, func() { saved <- struct{}{} }))
+	timeout := time.After(5 * time.Second)
+	for len(search(t, s, key, 3600, 3601)) == 0 {
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for the chunk to be readable")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	assertChunks(t, s, key, 3600, 3601, 3600)
+	if countIndexes(t, bucket) != 0 {
+		t.Fatalf("expected no blocks to be uploaded yet")
+	}
+	select {
+	case <-saved:
+		t.Fatalf("expected the chunk to not be saved yet")
+	default:
+	}
+
+	// pending chunks are uploaded on shutdown
+	s.Stop()
+	select {
+	case <-saved:
+	default:
+		t.Fatalf("expected the chunk to be saved on shutdown")
+	}
+	s = getTestStore(t, bucket, time.Hour)
+	assertChunks(t, s, key, 3600, 3601, 3600)
+	s.Stop()
+}
@@ -21,6 +21,9 @@ func NewDevnullStore() *devnullStore {
 
 func (c *devnullStore) Add(cwr *mdata.ChunkWriteRequest) {
 	c.AddCount++
+	if cwr.Callback != nil {
+		cwr.Callback()
+	}
 }
 
 func (c *devnullStore) Reset() {
@@ -0,0 +1,99 @@
+package tiered
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/globalconf"
+	"github.com/raintank/dur"
+	log "github.com/sirupsen/logrus"
+)
+
+// Backends are the names of the backend stores that tiers can use
+var Backends = []string{"cassandra", "bigtable", "local", "object"}
+
+type StoreConfig struct {
+	Enabled      bool
+	TiersStr     string
+	ReadFallback bool
+
+	Tiers []TierConfig
+}
+
+// TierConfig describes a tier: the backend that stores the chunks with a TTL of at least MinTTL,
+// and below the MinTTL of the next tier.
+type TierConfig struct {
+	Backend string
+	MinTTL  uint32 // in seconds
+}
+
+// ParseTiers parses a comma separated list of <backend>:<min ttl> tiers, in ascending ttl order.
+// the first tier must have a min ttl of 0.
+func ParseTiers(s string) ([]TierConfig, error) {
+	var tiers []TierConfig
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid tier %q: expected <backend>:<min ttl>", part)
+		}
+		backend := strings.TrimSpace(fields[0])
+		known := false
+		for _, b := range Backends {
+			known = known || b == backend
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid tier %q: unknown backend %q. must be one of %s", part, backend, strings.Join(Backends, ", "))
+		}
+		minTTL, err := dur.ParseDuration(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tier %q: invalid min ttl: %s", part, err)
+		}
+		if len(tiers) == 0 && minTTL != 0 {
+			return nil, fmt.Errorf("invalid tier %q: the first tier must have a min ttl of 0", part)
+		}
+		if len(tiers) > 0 && minTTL <= tiers[len(tiers)-1].MinTTL {
+			return nil, fmt.Errorf("invalid tier %q: tiers must be in ascending min ttl order", part)
+		}
+		tiers = append(tiers, TierConfig{Backend: backend, MinTTL: minTTL})
+	}
+	if len(tiers) < 2 {
+		return nil, errors.New("at least 2 tiers must be configured")
+	}
+	return tiers, nil
+}
+
+// return StoreConfig with default values set.
+func NewStoreConfig() *StoreConfig {
+	return &StoreConfig{
+		Enabled:      false,
+		TiersStr:     "cassandra:0,object:30d",
+		ReadFallback: true,
+	}
+}
+
+var CliConfig = NewStoreConfig()
+
+func ConfigSetup() {
+	tieredStore := flag.NewFlagSet("tiered-store", flag.ExitOnError)
+	tieredStore.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "enable the tiered store, which routes chunks to the backend stores of the tiers by their TTL. the backend stores of all tiers must be enabled")
+	tieredStore.StringVar(&CliConfig.TiersStr, "tiers", CliConfig.TiersStr, "comma separated list of tiers as <backend>:<min ttl>, in ascending ttl order. backend is one of cassandra, bigtable, local or object. the first tier must have a min ttl of 0")
+	tieredStore.BoolVar(&CliConfig.ReadFallback, "read-fallback", CliConfig.ReadFallback, "when the tier of a TTL doesn't have chunks for the start of a read, read the missing range from the other tiers. needed to read chunks written before the tiers changed")
+	globalconf.Register("tiered-store", tieredStore, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !CliConfig.Enabled {
+		return
+	}
+	var err error
+	CliConfig.Tiers, err = ParseTiers(CliConfig.TiersStr)
+	if err != nil {
+		log.Fatalf("tiered-store: Config validation error. %s", err)
+	}
+}
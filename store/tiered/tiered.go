@@ -0,0 +1,159 @@
+// Package tiered implements a store that routes chunks to different backend stores by their TTL,
+// e.g. to keep the raw data in cassandra, and the long-term rollups in object storage.
+//
+// reads go to the tier of the TTL. since the tiers may have been changed, chunks can also be in another tier.
+// so when the tier doesn't have the chunks for the start of the requested range, the missing range is read from the other tiers,
+// and the results are merged.
+package tiered
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/schema"
+)
+
+var (
+	// metric store.tiered.fallback.reads is the number of reads of which part of the range was read from other tiers
+	fallbackReads = stats.NewCounter32("store.tiered.fallback.reads")
+	// metric store.tiered.fallback.chunks is the number of chunks read from other tiers than the tier of their TTL
+	fallbackChunks = stats.NewCounter32("store.tiered.fallback.chunks")
+)
+
+type tier struct {
+	name   string
+	minTTL uint32
+	store  mdata.Store
+	// metric store.tiered.tier.<backend>.chunks is the number of chunks written to the backend of a tier
+	chunks *stats.Counter32
+}
+
+type Store struct {
+	tiers        []tier
+	readFallback bool
+	tracer       opentracing.Tracer
+}
+
+// NewStore returns a store for the configured tiers, using the given backend stores by name
+func NewStore(cfg *StoreConfig, backends map[string]mdata.Store) (*Store, error) {
+	s := &Store{
+		readFallback: cfg.ReadFallback,
+	}
+	for _, t := range cfg.Tiers {
+		store, ok := backends[t.Backend]
+		if !ok {
+			return nil, fmt.Errorf("backend store %q of tier with min ttl %d is not enabled", t.Backend, t.MinTTL)
+		}
+		s.tiers = append(s.tiers, tier{
+			name:   t.Backend,
+			minTTL: t.MinTTL,
+			store:  store,
+			chunks: stats.NewCounter32(fmt.Sprintf("store.tiered.tier.%s.chunks", t.Backend)),
+		})
+	}
+	for name := range backends {
+		used := false
+		for _, t := range s.tiers {
+			used = used || t.name == name
+		}
+		if !used {
+			return nil, fmt.Errorf("backend store %q is enabled, but not used by any tier", name)
+		}
+	}
+	return s, nil
+}
+
+// tierFor returns the index of the tier of the given TTL
+func (s *Store) tierFor(ttl uint32) int {
+	i := sort.Search(len(s.tiers), func(i int) bool { return s.tiers[i].minTTL > ttl })
+	return i - 1
+}
+
+func (s *Store) Add(cwr *mdata.ChunkWriteRequest) {
+	t := s.tiers[s.tierFor(cwr.TTL)]
+	t.chunks.Inc()
+	t.store.Add(cwr)
+}
+
+// Search returns the chunks of the key from the tier of the ttl.
+// if read fallback is enabled, and the tier doesn't have a chunk at or before start,
+// the other tiers are searched for the chunks before the first chunk of the tier.
+// start inclusive, end exclusive
+func (s *Store) Search(ctx context.Context, key schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	ctx, span := tracing.NewSpan(ctx, s.tracer, "TieredStore.Search")
+	defer span.Finish()
+
+	primary := s.tierFor(ttl)
+	itgens, err := s.tiers[primary].store.Search(ctx, key, ttl, start, end)
+	if err != nil || !s.readFallback {
+		return itgens, err
+	}
+	if len(itgens) > 0 && itgens[0].T0 <= start {
+		return itgens, nil
+	}
+
+	// search the other tiers for what we're missing: the chunks before the first one we have
+	missingEnd := end
+	if len(itgens) > 0 {
+		missingEnd = itgens[0].T0
+	}
+	var fallback []chunk.IterGen
+	for i, t := range s.tiers {
+		if i == primary || t.store == s.tiers[primary].store {
+			continue
+		}
+		res, err := t.store.Search(ctx, key, ttl, start, missingEnd)
+		if err != nil {
+			tracing.Failure(span)
+			tracing.Error(span, err)
+			return nil, err
+		}
+		for _, itgen := range res {
+			if itgen.T0 < missingEnd {
+				fallback = append(fallback, itgen)
+			}
+		}
+	}
+	if len(fallback) == 0 {
+		return itgens, nil
+	}
+	fallbackReads.Inc()
+	fallbackChunks.Add(len(fallback))
+
+	// if multiple other tiers have the same chunk, we use either of them
+	sort.Stable(chunk.IterGensAsc(fallback))
+	merged := make([]chunk.IterGen, 0, len(fallback)+len(itgens))
+	for i, itgen := range fallback {
+		if i > 0 && itgen.T0 == fallback[i-1].T0 {
+			continue
+		}
+		merged = append(merged, itgen)
+	}
+	return append(merged, itgens...), nil
+}
+
+// Stop stops the backend stores
+func (s *Store) Stop() {
+	for i, t := range s.tiers {
+		stopped := false
+		for _, prev := range s.tiers[:i] {
+			stopped = stopped || prev.store == t.store
+		}
+		if !stopped {
+			t.store.Stop()
+		}
+	}
+}
+
+func (s *Store) SetTracer(t opentracing.Tracer) {
+	s.tracer = t
+	for _, tier := range s.tiers {
+		tier.store.SetTracer(t)
+	}
+}
@@ -0,0 +1,155 @@
+package tiered
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/schema"
+)
+
+// fakeStore returns, like the real stores, the chunks with a t0 in the range, and the last one at or before start
+type fakeStore struct {
+	chunks  map[schema.AMKey][]*mdata.ChunkWriteRequest
+	stopped int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{chunks: make(map[schema.AMKey][]*mdata.ChunkWriteRequest)}
+}
+
+func (f *fakeStore) Add(cwr *mdata.ChunkWriteRequest) {
+	f.chunks[cwr.Key] = append(f.chunks[cwr.Key], cwr)
+	sort.Slice(f.chunks[cwr.Key], func(i, j int) bool { return f.chunks[cwr.Key][i].T0 < f.chunks[cwr.Key][j].T0 })
+}
+
+func (f *fakeStore) Search(ctx context.Context, key schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	var itgens []chunk.IterGen
+	cwrs := f.chunks[key]
+	for i := len(cwrs) - 1; i >= 0; i-- {
+		if cwrs[i].T0 >= end {
+			continue
+		}
+		itgen, err := chunk.NewIterGen(cwrs[i].T0, 0, cwrs[i].Data)
+		if err != nil {
+			return nil, err
+		}
+		itgens = append([]chunk.IterGen{itgen}, itgens...)
+		if cwrs[i].T0 <= start {
+			break
+		}
+	}
+	return itgens, nil
+}
+
+func (f *fakeStore) Stop() {
+	f.stopped++
+}
+
+func (f *fakeStore) SetTracer(t opentracing.Tracer) {}
+
+func addChunk(s mdata.Store, key schema.AMKey, ttl, t0 uint32) {
+	c := chunk.New(t0)
+	c.Push(t0, float64(t0))
+	c.Finish()
+	cwr := mdata.NewChunkWriteRequest(nil, key, ttl, t0, c.Encode(600), time.Now())
+	s.Add(&cwr)
+}
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("cassandra:0, object:30d,local:1y")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp := []TierConfig{{"cassandra", 0}, {"object", 30 * 24 * 3600}, {"local", 365 * 24 * 3600}}
+	if !reflect.DeepEqual(tiers, exp) {
+		t.Fatalf("expected %v, got %v", exp, tiers)
+	}
+	for _, in := range []string{
+		"cassandra:0",
+		"cassandra:1d,object:30d",
+		"cassandra:0,object:30d,local:7d",
+		"cassandra:0,foo:30d",
+		"cassandra:0,object",
+		"cassandra:0,object:foo",
+	} {
+		if _, err := ParseTiers(in); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
+
+func TestTieredStore(t *testing.T) {
+	short, long := newFakeStore(), newFakeStore()
+	tiers, _ := ParseTiers("cassandra:0,object:30d")
+	s, err := NewStore(&StoreConfig{Tiers: tiers, ReadFallback: true}, map[string]mdata.Store{"cassandra": short, "object": long})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tracer := opentracing.NoopTracer{}
+	s.SetTracer(tracer)
+	ctx := opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("test"))
+
+	key, _ := schema.AMKeyFromString("1.01234567890123456789012345678901")
+	rollup, _ := schema.AMKeyFromString("1.01234567890123456789012345678901_sum_3600")
+	addChunk(s, key, 7*24*3600, 600)
+	addChunk(s, rollup, 365*24*3600, 3600)
+	addChunk(s, rollup, 30*24*3600, 7200)
+	if len(short.chunks[key]) != 1 || len(short.chunks[rollup]) != 0 {
+		t.Fatalf("expected the short ttl chunk in the short tier, got %v", short.chunks)
+	}
+	if len(long.chunks[rollup]) != 2 || len(long.chunks[key]) != 0 {
+		t.Fatalf("expected the long ttl chunks in the long tier, got %v", long.chunks)
+	}
+
+	// before the tiers changed, rollups with a ttl of 60d were stored in the short tier
+	addChunk(short, rollup, 60*24*3600, 0)
+	addChunk(short, rollup, 60*24*3600, 3600)
+	addChunk(long, rollup, 60*24*3600, 10800)
+
+	assertT0s := func(start, end uint32, exp ...uint32) {
+		t.Helper()
+		itgens, err := s.Search(ctx, rollup, 60*24*3600, start, end)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var t0s []uint32
+		for _, itgen := range itgens {
+			t0s = append(t0s, itgen.T0)
+		}
+		if !reflect.DeepEqual(t0s, exp) {
+			t.Fatalf("search %d-%d: expected t0s %v, got %v", start, end, exp, t0s)
+		}
+	}
+	// entirely in the long tier
+	assertT0s(7200, 10801, 7200, 10800)
+	// straddles the tiers. the chunk at 3600 is in both tiers, and must not be duplicated
+	assertT0s(0, 10801, 0, 3600, 7200, 10800)
+	assertT0s(1800, 7201, 0, 3600, 7200)
+	// only in the short tier
+	assertT0s(0, 1, 0)
+
+	s.readFallback = false
+	assertT0s(0, 10801, 3600, 7200, 10800)
+
+	s.Stop()
+	if short.stopped != 1 || long.stopped != 1 {
+		t.Fatalf("expected the backend stores to be stopped once")
+	}
+}
+
+func TestNewStoreBackends(t *testing.T) {
+	tiers, _ := ParseTiers("cassandra:0,object:30d")
+	if _, err := NewStore(&StoreConfig{Tiers: tiers}, map[string]mdata.Store{"cassandra": newFakeStore()}); err == nil {
+		t.Fatalf("expected error for a tier of which the backend is not enabled")
+	}
+	backends := map[string]mdata.Store{"cassandra": newFakeStore(), "object": newFakeStore(), "local": newFakeStore()}
+	if _, err := NewStore(&StoreConfig{Tiers: tiers}, backends); err == nil {
+		t.Fatalf("expected error for an enabled backend that is not used")
+	}
+}